@@ -0,0 +1,120 @@
+// admin.go contains cumuli's admin routes, which need the ADMIN_TOKEN
+
+package main
+
+import (
+    "crypto/subtle"
+    "net/http"
+    "strings"
+
+    "github.com/lkvnstrs/cumuli/networkmapper"
+)
+
+// A type for what a cache purge dropped.
+type Purged struct {
+    Followings []string `json:"followings,omitempty" doc:"The users whose cached followings were dropped"`
+    Networks int `json:"networks" doc:"How many cached networks were dropped"`
+}
+
+// AdminOnly wraps h so it's only served to requests bearing the admin
+// token. Without an ADMIN_TOKEN, admin routes don't exist.
+func AdminOnly(h http.HandlerFunc) http.HandlerFunc {
+    return func(rw http.ResponseWriter, r *http.Request) {
+        if adminToken == "" {
+            renderNotFound(rw, "not found")
+            return
+        }
+
+        token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+        if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+            rw.Header().Set("WWW-Authenticate", `Bearer realm="cumuli admin"`)
+            renderError(rw, http.StatusUnauthorized, "unauthorized", "unauthorized", nil)
+            return
+        }
+
+        h(rw, r)
+    }
+}
+
+// CachePurgeHandler handles the route '/admin/cache/purge', dropping the
+// cached followings of the users given by user, and the cached networks of
+// the users given by users, built with any options, so they're fetched and
+// built again.
+func CachePurgeHandler(rw http.ResponseWriter, r *http.Request) {
+
+    if r.Method != "POST" {
+        renderMethodNotAllowed(rw, "POST")
+        return
+    }
+
+    source, err := getSource(r)
+    if err != nil {
+        renderBadRequest(rw, err.Error())
+        return
+    }
+
+    user := r.URL.Query().Get("user")
+    key := r.URL.Query().Get("users")
+    if user == "" && key == "" {
+        renderBadRequest(rw, "user or users is required")
+        return
+    }
+
+    purged := Purged{}
+
+    // Forget the followings on every source asked for, and have the CDN
+    // drop every network of the users
+    if user != "" {
+        surrogateKeys := []string{}
+        for _, s := range strings.Split(source, ",") {
+            if forgetter, ok := sources[s].(networkmapper.Forgetter); ok {
+                for _, u := range strings.Split(user, ",") {
+                    forgetter.Forget(u)
+                    purged.Followings = append(purged.Followings, u)
+                    surrogateKeys = append(surrogateKeys, userSurrogateKey(s, u))
+                }
+            }
+        }
+        purgeKeys(surrogateKeys)
+    }
+
+    if key != "" {
+        if purged.Networks, err = purgeNetworkMaps(source, strings.Replace(key, ",", "+", -1)); err != nil {
+            renderInternalError(rw, err)
+            return
+        }
+    }
+
+    renderJSON(rw, http.StatusOK, purged)
+}
+
+/* Helpers */
+
+// purgeNetworkMaps drops the cached networks for key, a '+' separated list
+// of users of source, built with any options, and has the CDN drop them
+// too. It returns how many were dropped.
+func purgeNetworkMaps(source, key string) (int, error) {
+
+    // Options are appended to the key after a '#'
+    cacheKey := networkCacheKey(source, key, networkOptions{})
+    keys, err := cache.Keys(cacheKey + "#")
+    if err != nil {
+        return 0, err
+    }
+    keys = append(keys, cacheKey)
+
+    fresh := make([]string, len(keys))
+    for i, k := range keys {
+        fresh[i] = freshKey(k)
+    }
+    purged, err := cache.Delete(keys...)
+    if err != nil {
+        return 0, err
+    }
+    if _, err = cache.Delete(fresh...); err != nil {
+        return 0, err
+    }
+
+    purgeKeys([]string{graphSurrogateKey(source, key)})
+    return purged, nil
+}
@@ -0,0 +1,355 @@
+// api.go contains the handler functions for cumuli's versioned JSON API
+
+package main
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/lkvnstrs/cumuli/networkmapper"
+)
+
+const (
+    MAX_SUBGRAPH_HOPS = 3
+    DEFAULT_SEARCH_LIMIT = 10
+    MAX_SEARCH_LIMIT = 100
+)
+
+// SubgraphHandler handles the route '/api/v1/subgraph', returning the
+// neighborhood of the center node within hops links of it, from the
+// already built network of the given users.
+func SubgraphHandler(rw http.ResponseWriter, r *http.Request) {
+
+    query := r.URL.Query()
+
+    source, err := getSource(r)
+    if err != nil {
+        renderBadRequest(rw, err.Error())
+        return
+    }
+
+    users, err := parseUsers(source, query.Get("users"), config.MaxUsers)
+    if err != nil {
+        renderUsersError(rw, err)
+        return
+    }
+
+    center := query.Get("center")
+    if center == "" {
+        renderBadRequest(rw, "center is required")
+        return
+    }
+
+    hops := 1
+    if h := query.Get("hops"); h != "" {
+        hops, err = strconv.Atoi(h)
+        if err != nil || hops < 0 || hops > MAX_SUBGRAPH_HOPS {
+            renderBadRequest(rw, "hops must be between 0 and " + strconv.Itoa(MAX_SUBGRAPH_HOPS))
+            return
+        }
+    }
+
+    result, err := getCachedResult(source, strings.Join(users, "+"), networkOptions{})
+    if err != nil {
+        renderInternalError(rw, err)
+        return
+    }
+    if result == nil {
+        renderNotFound(rw, "network has not been built")
+        return
+    }
+    setSurrogateKeys(rw, networkSurrogateKeys(source, strings.Join(users, "+")))
+
+    subgraph := result.Subgraph(center, hops)
+    if subgraph == nil {
+        renderNotFound(rw, "no node named " + center)
+        return
+    }
+
+    renderJSON(rw, http.StatusOK, subgraph)
+}
+
+// NodeSearchHandler handles the route '/api/v1/nodes', searching the nodes
+// of the already built network of the given users for names containing q.
+// The network is built and reshaped by the same options as at '/json/'.
+func NodeSearchHandler(rw http.ResponseWriter, r *http.Request) {
+
+    query := r.URL.Query()
+
+    source, err := getSource(r)
+    if err != nil {
+        renderBadRequest(rw, err.Error())
+        return
+    }
+
+    users, err := parseUsers(source, query.Get("users"), config.MaxUsers)
+    if err != nil {
+        renderUsersError(rw, err)
+        return
+    }
+
+    q := strings.TrimSpace(query.Get("q"))
+    if q == "" {
+        renderBadRequest(rw, "q is required")
+        return
+    }
+
+    limit := DEFAULT_SEARCH_LIMIT
+    if l := query.Get("limit"); l != "" {
+        limit, err = strconv.Atoi(l)
+        if err != nil || limit < 1 || limit > MAX_SEARCH_LIMIT {
+            renderBadRequest(rw, "limit must be between 1 and " + strconv.Itoa(MAX_SEARCH_LIMIT))
+            return
+        }
+    }
+
+    opts, err := getNetworkOptions(r, source)
+    if err != nil {
+        renderBadRequest(rw, err.Error())
+        return
+    }
+
+    result, err := getCachedResult(source, strings.Join(users, "+"), opts)
+    if err != nil {
+        renderInternalError(rw, err)
+        return
+    }
+    if result == nil {
+        renderNotFound(rw, "network has not been built")
+        return
+    }
+    setSurrogateKeys(rw, networkSurrogateKeys(source, strings.Join(users, "+")))
+
+    // Search the network as /json/ sends it with the same options, so the
+    // indexes are those of the nodes rendered
+    if result, err = reshapeNetwork(result, opts).Truncate(maxResponseSize, ""); err != nil {
+        renderInternalError(rw, err)
+        return
+    }
+
+    renderJSON(rw, http.StatusOK, result.Search(q, limit))
+}
+
+// A type for a SoundCloud account found by /api/search, with only what a
+// search box needs to show it.
+type SearchResult struct {
+    Permalink string `json:"permalink" doc:"The account's username, by which networks are built"`
+    Username string `json:"username" doc:"The account's display name, or its permalink if it has none"`
+    AvatarURL string `json:"avatar_url,omitempty" doc:"A link to the account's avatar"`
+}
+
+// SearchHandler handles the route '/api/search', searching SoundCloud's
+// accounts for q. Results are cached for search_cache_ttl, so a search box
+// asking again as it's typed in doesn't call SoundCloud every time.
+func SearchHandler(rw http.ResponseWriter, r *http.Request) {
+
+    query := r.URL.Query()
+
+    q := strings.TrimSpace(query.Get("q"))
+    if q == "" {
+        renderBadRequest(rw, "q is required")
+        return
+    }
+
+    limit := DEFAULT_SEARCH_LIMIT
+    if l := query.Get("limit"); l != "" {
+        var err error
+        limit, err = strconv.Atoi(l)
+        if err != nil || limit < 1 || limit > MAX_SEARCH_LIMIT {
+            renderBadRequest(rw, "limit must be between 1 and " + strconv.Itoa(MAX_SEARCH_LIMIT))
+            return
+        }
+    }
+
+    provider, ok := sources[DEFAULT_SOURCE].(networkmapper.Provider)
+    if !ok {
+        renderNotFound(rw, DEFAULT_SOURCE + " can't be searched")
+        return
+    }
+
+    results, err := searchUsers(r, provider, q, limit)
+    if err != nil {
+        // Only a source that's down is told apart; anything else is
+        // SoundCloud turning the search away
+        if _, ok := err.(*networkmapper.UnavailableError); ok {
+            renderBuildError(rw, err)
+            return
+        }
+        renderError(rw, http.StatusBadGateway, "source_error", err.Error(), map[string]string{"source": DEFAULT_SOURCE})
+        return
+    }
+
+    renderJSON(rw, http.StatusOK, results)
+}
+
+// QuotaHandler handles the route '/api/v1/quota', returning the state of
+// each source's quota of API calls, so clients can warn that builds may be
+// slow before they fail.
+func QuotaHandler(rw http.ResponseWriter, r *http.Request) {
+
+    quotas := make(map[string]networkmapper.QuotaStatus)
+    for name, source := range sources {
+        if reporter, ok := source.(networkmapper.QuotaReporter); ok {
+            quotas[name] = reporter.Quota()
+        }
+    }
+
+    renderJSON(rw, http.StatusOK, quotas)
+}
+
+// SchemaHandler handles the route '/api/v1/schema', returning the JSON
+// Schema of a format of the network.
+func SchemaHandler(rw http.ResponseWriter, r *http.Request) {
+
+    format := r.URL.Query().Get("format")
+    if format == "" {
+        format = networkmapper.FORMAT_RESULT
+    }
+
+    schema, err := networkmapper.ResultSchema(format)
+    if err != nil {
+        renderBadRequest(rw, err.Error())
+        return
+    }
+
+    renderJSON(rw, http.StatusOK, schema)
+}
+
+// JobsAPIHandler handles the route '/api/jobs'. Posting to it with the
+// users query parameter queues a build of their network, whose status is
+// at '/api/jobs/{id}' and result, once it's done, at the job's result.
+func JobsAPIHandler(rw http.ResponseWriter, r *http.Request) {
+
+    if strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/jobs"), "/") != "" {
+        JobHandler(rw, r)
+        return
+    }
+
+    if r.Method != "POST" {
+        renderMethodNotAllowed(rw, "POST")
+        return
+    }
+
+    source, err := getSource(r)
+    if err != nil {
+        renderBadRequest(rw, err.Error())
+        return
+    }
+    if strings.Contains(source, ",") {
+        renderBadRequest(rw, "jobs can only be built from one source")
+        return
+    }
+
+    users, err := parseUsers(source, r.URL.Query().Get("users"), MAX_UPLOAD_USERS)
+    if err != nil {
+        renderUsersError(rw, err)
+        return
+    }
+
+    opts, err := getNetworkOptions(r, source)
+    if err != nil {
+        renderBadRequest(rw, err.Error())
+        return
+    }
+    if option := unsupportedJobOption(opts); option != "" {
+        renderBadRequest(rw, "jobs can't be built with " + option)
+        return
+    }
+
+    job, err := StartJob(r.Context(), source, opts, users)
+    if err != nil {
+        renderBuildError(rw, err)
+        return
+    }
+
+    rw.Header().Set("Location", "/api/jobs/" + job.Id)
+    renderJSON(rw, http.StatusAccepted, job)
+}
+
+/* Helpers */
+
+// unsupportedJobOption returns the first of opts that a job can't build
+// its network with, or "" if it can build it. A job's result is sent as it
+// was built, so options that reshape a network are turned away too.
+func unsupportedJobOption(opts networkOptions) string {
+    switch {
+    case opts.Relation == networkmapper.RelationBoth:
+        return "relation=both"
+    case opts.Weight != "":
+        return "weight"
+    case opts.Metadata:
+        return "metadata"
+    case opts.Group != "":
+        return "group"
+    case opts.Depth > 1:
+        return "depth"
+    case opts.Playlists:
+        return "playlists"
+    case opts.Prune:
+        return "prune"
+    case opts.Directed:
+        return "directed"
+    case opts.MinShared > 0:
+        return "min_shared"
+    case opts.Rank:
+        return "rank"
+    case opts.Top > 0:
+        return "top"
+    case opts.MinDegree > 0:
+        return "min_degree"
+    case len(opts.Groups) > 0:
+        return "groups"
+    case opts.Name != nil:
+        return "name"
+    case opts.MinFollowers > 0:
+        return "min_followers"
+    case opts.MaxFollowers > 0:
+        return "max_followers"
+    case opts.MaxNodes > 0:
+        return "max_nodes"
+    }
+    return ""
+}
+
+// searchUsers returns up to limit accounts of p matching q, from the cache
+// if they've been searched for lately.
+func searchUsers(r *http.Request, p networkmapper.Provider, q string, limit int) ([]SearchResult, error) {
+
+    cacheKey := "search:" + DEFAULT_SOURCE + ":" + strconv.Itoa(limit) + ":" + strings.ToLower(q)
+    if js, ok, err := cache.Get(cacheKey); err == nil && ok {
+        var results []SearchResult
+        if err = json.Unmarshal(js, &results); err == nil {
+            return results, nil
+        }
+    }
+
+    users, err := p.Search(r.Context(), q, limit)
+    if err != nil {
+        return nil, err
+    }
+
+    results := make([]SearchResult, 0, len(users))
+    for _, u := range users {
+        result := SearchResult{Permalink: u.Name, Username: u.Name}
+        if u.Profile != nil {
+            if u.FullName != "" {
+                result.Username = u.FullName
+            }
+            result.AvatarURL = u.AvatarURL
+        }
+        results = append(results, result)
+    }
+
+    js, err := json.Marshal(results)
+    if err == nil {
+        err = cache.Set(cacheKey, js, time.Duration(config.SearchCacheTTL) * time.Second)
+    }
+    if err != nil {
+        log.Println("ERROR: Couldn't cache the search for " + q + ": " + err.Error())
+    }
+    return results, nil
+}
@@ -0,0 +1,162 @@
+// autocomplete.go contains the suggestions offered as a username is typed.
+// Suggestions are cached by prefix, and a prefix whose suggestions were
+// all there are answers for every longer prefix too, so typing on rarely
+// has to call SoundCloud
+
+package main
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+    "unicode/utf8"
+
+    "github.com/lkvnstrs/cumuli/networkmapper"
+)
+
+const (
+    AUTOCOMPLETE_LIMIT = 8 // suggestions for each prefix
+    AUTOCOMPLETE_MIN_PREFIX = 2 // characters typed before anything is suggested
+    AUTOCOMPLETE_MAX_PREFIX = 50
+)
+
+// A type for the suggestions for a prefix. The prefix is sent back so a
+// typeahead can drop the answers to what's no longer typed.
+type Autocomplete struct {
+    Prefix string `json:"q" doc:"The prefix, trimmed and lowercased, the suggestions are for"`
+    Users []Suggestion `json:"users" doc:"Up to 8 users, best match first"`
+}
+
+// A type for a user suggested for a prefix.
+type Suggestion struct {
+    Permalink string `json:"permalink" doc:"The user's username, by which networks are built"`
+    Label string `json:"label" doc:"The user's display name, or their permalink if they have none"`
+}
+
+// A type for the suggestions cached for a prefix.
+type cachedSuggestions struct {
+    Users []Suggestion `json:"users"`
+
+    // Whether Users are all the users matching the prefix, rather than
+    // the first AUTOCOMPLETE_LIMIT of them
+    Complete bool `json:"complete"`
+}
+
+// AutocompleteHandler handles the route '/api/autocomplete', suggesting
+// SoundCloud users for the prefix q. Prefixes shorter than
+// AUTOCOMPLETE_MIN_PREFIX get no suggestions rather than an error, so a
+// typeahead can ask on every key.
+func AutocompleteHandler(rw http.ResponseWriter, r *http.Request) {
+
+    prefix := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+    if utf8.RuneCountInString(prefix) > AUTOCOMPLETE_MAX_PREFIX {
+        renderBadRequest(rw, "q must be at most " + strconv.Itoa(AUTOCOMPLETE_MAX_PREFIX) + " characters")
+        return
+    }
+
+    result := Autocomplete{Prefix: prefix, Users: []Suggestion{}}
+    if utf8.RuneCountInString(prefix) < AUTOCOMPLETE_MIN_PREFIX {
+        renderJSON(rw, http.StatusOK, result)
+        return
+    }
+
+    provider, ok := sources[DEFAULT_SOURCE].(networkmapper.Provider)
+    if !ok {
+        renderNotFound(rw, DEFAULT_SOURCE + " can't be searched")
+        return
+    }
+
+    users, err := suggestUsers(r, provider, prefix)
+    if err != nil {
+        if _, ok := err.(*networkmapper.UnavailableError); ok {
+            renderBuildError(rw, err)
+            return
+        }
+        renderError(rw, http.StatusBadGateway, "source_error", err.Error(), map[string]string{"source": DEFAULT_SOURCE})
+        return
+    }
+    result.Users = users
+
+    renderJSON(rw, http.StatusOK, result)
+}
+
+/* Helpers */
+
+// suggestUsers returns the suggestions for prefix: those cached for it, or
+// those cached for a shorter prefix that were complete, narrowed down to
+// the ones matching prefix, or else a search of p, which is then cached.
+func suggestUsers(r *http.Request, p networkmapper.Provider, prefix string) ([]Suggestion, error) {
+
+    // Look for the prefix itself, then for ever shorter ones
+    for shorter := prefix; utf8.RuneCountInString(shorter) >= AUTOCOMPLETE_MIN_PREFIX; {
+        cached, ok := getCachedSuggestions(shorter)
+        if ok && shorter == prefix {
+            return cached.Users, nil
+        }
+        if ok && cached.Complete {
+            users := []Suggestion{}
+            for _, u := range cached.Users {
+                if suggestionMatches(u, prefix) {
+                    users = append(users, u)
+                }
+            }
+            return users, nil
+        }
+
+        _, size := utf8.DecodeLastRuneInString(shorter)
+        shorter = shorter[:len(shorter) - size]
+    }
+
+    found, err := p.Search(r.Context(), prefix, AUTOCOMPLETE_LIMIT)
+    if err != nil {
+        return nil, err
+    }
+
+    cached := cachedSuggestions{Users: make([]Suggestion, 0, len(found)), Complete: len(found) < AUTOCOMPLETE_LIMIT}
+    for _, u := range found {
+        s := Suggestion{Permalink: u.Name, Label: u.Name}
+        if u.Profile != nil && u.FullName != "" {
+            s.Label = u.FullName
+        }
+        cached.Users = append(cached.Users, s)
+    }
+
+    js, err := json.Marshal(cached)
+    if err == nil {
+        err = cache.Set(autocompleteKey(prefix), js, time.Duration(config.AutocompleteCacheTTL) * time.Second)
+    }
+    if err != nil {
+        log.Println("ERROR: Couldn't cache the suggestions for " + prefix + ": " + err.Error())
+    }
+    return cached.Users, nil
+}
+
+// getCachedSuggestions returns the suggestions cached for prefix, and
+// whether there are any.
+func getCachedSuggestions(prefix string) (cachedSuggestions, bool) {
+    var cached cachedSuggestions
+
+    js, ok, err := cache.Get(autocompleteKey(prefix))
+    if err != nil || !ok {
+        return cached, false
+    }
+    if err = json.Unmarshal(js, &cached); err != nil {
+        return cached, false
+    }
+    return cached, true
+}
+
+// suggestionMatches returns whether the permalink or label of s contains
+// prefix, as the source's search matches either.
+func suggestionMatches(s Suggestion, prefix string) bool {
+    return strings.Contains(strings.ToLower(s.Permalink), prefix) ||
+           strings.Contains(strings.ToLower(s.Label), prefix)
+}
+
+// autocompleteKey returns the cache key of the suggestions for prefix.
+func autocompleteKey(prefix string) string {
+    return "autocomplete:" + DEFAULT_SOURCE + ":" + prefix
+}
@@ -0,0 +1,413 @@
+// bolt.go contains the GraphStore and Cache kept in a bbolt file on disk
+
+package main
+
+import (
+    "bytes"
+    "encoding/binary"
+    "encoding/json"
+    "log"
+    "sync"
+    "time"
+
+    bolt "go.etcd.io/bbolt"
+)
+
+const (
+    BOLT_OPEN_TIMEOUT = 5 * time.Second // to wait for another process to let go of the file
+    BOLT_SWEEP_INTERVAL = time.Minute // between dropping expired cache entries
+)
+
+// The buckets of a bbolt database
+var (
+    graphsBucket = []byte("graphs")
+    snapshotsBucket = []byte("snapshots")
+    historyBucket = []byte("history")
+    networksBucket = []byte("networks")
+    cacheBucket = []byte("cache")
+    tokensBucket = []byte("tokens")
+)
+
+// The bbolt databases that are open, by path, since a file can only be
+// opened once
+var (
+    boltMu sync.Mutex
+    boltDBs = map[string]*bolt.DB{}
+)
+
+// openBolt opens the bbolt database at path, creating it if it doesn't
+// exist, or returns it if it's already open.
+func openBolt(path string) (*bolt.DB, error) {
+    boltMu.Lock()
+    defer boltMu.Unlock()
+
+    if db, ok := boltDBs[path]; ok {
+        return db, nil
+    }
+
+    db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: BOLT_OPEN_TIMEOUT})
+    if err != nil {
+        return nil, err
+    }
+    err = db.Update(func(tx *bolt.Tx) error {
+        for _, name := range [][]byte{graphsBucket, snapshotsBucket, cacheBucket, tokensBucket} {
+            if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        db.Close()
+        return nil, err
+    }
+
+    boltDBs[path] = db
+    return db, nil
+}
+
+// boltGraphs is a GraphStore in a bbolt database. Each graph's snapshots
+// are kept in its own bucket, keyed by when they were taken.
+type boltGraphs struct {
+    db *bolt.DB
+}
+
+// AddGraph saves graph, marshaled as gjs, unless it's already saved.
+func (s boltGraphs) AddGraph(graph *Graph, gjs []byte) error {
+    return s.db.Update(func(tx *bolt.Tx) error {
+        graphs := tx.Bucket(graphsBucket)
+        if graphs.Get([]byte(graph.Id)) != nil {
+            return nil
+        }
+        return graphs.Put([]byte(graph.Id), gjs)
+    })
+}
+
+// GetGraph returns the saved graph with the given id, or nil if there is
+// no such graph.
+func (s boltGraphs) GetGraph(id string) (*Graph, error) {
+
+    var graph *Graph
+    err := s.db.View(func(tx *bolt.Tx) error {
+        js := tx.Bucket(graphsBucket).Get([]byte(id))
+        if js == nil {
+            return nil
+        }
+        graph = &Graph{}
+        return json.Unmarshal(js, graph)
+    })
+    return graph, err
+}
+
+// AddSnapshot adds js as the newest snapshot of the saved graph with the
+// given id, dropping the oldest beyond max, unless max is negative.
+func (s boltGraphs) AddSnapshot(id string, snapshot Snapshot, js []byte, max int) error {
+
+    sjs, _ := json.Marshal(snapshot)
+    network, err := encodeNetwork(js)
+    if err != nil {
+        return err
+    }
+
+    return s.db.Update(func(tx *bolt.Tx) error {
+        graph, err := tx.Bucket(snapshotsBucket).CreateBucketIfNotExists([]byte(id))
+        if err != nil {
+            return err
+        }
+        history, err := graph.CreateBucketIfNotExists(historyBucket)
+        if err != nil {
+            return err
+        }
+        networks, err := graph.CreateBucketIfNotExists(networksBucket)
+        if err != nil {
+            return err
+        }
+
+        key := timestampKey(snapshot.Timestamp)
+        if err = history.Put(key, sjs); err != nil {
+            return err
+        }
+        if err = networks.Put(key, network); err != nil {
+            return err
+        }
+
+        // Drop the oldest snapshots
+        old := [][]byte{}
+        c := history.Cursor()
+        kept := 0
+        for k, _ := c.Last(); k != nil && max >= 0; k, _ = c.Prev() {
+            if kept++; kept > max {
+                old = append(old, append([]byte{}, k...))
+            }
+        }
+        for _, k := range old {
+            if err = history.Delete(k); err != nil {
+                return err
+            }
+            if err = networks.Delete(k); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+// GetHistory returns the snapshots of the saved graph with the given id,
+// newest first.
+func (s boltGraphs) GetHistory(id string) ([]Snapshot, error) {
+
+    history := []Snapshot{}
+    err := s.db.View(func(tx *bolt.Tx) error {
+        b := s.history(tx, id)
+        if b == nil {
+            return nil
+        }
+
+        c := b.Cursor()
+        for k, v := c.Last(); k != nil; k, v = c.Prev() {
+            var snapshot Snapshot
+            if err := json.Unmarshal(v, &snapshot); err != nil {
+                return err
+            }
+            history = append(history, snapshot)
+        }
+        return nil
+    })
+    return history, err
+}
+
+// GetSnapshot returns the network of the saved graph with the given id as
+// of its latest snapshot at or before at, or nil if there isn't one.
+func (s boltGraphs) GetSnapshot(id string, at time.Time) ([]byte, error) {
+
+    var js []byte
+    err := s.db.View(func(tx *bolt.Tx) error {
+        b := s.history(tx, id)
+        if b == nil {
+            return nil
+        }
+
+        // Find the first snapshot after at, and step back from it
+        c := b.Cursor()
+        k, _ := c.Seek(timestampKey(at.Unix() + 1))
+        if k == nil {
+            k, _ = c.Last()
+        } else {
+            k, _ = c.Prev()
+        }
+        if k == nil {
+            return nil
+        }
+
+        if network := tx.Bucket(snapshotsBucket).Bucket([]byte(id)).Bucket(networksBucket).Get(k); network != nil {
+            js = append([]byte{}, network...)
+        }
+        return nil
+    })
+    if err != nil || js == nil {
+        return nil, err
+    }
+    return decodeNetwork(js)
+}
+
+// ListGraphs returns the ids of every saved graph.
+func (s boltGraphs) ListGraphs() ([]string, error) {
+
+    ids := []string{}
+    err := s.db.View(func(tx *bolt.Tx) error {
+        return tx.Bucket(graphsBucket).ForEach(func(k, _ []byte) error {
+            ids = append(ids, string(k))
+            return nil
+        })
+    })
+    return ids, err
+}
+
+// history returns the bucket of the snapshots of the saved graph with the
+// given id, or nil if it has none.
+func (s boltGraphs) history(tx *bolt.Tx, id string) *bolt.Bucket {
+    graph := tx.Bucket(snapshotsBucket).Bucket([]byte(id))
+    if graph == nil {
+        return nil
+    }
+    return graph.Bucket(historyBucket)
+}
+
+// boltTokens keeps the refresh tokens of a source's connected accounts in
+// a bbolt database.
+type boltTokens struct {
+    db *bolt.DB
+    source string
+}
+
+// GetRefreshToken returns the refresh token of user, or "" if they
+// haven't connected their account.
+func (t boltTokens) GetRefreshToken(user string) (string, error) {
+
+    var token string
+    err := t.db.View(func(tx *bolt.Tx) error {
+        token = string(tx.Bucket(tokensBucket).Get(t.key(user)))
+        return nil
+    })
+    return token, err
+}
+
+// PutRefreshToken replaces the refresh token of user.
+func (t boltTokens) PutRefreshToken(user, token string) error {
+    return t.db.Update(func(tx *bolt.Tx) error {
+        return tx.Bucket(tokensBucket).Put(t.key(user), []byte(token))
+    })
+}
+
+// key returns the key holding user's refresh token.
+func (t boltTokens) key(user string) []byte {
+    return []byte(t.source + ":" + user)
+}
+
+// boltCache is a Cache in a bbolt database. Each value is kept after when
+// it expires, and expired values are dropped every BOLT_SWEEP_INTERVAL.
+type boltCache struct {
+    db *bolt.DB
+}
+
+// newBoltCache creates a boltCache in the bbolt database at path, sweeping
+// it until the process exits.
+func newBoltCache(path string) (*boltCache, error) {
+    db, err := openBolt(path)
+    if err != nil {
+        return nil, err
+    }
+
+    c := &boltCache{db}
+    go func () {
+        for range time.Tick(BOLT_SWEEP_INTERVAL) {
+            if err := c.sweep(); err != nil {
+                log.Println("ERROR: Couldn't sweep the cache: " + err.Error())
+            }
+        }
+    } ()
+    return c, nil
+}
+
+// Get returns the value of key, and whether there was one.
+func (c *boltCache) Get(key string) ([]byte, bool, error) {
+
+    var value []byte
+    err := c.db.View(func(tx *bolt.Tx) error {
+        if v, ok := live(tx.Bucket(cacheBucket).Get([]byte(key))); ok {
+            value = append([]byte{}, v...)
+        }
+        return nil
+    })
+    return value, value != nil, err
+}
+
+// Set sets the value of key, kept for ttl.
+func (c *boltCache) Set(key string, value []byte, ttl time.Duration) error {
+    return c.db.Update(func(tx *bolt.Tx) error {
+        return tx.Bucket(cacheBucket).Put([]byte(key), expiring(value, ttl))
+    })
+}
+
+// Add sets the value of key, kept for ttl, unless it already has one, and
+// returns whether it was set.
+func (c *boltCache) Add(key string, value []byte, ttl time.Duration) (bool, error) {
+
+    added := false
+    err := c.db.Update(func(tx *bolt.Tx) error {
+        b := tx.Bucket(cacheBucket)
+        if _, ok := live(b.Get([]byte(key))); ok {
+            return nil
+        }
+        added = true
+        return b.Put([]byte(key), expiring(value, ttl))
+    })
+    return added, err
+}
+
+// Delete drops keys and returns how many there were.
+func (c *boltCache) Delete(keys ...string) (int, error) {
+
+    deleted := 0
+    err := c.db.Update(func(tx *bolt.Tx) error {
+        b := tx.Bucket(cacheBucket)
+        for _, key := range keys {
+            if _, ok := live(b.Get([]byte(key))); ok {
+                deleted++
+            }
+            if err := b.Delete([]byte(key)); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    return deleted, err
+}
+
+// Keys returns the keys starting with prefix.
+func (c *boltCache) Keys(prefix string) ([]string, error) {
+
+    keys := []string{}
+    err := c.db.View(func(tx *bolt.Tx) error {
+        cur := tx.Bucket(cacheBucket).Cursor()
+        for k, v := cur.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = cur.Next() {
+            if _, ok := live(v); ok {
+                keys = append(keys, string(k))
+            }
+        }
+        return nil
+    })
+    return keys, err
+}
+
+// sweep drops the expired values of c.
+func (c *boltCache) sweep() error {
+    return c.db.Update(func(tx *bolt.Tx) error {
+        b := tx.Bucket(cacheBucket)
+
+        expired := [][]byte{}
+        b.ForEach(func(k, v []byte) error {
+            if _, ok := live(v); !ok {
+                expired = append(expired, append([]byte{}, k...))
+            }
+            return nil
+        })
+
+        for _, k := range expired {
+            if err := b.Delete(k); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+/* Helpers */
+
+// timestampKey returns the key of a snapshot taken at timestamp, which
+// sorts in time order.
+func timestampKey(timestamp int64) []byte {
+    key := make([]byte, 8)
+    binary.BigEndian.PutUint64(key, uint64(timestamp))
+    return key
+}
+
+// expiring returns value prefixed with when it expires, ttl from now.
+func expiring(value []byte, ttl time.Duration) []byte {
+    stored := make([]byte, 8 + len(value))
+    binary.BigEndian.PutUint64(stored, uint64(time.Now().Add(ttl).UnixNano()))
+    copy(stored[8:], value)
+    return stored
+}
+
+// live returns the value of stored, a value prefixed with when it expires,
+// and whether it hasn't expired yet.
+func live(stored []byte) ([]byte, bool) {
+    if len(stored) < 8 {
+        return nil, false
+    }
+    if time.Now().UnixNano() > int64(binary.BigEndian.Uint64(stored)) {
+        return nil, false
+    }
+    return stored[8:], true
+}
@@ -0,0 +1,419 @@
+// cache.go contains the caches behind cumuli's built networks, followings
+// and job results: Redis, shared by every server, or memory or a bbolt
+// file, so small deployments don't need Redis for them
+
+package main
+
+import (
+    "bytes"
+    "compress/gzip"
+    "container/list"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/garyburd/redigo/redis"
+)
+
+// gzipMagic starts every gzipped value.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// A type that satisfies Cache keeps values for a while by key.
+type Cache interface {
+
+    // Gets the value of a given key, and whether there was one
+    Get(key string) ([]byte, bool, error)
+
+    // Sets the value of a given key, kept for ttl
+    Set(key string, value []byte, ttl time.Duration) error
+
+    // Sets the value of a given key, kept for ttl, unless it already has
+    // one, and gets whether it was set
+    Add(key string, value []byte, ttl time.Duration) (bool, error)
+
+    // Drops the given keys, getting how many there were
+    Delete(keys ...string) (int, error)
+
+    // Gets the keys starting with a given prefix
+    Keys(prefix string) ([]string, error)
+
+}
+
+// NewCache creates the Cache configured by kind: "redis", "memory" of up
+// to size values, or "bolt" in the bbolt database at path.
+func NewCache(kind string, size int, path string) (Cache, error) {
+    switch kind {
+    case "", "redis":
+        return redisCache{}, nil
+    case "memory":
+        return newMemoryCache(size), nil
+    case "bolt":
+        return newBoltCache(path)
+    }
+    return nil, fmt.Errorf("unknown cache %q", kind)
+}
+
+// sizeLimitedCache is a Cache that doesn't keep values of more than max
+// bytes.
+type sizeLimitedCache struct {
+    Cache
+    max int
+}
+
+// A type for the error returned when a value is too big to cache.
+type EntryTooLargeError struct {
+    Key string
+
+    // How many bytes the value is over max_cache_entry_size
+    Over int
+}
+
+func (e *EntryTooLargeError) Error() string {
+    return fmt.Sprintf("not caching %s, %d bytes over max_cache_entry_size", e.Key, e.Over)
+}
+
+// Set sets the value of key, kept for ttl, or drops key and returns an
+// *EntryTooLargeError if value is too big to keep, so an older value
+// isn't left in its place.
+func (c sizeLimitedCache) Set(key string, value []byte, ttl time.Duration) error {
+    if len(value) > c.max {
+        if _, err := c.Cache.Delete(key); err != nil {
+            return err
+        }
+        return &EntryTooLargeError{Key: key, Over: len(value) - c.max}
+    }
+    return c.Cache.Set(key, value, ttl)
+}
+
+// Add sets the value of key, kept for ttl, unless it already has one or
+// value is too big to keep, and returns whether it was set.
+func (c sizeLimitedCache) Add(key string, value []byte, ttl time.Duration) (bool, error) {
+    if len(value) > c.max {
+        return false, nil
+    }
+    return c.Cache.Add(key, value, ttl)
+}
+
+// compressedCache is a Cache that gzips values of at least min bytes, and
+// gunzips them again when they're got. Values too small to be worth it,
+// and any cached before, are kept as they are; gzip's magic number tells
+// them apart, as neither JSON nor the cache's flags start with it.
+type compressedCache struct {
+    Cache
+    min int
+}
+
+// Get returns the value of key, gunzipped if need be, and whether there
+// was one.
+func (c compressedCache) Get(key string) ([]byte, bool, error) {
+    value, ok, err := c.Cache.Get(key)
+    if !ok || err != nil || !bytes.HasPrefix(value, gzipMagic) {
+        return value, ok, err
+    }
+
+    r, err := gzip.NewReader(bytes.NewReader(value))
+    if err != nil {
+        return nil, false, err
+    }
+    defer r.Close()
+
+    if value, err = ioutil.ReadAll(r); err != nil {
+        return nil, false, err
+    }
+    return value, true, nil
+}
+
+// Set sets the value of key, gzipped if it's big enough, kept for ttl.
+func (c compressedCache) Set(key string, value []byte, ttl time.Duration) error {
+    value, err := c.compress(value)
+    if err != nil {
+        return err
+    }
+    return c.Cache.Set(key, value, ttl)
+}
+
+// Add sets the value of key, gzipped if it's big enough, kept for ttl,
+// unless it already has one, and returns whether it was set.
+func (c compressedCache) Add(key string, value []byte, ttl time.Duration) (bool, error) {
+    value, err := c.compress(value)
+    if err != nil {
+        return false, err
+    }
+    return c.Cache.Add(key, value, ttl)
+}
+
+// compress returns value gzipped if it's at least min bytes.
+func (c compressedCache) compress(value []byte) ([]byte, error) {
+    if len(value) < c.min {
+        return value, nil
+    }
+
+    var buf bytes.Buffer
+    w, _ := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+    if _, err := w.Write(value); err != nil {
+        return nil, err
+    }
+    if err := w.Close(); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+// redisCache is a Cache in the Redis database of pool.
+type redisCache struct{}
+
+// Get returns the value of key, and whether there was one.
+func (redisCache) Get(key string) ([]byte, bool, error) {
+    conn := pool.Get()
+    defer conn.Close()
+
+    value, err := redis.Bytes(conn.Do("GET", key))
+    if err == redis.ErrNil {
+        return nil, false, nil
+    } else if err != nil {
+        return nil, false, err
+    }
+    return value, true, nil
+}
+
+// Set sets the value of key, kept for ttl.
+func (redisCache) Set(key string, value []byte, ttl time.Duration) error {
+    conn := pool.Get()
+    defer conn.Close()
+
+    _, err := conn.Do("SET", key, value, "EX", ttlSeconds(ttl))
+    return err
+}
+
+// Add sets the value of key, kept for ttl, unless it already has one, and
+// returns whether it was set.
+func (redisCache) Add(key string, value []byte, ttl time.Duration) (bool, error) {
+    conn := pool.Get()
+    defer conn.Close()
+
+    _, err := redis.String(conn.Do("SET", key, value, "NX", "EX", ttlSeconds(ttl)))
+    if err == redis.ErrNil {
+        return false, nil
+    }
+    return err == nil, err
+}
+
+// Delete drops keys and returns how many there were.
+func (redisCache) Delete(keys ...string) (int, error) {
+    if len(keys) == 0 {
+        return 0, nil
+    }
+
+    conn := pool.Get()
+    defer conn.Close()
+
+    args := make([]interface{}, len(keys))
+    for i, key := range keys {
+        args[i] = key
+    }
+    return redis.Int(conn.Do("DEL", args...))
+}
+
+// Keys returns the keys starting with prefix, scanning for them so Redis
+// isn't blocked.
+func (redisCache) Keys(prefix string) ([]string, error) {
+    conn := pool.Get()
+    defer conn.Close()
+
+    pattern := strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`).Replace(prefix) + "*"
+
+    keys := []string{}
+    for cursor := 0; ; {
+        values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 1000))
+        if err != nil {
+            return nil, err
+        }
+        matched, _ := redis.Strings(values[1], nil)
+        keys = append(keys, matched...)
+
+        if cursor, _ = redis.Int(values[0], nil); cursor == 0 {
+            return keys, nil
+        }
+    }
+}
+
+// memoryCache is a Cache of up to size values in memory, dropping the
+// least recently used first when it's full.
+type memoryCache struct {
+    mu sync.Mutex
+    size int
+    entries map[string]*list.Element
+    order *list.List // most recently used first
+}
+
+// memoryEntry is a value in a memoryCache.
+type memoryEntry struct {
+    key string
+    value []byte
+    expires time.Time
+}
+
+// newMemoryCache creates a memoryCache of up to size values.
+func newMemoryCache(size int) *memoryCache {
+    return &memoryCache{size: size, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// Get returns the value of key, and whether there was one.
+func (c *memoryCache) Get(key string) ([]byte, bool, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    e := c.live(key)
+    if e == nil {
+        return nil, false, nil
+    }
+    c.order.MoveToFront(e)
+    return e.Value.(*memoryEntry).value, true, nil
+}
+
+// Set sets the value of key, kept for ttl.
+func (c *memoryCache) Set(key string, value []byte, ttl time.Duration) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    c.set(key, value, ttl)
+    return nil
+}
+
+// Add sets the value of key, kept for ttl, unless it already has one, and
+// returns whether it was set.
+func (c *memoryCache) Add(key string, value []byte, ttl time.Duration) (bool, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if c.live(key) != nil {
+        return false, nil
+    }
+    c.set(key, value, ttl)
+    return true, nil
+}
+
+// Delete drops keys and returns how many there were.
+func (c *memoryCache) Delete(keys ...string) (int, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    deleted := 0
+    for _, key := range keys {
+        if e := c.live(key); e != nil {
+            c.order.Remove(e)
+            delete(c.entries, key)
+            deleted++
+        }
+    }
+    return deleted, nil
+}
+
+// Keys returns the keys starting with prefix.
+func (c *memoryCache) Keys(prefix string) ([]string, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    keys := []string{}
+    for key := range c.entries {
+        if strings.HasPrefix(key, prefix) && c.live(key) != nil {
+            keys = append(keys, key)
+        }
+    }
+    return keys, nil
+}
+
+// live returns the element of key if it hasn't expired, dropping it if it
+// has. c must be locked.
+func (c *memoryCache) live(key string) *list.Element {
+    e, ok := c.entries[key]
+    if !ok {
+        return nil
+    }
+    if time.Now().After(e.Value.(*memoryEntry).expires) {
+        c.order.Remove(e)
+        delete(c.entries, key)
+        return nil
+    }
+    return e
+}
+
+// set sets the value of key, dropping the least recently used values if c
+// is full. c must be locked.
+func (c *memoryCache) set(key string, value []byte, ttl time.Duration) {
+    entry := &memoryEntry{key: key, value: value, expires: time.Now().Add(ttl)}
+
+    if e, ok := c.entries[key]; ok {
+        e.Value = entry
+        c.order.MoveToFront(e)
+        return
+    }
+    c.entries[key] = c.order.PushFront(entry)
+
+    for c.order.Len() > c.size {
+        oldest := c.order.Back()
+        c.order.Remove(oldest)
+        delete(c.entries, oldest.Value.(*memoryEntry).key)
+    }
+}
+
+// cacheFollowings keeps users' followings in cache for ttl seconds, so
+// with Redis every server reuses the users any of them has fetched.
+type cacheFollowings struct {
+    ttl int
+}
+
+// GetFollowings returns the followings stored under key, and whether there
+// were any. The cache being unreachable counts as there being none.
+func (f cacheFollowings) GetFollowings(key string) ([]string, bool) {
+    js, ok, err := cache.Get(f.key(key))
+    if err != nil {
+        log.Printf("Couldn't get stored followings: %s", err)
+    }
+    if !ok {
+        return nil, false
+    }
+
+    var followings []string
+    if err = json.Unmarshal(js, &followings); err != nil {
+        return nil, false
+    }
+    return followings, true
+}
+
+// PutFollowings stores followings under key until they expire.
+func (f cacheFollowings) PutFollowings(key string, followings []string) {
+    js, err := json.Marshal(followings)
+    if err != nil {
+        return
+    }
+    if err = cache.Set(f.key(key), js, time.Duration(f.ttl) * time.Second); err != nil {
+        log.Printf("Couldn't store followings: %s", err)
+    }
+}
+
+// DeleteFollowings drops the followings stored under key.
+func (f cacheFollowings) DeleteFollowings(key string) {
+    if _, err := cache.Delete(f.key(key)); err != nil {
+        log.Printf("Couldn't delete stored followings: %s", err)
+    }
+}
+
+// key returns the cache key holding the followings stored under key.
+func (f cacheFollowings) key(key string) string {
+    return "followings:" + key
+}
+
+/* Helpers */
+
+// ttlSeconds returns ttl in whole seconds, at least one, as Redis wants it.
+func ttlSeconds(ttl time.Duration) int {
+    if s := int(ttl / time.Second); s > 0 {
+        return s
+    }
+    return 1
+}
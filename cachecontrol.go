@@ -0,0 +1,66 @@
+// cachecontrol.go contains the Cache-Control headers of cumuli's responses
+
+package main
+
+import (
+    "net/http"
+    "regexp"
+)
+
+// hashedAsset matches static files with a content hash in their name, such
+// as style.3f2a9c1d.css, which can be cached forever.
+var hashedAsset = regexp.MustCompile(`\.[0-9a-f]{8,}\.[a-z0-9]+$`)
+
+// cacheWriter is an http.ResponseWriter that sets the Cache-Control header
+// of successful responses.
+type cacheWriter struct {
+    http.ResponseWriter
+    value string
+    wroteHeader bool
+}
+
+// WriteHeader sets the Cache-Control header if status is cacheable and the
+// handler hasn't set one itself.
+func (cw *cacheWriter) WriteHeader(status int) {
+    if cw.wroteHeader {
+        return
+    }
+    cw.wroteHeader = true
+
+    if (status == http.StatusOK || status == http.StatusNotModified) &&
+        cw.value != "" && cw.Header().Get("Cache-Control") == "" {
+        cw.Header().Set("Cache-Control", cw.value)
+    }
+
+    cw.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes b to the response.
+func (cw *cacheWriter) Write(b []byte) (int, error) {
+    if !cw.wroteHeader {
+        cw.WriteHeader(http.StatusOK)
+    }
+    return cw.ResponseWriter.Write(b)
+}
+
+// CacheControl wraps h so its responses get the Cache-Control header
+// configured for class. Uncacheable classes get their header on every
+// response, errors included.
+func CacheControl(class string, h http.HandlerFunc) http.HandlerFunc {
+    return func(rw http.ResponseWriter, r *http.Request) {
+
+        c := class
+        if c == "static" && hashedAsset.MatchString(r.URL.Path) {
+            c = "static_hashed"
+        }
+
+        value := config.CacheControl[c]
+        if value == "no-store" {
+            rw.Header().Set("Cache-Control", value)
+            h(rw, r)
+            return
+        }
+
+        h(&cacheWriter{ResponseWriter: rw, value: value}, r)
+    }
+}
@@ -0,0 +1,175 @@
+// cdn.go contains the surrogate keys that let a CDN in front of cumuli
+// cache networks for long and purge them as soon as they change.
+//
+// Responses built from a network are tagged with a key for the network and
+// one for each of its users, in both the Surrogate-Key header Fastly reads
+// and the Cache-Tag header Cloudflare reads.
+
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+)
+
+const (
+    fastlyAPI = `https://api.fastly.com`
+    cloudflareAPI = `https://api.cloudflare.com/client/v4`
+
+    // The most keys Fastly and Cloudflare take in a single purge
+    MAX_PURGE_KEYS = 30
+)
+
+// A type that satisfies Purger can purge the responses tagged with
+// surrogate keys from a CDN.
+type Purger interface {
+
+    // Purges everything tagged with any of keys
+    Purge(keys []string) error
+
+}
+
+// NewPurger creates the Purger for the CDN configured in c, or returns nil
+// if there isn't one.
+func NewPurger(c CDNConfig) (Purger, error) {
+    switch c.Provider {
+    case "":
+        return nil, nil
+    case "fastly":
+        if c.ServiceId == "" || c.Token == "" {
+            return nil, errors.New("fastly needs a service_id and token")
+        }
+        return &fastlyPurger{serviceId: c.ServiceId, token: c.Token}, nil
+    case "cloudflare":
+        if c.ZoneId == "" || c.Token == "" {
+            return nil, errors.New("cloudflare needs a zone_id and token")
+        }
+        return &cloudflarePurger{zoneId: c.ZoneId, token: c.Token}, nil
+    default:
+        return nil, errors.New("unknown CDN provider " + c.Provider)
+    }
+}
+
+// fastlyPurger purges a Fastly service by surrogate key.
+type fastlyPurger struct {
+    serviceId string
+    token string
+}
+
+// Purge purges everything tagged with any of keys.
+func (p *fastlyPurger) Purge(keys []string) error {
+
+    req, err := http.NewRequest("POST", fastlyAPI + `/service/` + p.serviceId + `/purge`, nil)
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Fastly-Key", p.token)
+    req.Header.Set("Surrogate-Key", strings.Join(keys, " "))
+
+    return doPurge(req)
+}
+
+// cloudflarePurger purges a Cloudflare zone by cache tag.
+type cloudflarePurger struct {
+    zoneId string
+    token string
+}
+
+// Purge purges everything tagged with any of keys.
+func (p *cloudflarePurger) Purge(keys []string) error {
+
+    body, err := json.Marshal(map[string][]string{"tags": keys})
+    if err != nil {
+        return err
+    }
+
+    req, err := http.NewRequest("POST", cloudflareAPI + `/zones/` + p.zoneId + `/purge_cache`, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Authorization", "Bearer " + p.token)
+    req.Header.Set("Content-Type", "application/json")
+
+    return doPurge(req)
+}
+
+/* Helpers */
+
+// doPurge sends a purge request, returning an error if it didn't succeed.
+func doPurge(req *http.Request) error {
+
+    client := &http.Client{Timeout: 10 * time.Second}
+    r, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer r.Body.Close()
+
+    if r.StatusCode != http.StatusOK {
+        body, _ := ioutil.ReadAll(r.Body)
+        return errors.New("purge: " + r.Status + ": " + string(body))
+    }
+    return nil
+}
+
+// networkSurrogateKeys returns the surrogate keys of the network for key,
+// a '+' separated list of users of source: one for the network, whatever
+// options it's built with, and one for each user.
+func networkSurrogateKeys(source, key string) []string {
+
+    keys := []string{graphSurrogateKey(source, key)}
+
+    for _, u := range strings.Split(key, "+") {
+        if i := strings.Index(u, ":"); i >= 0 {
+            keys = append(keys, userSurrogateKey(u[:i], u[i + 1:]))
+            continue
+        }
+        for _, s := range strings.Split(source, ",") {
+            keys = append(keys, userSurrogateKey(s, u))
+        }
+    }
+
+    return keys
+}
+
+// graphSurrogateKey returns the surrogate key of the network for key,
+// which is named by its graph's id since keys are limited in length.
+func graphSurrogateKey(source, key string) string {
+    return "graph-" + graphId(source, key)
+}
+
+// userSurrogateKey returns the surrogate key of user of source.
+func userSurrogateKey(source, user string) string {
+    return "user-" + source + "-" + strings.ToLower(user)
+}
+
+// setSurrogateKeys tags the response with keys.
+func setSurrogateKeys(rw http.ResponseWriter, keys []string) {
+    rw.Header().Set("Surrogate-Key", strings.Join(keys, " "))
+    rw.Header().Set("Cache-Tag", strings.Join(keys, ","))
+}
+
+// purgeKeys purges keys from the CDN, if there is one, in the background.
+func purgeKeys(keys []string) {
+    if purger == nil {
+        return
+    }
+
+    go func(keys []string) {
+        for start := 0; start < len(keys); start += MAX_PURGE_KEYS {
+            end := start + MAX_PURGE_KEYS
+            if end > len(keys) {
+                end = len(keys)
+            }
+            if err := purger.Purge(keys[start:end]); err != nil {
+                log.Println("ERROR: Couldn't purge the CDN: " + err.Error())
+            }
+        }
+    } (keys)
+}
@@ -0,0 +1,359 @@
+// cluster.go contains connections to a Redis Cluster, which send each
+// command to the node serving its key and follow the cluster as its slots
+// move between nodes
+
+package main
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "net"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/garyburd/redigo/redis"
+)
+
+const (
+    CLUSTER_SLOTS = 16384 // a Redis Cluster's keys are hashed into
+)
+
+// redisCluster keeps which node of a Redis Cluster serves each slot.
+type redisCluster struct {
+    mu sync.RWMutex
+    addrs []string // the nodes the cluster is discovered from
+    password string
+    slots [CLUSTER_SLOTS]string // the address of the node serving each
+}
+
+// NewClusterPool creates a Redis pool of connections to the Redis Cluster
+// the nodes at addrs belong to.
+func NewClusterPool(addrs []string, password string) *redis.Pool {
+
+    cluster := &redisCluster{addrs: addrs, password: password}
+    if err := cluster.refresh(); err != nil {
+        log.Println("ERROR: Couldn't get the slots of the Redis Cluster: " + err.Error())
+    }
+
+    return &redis.Pool{
+        MaxIdle: 3,
+        IdleTimeout: 240 * time.Second,
+        Dial: func () (redis.Conn, error) {
+            return &clusterConn{cluster: cluster, conns: map[string]redis.Conn{}}, nil
+        },
+        TestOnBorrow: func(c redis.Conn, t time.Time) error {
+            _, err := c.Do("PING")
+            return err
+        },
+    }
+}
+
+// refresh asks the nodes of the cluster in turn which of them serves each
+// slot.
+func (c *redisCluster) refresh() error {
+
+    var err error
+    for _, addr := range c.addrs {
+        var conn redis.Conn
+        if conn, err = dialRedis(addr, c.password); err != nil {
+            continue
+        }
+
+        var ranges []interface{}
+        ranges, err = redis.Values(conn.Do("CLUSTER", "SLOTS"))
+        conn.Close()
+        if err != nil {
+            continue
+        }
+
+        // Each range is its first and last slot, then its primary's host
+        // and port, then its replicas'
+        c.mu.Lock()
+        for _, r := range ranges {
+            slots, _ := redis.Values(r, nil)
+            if len(slots) < 3 {
+                continue
+            }
+            node, _ := redis.Values(slots[2], nil)
+            if len(node) < 2 {
+                continue
+            }
+
+            first, _ := redis.Int(slots[0], nil)
+            last, _ := redis.Int(slots[1], nil)
+            host, _ := redis.String(node[0], nil)
+            port, _ := redis.Int(node[1], nil)
+
+            // An empty host is the node that was asked
+            if host == "" {
+                host, _, _ = net.SplitHostPort(addr)
+            }
+            for slot := first; slot <= last && slot < CLUSTER_SLOTS; slot++ {
+                c.slots[slot] = net.JoinHostPort(host, strconv.Itoa(port))
+            }
+        }
+        c.mu.Unlock()
+        return nil
+    }
+    return err
+}
+
+// node returns the address of the node serving slot, or of the first node
+// the cluster is discovered from if it isn't known.
+func (c *redisCluster) node(slot int) string {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+
+    if c.slots[slot] == "" {
+        return c.addrs[0]
+    }
+    return c.slots[slot]
+}
+
+// primaries returns the addresses of the nodes serving any slot.
+func (c *redisCluster) primaries() []string {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+
+    seen := map[string]bool{}
+    addrs := []string{}
+    for _, addr := range c.slots {
+        if addr != "" && !seen[addr] {
+            seen[addr] = true
+            addrs = append(addrs, addr)
+        }
+    }
+    if len(addrs) == 0 {
+        return c.addrs[:1]
+    }
+    return addrs
+}
+
+// clusterConn is a redis.Conn to a Redis Cluster. Commands sent are held
+// until the next Do, then sent with it to the node serving the first key
+// among them, so a transaction must only touch keys in one slot. DEL of
+// several keys is split up by key, and SCAN goes through every primary in
+// one go, coming back with a cursor of 0. Receive isn't supported.
+type clusterConn struct {
+    cluster *redisCluster
+    conns map[string]redis.Conn // by the address of their node
+    pending []clusterCommand
+}
+
+// A type for a command held by a clusterConn.
+type clusterCommand struct {
+    name string
+    args []interface{}
+}
+
+// Close closes the connections to every node.
+func (c *clusterConn) Close() error {
+    for addr, conn := range c.conns {
+        conn.Close()
+        delete(c.conns, addr)
+    }
+    return nil
+}
+
+// Err returns the error of a connection to a node that can no longer be
+// used, if there is one.
+func (c *clusterConn) Err() error {
+    for _, conn := range c.conns {
+        if err := conn.Err(); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Send holds a command until the next Do.
+func (c *clusterConn) Send(name string, args ...interface{}) error {
+    c.pending = append(c.pending, clusterCommand{name, args})
+    return nil
+}
+
+// Flush does nothing, as commands are only sent by Do.
+func (c *clusterConn) Flush() error {
+    return nil
+}
+
+// Receive isn't supported.
+func (c *clusterConn) Receive() (interface{}, error) {
+    return nil, errors.New("redis: Receive isn't supported on a cluster")
+}
+
+// Do sends the commands held and a command to the node serving the first
+// key among them, and returns the command's reply.
+func (c *clusterConn) Do(name string, args ...interface{}) (interface{}, error) {
+
+    commands := c.pending
+    c.pending = nil
+
+    // An empty command only sends what's held
+    if name != "" {
+        commands = append(commands, clusterCommand{name, args})
+    }
+    if len(commands) == 0 {
+        return nil, nil
+    }
+
+    if len(commands) == 1 {
+        switch strings.ToUpper(name) {
+        case "DEL":
+            if len(args) > 1 {
+                return c.del(args)
+            }
+        case "SCAN":
+            return c.scan(args)
+        }
+    }
+
+    slot := 0
+    for _, cmd := range commands {
+        if key, ok := commandKey(cmd.name, cmd.args); ok {
+            slot = clusterSlot(key)
+            break
+        }
+    }
+
+    reply, err := c.do(c.cluster.node(slot), commands)
+
+    // Follow a slot that has moved to another node, for good or while
+    // it's being migrated
+    if e, ok := err.(redis.Error); ok {
+        if f := strings.Fields(string(e)); len(f) == 3 && (f[0] == "MOVED" || f[0] == "ASK") {
+            if f[0] == "MOVED" {
+                c.cluster.refresh()
+            } else {
+                commands = append([]clusterCommand{{"ASKING", nil}}, commands...)
+            }
+            reply, err = c.do(f[2], commands)
+        }
+    }
+    return reply, err
+}
+
+// do sends commands to the node at addr, connecting to it if need be, and
+// returns the last one's reply.
+func (c *clusterConn) do(addr string, commands []clusterCommand) (interface{}, error) {
+
+    conn, ok := c.conns[addr]
+    if !ok || conn.Err() != nil {
+        if ok {
+            conn.Close()
+        }
+
+        var err error
+        if conn, err = dialRedis(addr, c.cluster.password); err != nil {
+            delete(c.conns, addr)
+            return nil, err
+        }
+        c.conns[addr] = conn
+    }
+
+    last := commands[len(commands) - 1]
+    for _, cmd := range commands[:len(commands) - 1] {
+        conn.Send(cmd.name, cmd.args...)
+    }
+    return conn.Do(last.name, last.args...)
+}
+
+// del drops keys one at a time, as they may be in different slots, and
+// returns how many there were.
+func (c *clusterConn) del(keys []interface{}) (interface{}, error) {
+    deleted := int64(0)
+    for _, key := range keys {
+        n, err := redis.Int64(c.Do("DEL", key))
+        if err != nil {
+            return nil, err
+        }
+        deleted += n
+    }
+    return deleted, nil
+}
+
+// scan scans every primary with the arguments of SCAN after its cursor,
+// and returns the keys found as a SCAN reply with a cursor of 0.
+func (c *clusterConn) scan(args []interface{}) (interface{}, error) {
+
+    keys := []interface{}{}
+    for _, addr := range c.cluster.primaries() {
+        for cursor := "0"; ; {
+            reply, err := redis.Values(c.do(addr, []clusterCommand{{"SCAN", append([]interface{}{cursor}, args[1:]...)}}))
+            if err != nil {
+                return nil, err
+            }
+            if len(reply) < 2 {
+                return nil, fmt.Errorf("redis: unexpected SCAN reply from %s", addr)
+            }
+
+            found, _ := redis.Values(reply[1], nil)
+            keys = append(keys, found...)
+            if cursor, _ = redis.String(reply[0], nil); cursor == "0" {
+                break
+            }
+        }
+    }
+    return []interface{}{[]byte("0"), keys}, nil
+}
+
+/* Helpers */
+
+// commandKey returns the key a command touches, and whether it touches
+// one.
+func commandKey(name string, args []interface{}) (string, bool) {
+    switch strings.ToUpper(name) {
+    case "PING", "MULTI", "EXEC", "DISCARD", "UNWATCH", "ROLE", "ASKING":
+        return "", false
+    case "EVAL", "EVALSHA":
+        // The script, the number of keys, then the keys
+        if len(args) < 3 {
+            return "", false
+        }
+        if n, _ := redis.Int(args[1], nil); n == 0 {
+            return "", false
+        }
+        return fmt.Sprint(redisString(args[2])), true
+    }
+
+    if len(args) == 0 {
+        return "", false
+    }
+    return fmt.Sprint(redisString(args[0])), true
+}
+
+// redisString returns arg as a string if it is bytes, and as it is
+// otherwise.
+func redisString(arg interface{}) interface{} {
+    if b, ok := arg.([]byte); ok {
+        return string(b)
+    }
+    return arg
+}
+
+// clusterSlot returns the slot of key: the CRC16 of key, or of the part of
+// it in the first braces if there is one, modulo CLUSTER_SLOTS.
+func clusterSlot(key string) int {
+    if i := strings.IndexByte(key, '{'); i >= 0 {
+        if j := strings.IndexByte(key[i + 1:], '}'); j > 0 {
+            key = key[i + 1:i + 1 + j]
+        }
+    }
+
+    // CRC16-CCITT (XMODEM), as Redis Cluster uses
+    crc := uint16(0)
+    for i := 0; i < len(key); i++ {
+        crc ^= uint16(key[i]) << 8
+        for b := 0; b < 8; b++ {
+            if crc & 0x8000 != 0 {
+                crc = crc << 1 ^ 0x1021
+            } else {
+                crc <<= 1
+            }
+        }
+    }
+    return int(crc) % CLUSTER_SLOTS
+}
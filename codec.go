@@ -0,0 +1,96 @@
+// codec.go contains how networks are encoded where they're kept: as the
+// JSON they're served as, or as gob, which takes less memory. A gob
+// network starts with a byte giving the version of its encoding, so an
+// older server can tell it can't decode a newer one's networks
+
+package main
+
+import (
+    "bytes"
+    "encoding/gob"
+    "encoding/json"
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/lkvnstrs/cumuli/networkmapper"
+)
+
+const (
+    NETWORK_GOB = 1 // the version byte of gob networks; versions are below ' ', so JSON never starts with one
+)
+
+// encodeNetwork returns js, a network's JSON, in the format of
+// network_format.
+func encodeNetwork(js []byte) ([]byte, error) {
+    if config.NetworkFormat != "gob" {
+        return js, nil
+    }
+
+    var result networkmapper.Result
+    if err := json.Unmarshal(js, &result); err != nil {
+        return nil, err
+    }
+
+    var buf bytes.Buffer
+    buf.WriteByte(NETWORK_GOB)
+    if err := gob.NewEncoder(&buf).Encode(&result); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+// decodeNetwork returns the JSON of value, a network encoded by
+// encodeNetwork in any format.
+func decodeNetwork(value []byte) ([]byte, error) {
+    if len(value) == 0 || value[0] >= ' ' {
+        return value, nil
+    }
+    if value[0] != NETWORK_GOB {
+        return nil, fmt.Errorf("unknown network encoding %d", value[0])
+    }
+
+    var result networkmapper.Result
+    if err := gob.NewDecoder(bytes.NewReader(value[1:])).Decode(&result); err != nil {
+        return nil, err
+    }
+
+    // Gob doesn't tell empty slices from nil ones, which JSON does
+    if result.Nodes == nil {
+        result.Nodes = []networkmapper.Node{}
+    }
+    if result.Links == nil {
+        result.Links = []networkmapper.Link{}
+    }
+    if s := result.Similarity; s != nil && s.Users == nil {
+        s.Users, s.Jaccard = []string{}, [][]float64{}
+    }
+    return json.Marshal(&result)
+}
+
+// getCachedNetwork returns the JSON of the network cached at cacheKey, and
+// whether there is one. A network in an encoding this server doesn't know,
+// as a newer one may have cached, counts as missing, so it's rebuilt.
+func getCachedNetwork(cacheKey string) ([]byte, bool, error) {
+    value, ok, err := cache.Get(cacheKey)
+    if err != nil || !ok {
+        return nil, ok, err
+    }
+
+    js, err := decodeNetwork(value)
+    if err != nil {
+        log.Printf("Couldn't decode the network cached at %s: %s", cacheKey, err)
+        return nil, false, nil
+    }
+    return js, true, nil
+}
+
+// setCachedNetwork caches js, a network's JSON, at cacheKey for ttl in the
+// format of network_format.
+func setCachedNetwork(cacheKey string, js []byte, ttl time.Duration) error {
+    value, err := encodeNetwork(js)
+    if err != nil {
+        return err
+    }
+    return cache.Set(cacheKey, value, ttl)
+}
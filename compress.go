@@ -0,0 +1,69 @@
+// compress.go contains the compression of cumuli's responses
+
+package main
+
+import (
+    "io"
+    "net/http"
+
+    "github.com/andybalholm/brotli"
+)
+
+// compressWriter is an http.ResponseWriter that compresses successful
+// responses with the best encoding the client accepts.
+type compressWriter struct {
+    http.ResponseWriter
+    r *http.Request
+    w io.WriteCloser
+    wroteHeader bool
+}
+
+// WriteHeader starts compressing the response if status is 200 OK and
+// nothing else has encoded it already.
+func (cw *compressWriter) WriteHeader(status int) {
+    if cw.wroteHeader {
+        return
+    }
+    cw.wroteHeader = true
+
+    // Leave partial, empty and already encoded responses alone
+    if status == http.StatusOK && cw.Header().Get("Content-Encoding") == "" {
+        cw.Header().Del("Content-Length")
+
+        // HTTPCompressor only says it varies by Accept-Encoding if nothing
+        // else has said what the response varies by
+        cw.Header().Add("Vary", "Accept-Encoding")
+        cw.w = brotli.HTTPCompressor(cw.ResponseWriter, cw.r)
+    }
+
+    cw.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes b to the response, compressing it if need be.
+func (cw *compressWriter) Write(b []byte) (int, error) {
+    if !cw.wroteHeader {
+        cw.WriteHeader(http.StatusOK)
+    }
+    if cw.w != nil {
+        return cw.w.Write(b)
+    }
+    return cw.ResponseWriter.Write(b)
+}
+
+// Close flushes the compressed response.
+func (cw *compressWriter) Close() error {
+    if cw.w != nil {
+        return cw.w.Close()
+    }
+    return nil
+}
+
+// Compress wraps h so its responses are compressed with Brotli, or with
+// gzip for clients that don't accept Brotli.
+func Compress(h http.HandlerFunc) http.HandlerFunc {
+    return func(rw http.ResponseWriter, r *http.Request) {
+        cw := &compressWriter{ResponseWriter: rw, r: r}
+        defer cw.Close()
+        h(cw, r)
+    }
+}
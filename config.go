@@ -0,0 +1,416 @@
+// config.go contains cumuli's configuration file
+
+package main
+
+import (
+    "encoding/json"
+    "log"
+    "os"
+    "strconv"
+)
+
+// A type for the configuration file named by CONFIG_FILE. Anything left
+// out of the file keeps its default from DefaultConfig.
+type Config struct {
+
+    // Cache-Control header values by route class: static, static_hashed,
+    // json, private and admin. An empty value sends no header.
+    CacheControl map[string]string `json:"cache_control"`
+
+    // The number of network builds to run at once, one of which is
+    // reserved for interactive builds if there's more than one.
+    BuildWorkers int `json:"build_workers"`
+
+    // The most interactive builds and background jobs that may wait for
+    // a worker before new ones are turned away.
+    MaxQueuedBuilds int `json:"max_queued_builds"`
+    MaxQueuedJobs int `json:"max_queued_jobs"`
+
+    // The seconds an interactive build may spend fetching before it gives
+    // up and sends a partial network of what it has, or -1 for no limit.
+    BuildDeadline int `json:"build_deadline"`
+
+    // A JSON file mapping the accounts of the same people on different
+    // sources, used when merging federated networks.
+    IdentityFile string `json:"identity_file"`
+
+    // How many followings to fetch per request from each source, up to
+    // the most it allows; page_size overrides it per request.
+    PageSizes map[string]int `json:"page_sizes"`
+
+    // The most times to try a request SoundCloud turns away with a 429 or
+    // 5xx, backing off in between; 1 disables retries.
+    MaxAttempts int `json:"max_attempts"`
+
+    // Deprecated: ignored, with a warning at startup, as a user's pages of
+    // followings are fetched one after another by cursor.
+    PageWorkers int `json:"page_workers"`
+
+    // The seconds to wait to connect to SoundCloud, and then for it to
+    // respond, before giving up on a request.
+    ConnectTimeout int `json:"connect_timeout"`
+    ReadTimeout int `json:"read_timeout"`
+
+    // The seconds any build may take before it fails, or -1 for no limit.
+    // Unlike build_deadline, this applies to background jobs too.
+    BuildTimeout int `json:"build_timeout"`
+
+    // The most users whose followings are kept in memory, and the seconds
+    // they're kept for; -1 turns the cache off.
+    FollowingsCacheSize int `json:"followings_cache_size"`
+    FollowingsCacheTTL int `json:"followings_cache_ttl"`
+
+    // Where built networks, followings and job results are cached: redis,
+    // by default, memory, keeping up to memory_cache_size of them, or bolt.
+    Cache string `json:"cache"`
+    MemoryCacheSize int `json:"memory_cache_size"`
+
+    // Where saved graphs, their snapshots and connected accounts' tokens
+    // are kept: redis, by default, bolt, the default when the cache isn't
+    // redis, or postgres, in the database at DATABASE_URL.
+    Store string `json:"store"`
+
+    // The most snapshots kept per saved graph, or -1 to keep every one.
+    MaxSnapshots int `json:"max_snapshots"`
+
+    // The seconds between rebuilding every saved graph, so its history
+    // grows even while nobody asks for it, on one instance only; -1 turns
+    // this off.
+    RecrawlInterval int `json:"recrawl_interval"`
+
+    // Whether every network built without options is saved as a graph, so
+    // its history can be looked up without saving it first.
+    SaveAllGraphs bool `json:"save_all_graphs"`
+
+    // How networks are kept in the cache and in the redis and bolt stores:
+    // json, as they're served, or gob, which takes less memory but has to
+    // be turned back into JSON every time one is served.
+    NetworkFormat string `json:"network_format"`
+
+    // The bbolt database file used by the bolt cache and store.
+    BoltPath string `json:"bolt_path"`
+
+    // The seconds users' followings are kept in the cache for, shared by every
+    // server; -1 turns this off.
+    FollowingsStoreTTL int `json:"followings_store_ttl"`
+
+    // The seconds built networks are kept in the cache for before they're
+    // built again.
+    ResultCacheTTL int `json:"result_cache_ttl"`
+
+    // The seconds a server may hold the lock on building a network before
+    // another may build it too, if it hasn't been cached by then; -1 lets
+    // every server build the same network at once.
+    BuildLockTTL int `json:"build_lock_ttl"`
+
+    // The seconds past result_cache_ttl a network is still served for while
+    // it's rebuilt in the background; -1 turns this off.
+    StaleTTL int `json:"stale_ttl"`
+
+    // The most bytes a value may take in the cache, once it has been
+    // compressed, so a few prolific users' networks can't crowd out the
+    // rest; -1 for no limit.
+    MaxCacheEntrySize int `json:"max_cache_entry_size"`
+
+    // The fewest bytes a value must take to be gzipped in the cache, as
+    // smaller ones gain too little; -1 turns compression off.
+    CacheCompressMin int `json:"cache_compress_min"`
+
+    // The most pages of followings to keep with their ETags, so they're
+    // only fetched again if they've changed; -1 turns this off.
+    ETagCacheSize int `json:"etag_cache_size"`
+
+    // The most shared followings whose own followings are fetched when a
+    // network is built with depth=2.
+    MaxExpanded int `json:"max_expanded"`
+
+    // The most users a network may be built for at once; -1 for no limit.
+    MaxUsers int `json:"max_users"`
+
+    // The seconds results of /api/search are kept in the cache for.
+    SearchCacheTTL int `json:"search_cache_ttl"`
+
+    // The requests a second each client may make to /api/search, which
+    // calls SoundCloud every time it misses the cache; -1 for no limit.
+    SearchRPS int `json:"search_rps"`
+
+    // The seconds the suggestions of /api/autocomplete for a prefix are
+    // kept in the cache for.
+    AutocompleteCacheTTL int `json:"autocomplete_cache_ttl"`
+
+    // The requests a second each client may make to /api/autocomplete;
+    // -1 for no limit.
+    AutocompleteRPS int `json:"autocomplete_rps"`
+
+    // The proxies, as IPs or CIDRs, whose X-Forwarded-For is believed when
+    // telling clients apart for their rate limits. None are by default, so
+    // clients are told apart by the address they connect from; 0.0.0.0/0
+    // trusts any, for a router only it can reach cumuli through.
+    TrustedProxies []string `json:"trusted_proxies"`
+
+    // Whether a build fails when some followings couldn't be fetched,
+    // rather than sending the network without them, listed in its meta.
+    StrictBuilds bool `json:"strict_builds"`
+
+    // Whether to offer Mastodon as a source. It's off by default, as every
+    // handle asked for sends requests to the instance it names.
+    Mastodon bool `json:"mastodon"`
+
+    // The CDN to purge when networks change, if there is one.
+    CDN CDNConfig `json:"cdn"`
+
+    // How to reach Redis, if it isn't just the server at REDISTOGO_URL.
+    Redis RedisConfig `json:"redis"`
+}
+
+// A type for the configuration of the Redis deployment. Its password is
+// still the one in REDISTOGO_URL.
+type RedisConfig struct {
+
+    // single, the server at REDISTOGO_URL, sentinel or cluster
+    Mode string `json:"mode"`
+
+    // The sentinels, or the cluster nodes the rest are discovered from,
+    // as host:port
+    Addrs []string `json:"addrs"`
+
+    // The name the sentinels know the primary by
+    MasterName string `json:"master_name"`
+}
+
+// A type for the configuration of the CDN in front of cumuli.
+type CDNConfig struct {
+
+    // fastly or cloudflare
+    Provider string `json:"provider"`
+
+    // The Fastly service or Cloudflare zone to purge
+    ServiceId string `json:"service_id"`
+    ZoneId string `json:"zone_id"`
+
+    // An API token allowed to purge it
+    Token string `json:"token"`
+}
+
+// DefaultConfig returns the configuration used when there is no
+// configuration file.
+func DefaultConfig() *Config {
+    return &Config{
+        CacheControl: map[string]string{
+            "static": "public, max-age=3600",
+            "static_hashed": "public, max-age=31536000, immutable",
+            "json": "public, max-age=60, stale-while-revalidate=300",
+            "private": "no-store",
+            "admin": "no-store",
+        },
+        BuildWorkers: 4,
+        MaxQueuedBuilds: 16,
+        MaxQueuedJobs: 100,
+        BuildDeadline: 25,
+        MaxAttempts: 4,
+        ConnectTimeout: 10,
+        ReadTimeout: 30,
+        BuildTimeout: -1,
+        FollowingsCacheSize: 1000,
+        FollowingsCacheTTL: 600,
+        Cache: "redis",
+        MemoryCacheSize: 10000,
+        Store: "redis",
+        MaxSnapshots: MAX_SNAPSHOTS,
+        RecrawlInterval: 86400,
+        NetworkFormat: "json",
+        BoltPath: "cumuli.db",
+        FollowingsStoreTTL: 3600,
+        ResultCacheTTL: EXPIRE_TIME,
+        BuildLockTTL: 120,
+        StaleTTL: 300,
+        MaxCacheEntrySize: 8 << 20,
+        CacheCompressMin: 1024,
+        ETagCacheSize: 10000,
+        MaxExpanded: 50,
+        MaxUsers: 50,
+        SearchCacheTTL: 60,
+        SearchRPS: 5,
+        AutocompleteCacheTTL: 600,
+        AutocompleteRPS: 10,
+        PageSizes: map[string]int{},
+    }
+}
+
+// LoadConfig reads the configuration file at path over DefaultConfig.
+func LoadConfig(path string) (*Config, error) {
+
+    config := DefaultConfig()
+
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    var file Config
+    if err = json.NewDecoder(f).Decode(&file); err != nil {
+        return nil, err
+    }
+
+    for class, value := range file.CacheControl {
+        config.CacheControl[class] = value
+    }
+    if file.BuildWorkers > 0 {
+        config.BuildWorkers = file.BuildWorkers
+    }
+    if file.MaxQueuedBuilds > 0 {
+        config.MaxQueuedBuilds = file.MaxQueuedBuilds
+    }
+    if file.MaxQueuedJobs > 0 {
+        config.MaxQueuedJobs = file.MaxQueuedJobs
+    }
+    if file.MaxAttempts > 0 {
+        config.MaxAttempts = file.MaxAttempts
+    }
+    if file.PageWorkers > 0 {
+        config.PageWorkers = file.PageWorkers
+    }
+    if file.ConnectTimeout > 0 {
+        config.ConnectTimeout = file.ConnectTimeout
+    }
+    if file.ReadTimeout > 0 {
+        config.ReadTimeout = file.ReadTimeout
+    }
+    if file.FollowingsCacheSize != 0 {
+        config.FollowingsCacheSize = file.FollowingsCacheSize
+    }
+    if file.FollowingsCacheTTL != 0 {
+        config.FollowingsCacheTTL = file.FollowingsCacheTTL
+    }
+    if file.Cache != "" {
+        config.Cache = file.Cache
+    }
+    if file.MemoryCacheSize > 0 {
+        config.MemoryCacheSize = file.MemoryCacheSize
+    }
+    if file.Store != "" {
+        config.Store = file.Store
+    } else if config.Cache != "redis" {
+        config.Store = "bolt"
+    }
+    if file.MaxSnapshots != 0 {
+        config.MaxSnapshots = file.MaxSnapshots
+    }
+    if file.RecrawlInterval != 0 {
+        config.RecrawlInterval = file.RecrawlInterval
+    }
+    config.SaveAllGraphs = file.SaveAllGraphs
+    if file.NetworkFormat != "" {
+        config.NetworkFormat = file.NetworkFormat
+    }
+    if file.BoltPath != "" {
+        config.BoltPath = file.BoltPath
+    }
+    if file.FollowingsStoreTTL != 0 {
+        config.FollowingsStoreTTL = file.FollowingsStoreTTL
+    }
+    if file.ResultCacheTTL > 0 {
+        config.ResultCacheTTL = file.ResultCacheTTL
+    }
+    if file.BuildLockTTL != 0 {
+        config.BuildLockTTL = file.BuildLockTTL
+    }
+    if file.StaleTTL != 0 {
+        config.StaleTTL = file.StaleTTL
+    }
+    if file.MaxCacheEntrySize != 0 {
+        config.MaxCacheEntrySize = file.MaxCacheEntrySize
+    }
+    if file.CacheCompressMin != 0 {
+        config.CacheCompressMin = file.CacheCompressMin
+    }
+    if file.ETagCacheSize != 0 {
+        config.ETagCacheSize = file.ETagCacheSize
+    }
+    if file.MaxExpanded > 0 {
+        config.MaxExpanded = file.MaxExpanded
+    }
+    if file.MaxUsers != 0 {
+        config.MaxUsers = file.MaxUsers
+    }
+    if file.SearchCacheTTL > 0 {
+        config.SearchCacheTTL = file.SearchCacheTTL
+    }
+    if file.SearchRPS != 0 {
+        config.SearchRPS = file.SearchRPS
+    }
+    if file.AutocompleteCacheTTL > 0 {
+        config.AutocompleteCacheTTL = file.AutocompleteCacheTTL
+    }
+    if file.AutocompleteRPS != 0 {
+        config.AutocompleteRPS = file.AutocompleteRPS
+    }
+    if file.BuildTimeout != 0 {
+        config.BuildTimeout = file.BuildTimeout
+    }
+    if file.BuildDeadline != 0 {
+        config.BuildDeadline = file.BuildDeadline
+    }
+    if len(file.TrustedProxies) > 0 {
+        config.TrustedProxies = file.TrustedProxies
+    }
+    config.StrictBuilds = file.StrictBuilds
+    config.Mastodon = file.Mastodon
+    config.IdentityFile = file.IdentityFile
+    config.CDN = file.CDN
+    config.Redis = file.Redis
+    for source, size := range file.PageSizes {
+        config.PageSizes[source] = size
+    }
+
+    return config, nil
+}
+
+// GetConfig loads the configuration file named by the CONFIG_FILE env, or
+// DefaultConfig if it isn't set, with the envs of configEnvs over it.
+func GetConfig() *Config {
+
+    config := DefaultConfig()
+    if path := os.Getenv("CONFIG_FILE"); path != "" {
+        var err error
+        if config, err = LoadConfig(path); err != nil {
+            log.Fatal("Couldn't load CONFIG_FILE: ", err)
+        }
+    }
+
+    for env, field := range configEnvs {
+        value := os.Getenv(env)
+        if value == "" {
+            continue
+        }
+
+        n, err := strconv.Atoi(value)
+        if err != nil || n == 0 || n < -1 {
+            log.Fatal(env + " must be a positive number, or -1")
+        }
+        *field(config) = n
+    }
+    if config.ResultCacheTTL < 0 {
+        log.Fatal("RESULT_CACHE_TTL must be a positive number")
+    }
+    return config
+}
+
+// UsesRedis returns whether c keeps anything in Redis, without which there
+// is no Redis to connect to.
+func (c *Config) UsesRedis() bool {
+    return c.Cache == "redis" || c.Store == "redis"
+}
+
+// configEnvs maps the envs that override the configuration file, so a
+// deployment can tune how fresh its networks are without one, to the
+// settings they override.
+var configEnvs = map[string]func(*Config) *int{
+    "FOLLOWINGS_CACHE_TTL": func(c *Config) *int { return &c.FollowingsCacheTTL },
+    "FOLLOWINGS_STORE_TTL": func(c *Config) *int { return &c.FollowingsStoreTTL },
+    "RESULT_CACHE_TTL": func(c *Config) *int { return &c.ResultCacheTTL },
+    "STALE_TTL": func(c *Config) *int { return &c.StaleTTL },
+    "MAX_CACHE_ENTRY_SIZE": func(c *Config) *int { return &c.MaxCacheEntrySize },
+    "CACHE_COMPRESS_MIN": func(c *Config) *int { return &c.CacheCompressMin },
+}
@@ -0,0 +1,126 @@
+// connect.go contains the connecting of users' accounts on sources that
+// only tell a user who they follow, such as Spotify, and the keeping of
+// their tokens
+
+package main
+
+import (
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+
+    "github.com/garyburd/redigo/redis"
+    "github.com/lkvnstrs/cumuli/networkmapper"
+)
+
+const CONNECT_STATE_COOKIE = "connect_state"
+
+// NewTokenStore creates where the refresh tokens of source's connected
+// accounts are kept: with the saved graphs of store, so they're in
+// whichever of Redis, bbolt or Postgres is configured.
+func NewTokenStore(store GraphStore, source string) networkmapper.SpotifyTokens {
+    switch s := store.(type) {
+    case boltGraphs:
+        return boltTokens{s.db, source}
+    case *postgresGraphs:
+        return postgresTokens{s.db, source}
+    }
+    return redisTokens{source}
+}
+
+// redisTokens keeps the refresh tokens of a source's connected accounts
+// in Redis.
+type redisTokens struct {
+    source string
+}
+
+// GetRefreshToken returns the refresh token of user, or "" if they
+// haven't connected their account.
+func (t redisTokens) GetRefreshToken(user string) (string, error) {
+    conn := pool.Get()
+    defer conn.Close()
+
+    token, err := redis.String(conn.Do("GET", t.key(user)))
+    if err == redis.ErrNil {
+        return "", nil
+    }
+    return token, err
+}
+
+// PutRefreshToken replaces the refresh token of user.
+func (t redisTokens) PutRefreshToken(user, token string) error {
+    conn := pool.Get()
+    defer conn.Close()
+
+    _, err := conn.Do("SET", t.key(user), token)
+    return err
+}
+
+// key returns the Redis key holding user's refresh token.
+func (t redisTokens) key(user string) string {
+    return "token:" + t.source + ":" + user
+}
+
+// ConnectHandler handles the route '/connect/'. '/connect/{source}' sends
+// the user to connect their account on source, which sends them back to
+// '/connect/{source}/callback', from where they're shown their network.
+func ConnectHandler(rw http.ResponseWriter, r *http.Request) {
+
+    parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/connect/"), "/"), "/")
+    connector, ok := sources[parts[0]].(networkmapper.Connector)
+    if !ok || len(parts) > 2 || (len(parts) == 2 && parts[1] != "callback") {
+        http.NotFound(rw, r)
+        return
+    }
+    redirectURI := GetConnectRedirectURI(r, parts[0])
+
+    // Send the user off, remembering the state to expect back
+    if len(parts) == 1 {
+        state, err := newId()
+        if err != nil {
+            http.Error(rw, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        http.SetCookie(rw, &http.Cookie{Name: CONNECT_STATE_COOKIE, Value: state, Path: "/connect/",
+            MaxAge: 600, HttpOnly: true, SameSite: http.SameSiteLaxMode})
+        http.Redirect(rw, r, connector.AuthorizeURL(redirectURI, state), http.StatusFound)
+        return
+    }
+
+    cookie, err := r.Cookie(CONNECT_STATE_COOKIE)
+    if err != nil || cookie.Value != r.URL.Query().Get("state") {
+        http.Error(rw, "the connection expired, try again", http.StatusBadRequest)
+        return
+    }
+    http.SetCookie(rw, &http.Cookie{Name: CONNECT_STATE_COOKIE, Path: "/connect/", MaxAge: -1})
+
+    if e := r.URL.Query().Get("error"); e != "" {
+        http.Error(rw, "couldn't connect: " + e, http.StatusForbidden)
+        return
+    }
+
+    user, err := connector.Connect(r.Context(), r.URL.Query().Get("code"), redirectURI)
+    if err != nil {
+        http.Error(rw, err.Error(), http.StatusBadGateway)
+        return
+    }
+
+    http.Redirect(rw, r, "/u/" + url.PathEscape(user) + "?" + url.Values{"source": {parts[0]}}.Encode(), http.StatusFound)
+}
+
+// GetConnectRedirectURI gets where source sends users back to once they've
+// connected their accounts, under the PUBLIC_URL env if it's set, since
+// sources only send them back to URLs registered with them, and the host
+// of r otherwise.
+func GetConnectRedirectURI(r *http.Request, source string) string {
+    base := strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+    if base == "" {
+        scheme := "http"
+        if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+            scheme = "https"
+        }
+        base = scheme + "://" + r.Host
+    }
+    return base + "/connect/" + source + "/callback"
+}
@@ -3,11 +3,35 @@
 package main
 
 import (
+    "errors"
+    "fmt"
+    "net"
     "time"
 
     "github.com/garyburd/redigo/redis"
 )
 
+// NewRedisPool creates the Redis pool configured by c: of connections to
+// server, by default, to the primary of a Sentinel deployment, or to a
+// Redis Cluster, authenticating with password unless it is empty.
+func NewRedisPool(c RedisConfig, server, password string) (*redis.Pool, error) {
+    switch c.Mode {
+    case "", "single":
+        return NewPool(server, password), nil
+    case "sentinel":
+        if len(c.Addrs) == 0 || c.MasterName == "" {
+            return nil, errors.New("sentinel needs addrs and master_name")
+        }
+        return NewSentinelPool(c.Addrs, c.MasterName, password), nil
+    case "cluster":
+        if len(c.Addrs) == 0 {
+            return nil, errors.New("cluster needs addrs")
+        }
+        return NewClusterPool(c.Addrs, password), nil
+    }
+    return nil, fmt.Errorf("unknown redis mode %q", c.Mode)
+}
+
 // NewPool creates a new Redis pool from the given server and password.
 func NewPool(server, password string) *redis.Pool {
 
@@ -29,19 +53,76 @@ func NewPool(server, password string) *redis.Pool {
         MaxIdle: 3,
         IdleTimeout: 240 * time.Second,
         Dial: func () (redis.Conn, error) {
-            c, err := redis.Dial("tcp", server)
+            return dialRedis(server, password)
+        },
+        TestOnBorrow: func(c redis.Conn, t time.Time) error {
+            _, err := c.Do("PING")
+            return err
+        },
+    }
+}
+
+// NewSentinelPool creates a Redis pool of connections to the primary the
+// sentinels at addrs know as name. The sentinels are asked for it every
+// time a connection is made, and connections to a primary that has since
+// been demoted are dropped, so the pool follows it through a failover.
+func NewSentinelPool(addrs []string, name, password string) *redis.Pool {
+    return &redis.Pool{
+        MaxIdle: 3,
+        IdleTimeout: 240 * time.Second,
+        Dial: func () (redis.Conn, error) {
+            server, err := sentinelPrimary(addrs, name)
             if err != nil {
                 return nil, err
             }
-            if _, err := c.Do("AUTH", password); err != nil {
-                c.Close()
-                return nil, err
-            }
-            return c, err
+            return dialRedis(server, password)
         },
         TestOnBorrow: func(c redis.Conn, t time.Time) error {
-            _, err := c.Do("PING")
-            return err
+            role, err := redis.Values(c.Do("ROLE"))
+            if err != nil {
+                return err
+            }
+            if r, _ := redis.String(role[0], nil); r != "master" {
+                return errors.New("redis: no longer the primary")
+            }
+            return nil
         },
     }
-}
\ No newline at end of file
+}
+
+/* Helpers */
+
+// dialRedis connects to the Redis server at addr, authenticating with
+// password unless it is empty.
+func dialRedis(addr, password string) (redis.Conn, error) {
+    c, err := redis.Dial("tcp", addr)
+    if err != nil || password == "" {
+        return c, err
+    }
+    if _, err := c.Do("AUTH", password); err != nil {
+        c.Close()
+        return nil, err
+    }
+    return c, nil
+}
+
+// sentinelPrimary asks the sentinels at addrs in turn for the address of
+// the primary they know as name.
+func sentinelPrimary(addrs []string, name string) (string, error) {
+
+    var err error
+    for _, addr := range addrs {
+        var c redis.Conn
+        if c, err = redis.DialTimeout("tcp", addr, time.Second, time.Second, time.Second); err != nil {
+            continue
+        }
+
+        var primary []string
+        primary, err = redis.Strings(c.Do("SENTINEL", "get-master-addr-by-name", name))
+        c.Close()
+        if err == nil && len(primary) == 2 {
+            return net.JoinHostPort(primary[0], primary[1]), nil
+        }
+    }
+    return "", fmt.Errorf("redis: no sentinel knows the primary %s: %v", name, err)
+}
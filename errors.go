@@ -0,0 +1,113 @@
+// errors.go contains the errors cumuli's JSON routes respond with, each an
+// envelope of a code for programs and a message for people
+
+package main
+
+import (
+    "context"
+    "log"
+    "math"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/lkvnstrs/cumuli/networkmapper"
+)
+
+// A type for the body of every error response from a JSON route.
+type ErrorResponse struct {
+    Error APIError `json:"error"`
+}
+
+// A type for what went wrong.
+type APIError struct {
+    Code string `json:"code" doc:"What went wrong, for programs: bad_request, invalid_username, unauthorized, not_connected, not_found, unknown_user, method_not_allowed, conflict, rate_limited, internal, source_error, queue_full, source_unavailable or timeout"`
+    Message string `json:"message" doc:"What went wrong, for people"`
+    Details interface{} `json:"details,omitempty" doc:"More about what went wrong, depending on the code"`
+}
+
+// renderError writes an error response with status, code, message and,
+// unless they're nil, details.
+func renderError(rw http.ResponseWriter, status int, code, message string, details interface{}) {
+    renderJSON(rw, status, ErrorResponse{APIError{Code: code, Message: message, Details: details}})
+}
+
+// renderBadRequest writes a 400 saying what's wrong with the request.
+func renderBadRequest(rw http.ResponseWriter, message string) {
+    renderError(rw, http.StatusBadRequest, "bad_request", message, nil)
+}
+
+// renderInvalidUsername writes a 400 saying user isn't a valid username.
+func renderInvalidUsername(rw http.ResponseWriter, user string) {
+    renderError(rw, http.StatusBadRequest, "invalid_username", "invalid username " + strconv.Quote(user), map[string]string{"user": user})
+}
+
+// renderUsersError writes err from reading the users of a request.
+func renderUsersError(rw http.ResponseWriter, err error) {
+    if e, ok := err.(*InvalidUsernameError); ok {
+        renderInvalidUsername(rw, e.User)
+        return
+    }
+    renderBadRequest(rw, err.Error())
+}
+
+// renderNotFound writes a 404 saying what wasn't found.
+func renderNotFound(rw http.ResponseWriter, message string) {
+    renderError(rw, http.StatusNotFound, "not_found", message, nil)
+}
+
+// renderMethodNotAllowed writes a 405 saying allow is the method to use.
+func renderMethodNotAllowed(rw http.ResponseWriter, allow string) {
+    rw.Header().Set("Allow", allow)
+    renderError(rw, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+}
+
+// renderInternalError logs err and writes a 500 with it.
+func renderInternalError(rw http.ResponseWriter, err error) {
+    log.Println("ERROR: " + err.Error())
+    renderError(rw, http.StatusInternalServerError, "internal", err.Error(), nil)
+}
+
+// renderBuildError writes err from building a network, telling the client
+// when to retry if the build queue was full or the source is down.
+func renderBuildError(rw http.ResponseWriter, err error) {
+    switch e := err.(type) {
+    case *QueueFullError:
+        setRetryAfter(rw, e.RetryAfter)
+        renderError(rw, http.StatusServiceUnavailable, "queue_full", err.Error(), nil)
+
+    case *networkmapper.UnavailableError:
+        setRetryAfter(rw, e.RetryAfter)
+        renderError(rw, http.StatusServiceUnavailable, "source_unavailable", err.Error(), map[string]string{"source": e.Source})
+
+    case *networkmapper.FetchError:
+        switch {
+        case e.UnknownUser():
+            renderError(rw, http.StatusNotFound, "unknown_user", "no user named " + e.User, e)
+        case e.Status == http.StatusTooManyRequests:
+            renderError(rw, http.StatusTooManyRequests, "rate_limited", err.Error(), e)
+        default:
+            renderError(rw, http.StatusBadGateway, "source_error", err.Error(), e)
+        }
+
+    case *networkmapper.SourceError:
+        renderError(rw, http.StatusBadGateway, "source_error", err.Error(), map[string]string{"source": e.Source})
+
+    case *networkmapper.NotConnectedError:
+        renderError(rw, http.StatusForbidden, "not_connected", err.Error(), map[string]string{"user": e.User})
+
+    default:
+        if err == context.DeadlineExceeded {
+            renderError(rw, http.StatusGatewayTimeout, "timeout", "building the network took too long", nil)
+            return
+        }
+        renderInternalError(rw, err)
+    }
+}
+
+/* Helpers */
+
+// setRetryAfter tells the client to retry after d, in whole seconds.
+func setRetryAfter(rw http.ResponseWriter, d time.Duration) {
+    rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+}
@@ -0,0 +1,360 @@
+// graphs.go contains saved graphs, whose networks are kept as a dated
+// snapshot every time they're rebuilt, so how a network evolved can be
+// replayed on a timeline
+
+package main
+
+import (
+    "context"
+    "crypto/sha1"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/lkvnstrs/cumuli/networkmapper"
+)
+
+const (
+    MAX_SNAPSHOTS = 100 // kept per graph unless max_snapshots says otherwise, oldest dropped first
+    DATE_FORMAT = "2006-01-02" // of dates given for timestamps
+)
+
+// A type for a saved graph.
+type Graph struct {
+    Id string `json:"id"`
+    Source string `json:"source"`
+    Users []string `json:"users"`
+    Ids []int64 `json:"ids,omitempty" doc:"The users' ids, by which they can still be found if they're renamed"`
+    Saved time.Time `json:"saved"`
+}
+
+// A type for the metadata of a snapshot of a saved graph's network.
+type Snapshot struct {
+    At time.Time `json:"at" doc:"When the network was built"`
+    Timestamp int64 `json:"timestamp" doc:"At, in seconds since the epoch, for /g/{id}/at/{timestamp}"`
+    Nodes int `json:"nodes"`
+    Links int `json:"links"`
+    Partial bool `json:"partial,omitempty" doc:"Whether the build ran out of time"`
+}
+
+// GraphHandler handles the route '/g/'. Posting to it with the users and
+// source query parameters saves their graph. '/g/{id}' returns a saved
+// graph, '/g/{id}/history' its snapshots, newest first, optionally only
+// those from and to the timestamps of the from and to query parameters,
+// '/g/{id}/at/{timestamp}' the network as it was at timestamp, in seconds
+// since the epoch, RFC 3339 or a date, and '/g/{id}/diff/{from}/{to}' what
+// changed in it from one timestamp to another, or to its latest snapshot
+// if to is left out.
+func GraphHandler(rw http.ResponseWriter, r *http.Request) {
+
+    parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/g/"), "/"), "/")
+
+    if parts[0] == "" {
+        if r.Method != "POST" {
+            renderMethodNotAllowed(rw, "POST")
+            return
+        }
+        saveGraph(rw, r)
+        return
+    }
+
+    graph, err := GetGraph(parts[0])
+    if err != nil {
+        renderInternalError(rw, err)
+        return
+    }
+    if graph == nil {
+        renderNotFound(rw, "no graph " + parts[0])
+        return
+    }
+
+    switch {
+    case len(parts) == 1:
+        renderJSON(rw, http.StatusOK, graph)
+
+    case len(parts) == 2 && parts[1] == "history":
+        from, to, err := historyRange(r)
+        if err != nil {
+            renderBadRequest(rw, err.Error())
+            return
+        }
+
+        history, err := GetHistory(graph.Id)
+        if err != nil {
+            renderInternalError(rw, err)
+            return
+        }
+
+        // Keep the snapshots taken in the range
+        kept := []Snapshot{}
+        for _, snapshot := range history {
+            if !snapshot.At.Before(from) && !snapshot.At.After(to) {
+                kept = append(kept, snapshot)
+            }
+        }
+        renderJSON(rw, http.StatusOK, kept)
+
+    case len(parts) == 3 && parts[1] == "at":
+        at, err := parseTimestamp(parts[2])
+        if err != nil {
+            renderBadRequest(rw, "timestamp must be seconds since the epoch, RFC 3339 or a date")
+            return
+        }
+
+        js, err := GetSnapshot(graph.Id, at)
+        if err != nil {
+            renderInternalError(rw, err)
+            return
+        }
+        if js == nil {
+            renderNotFound(rw, "no snapshot that old")
+            return
+        }
+
+        rw.Header().Set("Content-Type", "application/json")
+        rw.Write(js)
+
+    case (len(parts) == 3 || len(parts) == 4) && parts[1] == "diff":
+        times := []time.Time{{}, time.Now()}
+        for i, s := range parts[2:] {
+            if times[i], err = parseTimestamp(s); err != nil {
+                renderBadRequest(rw, "timestamps must be seconds since the epoch, RFC 3339 or dates")
+                return
+            }
+        }
+
+        results := make([]*networkmapper.Result, 2)
+        for i, at := range times {
+            js, err := GetSnapshot(graph.Id, at)
+            if err != nil {
+                renderInternalError(rw, err)
+                return
+            }
+            if js == nil {
+                renderNotFound(rw, "no snapshot that old")
+                return
+            }
+
+            results[i] = &networkmapper.Result{}
+            if err = json.Unmarshal(js, results[i]); err != nil {
+                renderInternalError(rw, err)
+                return
+            }
+        }
+
+        renderJSON(rw, http.StatusOK, networkmapper.Diff(results[0], results[1]))
+
+    default:
+        renderNotFound(rw, "not found")
+    }
+}
+
+// saveGraph saves the graph of the users and source of r, building its
+// network if it hasn't been, and renders it.
+func saveGraph(rw http.ResponseWriter, r *http.Request) {
+
+    source, err := getSource(r)
+    if err != nil {
+        renderBadRequest(rw, err.Error())
+        return
+    }
+
+    users, err := parseUsers(source, r.URL.Query().Get("users"), config.MaxUsers)
+    if err != nil {
+        renderUsersError(rw, err)
+        return
+    }
+    key := strings.Join(users, "+")
+
+    js, err := getNetworkMap(r.Context(), source, key, networkOptions{})
+    if err != nil {
+        renderBuildError(rw, err)
+        return
+    }
+
+    graph := &Graph{Id: graphId(source, key), Source: source, Users: users, Saved: time.Now().UTC()}
+
+    // Keep the users' ids, in the same order, if the source has them all
+    var result networkmapper.Result
+    if err = json.Unmarshal(js, &result); err != nil {
+        renderInternalError(rw, err)
+        return
+    }
+    for _, node := range result.Nodes {
+        if node.Group == 1 && node.Id != 0 {
+            graph.Ids = append(graph.Ids, node.Id)
+        }
+    }
+    if len(graph.Ids) != len(users) {
+        graph.Ids = nil
+    }
+
+    gjs, err := json.Marshal(graph)
+    if err != nil {
+        renderInternalError(rw, err)
+        return
+    }
+
+    // Keep the first save, so saving again changes nothing
+    if err = graphStore.AddGraph(graph, gjs); err != nil {
+        renderInternalError(rw, err)
+        return
+    }
+    if graph, err = GetGraph(graph.Id); err != nil {
+        renderInternalError(rw, err)
+        return
+    }
+
+    // Start the history with the network as it is, unless it's partial
+    if history, err := GetHistory(graph.Id); err == nil && len(history) == 0 && !isPartialNetwork(js) {
+        recordSnapshot(graph.Id, js)
+    }
+
+    rw.Header().Set("Location", "/g/" + graph.Id)
+    renderJSON(rw, http.StatusCreated, graph)
+}
+
+// GetGraph returns the saved graph with the given id, or nil if there is
+// no such graph.
+func GetGraph(id string) (*Graph, error) {
+    return graphStore.GetGraph(id)
+}
+
+// GetHistory returns the snapshots of the saved graph with the given id,
+// newest first.
+func GetHistory(id string) ([]Snapshot, error) {
+    return graphStore.GetHistory(id)
+}
+
+// GetSnapshot returns the network of the saved graph with the given id as
+// of its latest snapshot at or before at, or nil if there isn't one.
+func GetSnapshot(id string, at time.Time) ([]byte, error) {
+    return graphStore.GetSnapshot(id, at)
+}
+
+// snapshotIfSaved records js, a freshly built network for key, a '+'
+// separated list of users of source, if its graph has been saved, or saves
+// it first if every graph is to be saved.
+func snapshotIfSaved(source, key string, js []byte) {
+
+    id := graphId(source, key)
+    graph, err := GetGraph(id)
+    if err != nil {
+        return
+    }
+
+    if graph == nil {
+        if !config.SaveAllGraphs {
+            return
+        }
+        graph = &Graph{Id: id, Source: source, Users: strings.Split(key, "+"), Saved: time.Now().UTC()}
+        gjs, _ := json.Marshal(graph)
+        if err = graphStore.AddGraph(graph, gjs); err != nil {
+            log.Println("ERROR: Couldn't save graph " + id + ": " + err.Error())
+            return
+        }
+    }
+
+    recordSnapshot(id, js)
+}
+
+// recordSnapshot adds js as the newest snapshot of the saved graph with the
+// given id, dropping the oldest beyond max_snapshots.
+func recordSnapshot(id string, js []byte) {
+
+    var result networkmapper.Result
+    if err := json.Unmarshal(js, &result); err != nil {
+        log.Println("ERROR: Couldn't snapshot graph " + id + ": " + err.Error())
+        return
+    }
+
+    now := time.Now().UTC().Truncate(time.Second)
+    snapshot := Snapshot{
+        At: now,
+        Timestamp: now.Unix(),
+        Nodes: len(result.Nodes),
+        Links: len(result.Links),
+        Partial: result.Meta != nil && result.Meta.Partial,
+    }
+    if err := graphStore.AddSnapshot(id, snapshot, js, config.MaxSnapshots); err != nil {
+        log.Println("ERROR: Couldn't snapshot graph " + id + ": " + err.Error())
+    }
+}
+
+// recrawlGraphs rebuilds the network of every saved graph, which adds it
+// to the graph's history. A graph that can't be rebuilt is left for the
+// next time.
+func recrawlGraphs() {
+
+    ids, err := graphStore.ListGraphs()
+    if err != nil {
+        log.Println("ERROR: Couldn't list the saved graphs: " + err.Error())
+        return
+    }
+    log.Printf("INFO: Re-crawling %d saved graphs", len(ids))
+
+    for _, id := range ids {
+        graph, err := GetGraph(id)
+        if err != nil || graph == nil {
+            continue
+        }
+        if _, ok := sources[graph.Source]; !ok && !strings.Contains(graph.Source, ",") {
+            continue
+        }
+
+        key := strings.Join(graph.Users, "+")
+        if _, err = buildAndStoreNetworkMap(context.Background(), graph.Source, key, networkOptions{}); err != nil {
+            log.Println("ERROR: Couldn't re-crawl graph " + id + ": " + err.Error())
+            continue
+        }
+        purgeKeys([]string{graphSurrogateKey(graph.Source, key)})
+    }
+}
+
+/* Helpers */
+
+// graphId returns the id of the graph of key, a '+' separated list of
+// users of source.
+func graphId(source, key string) string {
+    sum := sha1.Sum([]byte(networkCacheKey(source, key, networkOptions{})))
+    return hex.EncodeToString(sum[:8])
+}
+
+// parseTimestamp parses seconds since the epoch, an RFC 3339 time or a
+// date, YYYY-MM-DD, which stands for the end of that day in UTC so the
+// snapshot as of a date is the last one taken on it.
+func parseTimestamp(s string) (time.Time, error) {
+    if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+        return time.Unix(secs, 0), nil
+    }
+    if day, err := time.Parse(DATE_FORMAT, s); err == nil {
+        return day.Add(24 * time.Hour - time.Second), nil
+    }
+    return time.Parse(time.RFC3339, s)
+}
+
+// historyRange returns the times of the from and to query parameters of
+// r, from being the start of its day if it's a date, or the start and end
+// of time if they're left out.
+func historyRange(r *http.Request) (from, to time.Time, err error) {
+
+    to = time.Now().Add(time.Hour)
+    if s := r.URL.Query().Get("from"); s != "" {
+        if day, err := time.Parse(DATE_FORMAT, s); err == nil {
+            from = day
+        } else if from, err = parseTimestamp(s); err != nil {
+            return from, to, errors.New("from must be seconds since the epoch, RFC 3339 or a date")
+        }
+    }
+    if s := r.URL.Query().Get("to"); s != "" {
+        if to, err = parseTimestamp(s); err != nil {
+            return from, to, errors.New("to must be seconds since the epoch, RFC 3339 or a date")
+        }
+    }
+    return from, to, nil
+}
@@ -3,18 +3,26 @@
 package main 
 
 import (
+    "encoding/json"
+    "errors"
     "html/template"
+    "io"
+    "math"
     "net/http"
+    "net/url"
     "path"
+    "strconv"
     "strings"
-    "time"
 
-    "github.com/garyburd/redigo/redis"
     "github.com/lkvnstrs/cumuli/networkmapper"
 )
 
 var templates map[string]*template.Template
-const EXPIRE_TIME = 60 // in seconds
+const (
+    EXPIRE_TIME = 60 // in seconds
+    MAX_UPLOAD_SIZE = 1 << 20 // in bytes
+    MAX_FOLLOWS_UPLOAD_SIZE = 32 << 20 // in bytes
+)
 
 // MainHandler handles the route '/'.
 func MainHandler(rw http.ResponseWriter, r *http.Request) {
@@ -30,6 +38,22 @@ func UserHandler(rw http.ResponseWriter, r *http.Request) {
     var jsonPath string = `/json/` + path.Base(r.URL.Path)
     jsonPath = strings.Trim(jsonPath, "+")
 
+    // Keep the source and any other options, drawing no more nodes than
+    // the browser can handle
+    query := r.URL.Query()
+    if query.Get("max_nodes") == "" {
+        query.Set("max_nodes", strconv.Itoa(MAX_VIEW_NODES))
+    }
+    jsonPath += "?" + query.Encode()
+
+    // Say so if a source is down rather than waiting on it
+    if source, err := getSource(r); err == nil {
+        if err = sourceUnavailable(source); err != nil {
+            renderUnavailable(rw, err.(*networkmapper.UnavailableError))
+            return
+        }
+    }
+
     // Render the page
     renderTemplate(rw, "index.html", jsonPath)
 }
@@ -42,52 +66,479 @@ func AboutHandler(rw http.ResponseWriter, r *http.Request) {
 }
 
 // JSONHandler handles the generation and display of JSON for D3 at the
-// the route '/json/'.
+// the route '/json/'. The network is sent as CSV, GraphML or GEXF instead
+// if the Accept header prefers one of them.
 func JSONHandler(rw http.ResponseWriter, r *http.Request) {
 
-    var js []byte
+    source, err := getSource(r)
+    if err != nil {
+        renderBadRequest(rw, err.Error())
+        return
+    }
 
-    conn := pool.Get()
-    defer conn.Close()
+    // Get the users, from the query or the path base
+    users, err := requestUsers(r, source, "/json/")
+    if err != nil {
+        renderUsersError(rw, err)
+        return
+    }
+    key := strings.Join(users, "+")
 
-    // Get the path base
-    key := path.Base(r.URL.Path)
+    compat := r.URL.Query().Get("compat")
+    if compat != "" && compat != "v0" {
+        renderBadRequest(rw, "unknown compat " + compat)
+        return
+    }
+
+    view := r.URL.Query().Get("view")
+    if view != "" && view != "bundle" {
+        renderBadRequest(rw, "unknown view " + view)
+        return
+    }
+
+    collapse := r.URL.Query().Get("collapse")
+    if collapse != "" && collapse != networkmapper.GroupCommunity && collapse != networkmapper.GroupGenre &&
+        collapse != networkmapper.GroupLabel {
+        renderBadRequest(rw, "unknown collapse " + collapse)
+        return
+    }
+
+    format := r.URL.Query().Get("format")
+    if format != "" && format != "d3v7" && format != "cytoscape" {
+        renderBadRequest(rw, "unknown format " + format)
+        return
+    }
+
+    given := 0
+    for _, v := range []string{compat, view, collapse, format} {
+        if v != "" {
+            given++
+        }
+    }
+    if given > 1 {
+        renderBadRequest(rw, "only one of compat, view, collapse and format may be given")
+        return
+    }
+
+    // Send the network as the Accept header asks, unless it's been asked
+    // for in one of the JSON shapes
+    rw.Header().Add("Vary", "Accept")
+    mediaType := "application/json"
+    if given == 0 {
+        mediaType = negotiateNetworkType(r)
+    }
+    table := r.URL.Query().Get("table")
+    if mediaType == "text/csv" && table != "" && table != "nodes" && table != "links" {
+        renderBadRequest(rw, "table must be nodes or links")
+        return
+    }
+
+    opts, err := getNetworkOptions(r, source)
+    if err != nil {
+        renderBadRequest(rw, err.Error())
+        return
+    }
+    if opts.MinShared > len(users) {
+        renderBadRequest(rw, "min_shared can be at most the number of users, " + strconv.Itoa(len(users)))
+        return
+    }
+
+    js, err := getNetworkMap(r.Context(), source, key, opts)
+    if err != nil {
+        renderBuildError(rw, err)
+        return
+    }
+    setSurrogateKeys(rw, networkSurrogateKeys(source, key))
+
+    // Send the whole network in any other format, as an export would be
+    if mediaType != "application/json" {
+        var result *networkmapper.Result
+        if err = json.Unmarshal(js, &result); err != nil {
+            renderInternalError(rw, err)
+            return
+        }
+
+        buf := networkmapper.GetBuffer()
+        defer networkmapper.PutBuffer(buf)
+        if err = writeNetwork(buf, reshapeNetwork(result, opts), mediaType, table); err != nil {
+            renderInternalError(rw, err)
+            return
+        }
+
+        rw.Header().Set("Content-Type", mediaType)
+        rw.Write(buf.Bytes())
+        return
+    }
+
+    // Transform the network if need be
+    if len(js) > maxResponseSize || given > 0 || opts.reshapes() {
+        var result *networkmapper.Result
+        if err = json.Unmarshal(js, &result); err != nil {
+            renderInternalError(rw, err)
+            return
+        }
+
+        result = reshapeNetwork(result, opts)
+
+        // Truncate networks too large to send, linking to the full export
+        if len(js) > maxResponseSize {
+            full := url.Values{"format": {"json"}}
+            if source != DEFAULT_SOURCE {
+                full.Set("source", source)
+            }
+            if opts.Weight != "" {
+                full.Set("weight", opts.Weight)
+            }
+
+            if opts.Relation != "" {
+                full.Set("relation", opts.Relation)
+            }
+            if opts.Playlists {
+                full.Set("playlists", "true")
+            }
+            if opts.Depth > 1 {
+                full.Set("depth", strconv.Itoa(opts.Depth))
+            }
+            if opts.Prune {
+                full.Set("prune", "true")
+            }
+            if opts.Directed {
+                full.Set("directed", "true")
+            }
+            if opts.MinShared > 0 {
+                full.Set("min_shared", strconv.Itoa(opts.MinShared))
+            }
+            if opts.Rank {
+                full.Set("rank", "true")
+            }
+            if opts.Top > 0 {
+                full.Set("top", strconv.Itoa(opts.Top))
+            }
+            if opts.MinDegree > 0 {
+                full.Set("min_degree", strconv.Itoa(opts.MinDegree))
+            }
+            if len(opts.Groups) > 0 {
+                groups := make([]string, len(opts.Groups))
+                for i, g := range opts.Groups {
+                    groups[i] = strconv.Itoa(g)
+                }
+                full.Set("groups", strings.Join(groups, ","))
+            }
+            if opts.Name != nil {
+                full.Set("name", opts.Name.String())
+            }
+            if opts.MinFollowers > 0 {
+                full.Set("min_followers", strconv.Itoa(opts.MinFollowers))
+            }
+            if opts.MaxFollowers > 0 {
+                full.Set("max_followers", strconv.Itoa(opts.MaxFollowers))
+            }
+            if opts.MaxNodes > 0 {
+                full.Set("max_nodes", strconv.Itoa(opts.MaxNodes))
+            }
+            if opts.Metadata {
+                full.Set("metadata", "true")
+            }
+            if opts.Group != "" {
+                full.Set("group", opts.Group)
+            }
+
+            result, err = result.Truncate(maxResponseSize, "/export/" + key + "?" + full.Encode())
+            if err != nil {
+                renderInternalError(rw, err)
+                return
+            }
+
+            // Truncating can leave nodes without links of their own
+            if opts.Prune && result.Meta != nil {
+                result = result.PruneOrphans()
+            }
+        }
+
+        var v interface{} = result
+        switch {
+        case view == "bundle":
+            // Precompute the hierarchical edge bundling data
+            v = result.Bundle()
+        case collapse != "":
+            // Collapse the network into super-nodes
+            if v, err = result.CollapseBy(collapse); err != nil {
+                renderInternalError(rw, err)
+                return
+            }
+        case compat == "v0":
+            // Convert to the original format
+            v = result.Legacy()
+        case format == "d3v7":
+            // Refer to nodes by name for d3-force v4 and later
+            v = result.Named()
+        case format == "cytoscape":
+            // Convert to Cytoscape.js elements
+            v = result.Cytoscape()
+        }
+
+        buf := networkmapper.GetBuffer()
+        defer networkmapper.PutBuffer(buf)
+        if err = networkmapper.EncodeJSON(buf, v); err != nil {
+            renderInternalError(rw, err)
+            return
+        }
+        js = buf.Bytes()
+    }
+
+    // Render the JSON
+    rw.Header().Set("Content-Type", "application/json")
+    rw.Write(js)
+}
+
+// ExportHandler handles exporting a network for download at the route
+// '/export/'. The format is chosen with the format query parameter: json
+// for the full D3 JSON, however large, parquet with table=nodes or
+// table=links, csv with either table or without for a zip of both,
+// graphml or dot.
+func ExportHandler(rw http.ResponseWriter, r *http.Request) {
+
+    query := r.URL.Query()
+
+    source, err := getSource(r)
+    if err != nil {
+        renderBadRequest(rw, err.Error())
+        return
+    }
+
+    // Get the users, from the query or the path base
+    users, err := requestUsers(r, source, "/export/")
+    if err != nil {
+        renderUsersError(rw, err)
+        return
+    }
+    key := strings.Join(users, "+")
+
+    opts, err := getNetworkOptions(r, source)
+    if err != nil {
+        renderBadRequest(rw, err.Error())
+        return
+    }
+
+    anonymize := false
+    if a := query.Get("anonymize"); a != "" {
+        if anonymize, err = strconv.ParseBool(a); err != nil {
+            renderBadRequest(rw, "anonymize must be true or false")
+            return
+        }
+    }
+
+    // Names hashed without a key could be found by hashing known names
+    if anonymize && len(anonymizeKey) == 0 {
+        renderBadRequest(rw, "anonymized exports are turned off, as there's no ANONYMIZE_KEY")
+        return
+    }
+
+    js, err := getNetworkMap(r.Context(), source, key, opts)
+    if err != nil {
+        renderBuildError(rw, err)
+        return
+    }
+    setSurrogateKeys(rw, networkSurrogateKeys(source, key))
+
+    var result *networkmapper.Result
+    if err = json.Unmarshal(js, &result); err != nil {
+        renderInternalError(rw, err)
+        return
+    }
+
+    result = reshapeNetwork(result, opts)
+
+    // Hide who's in the network for sharing it as a dataset
+    if anonymize {
+        result = result.Anonymize(anonymizeKey)
+    }
+
+    buf := networkmapper.GetBuffer()
+    defer networkmapper.PutBuffer(buf)
 
-    if key == "" {
+    switch query.Get("format") {
+    case "json":
+        if opts.reshapes() || anonymize {
+            err = networkmapper.EncodeJSON(buf, result)
+        } else {
+            buf.Write(js)
+        }
         rw.Header().Set("Content-Type", "application/json")
-        rw.Write([]byte{})
+        rw.Header().Set("Content-Disposition", `attachment; filename="network.json"`)
+    case "parquet":
+        table := query.Get("table")
+        switch table {
+        case "nodes":
+            err = networkmapper.WriteNodesParquet(buf, result)
+        case "links":
+            err = networkmapper.WriteLinksParquet(buf, result)
+        default:
+            renderBadRequest(rw, "table must be nodes or links")
+            return
+        }
+        rw.Header().Set("Content-Type", "application/vnd.apache.parquet")
+        rw.Header().Set("Content-Disposition", `attachment; filename="` + table + `.parquet"`)
+    case "graphml":
+        err = networkmapper.WriteGraphML(buf, result)
+        rw.Header().Set("Content-Type", "application/graphml+xml")
+        rw.Header().Set("Content-Disposition", `attachment; filename="network.graphml"`)
+    case "csv":
+        table := query.Get("table")
+        switch table {
+        case "nodes":
+            err = networkmapper.WriteNodesCSV(buf, result)
+        case "links":
+            table = "edges"
+            err = networkmapper.WriteEdgesCSV(buf, result)
+        case "":
+            err = networkmapper.WriteCSVZip(buf, result)
+            rw.Header().Set("Content-Type", "application/zip")
+            rw.Header().Set("Content-Disposition", `attachment; filename="network.zip"`)
+        default:
+            renderBadRequest(rw, "table must be nodes or links")
+            return
+        }
+        if table != "" {
+            rw.Header().Set("Content-Type", "text/csv")
+            rw.Header().Set("Content-Disposition", `attachment; filename="` + table + `.csv"`)
+        }
+    case "dot":
+        err = networkmapper.WriteDOT(buf, result)
+        rw.Header().Set("Content-Type", "text/vnd.graphviz")
+        rw.Header().Set("Content-Disposition", `attachment; filename="network.dot"`)
+    default:
+        renderBadRequest(rw, "unsupported export format")
+        return
     }
 
-    js, err := redis.Bytes(conn.Do("GET", key))
+    if err != nil {
+        rw.Header().Del("Content-Disposition")
+        renderInternalError(rw, err)
+        return
+    }
+
+    rw.Write(buf.Bytes())
+}
 
-    // Handle key doesn't exist
-    if err == redis.ErrNil {
+// UploadHandler handles the upload of a CSV or text file of usernames at
+// the route '/upload/', starting an asynchronous build of their network.
+// The file is read from the "file" form field, or from the request body
+// if the request isn't a multipart form.
+func UploadHandler(rw http.ResponseWriter, r *http.Request) {
 
-        users := strings.Split(key, "+")
+    if r.Method != "POST" {
+        renderMethodNotAllowed(rw, "POST")
+        return
+    }
 
-        js, err = networkmapper.BuildNetworkMap(n, users[0:])
+    r.Body = http.MaxBytesReader(rw, r.Body, MAX_UPLOAD_SIZE)
+
+    // Get the uploaded file
+    var body io.Reader = r.Body
+    if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+        file, _, err := r.FormFile("file")
         if err != nil {
-            http.Error(rw, err.Error(), http.StatusInternalServerError)
+            renderBadRequest(rw, err.Error())
             return
         }
+        defer file.Close()
+        body = file
+    }
+
+    source, err := getSource(r)
+    if err != nil {
+        renderBadRequest(rw, err.Error())
+        return
+    }
 
-        // Store the result
-         _, err = conn.Do("SET", key, js)
+    if strings.Contains(source, ",") {
+        renderBadRequest(rw, "uploads can only be built from one source")
+        return
+    }
+
+    users, err := ParseUsernames(body, source)
+    if err != nil {
+        renderBadRequest(rw, err.Error())
+        return
+    }
+
+    job, err := StartJob(r.Context(), source, networkOptions{}, users)
+    if err != nil {
+        renderBuildError(rw, err)
+        return
+    }
+
+    rw.Header().Set("Location", "/jobs/" + job.Id)
+    renderJSON(rw, http.StatusAccepted, job)
+}
+
+// OfflineHandler handles building a network from uploaded follow data at
+// the route '/offline/', without calling SoundCloud. The data is read like
+// in UploadHandler and is parsed as JSON if the format query parameter,
+// the content type or the file name says so, and as CSV otherwise. All of
+// the users in the data are compared unless a '+' separated users query
+// parameter picks some of them.
+func OfflineHandler(rw http.ResponseWriter, r *http.Request) {
+
+    if r.Method != "POST" {
+        renderMethodNotAllowed(rw, "POST")
+        return
+    }
+
+    r.Body = http.MaxBytesReader(rw, r.Body, MAX_FOLLOWS_UPLOAD_SIZE)
+
+    // Get the uploaded file and guess its format
+    var body io.Reader = r.Body
+    isJSON := strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+    if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+        file, header, err := r.FormFile("file")
         if err != nil {
-            http.Error(rw, err.Error(), http.StatusInternalServerError)
+            renderBadRequest(rw, err.Error())
             return
         }
+        defer file.Close()
+        body = file
+        isJSON = strings.HasSuffix(strings.ToLower(header.Filename), ".json")
+    }
 
-        go func (key string) {
-            time.Sleep(time.Second * EXPIRE_TIME)
-            conn := pool.Get()
-            defer conn.Close()
-            conn.Do("DEL", key)
-        } (key)
-        
+    switch r.URL.Query().Get("format") {
+    case "json":
+        isJSON = true
+    case "csv":
+        isJSON = false
+    }
 
-    } else if err != nil {
-        http.Error(rw, err.Error(), http.StatusInternalServerError)
+    var fs []networkmapper.Followings
+    var err error
+    if isJSON {
+        fs, err = networkmapper.ReadFollowingsJSON(body)
+    } else {
+        fs, err = networkmapper.ReadFollowingsCSV(body)
+    }
+    if err != nil {
+        renderBadRequest(rw, err.Error())
+        return
+    }
+
+    // Pick the users to compare
+    var users []string
+    if q := r.URL.Query().Get("users"); q != "" {
+        users, err = parseUsers("offline", q, config.MaxUsers)
+    } else {
+        for _, f := range fs {
+            users = append(users, f.Who)
+        }
+        users, err = checkUsers("offline", users, MAX_UPLOAD_USERS)
+    }
+    if err != nil {
+        renderUsersError(rw, err)
+        return
+    }
+
+    js, err := networkmapper.BuildNetworkMap(r.Context(), networkmapper.NewOfflineNetworkMapper(fs), users[0:])
+    if err != nil {
+        renderInternalError(rw, err)
         return
     }
 
@@ -96,6 +547,46 @@ func JSONHandler(rw http.ResponseWriter, r *http.Request) {
     rw.Write(js)
 }
 
+// JobHandler handles the status of asynchronous builds at the route
+// '/jobs/{id}' and their results at '/jobs/{id}/result', and the same
+// under '/api/jobs/'.
+func JobHandler(rw http.ResponseWriter, r *http.Request) {
+
+    parts := strings.Split(strings.Trim(strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api"), "/jobs/"), "/"), "/")
+
+    job, ok := GetJob(parts[0])
+    if !ok {
+        renderNotFound(rw, "no job " + parts[0])
+        return
+    }
+
+    switch {
+    case len(parts) == 1:
+        renderJSON(rw, http.StatusOK, job)
+
+    case len(parts) == 2 && parts[1] == "result":
+        if job.Status != JobDone {
+            renderError(rw, http.StatusConflict, "conflict", "job is " + job.Status, map[string]string{"status": job.Status})
+            return
+        }
+
+        js, ok, err := getCachedNetwork(jobResultKey(job.Id))
+        if err != nil {
+            renderInternalError(rw, err)
+            return
+        } else if !ok {
+            renderNotFound(rw, "the job's result has expired")
+            return
+        }
+
+        rw.Header().Set("Content-Type", "application/json")
+        rw.Write(js)
+
+    default:
+        renderNotFound(rw, "not found")
+    }
+}
+
 // StaticHandler handles the static assets of the app.
 func StaticHandler(rw http.ResponseWriter, r *http.Request) {
 
@@ -113,6 +604,67 @@ func StaticHandler(rw http.ResponseWriter, r *http.Request) {
 
 /* Helpers */
 
+// getSource returns the name of the source given by the source query
+// parameter of r, or its platform parameter, or DEFAULT_SOURCE if there is
+// neither. A ',' separated list of sources federates their networks into
+// one.
+func getSource(r *http.Request) (string, error) {
+    source := r.URL.Query().Get("source")
+    if source == "" {
+        source = r.URL.Query().Get("platform")
+    }
+    if source == "" {
+        return DEFAULT_SOURCE, nil
+    }
+
+    for _, s := range strings.Split(source, ",") {
+        if _, ok := sources[s]; !ok {
+            return "", errors.New("unknown source " + s)
+        }
+    }
+    return source, nil
+}
+
+// renderUnavailable renders the page saying a source is down.
+func renderUnavailable(rw http.ResponseWriter, ue *networkmapper.UnavailableError) {
+    retryAfter := int(math.Ceil(ue.RetryAfter.Seconds()))
+    rw.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+    rw.WriteHeader(http.StatusServiceUnavailable)
+
+    renderTemplate(rw, "unavailable.html", struct {
+        Source string
+        RetryAfter int
+    }{ue.Source, retryAfter})
+}
+
+// sourceUnavailable returns the *networkmapper.UnavailableError of the
+// first of source, a ',' separated list of sources, that's down, or nil.
+func sourceUnavailable(source string) error {
+    for _, s := range strings.Split(source, ",") {
+        if reporter, ok := sources[s].(networkmapper.AvailabilityReporter); ok {
+            if err := reporter.Available(); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// renderJSON writes v as JSON with the given status code.
+func renderJSON(rw http.ResponseWriter, status int, v interface{}) {
+    buf := networkmapper.GetBuffer()
+    defer networkmapper.PutBuffer(buf)
+
+    if err := networkmapper.EncodeJSON(buf, v); err != nil {
+        http.Error(rw, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    rw.Header().Set("Content-Type", "application/json")
+    rw.WriteHeader(status)
+    rw.Write(buf.Bytes())
+}
+
 // renderTemplate is used to avoid code repetition for calling the 
 func renderTemplate(rw http.ResponseWriter, filename string, data interface{}) {
     if err := templates[filename].ExecuteTemplate(rw, "base", data); err != nil {
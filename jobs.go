@@ -0,0 +1,435 @@
+// jobs.go contains cumuli's asynchronous network builds. With a Redis
+// cache, jobs are queued in Redis, where any server's build workers can
+// claim them and a job whose server goes down is queued again, and their
+// status is kept in the cache, so any server can report it. Otherwise
+// jobs are run by the build workers of the server they were started on
+
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "log"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/garyburd/redigo/redis"
+    "github.com/lkvnstrs/cumuli/networkmapper"
+)
+
+const (
+    JOB_EXPIRE_TIME = 60 * 60 // in seconds
+    JOB_CHUNK_SIZE = 25 // users fetched at a time
+    JOB_CLAIM_POLL = 5 * time.Second // a server waits for a queued job before looking again
+    JOB_CLAIM_TTL = 60 * time.Second // after which a claim that isn't renewed is queued again
+)
+
+// The Redis keys of the job queue, which share a Redis Cluster slot: the
+// queued jobs, those claimed by a server, and when each claim was renewed
+const (
+    jobQueueKey = "{jobs}:queue"
+    jobRunningKey = "{jobs}:running"
+    jobClaimsKey = "{jobs}:claims"
+)
+
+// requeueJob moves a claimed job back to the front of the queue, unless
+// it's been finished meanwhile.
+var requeueJob = redis.NewScript(3, `
+if redis.call("LREM", KEYS[1], 1, ARGV[1]) == 1 then
+    redis.call("RPUSH", KEYS[2], ARGV[1])
+end
+redis.call("ZREM", KEYS[3], ARGV[2])
+return 1
+`)
+
+// Job statuses.
+const (
+    JobQueued = "queued"
+    JobRunning = "running"
+    JobDone = "done"
+    JobFailed = "failed"
+)
+
+// A type for an asynchronous network build.
+type Job struct {
+    Id string `json:"id"`
+    Status string `json:"status"`
+    Users int `json:"users"`
+    Cost int `json:"cost"`
+    UsersDone int `json:"users_done" doc:"The users whose followings have been fetched"`
+    Followings int `json:"followings" doc:"The followings fetched so far"`
+    Error string `json:"error,omitempty"`
+    Result string `json:"result,omitempty" doc:"Where to get the network once the job is done"`
+}
+
+// A type for what a job builds, kept in the job queue until it's done.
+type jobSpec struct {
+    Id string `json:"id"`
+    Source string `json:"source"`
+    Users []string `json:"users"`
+    Relation string `json:"relation,omitempty"`
+    PageSize int `json:"page_size,omitempty"`
+    Cost int `json:"cost"`
+}
+
+// jobProgress is the networkmapper.Hooks keeping a job's progress.
+type jobProgress struct {
+    networkmapper.NopHooks
+    id string
+}
+
+// The jobs this server is running, until they're done
+var (
+    jobs = make(map[string]*Job)
+    jobsMu sync.Mutex
+)
+
+// StartJob queues a background build of the network for users of source
+// with opts and returns the new Job, or a *QueueFullError if there are too
+// many jobs waiting. Its cost is estimated within ctx, that of the request
+// starting it. The result is cached under jobResultKey.
+func StartJob(ctx context.Context, source string, opts networkOptions, users []string) (*Job, error) {
+
+    // Turn the job away before estimating its cost if there's no room
+    if err := jobsFull(); err != nil {
+        return nil, err
+    }
+
+    id, err := newId()
+    if err != nil {
+        return nil, err
+    }
+
+    m := networkMapperFor(source, opts)
+    cost := networkmapper.EstimateCost(ctx, m, users[0:])
+
+    job := &Job{Id: id, Status: JobQueued, Users: len(users), Cost: cost}
+    saveJob(*job)
+
+    spec := jobSpec{Id: id, Source: source, Users: users[0:], Relation: opts.Relation, PageSize: opts.PageSize, Cost: cost}
+    if sharedJobs() {
+        err = enqueueJob(spec)
+    } else {
+        err = runJob(spec, nil)
+    }
+    if err != nil {
+        cache.Delete(jobKey(id))
+        return nil, err
+    }
+
+    return job, nil
+}
+
+// runJob pushes the job of spec to this server's build workers, calling
+// done, if set, once it's finished.
+func runJob(spec jobSpec, done func()) error {
+
+    id := spec.Id
+    jobsMu.Lock()
+    jobs[id] = &Job{Id: id, Status: JobQueued, Users: len(spec.Users), Cost: spec.Cost}
+    jobsMu.Unlock()
+
+    err := builds.push(&build{
+        ctx: networkmapper.WithHooks(context.Background(), &jobProgress{id: id}),
+        mapper: networkMapperFor(spec.Source, networkOptions{Relation: spec.Relation, PageSize: spec.PageSize}),
+        users: spec.Users,
+        chunkSize: JOB_CHUNK_SIZE,
+        cost: spec.Cost,
+        start: func() {
+            setJobStatus(id, JobRunning, "")
+        },
+        finish: func(js []byte, err error) {
+            finishJob(id, js, err)
+            if done != nil {
+                done()
+            }
+        },
+    })
+    if err != nil {
+        jobsMu.Lock()
+        delete(jobs, id)
+        jobsMu.Unlock()
+    }
+    return err
+}
+
+// GetJob returns a copy of the Job with the given id, whichever server
+// it's running on, and false if there is no such job.
+func GetJob(id string) (Job, bool) {
+    jobsMu.Lock()
+    job, ok := jobs[id]
+    var running Job
+    if ok {
+        running = *job
+    }
+    jobsMu.Unlock()
+    if ok {
+        return running, true
+    }
+
+    js, ok, err := cache.Get(jobKey(id))
+    if err != nil || !ok {
+        return Job{}, false
+    }
+
+    var saved Job
+    if err = json.Unmarshal(js, &saved); err != nil {
+        return Job{}, false
+    }
+    return saved, true
+}
+
+// finishJob stores the result of the job with the given id.
+func finishJob(id string, js []byte, err error) {
+
+    if err == nil {
+        err = setCachedNetwork(jobResultKey(id), js, JOB_EXPIRE_TIME * time.Second)
+        if e, ok := err.(*EntryTooLargeError); ok {
+            err = fmt.Errorf("the network is %d bytes over max_cache_entry_size, too big to keep", e.Over)
+        }
+    }
+
+    if err != nil {
+        log.Println("ERROR: Job " + id + " failed: " + err.Error())
+        setJobStatus(id, JobFailed, err.Error())
+        return
+    }
+
+    setJobStatus(id, JobDone, "")
+}
+
+// setJobStatus updates the status of the job with the given id, leaving it
+// only in the cache once it's finished.
+func setJobStatus(id, status, errMsg string) {
+    jobsMu.Lock()
+    job, ok := jobs[id]
+    if !ok {
+        jobsMu.Unlock()
+        return
+    }
+    job.Status = status
+    job.Error = errMsg
+    if status == JobDone {
+        job.Result = "/jobs/" + id + "/result"
+    }
+    if status == JobDone || status == JobFailed {
+        delete(jobs, id)
+    }
+    saved := *job
+    jobsMu.Unlock()
+
+    saveJob(saved)
+}
+
+// saveJob keeps job in the cache, where every server can find it.
+func saveJob(job Job) {
+    js, err := json.Marshal(job)
+    if err != nil {
+        return
+    }
+    if err = cache.Set(jobKey(job.Id), js, JOB_EXPIRE_TIME * time.Second); err != nil {
+        log.Println("ERROR: Couldn't save job " + job.Id + ": " + err.Error())
+    }
+}
+
+// sharedJobs returns whether jobs are queued in Redis for any server to
+// run, which needs their status in a Redis cache too.
+func sharedJobs() bool {
+    return config.Cache == "redis"
+}
+
+// jobsFull returns a *QueueFullError if there's no room for another job.
+func jobsFull() error {
+    if !sharedJobs() {
+        return builds.full(false)
+    }
+
+    conn := pool.Get()
+    defer conn.Close()
+
+    queued, err := redis.Int(conn.Do("LLEN", jobQueueKey))
+    if err != nil {
+        return err
+    }
+    if queued >= config.MaxQueuedJobs {
+        return &QueueFullError{RetryAfter: builds.wait(queued)}
+    }
+    return nil
+}
+
+// enqueueJob adds the job of spec to the Redis job queue.
+func enqueueJob(spec jobSpec) error {
+
+    js, err := json.Marshal(spec)
+    if err != nil {
+        return err
+    }
+
+    conn := pool.Get()
+    defer conn.Close()
+
+    _, err = conn.Do("LPUSH", jobQueueKey, js)
+    return err
+}
+
+// claimJobs runs jobs from the Redis job queue on this server's build
+// workers forever, claiming one whenever none of its jobs are waiting for
+// a worker, so the jobs are spread over the servers with room for them.
+func claimJobs() {
+    for {
+        if !builds.idle() {
+            time.Sleep(JOB_CLAIM_POLL)
+            continue
+        }
+
+        if err := claimJob(); err != nil {
+            log.Println("ERROR: Couldn't claim a job: " + err.Error())
+            time.Sleep(JOB_CLAIM_POLL)
+        }
+    }
+}
+
+// claimJob waits up to JOB_CLAIM_POLL for a queued job, and runs it while
+// renewing its claim every JOB_CLAIM_TTL / 3, releasing it once it's done.
+func claimJob() error {
+
+    conn := pool.Get()
+    defer conn.Close()
+
+    js, err := redis.String(conn.Do("BRPOPLPUSH", jobQueueKey, jobRunningKey, int(JOB_CLAIM_POLL / time.Second)))
+    if err == redis.ErrNil {
+        return nil
+    } else if err != nil {
+        return err
+    }
+
+    var spec jobSpec
+    if err = json.Unmarshal([]byte(js), &spec); err != nil {
+        conn.Do("LREM", jobRunningKey, 1, js)
+        return err
+    }
+    if _, err = conn.Do("ZADD", jobClaimsKey, time.Now().Unix(), spec.Id); err != nil {
+        return err
+    }
+
+    done := make(chan struct{})
+    go func () {
+        ticker := time.NewTicker(JOB_CLAIM_TTL / 3)
+        defer ticker.Stop()
+
+        for {
+            select {
+            case <-done:
+                return
+            case <-ticker.C:
+                conn := pool.Get()
+                if _, err := conn.Do("ZADD", jobClaimsKey, time.Now().Unix(), spec.Id); err != nil {
+                    log.Println("ERROR: Couldn't renew the claim on job " + spec.Id + ": " + err.Error())
+                }
+                conn.Close()
+            }
+        }
+    } ()
+
+    err = runJob(spec, func() {
+        close(done)
+
+        conn := pool.Get()
+        defer conn.Close()
+        conn.Do("LREM", jobRunningKey, 1, js)
+        conn.Do("ZREM", jobClaimsKey, spec.Id)
+    })
+    if err != nil {
+        close(done)
+        requeueJob.Do(conn, jobRunningKey, jobQueueKey, jobClaimsKey, js, spec.Id)
+    }
+    return err
+}
+
+// requeueStaleJobs queues again the claimed jobs whose claims haven't been
+// renewed for JOB_CLAIM_TTL, as their servers have gone down. A claimed
+// job without a claim, as it has for a moment after it's taken, is given
+// one, so it's queued again if it still isn't renewed by then.
+func requeueStaleJobs() {
+
+    conn := pool.Get()
+    defer conn.Close()
+
+    claimed, err := redis.Strings(conn.Do("LRANGE", jobRunningKey, 0, -1))
+    if err != nil {
+        log.Println("ERROR: Couldn't list the claimed jobs: " + err.Error())
+        return
+    }
+
+    now := time.Now().Unix()
+    for _, js := range claimed {
+        var spec jobSpec
+        if err = json.Unmarshal([]byte(js), &spec); err != nil {
+            conn.Do("LREM", jobRunningKey, 1, js)
+            continue
+        }
+
+        renewed, err := redis.Int64(conn.Do("ZSCORE", jobClaimsKey, spec.Id))
+        if err == redis.ErrNil {
+            conn.Do("ZADD", jobClaimsKey, "NX", now, spec.Id)
+            continue
+        } else if err != nil || now - renewed < int64(JOB_CLAIM_TTL / time.Second) {
+            continue
+        }
+
+        if _, err = requeueJob.Do(conn, jobRunningKey, jobQueueKey, jobClaimsKey, js, spec.Id); err != nil {
+            log.Println("ERROR: Couldn't queue job " + spec.Id + " again: " + err.Error())
+            continue
+        }
+        log.Println("INFO: Queued job " + spec.Id + " again, its server stopped renewing its claim")
+        saveJob(Job{Id: spec.Id, Status: JobQueued, Users: len(spec.Users), Cost: spec.Cost})
+    }
+}
+
+// OnUserDone counts the users whose followings have been fetched, and
+// their followings.
+func (p *jobProgress) OnUserDone(user string, followings int, err error) {
+    jobsMu.Lock()
+    job, ok := jobs[p.id]
+    if !ok {
+        jobsMu.Unlock()
+        return
+    }
+    job.UsersDone++
+    job.Followings += followings
+    saved := *job
+    jobsMu.Unlock()
+
+    saveJob(saved)
+}
+
+// OnBuildDone logs how long the job's build took.
+func (p *jobProgress) OnBuildDone(users int, elapsed time.Duration, err error) {
+    if err == nil {
+        log.Println("INFO: Job " + p.id + " built the network of " + strconv.Itoa(users) +
+            " users in " + elapsed.Round(time.Millisecond).String())
+    }
+}
+
+// jobKey returns the cache key holding the status of a job.
+func jobKey(id string) string {
+    return "job:" + id + ":status"
+}
+
+// jobResultKey returns the cache key holding the result of a job.
+func jobResultKey(id string) string {
+    return "job:" + id
+}
+
+// newId returns a random id.
+func newId() (string, error) {
+    b := make([]byte, 8)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(b), nil
+}
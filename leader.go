@@ -0,0 +1,156 @@
+// leader.go contains the Redis-based leader election that keeps scheduled
+// tasks, such as re-crawls and sweepers, running on exactly one instance
+// when several replicas share a Redis database.
+
+package main
+
+import (
+    "log"
+    "os"
+    "sync"
+    "time"
+
+    "github.com/garyburd/redigo/redis"
+)
+
+// renewLease extends a lease only if it is still held by this instance.
+var renewLease = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+    return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseLease deletes a lease only if it is still held by this instance.
+var releaseLease = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+    return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// A type for an election between instances, won by whichever holds its
+// lease in Redis.
+type Leader struct {
+    key string
+    id string
+    ttl time.Duration
+
+    mu sync.Mutex
+    leading bool
+}
+
+// NewLeader creates a Leader for the election called name. The lease lasts
+// ttl, so a crashed leader is replaced within ttl.
+func NewLeader(name string, ttl time.Duration) (*Leader, error) {
+
+    id, err := newId()
+    if err != nil {
+        return nil, err
+    }
+
+    host, _ := os.Hostname()
+    return &Leader{key: "leader:" + name, id: host + ":" + id, ttl: ttl}, nil
+}
+
+// IsLeader reports whether this instance currently holds the lease. A nil
+// Leader, when there's no election to hold, always leads.
+func (l *Leader) IsLeader() bool {
+    if l == nil {
+        return true
+    }
+
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    return l.leading
+}
+
+// Run campaigns for the lease, renewing it while it is held, until stop
+// is closed, when the lease is given up.
+func (l *Leader) Run(stop <-chan struct{}) {
+
+    ticker := time.NewTicker(l.ttl / 3)
+    defer ticker.Stop()
+
+    for {
+        l.campaign()
+
+        select {
+        case <-stop:
+            l.resign()
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// campaign takes or renews the lease.
+func (l *Leader) campaign() {
+
+    conn := pool.Get()
+    defer conn.Close()
+
+    ttl := int64(l.ttl / time.Millisecond)
+
+    var leading bool
+    if l.IsLeader() {
+        renewed, err := redis.Int(renewLease.Do(conn, l.key, l.id, ttl))
+        leading = err == nil && renewed == 1
+        if err != nil {
+            log.Println("ERROR: Couldn't renew " + l.key + ": " + err.Error())
+        }
+    } else {
+        _, err := redis.String(conn.Do("SET", l.key, l.id, "NX", "PX", ttl))
+        leading = err == nil
+        if err != nil && err != redis.ErrNil {
+            log.Println("ERROR: Couldn't campaign for " + l.key + ": " + err.Error())
+        }
+    }
+
+    l.mu.Lock()
+    if leading != l.leading {
+        if leading {
+            log.Println("INFO: Became leader for " + l.key)
+        } else {
+            log.Println("INFO: Lost leadership for " + l.key)
+        }
+    }
+    l.leading = leading
+    l.mu.Unlock()
+}
+
+// resign gives up the lease if it is held.
+func (l *Leader) resign() {
+
+    l.mu.Lock()
+    leading := l.leading
+    l.leading = false
+    l.mu.Unlock()
+
+    if !leading {
+        return
+    }
+
+    conn := pool.Get()
+    defer conn.Close()
+    releaseLease.Do(conn, l.key, l.id)
+}
+
+// Schedule runs task every interval, but only on the instance that is
+// leader, until stop is closed.
+func Schedule(leader *Leader, interval time.Duration, task func(), stop <-chan struct{}) {
+
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-stop:
+            return
+        case <-ticker.C:
+            if leader.IsLeader() {
+                task()
+            }
+        }
+    }
+}
@@ -12,23 +12,59 @@
 package main 
 
 import (
+    "context"
+    "encoding/json"
+    "errors"
+    "flag"
     "html/template"
     "io/ioutil"
     "log"
+    "net"
     "net/http"
     "net/url"
     "os"
     "path"
+    "strconv"
+    "strings"
+    "time"
 
     "github.com/garyburd/redigo/redis"
+    "github.com/google/gops/agent"
     "github.com/lkvnstrs/cumuli/networkmapper"
 )
 
-const TEMPLATES_DIR = `./templates`
+const (
+    TEMPLATES_DIR = `./templates`
+    DEFAULT_SOURCE = "soundcloud"
+    LEADER_TTL = 30 * time.Second
+    BUILD_LOCK_POLL = 250 * time.Millisecond // how often a build waits on another server's checks for its network
+    MAX_DEPTH = 2 // hops out from the users a network may reach
+    MAX_INTERACTION_ACCOUNTS = 25 // besides the users, whose interactions weight=interactions fetches
+    MAX_VIEW_NODES = 1000 // drawn on the map unless max_nodes says otherwise
+    DEMO_SEED = 1 // so every demo shows the same mock network
+    DEMO_ACCOUNTS = 500 // in the mock network unless DEMO_MODE says otherwise
+)
 
 var (
     n networkmapper.NetworkMapper
+    sources map[string]networkmapper.NetworkMapper
+    identities *networkmapper.Identities
     pool *redis.Pool
+    cache Cache
+    graphStore GraphStore
+    builds *buildQueue
+    leader *Leader
+    purger Purger
+    config *Config
+    maxResponseSize int
+    anonymizeKey []byte
+    adminToken string
+    searchLimits *ClientRateLimits
+    trustedProxies []*net.IPNet
+    autocompleteLimits *ClientRateLimits
+
+    usersFile = flag.String("users", "", "build the network for a CSV or text file of usernames, print its JSON and exit")
+    usersFormat = flag.String("format", "json", "the format to print the network built with -users in: json or dot")
 )
 
 func init() {
@@ -39,33 +75,208 @@ func init() {
     // Load templates
     loadTemplates()
 
-    // Get the SoundCloud client Id
-    clientId := GetClientId()
+    // Load the configuration file
+    config = GetConfig()
+
+    // Get the response size limit
+    maxResponseSize = GetMaxResponseSize()
+
+    // Get the key for anonymized exports
+    anonymizeKey = GetAnonymizeKey()
+
+    // Get the token for the admin routes
+    adminToken = GetAdminToken()
+
+    // Get the proxies whose X-Forwarded-For tells clients apart
+    var err error
+    if trustedProxies, err = ParseTrustedProxies(config.TrustedProxies); err != nil {
+        log.Fatal("Couldn't configure the trusted proxies: ", err)
+    }
+
+    // Limit how often each client may search
+    searchLimits = NewClientRateLimits(float64(config.SearchRPS))
+    autocompleteLimits = NewClientRateLimits(float64(config.AutocompleteRPS))
+
+    // Get the SoundCloud client Id, which demo mode does without
+    demoAccounts, demo := GetDemoMode()
+    clientId := ""
+    if !demo {
+        clientId = GetClientId()
+    }
 
      // Initialize the pool
     redisServer, redisPassword := GetRedisInfo()
-    pool = NewPool(redisServer, redisPassword)
+    if pool, err = NewRedisPool(config.Redis, redisServer, redisPassword); err != nil {
+        log.Fatal("Couldn't configure Redis: ", err)
+    }
+
+    // Initialize the cache
+    if cache, err = NewCache(config.Cache, config.MemoryCacheSize, config.BoltPath); err != nil {
+        log.Fatal("Couldn't configure the cache: ", err)
+    }
+    if config.MaxCacheEntrySize > 0 {
+        cache = sizeLimitedCache{cache, config.MaxCacheEntrySize}
+    }
+    if config.CacheCompressMin > 0 {
+        cache = compressedCache{cache, config.CacheCompressMin}
+    }
+
+    if config.NetworkFormat != "json" && config.NetworkFormat != "gob" {
+        log.Fatal("Unknown network_format " + config.NetworkFormat)
+    }
+
+    // Open where saved graphs are kept
+    if graphStore, err = NewGraphStore(config.Store, config.BoltPath, GetDatabaseURL()); err != nil {
+        log.Fatal("Couldn't open the graph store: ", err)
+    }
 
     // Initialize the networker
+    if config.PageWorkers > 0 {
+        log.Println("WARNING: page_workers is deprecated and ignored, as followings are paged through by cursor one page at a time")
+    }
     numResults := 50
-    n = networkmapper.NewNetworkMapper(clientId, numResults)
+    opts := []networkmapper.Option{
+        networkmapper.WithMaxAttempts(config.MaxAttempts),
+        networkmapper.WithPartialResults(!config.StrictBuilds),
+        networkmapper.WithFollowingsCache(config.FollowingsCacheSize,
+            time.Duration(config.FollowingsCacheTTL) * time.Second),
+        networkmapper.WithETagCache(config.ETagCacheSize),
+        networkmapper.WithTimeouts(time.Duration(config.ConnectTimeout) * time.Second,
+            time.Duration(config.ReadTimeout) * time.Second),
+    }
+    if config.FollowingsStoreTTL > 0 {
+        opts = append(opts, networkmapper.WithFollowingsStore(cacheFollowings{config.FollowingsStoreTTL}))
+    }
+    if config.BuildTimeout > 0 {
+        opts = append(opts, networkmapper.WithBuildTimeout(time.Duration(config.BuildTimeout) * time.Second))
+    }
+    if secret := GetClientSecret(); secret != "" {
+        opts = append(opts, networkmapper.WithClientSecret(secret))
+    }
+    if rps := GetMaxRPS(); rps > 0 {
+        opts = append(opts, networkmapper.WithMaxRPS(rps))
+    }
+    if api := GetSoundCloudAPI(); api != "" {
+        opts = append(opts, networkmapper.WithBaseURL(api))
+    }
+    n = networkmapper.NewNetworkMapper(clientId, numResults, opts...)
+
+    // Start the build workers
+    builds = NewBuildQueue(config.BuildWorkers, config.MaxQueuedBuilds, config.MaxQueuedJobs,
+        time.Duration(config.BuildDeadline) * time.Second)
+
+    // Initialize the networkers for the other sources
+    sources = map[string]networkmapper.NetworkMapper{DEFAULT_SOURCE: n}
+    if likes, ok := networkmapper.NewLikesMapper(n, false); ok {
+        sources["soundcloud-likes"] = likes
+    }
+    if artists, ok := networkmapper.NewLikesMapper(n, true); ok {
+        sources["soundcloud-liked-artists"] = artists
+    }
+    if reposts, ok := networkmapper.NewRepostsMapper(n); ok {
+        sources["soundcloud-reposts"] = reposts
+    }
+    if token := GetGitHubToken(); token != "" {
+        sources["github"] = networkmapper.NewGitHubNetworkMapper(token)
+        sources["github-stars"] = networkmapper.NewGitHubStarsNetworkMapper(token)
+    }
+    if key := GetLastFMKey(); key != "" {
+        sources["lastfm"] = networkmapper.NewLastFMNetworkMapper(key)
+        sources["lastfm-artists"] = networkmapper.NewLastFMArtistsNetworkMapper(key)
+    }
+    if paths := GetOfflineData(); len(paths) > 0 {
+        sources["offline"] = GetOfflineNetworkMapper(paths)
+    }
+    sources["mixcloud"] = networkmapper.NewMixcloudNetworkMapper()
+    if config.Mastodon {
+        sources["mastodon"] = networkmapper.NewMastodonNetworkMapper(GetMastodonTokens())
+    }
+    if id, secret := GetSpotifyClient(); id != "" {
+        sources["spotify"] = networkmapper.NewSpotifyNetworkMapper(id, secret, NewTokenStore(graphStore, "spotify"))
+    }
+
+    // Demo mode builds from a mock network and any offline data, so
+    // nothing is called
+    if demo {
+        mock := networkmapper.NewMockNetworkMapper(DEMO_SEED, demoAccounts)
+        demoSources := map[string]networkmapper.NetworkMapper{DEFAULT_SOURCE: mock, "mock": mock}
+        if offline, ok := sources["offline"]; ok {
+            demoSources["offline"] = offline
+        }
+        sources = demoSources
+        log.Printf("Running in demo mode with %d mock accounts", demoAccounts)
+    }
+
+    // Apply the configured page sizes
+    for source, size := range config.PageSizes {
+        if m, ok := sources[source]; ok {
+            sources[source] = networkmapper.WithPageSize(m, size)
+        }
+    }
+    n = sources[DEFAULT_SOURCE]
+
+    // Connect to the CDN to purge
+    if purger, err = NewPurger(config.CDN); err != nil {
+        log.Fatal("Couldn't configure the CDN: ", err)
+    }
+
+    // Load the identities for merging federated networks
+    identities = GetIdentities(config.IdentityFile)
 
     // Routes
-    http.HandleFunc("/", MainHandler)
-    http.HandleFunc("/u/", UserHandler)
-    http.HandleFunc("/about/", AboutHandler)
-    http.HandleFunc("/json/", JSONHandler)
-    http.HandleFunc("/static/", StaticHandler)    
+    for _, rt := range routes() {
+        http.HandleFunc(rt.Pattern, rt.Handler)
+    }
 }
 
 func main() {
 
+    flag.Parse()
+
+    // Start the gops diagnostics agent if asked
+    if addr, ok := GetGopsAddr(); ok {
+        if err := agent.Listen(agent.Options{Addr: addr}); err != nil {
+            log.Fatal(err)
+        }
+        log.Println("INFO: gops agent started")
+    }
+
+    // Build from a file of usernames instead of serving
+    if *usersFile != "" {
+        if err := buildFromFile(*usersFile); err != nil {
+            log.Fatal(err)
+        }
+        return
+    }
+
     // Get the web port
     port := GetWebPort()
 
     // Defer close for the networker
     defer pool.Close()
 
+    // Elect the instance that runs scheduled tasks among those sharing
+    // Redis; without it, this is the only instance, and always leads
+    if config.UsesRedis() {
+        var err error
+        if leader, err = NewLeader("scheduler", LEADER_TTL); err != nil {
+            log.Fatal(err)
+        }
+        go leader.Run(nil)
+    }
+
+    // Run jobs from the shared queue, and queue again those of servers
+    // that went down
+    if sharedJobs() {
+        go claimJobs()
+        go Schedule(leader, JOB_CLAIM_TTL / 3, requeueStaleJobs, nil)
+    }
+
+    // Re-crawl the saved graphs on the leader
+    if config.RecrawlInterval > 0 {
+        go Schedule(leader, time.Duration(config.RecrawlInterval) * time.Second, recrawlGraphs, nil)
+    }
+
     log.Println("Running on port ", port)
     http.ListenAndServe(port, nil)
 
@@ -89,6 +300,41 @@ func loadTemplates() {
     }
 }
 
+// buildFromFile builds the network for the SoundCloud usernames in the
+// named file and writes it to stdout in the format given by -format.
+func buildFromFile(name string) error {
+    f, err := os.Open(name)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    users, err := ParseUsernames(f, DEFAULT_SOURCE)
+    if err != nil {
+        return err
+    }
+
+    log.Printf("Building network for %d users", len(users))
+    js, err := networkmapper.BuildNetworkMapChunked(context.Background(), n, users, JOB_CHUNK_SIZE)
+    if err != nil {
+        return err
+    }
+
+    switch *usersFormat {
+    case "json":
+        _, err = os.Stdout.Write(js)
+    case "dot":
+        var result networkmapper.Result
+        if err = json.Unmarshal(js, &result); err != nil {
+            return err
+        }
+        err = networkmapper.WriteDOT(os.Stdout, &result)
+    default:
+        err = errors.New("unknown format " + *usersFormat)
+    }
+    return err
+}
+
 // GetPort gets a PORT env if set and returns 8080 otherwise.
 func GetWebPort() string {
         var port = os.Getenv("PORT")
@@ -100,6 +346,53 @@ func GetWebPort() string {
         return ":" + port
 }
 
+// GetMaxResponseSize gets the MAX_RESPONSE_SIZE env, the most bytes of
+// network JSON to send before truncating it, and returns 5MB otherwise.
+func GetMaxResponseSize() int {
+    size := os.Getenv("MAX_RESPONSE_SIZE")
+    if size == "" {
+        return 5 << 20
+    }
+
+    max, err := strconv.Atoi(size)
+    if err != nil || max <= 0 {
+        log.Fatal("MAX_RESPONSE_SIZE must be a positive number of bytes")
+    }
+    return max
+}
+
+// GetGopsAddr gets the GOPS_AGENT env, which enables the gops agent for
+// inspecting the running process. It may be an address for the agent to
+// listen on; otherwise the agent picks a local port.
+func GetGopsAddr() (string, bool) {
+    switch addr := os.Getenv("GOPS_AGENT"); addr {
+    case "", "0", "false":
+        return "", false
+    case "1", "true":
+        return "", true
+    default:
+        return addr, true
+    }
+}
+
+// GetDemoMode gets the DEMO_MODE env, which builds networks from a mock
+// network instead of calling any API. It may be the number of accounts in
+// the mock network; otherwise there are DEMO_ACCOUNTS.
+func GetDemoMode() (int, bool) {
+    switch mode := os.Getenv("DEMO_MODE"); mode {
+    case "", "0", "false":
+        return 0, false
+    case "1", "true":
+        return DEMO_ACCOUNTS, true
+    default:
+        accounts, err := strconv.Atoi(mode)
+        if err != nil || accounts < 1 {
+            log.Fatal("DEMO_MODE must be true or a positive number of mock accounts")
+        }
+        return accounts, true
+    }
+}
+
 // GetClientId gets the Soundcloud API client id.
 func GetClientId() string {
     cid := os.Getenv("SC_CLIENT_ID")
@@ -109,6 +402,129 @@ func GetClientId() string {
     return cid
 }
 
+// GetClientSecret gets the SoundCloud API client secret, which makes
+// requests authenticate with an OAuth2 token if set.
+func GetClientSecret() string {
+    return os.Getenv("SC_CLIENT_SECRET")
+}
+
+// GetSoundCloudAPI gets the SC_API_URL env, the SoundCloud API to call
+// instead of api.soundcloud.com, such as a caching proxy.
+func GetSoundCloudAPI() string {
+    return os.Getenv("SC_API_URL")
+}
+
+// GetMaxRPS gets the SC_MAX_RPS env, the most requests a second to send
+// to SoundCloud, and returns 0, no limit, otherwise.
+func GetMaxRPS() float64 {
+    rps := os.Getenv("SC_MAX_RPS")
+    if rps == "" {
+        return 0
+    }
+
+    max, err := strconv.ParseFloat(rps, 64)
+    if err != nil || max <= 0 {
+        log.Fatal("SC_MAX_RPS must be a positive number of requests a second")
+    }
+    return max
+}
+
+// GetIdentities loads the identity mapping file at path, or returns
+// Identities that only match by name if path is empty.
+func GetIdentities(path string) *networkmapper.Identities {
+    if path == "" {
+        return networkmapper.NewIdentities()
+    }
+
+    f, err := os.Open(path)
+    if err != nil {
+        log.Fatal("Couldn't open the identity file: ", err)
+    }
+    defer f.Close()
+
+    ids, err := networkmapper.ReadIdentities(f)
+    if err != nil {
+        log.Fatal("Couldn't read the identity file: ", err)
+    }
+    return ids
+}
+
+// GetOfflineData gets the paths of the local CSV and JSON follow data
+// files, or directories of them, separated by commas, which enable the
+// offline source if set.
+func GetOfflineData() []string {
+    paths := []string{}
+    for _, path := range strings.Split(os.Getenv("OFFLINE_DATA"), ",") {
+        if path = strings.TrimSpace(path); path != "" {
+            paths = append(paths, path)
+        }
+    }
+    return paths
+}
+
+// GetOfflineNetworkMapper loads the follow data at paths into a
+// NetworkMapper that builds networks without any network access.
+func GetOfflineNetworkMapper(paths []string) networkmapper.NetworkMapper {
+    fs, err := networkmapper.ReadFollowingsFiles(paths...)
+    if err != nil {
+        log.Fatal("Couldn't read the offline data: ", err)
+    }
+    return networkmapper.NewOfflineNetworkMapper(fs)
+}
+
+// GetGitHubToken gets the GitHub personal access token, which enables the
+// GitHub sources if set.
+func GetGitHubToken() string {
+    return os.Getenv("GITHUB_TOKEN")
+}
+
+// GetLastFMKey gets the Last.fm API key, which enables the Last.fm
+// sources if set.
+func GetLastFMKey() string {
+    return os.Getenv("LASTFM_API_KEY")
+}
+
+// GetMastodonTokens gets the access tokens to call Mastodon instances
+// with, given as instance=token pairs separated by commas.
+func GetMastodonTokens() map[string]string {
+    tokens := map[string]string{}
+    for _, pair := range strings.Split(os.Getenv("MASTODON_TOKENS"), ",") {
+        if i := strings.Index(pair, "="); i > 0 {
+            tokens[strings.ToLower(strings.TrimSpace(pair[:i]))] = strings.TrimSpace(pair[i + 1:])
+        }
+    }
+    return tokens
+}
+
+// GetSpotifyClient gets the Spotify app's client id and secret, which
+// enable the Spotify source if set.
+func GetSpotifyClient() (string, string) {
+    return os.Getenv("SPOTIFY_CLIENT_ID"), os.Getenv("SPOTIFY_CLIENT_SECRET")
+}
+
+// GetAnonymizeKey gets the key names are hashed with in anonymized
+// exports, which keeps them from being found by hashing known names.
+// Without one, there are no anonymized exports.
+func GetAnonymizeKey() []byte {
+    key := os.Getenv("ANONYMIZE_KEY")
+    if key == "" {
+        log.Println("INFO: No ANONYMIZE_KEY environment variable detected, anonymized exports are turned off")
+    }
+    return []byte(key)
+}
+
+// GetAdminToken gets the token admin requests must bear, without which
+// there are no admin routes.
+func GetAdminToken() string {
+    return os.Getenv("ADMIN_TOKEN")
+}
+
+// GetDatabaseURL gets the URL of the Postgres database of the postgres
+// store.
+func GetDatabaseURL() string {
+    return os.Getenv("DATABASE_URL")
+}
+
 // GetRedisInfo gets the port and password for the Redis database
 func GetRedisInfo() (string, string) {
 
@@ -0,0 +1,102 @@
+// negotiate.go contains the content negotiation of the network route,
+// which sends a network in whichever of its formats the Accept header
+// prefers, and as JSON if it prefers none of them
+
+package main
+
+import (
+    "errors"
+    "io"
+    "mime"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/lkvnstrs/cumuli/networkmapper"
+)
+
+// The types a network can be sent as, JSON first so it wins ties.
+var networkTypes = []string{
+    "application/json",
+    "text/csv",
+    "application/graphml+xml",
+    "application/vnd.gexf",
+    "application/gexf+xml",
+}
+
+// negotiateNetworkType returns the type of networkTypes the Accept header
+// of r prefers, or JSON if it doesn't accept any of them.
+func negotiateNetworkType(r *http.Request) string {
+
+    accept := r.Header.Get("Accept")
+    if accept == "" {
+        return "application/json"
+    }
+
+    best, bestQ := "application/json", 0.0
+    for _, t := range networkTypes {
+        if q := acceptQuality(accept, t); q > bestQ {
+            best, bestQ = t, q
+        }
+    }
+    return best
+}
+
+// writeNetwork writes result to w as mediaType, one of networkTypes other
+// than JSON. A network is sent as CSV one table at a time: its links, or
+// its nodes if table says so.
+func writeNetwork(w io.Writer, result *networkmapper.Result, mediaType, table string) error {
+    switch mediaType {
+    case "text/csv":
+        switch table {
+        case "", "links":
+            return networkmapper.WriteEdgesCSV(w, result)
+        case "nodes":
+            return networkmapper.WriteNodesCSV(w, result)
+        }
+        return errors.New("table must be nodes or links")
+    case "application/graphml+xml":
+        return networkmapper.WriteGraphML(w, result)
+    case "application/vnd.gexf", "application/gexf+xml":
+        return networkmapper.WriteGEXF(w, result)
+    }
+    return errors.New("can't send a network as " + mediaType)
+}
+
+/* Helpers */
+
+// acceptQuality returns the quality accept, an Accept header, gives
+// mediaType: that of the most specific range matching it, or 0 if none
+// does.
+func acceptQuality(accept, mediaType string) float64 {
+
+    q, specificity := 0.0, -1
+    for _, r := range strings.Split(accept, ",") {
+        t, params, err := mime.ParseMediaType(strings.TrimSpace(r))
+        if err != nil {
+            continue
+        }
+
+        // Exact types beat type/*, which beats */*
+        s := -1
+        switch {
+        case t == mediaType:
+            s = 2
+        case strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*")):
+            s = 1
+        case t == "*/*":
+            s = 0
+        }
+        if s <= specificity {
+            continue
+        }
+
+        specificity, q = s, 1
+        if v, ok := params["q"]; ok {
+            if q, err = strconv.ParseFloat(v, 64); err != nil {
+                q = 0
+            }
+        }
+    }
+    return q
+}
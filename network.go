@@ -0,0 +1,803 @@
+// network.go contains the building and caching of networks for cumuli's
+// handlers
+
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/lkvnstrs/cumuli/networkmapper"
+)
+
+// A type for the options a network is built with.
+type networkOptions struct {
+
+    // How to weight links: "" for no weights, "interactions" or "shared"
+    Weight string
+
+    // How many followings to fetch per request, or 0 for the source's
+    // default. It doesn't change the network, so isn't part of its key.
+    PageSize int
+
+    // Which relation to build the network from: "" for who the users
+    // follow, "followers" for who follows them, or "both"
+    Relation string
+
+    // Whether to build the network of the artists in the users' playlists,
+    // linked by how many playlists they're both in, instead of followings
+    Playlists bool
+
+    // How many hops out from the users to build the network: 1 for their
+    // shared followings, or 2 to add those of the most shared followings
+    Depth int
+
+    // Whether to leave out nodes without links from what is sent. The
+    // network is cached whole, so this isn't part of its key either.
+    Prune bool
+
+    // How many of the users must follow an account for it to be a node,
+    // or 0 for the default of two. Worked out from the cached network too.
+    MinShared int
+
+    // Whether to give each node its PageRank, and how many of the highest
+    // ranked nodes to keep, highest first, or 0 for all of them. Worked
+    // out from the cached network too.
+    Rank bool
+    Top int
+
+    // Which nodes to send: those with at least MinDegree links, in one of
+    // Groups, whose names match Name, and with from MinFollowers to
+    // MaxFollowers followers, where each is set. Worked out from the cached
+    // network too.
+    MinDegree int
+    Groups []int
+    Name *regexp.Regexp
+    MinFollowers int
+    MaxFollowers int
+
+    // The most nodes to send, keeping the users and then the best linked,
+    // or 0 for all of them. Worked out from the cached network too.
+    MaxNodes int
+
+    // Whether to mark the network as directed and its mutual follows.
+    // That's worked out from the cached network, so isn't in its key.
+    Directed bool
+
+    // Whether to give each node the profile of its account, which takes
+    // longer to build
+    Metadata bool
+
+    // What to group nodes by besides role, such as genre, which needs
+    // their profiles too, or community
+    Group string
+}
+
+// getNetworkOptions reads the options for building a network of source
+// from the query of r.
+func getNetworkOptions(r *http.Request, source string) (networkOptions, error) {
+
+    var opts networkOptions
+
+    switch opts.Weight = r.URL.Query().Get("weight"); opts.Weight {
+    case "", "shared":
+    case "interactions":
+        for _, s := range strings.Split(source, ",") {
+            if _, ok := sources[s].(networkmapper.InteractionFetcher); !ok {
+                return opts, errors.New(s + " can't weight by interactions")
+            }
+        }
+    default:
+        return opts, errors.New("unknown weight " + opts.Weight)
+    }
+
+    // weighted is a switch for weight=shared, which weight can pick instead
+    if weighted := r.URL.Query().Get("weighted"); weighted != "" {
+        w, err := strconv.ParseBool(weighted)
+        if err != nil {
+            return opts, errors.New("weighted must be true or false")
+        }
+        if w && opts.Weight == "" {
+            opts.Weight = "shared"
+        }
+        if !w && opts.Weight != "" {
+            return opts, errors.New("weight can't be used with weighted=false")
+        }
+    }
+
+    if size := r.URL.Query().Get("page_size"); size != "" {
+        var err error
+        if opts.PageSize, err = strconv.Atoi(size); err != nil || opts.PageSize < 1 {
+            return opts, errors.New("page_size must be a positive number")
+        }
+        for _, s := range strings.Split(source, ",") {
+            sizer, ok := sources[s].(networkmapper.PageSizer)
+            if !ok {
+                return opts, errors.New(s + " doesn't fetch by page")
+            }
+            if max := sizer.MaxPageSize(); opts.PageSize > max {
+                return opts, errors.New("page_size for " + s + " can be at most " + strconv.Itoa(max))
+            }
+        }
+    }
+
+    switch opts.Relation = r.URL.Query().Get("relation"); opts.Relation {
+    case "", networkmapper.RelationFollowings:
+        opts.Relation = ""
+    case networkmapper.RelationFollowers, networkmapper.RelationBoth:
+        for _, s := range strings.Split(source, ",") {
+            if _, ok := sources[s].(networkmapper.FollowersFetcher); !ok {
+                return opts, errors.New(s + " can't build networks of followers")
+            }
+        }
+    default:
+        return opts, errors.New("unknown relation " + opts.Relation)
+    }
+
+    if playlists := r.URL.Query().Get("playlists"); playlists != "" {
+        var err error
+        if opts.Playlists, err = strconv.ParseBool(playlists); err != nil {
+            return opts, errors.New("playlists must be true or false")
+        }
+        if _, ok := sources[source].(networkmapper.PlaylistFetcher); opts.Playlists && !ok {
+            return opts, errors.New(source + " doesn't have playlists")
+        }
+        if opts.Playlists && (opts.Weight != "" || opts.Relation != "") {
+            return opts, errors.New("playlists can't be used with weight or relation")
+        }
+    }
+
+    if depth := r.URL.Query().Get("depth"); depth != "" {
+        var err error
+        if opts.Depth, err = strconv.Atoi(depth); err != nil || opts.Depth < 1 || opts.Depth > MAX_DEPTH {
+            return opts, errors.New("depth must be from 1 to " + strconv.Itoa(MAX_DEPTH))
+        }
+        if opts.Playlists && opts.Depth > 1 {
+            return opts, errors.New("playlists can't be used with depth")
+        }
+    }
+
+    if prune := r.URL.Query().Get("prune"); prune != "" {
+        var err error
+        if opts.Prune, err = strconv.ParseBool(prune); err != nil {
+            return opts, errors.New("prune must be true or false")
+        }
+    }
+
+    if min := r.URL.Query().Get("min_shared"); min != "" {
+        var err error
+        if opts.MinShared, err = strconv.Atoi(min); err != nil || opts.MinShared < 2 {
+            return opts, errors.New("min_shared must be a number of at least 2")
+        }
+    }
+
+    if rank := r.URL.Query().Get("rank"); rank != "" {
+        var err error
+        if opts.Rank, err = strconv.ParseBool(rank); err != nil {
+            return opts, errors.New("rank must be true or false")
+        }
+    }
+
+    if top := r.URL.Query().Get("top"); top != "" {
+        var err error
+        if opts.Top, err = strconv.Atoi(top); err != nil || opts.Top < 1 {
+            return opts, errors.New("top must be a positive number")
+        }
+        opts.Rank = true
+    }
+
+    if degree := r.URL.Query().Get("min_degree"); degree != "" {
+        var err error
+        if opts.MinDegree, err = strconv.Atoi(degree); err != nil || opts.MinDegree < 1 {
+            return opts, errors.New("min_degree must be a positive number")
+        }
+    }
+
+    if groups := r.URL.Query().Get("groups"); groups != "" {
+        for _, g := range strings.Split(groups, ",") {
+            group, err := strconv.Atoi(g)
+            if err != nil {
+                return opts, errors.New("groups must be a ',' separated list of numbers")
+            }
+            opts.Groups = append(opts.Groups, group)
+        }
+    }
+
+    if name := r.URL.Query().Get("name"); name != "" {
+        var err error
+        if opts.Name, err = regexp.Compile(name); err != nil {
+            return opts, errors.New("name must be a regular expression: " + err.Error())
+        }
+    }
+
+    if min := r.URL.Query().Get("min_followers"); min != "" {
+        var err error
+        if opts.MinFollowers, err = strconv.Atoi(min); err != nil || opts.MinFollowers < 0 {
+            return opts, errors.New("min_followers must be a number")
+        }
+    }
+
+    if max := r.URL.Query().Get("max_followers"); max != "" {
+        var err error
+        if opts.MaxFollowers, err = strconv.Atoi(max); err != nil || opts.MaxFollowers < 1 {
+            return opts, errors.New("max_followers must be a positive number")
+        }
+    }
+
+    if max := r.URL.Query().Get("max_nodes"); max != "" {
+        var err error
+        if opts.MaxNodes, err = strconv.Atoi(max); err != nil || opts.MaxNodes < 1 {
+            return opts, errors.New("max_nodes must be a positive number")
+        }
+    }
+
+    if directed := r.URL.Query().Get("directed"); directed != "" {
+        var err error
+        if opts.Directed, err = strconv.ParseBool(directed); err != nil {
+            return opts, errors.New("directed must be true or false")
+        }
+    }
+
+    if metadata := r.URL.Query().Get("metadata"); metadata != "" {
+        var err error
+        if opts.Metadata, err = strconv.ParseBool(metadata); err != nil {
+            return opts, errors.New("metadata must be true or false")
+        }
+        for _, s := range strings.Split(source, ",") {
+            if _, ok := sources[s].(networkmapper.ProfileFetcher); opts.Metadata && !ok {
+                return opts, errors.New(s + " doesn't have profiles")
+            }
+        }
+    }
+
+    // Collapsing by genre or label groups by it first, for the profiles
+    group := r.URL.Query().Get("group")
+    if collapse := r.URL.Query().Get("collapse"); collapse == networkmapper.GroupGenre || collapse == networkmapper.GroupLabel {
+        if group != "" && group != collapse {
+            return opts, errors.New("collapse " + collapse + " can't be used with group " + group)
+        }
+        group = collapse
+    }
+
+    switch opts.Group = group; opts.Group {
+    case "":
+    case networkmapper.GroupGenre, networkmapper.GroupLabel, networkmapper.GroupCountry, networkmapper.GroupCity,
+        networkmapper.GroupFollowers, networkmapper.GroupCommunity:
+        if strings.Contains(source, ",") {
+            return opts, errors.New("group can't be used with more than one source")
+        }
+        if _, ok := sources[source].(networkmapper.ProfileFetcher); networkmapper.GroupNeedsProfiles(opts.Group) && !ok {
+            return opts, errors.New(source + " doesn't have profiles")
+        }
+        if _, ok := sources[source].(networkmapper.GenreFetcher); opts.Group == networkmapper.GroupGenre && !ok {
+            return opts, errors.New(source + " doesn't have genres")
+        }
+        if _, ok := sources[source].(networkmapper.LabelFetcher); opts.Group == networkmapper.GroupLabel && !ok {
+            return opts, errors.New(source + " doesn't have labels")
+        }
+    default:
+        return opts, errors.New("unknown group " + opts.Group)
+    }
+
+    // Followers are only known from profiles
+    if (opts.MinFollowers > 0 || opts.MaxFollowers > 0) && !opts.Metadata {
+        return opts, errors.New("min_followers and max_followers need metadata")
+    }
+
+    return opts, nil
+}
+
+// reshapes returns whether opts change a network after it's built, so it
+// has to be decoded before it's sent.
+func (opts networkOptions) reshapes() bool {
+    return opts.Prune || opts.Directed || opts.MinShared > 0 || opts.Rank || len(opts.filters()) > 0 ||
+        opts.MaxNodes > 0
+}
+
+// filters returns the tests of which nodes opts send.
+func (opts networkOptions) filters() []networkmapper.Predicate {
+    filters := []networkmapper.Predicate{}
+    if opts.MinDegree > 0 {
+        filters = append(filters, networkmapper.MinDegree(opts.MinDegree))
+    }
+    if len(opts.Groups) > 0 {
+        filters = append(filters, networkmapper.InGroups(opts.Groups...))
+    }
+    if opts.Name != nil {
+        filters = append(filters, networkmapper.NameMatches(opts.Name))
+    }
+    if opts.MinFollowers > 0 || opts.MaxFollowers > 0 {
+        filters = append(filters, networkmapper.FollowersBetween(opts.MinFollowers, opts.MaxFollowers))
+    }
+    return filters
+}
+
+// reshapeNetwork applies the options that change a network after it's
+// built to result.
+func reshapeNetwork(result *networkmapper.Result, opts networkOptions) *networkmapper.Result {
+    if opts.MinShared > 0 {
+        result = result.RequireShared(opts.MinShared)
+    }
+    if opts.Rank {
+        result.AddRanks()
+    }
+    if opts.Top > 0 {
+        result = result.TopByRank(opts.Top)
+    }
+    if filters := opts.filters(); len(filters) > 0 {
+        result = result.Filter(filters...)
+    }
+    if opts.MaxNodes > 0 {
+        result = result.TopByDegree(opts.MaxNodes)
+    }
+    if opts.Directed {
+        result.MarkDirected()
+    }
+    if opts.Prune {
+        result = result.PruneOrphans()
+    }
+    return result
+}
+
+// getNetworkMap returns the JSON network map for key, a '+' separated list
+// of users of source, built with opts, building and caching it if it isn't
+// already in the cache. Building gives up if ctx is done first. A cached
+// network that's due to be rebuilt is still returned while it's rebuilt in
+// the background.
+func getNetworkMap(ctx context.Context, source, key string, opts networkOptions) ([]byte, error) {
+
+    js, ok, err := getCachedNetwork(networkCacheKey(source, key, opts))
+    if err != nil {
+        return nil, err
+    }
+
+    // Handle key doesn't exist
+    if !ok {
+
+        if js, err = buildOnce(ctx, source, key, opts); err != nil {
+            return nil, err
+        }
+
+    } else {
+        refreshIfStale(source, key, opts)
+    }
+
+    return js, nil
+}
+
+// buildOnce builds and caches the network for key, a '+' separated list
+// of users of source, with opts, unless another request or server already
+// is, in which case it waits for theirs to be cached instead. It gives up
+// if ctx is done first.
+func buildOnce(ctx context.Context, source, key string, opts networkOptions) ([]byte, error) {
+
+    if config.BuildLockTTL <= 0 {
+        return buildAndStoreNetworkMap(ctx, source, key, opts)
+    }
+
+    cacheKey := networkCacheKey(source, key, opts)
+    ticker := time.NewTicker(BUILD_LOCK_POLL)
+    defer ticker.Stop()
+
+    for {
+
+        // Claim the build until it should have long finished, in case
+        // this server goes down before it does
+        claimed, err := cache.Add(buildLockKey(cacheKey), []byte{1}, time.Duration(config.BuildLockTTL) * time.Second)
+        if err != nil {
+            return nil, err
+        }
+        if claimed {
+            defer cache.Delete(buildLockKey(cacheKey))
+            return buildAndStoreNetworkMap(ctx, source, key, opts)
+        }
+
+        // Wait for the network, or for the build to be given up, as it is
+        // when it fails, and then try to claim it again
+        for held := true; held; {
+            select {
+            case <-ctx.Done():
+                return nil, ctx.Err()
+            case <-ticker.C:
+            }
+
+            js, ok, err := getCachedNetwork(cacheKey)
+            if err != nil {
+                return nil, err
+            }
+            if ok {
+                return js, nil
+            }
+            if _, held, err = cache.Get(buildLockKey(cacheKey)); err != nil {
+                return nil, err
+            }
+        }
+    }
+}
+
+// buildAndStoreNetworkMap builds and caches the network for key, a '+'
+// separated list of users of source, with opts, giving up if ctx is done
+// first.
+func buildAndStoreNetworkMap(ctx context.Context, source, key string, opts networkOptions) ([]byte, error) {
+    js, err := buildNetworkMap(ctx, source, key, opts)
+    if err != nil {
+        return nil, err
+    }
+    if err = storeNetworkMap(source, key, opts, js); err != nil {
+        return nil, err
+    }
+    return js, nil
+}
+
+// buildNetworkMap builds the JSON network map for key, a '+' separated list
+// of users of source, with opts, giving up if ctx is done first.
+func buildNetworkMap(ctx context.Context, source, key string, opts networkOptions) ([]byte, error) {
+    if parts := strings.Split(source, ","); len(parts) > 1 {
+        return buildFederatedNetworkMap(ctx, parts, key, opts)
+    } else if opts.Group != "" {
+        return groupNetworkMap(ctx, source, key, opts)
+    } else if opts.Metadata {
+        return describeNetworkMap(ctx, source, key, opts)
+    } else if opts.Weight != "" {
+        return weightNetworkMap(ctx, source, key, opts)
+    } else if opts.Playlists {
+        return playlistNetworkMap(ctx, source, key)
+    } else if opts.Relation == networkmapper.RelationBoth {
+        return combineNetworkMap(ctx, source, key, opts)
+    } else if opts.Depth > 1 {
+        return expandNetworkMap(ctx, source, key, opts)
+    }
+    return builds.Build(ctx, networkMapperFor(source, opts), strings.Split(key, "+"))
+}
+
+// storeNetworkMap caches js as the network for key built with opts. It's
+// fresh for result_cache_ttl seconds, and then served stale for stale_ttl
+// more while it's rebuilt. A partial network is neither cached nor kept in
+// history, so the next request builds it again.
+func storeNetworkMap(source, key string, opts networkOptions, js []byte) error {
+
+    if isPartialNetwork(js) {
+        return nil
+    }
+
+    cacheKey := networkCacheKey(source, key, opts)
+    ttl := time.Duration(config.ResultCacheTTL) * time.Second
+    if config.StaleTTL > 0 {
+        if err := cache.Set(freshKey(cacheKey), []byte{1}, ttl); err != nil {
+            return err
+        }
+        ttl += time.Duration(config.StaleTTL) * time.Second
+    }
+    // A network too big to cache is still served, and built again next time
+    if err := setCachedNetwork(cacheKey, js, ttl); err != nil {
+        if _, ok := err.(*EntryTooLargeError); !ok {
+            return err
+        }
+        log.Println("INFO: " + err.Error())
+    }
+
+    // Keep the rebuilt network in its saved graph's history
+    if opts.Weight == "" && !opts.Metadata && opts.Group == "" && opts.Depth <= 1 && opts.Relation == "" && !opts.Playlists {
+        go snapshotIfSaved(source, key, js)
+    }
+
+    return nil
+}
+
+// refreshIfStale rebuilds the cached network for key built with opts in the
+// background if it's past result_cache_ttl, unless another request or
+// server already is.
+func refreshIfStale(source, key string, opts networkOptions) {
+    if config.StaleTTL <= 0 {
+        return
+    }
+
+    cacheKey := networkCacheKey(source, key, opts)
+    if _, fresh, err := cache.Get(freshKey(cacheKey)); err != nil || fresh {
+        return
+    }
+
+    // Claim the rebuild until the network expires, so it happens once
+    claimed, err := cache.Add(refreshKey(cacheKey), []byte{1}, time.Duration(config.StaleTTL) * time.Second)
+    if err != nil || !claimed {
+        return
+    }
+
+    go func () {
+        defer cache.Delete(refreshKey(cacheKey))
+
+        js, err := buildNetworkMap(context.Background(), source, key, opts)
+        if err != nil {
+            log.Printf("Couldn't refresh the network for %s: %s", cacheKey, err)
+            return
+        }
+        if err = storeNetworkMap(source, key, opts, js); err != nil {
+            log.Printf("Couldn't refresh the network for %s: %s", cacheKey, err)
+            return
+        }
+
+        // Have the CDN fetch the rebuilt network
+        purgeKeys([]string{graphSurrogateKey(source, key)})
+    } ()
+}
+
+// networkMapperFor returns the NetworkMapper of source that fetches what
+// opts ask for.
+func networkMapperFor(source string, opts networkOptions) networkmapper.NetworkMapper {
+    m := networkmapper.WithPageSize(sources[source], opts.PageSize)
+    if opts.Relation == networkmapper.RelationFollowers {
+        m, _ = networkmapper.WithFollowers(m)
+    }
+    return m
+}
+
+// buildFederatedNetworkMap builds the network for key on each of sources
+// and merges them, resolving accounts to identities. Users in key may be
+// qualified as source:user to only look for them on that source.
+func buildFederatedNetworkMap(ctx context.Context, sources []string, key string, opts networkOptions) ([]byte, error) {
+
+    parts := []networkmapper.SourceResult{}
+
+    for _, source := range sources {
+
+        // Pick the users to look for on this source
+        users := []string{}
+        for _, u := range strings.Split(key, "+") {
+            if i := strings.Index(u, ":"); i < 0 {
+                users = append(users, u)
+            } else if u[:i] == source {
+                users = append(users, u[i + 1:])
+            }
+        }
+        if len(users) == 0 {
+            continue
+        }
+
+        js, err := getNetworkMap(ctx, source, strings.Join(users, "+"), opts)
+        if err != nil {
+            return nil, err
+        }
+
+        var result networkmapper.Result
+        if err = json.Unmarshal(js, &result); err != nil {
+            return nil, err
+        }
+        parts = append(parts, networkmapper.SourceResult{Source: source, Result: &result})
+    }
+
+    return json.Marshal(networkmapper.Merge(parts, identities))
+}
+
+// weightNetworkMap weights the links of the network for key, a '+'
+// separated list of users of source, by the interactions between them or
+// by how many of the users share their targets.
+func weightNetworkMap(ctx context.Context, source, key string, opts networkOptions) ([]byte, error) {
+
+    base := opts
+    base.Weight = ""
+    js, err := getNetworkMap(ctx, source, key, base)
+    if err != nil {
+        return nil, err
+    }
+
+    var result networkmapper.Result
+    if err = json.Unmarshal(js, &result); err != nil {
+        return nil, err
+    }
+
+    switch opts.Weight {
+    case "interactions":
+        err = networkmapper.WeightByInteractions(ctx, &result, sources[source].(networkmapper.InteractionFetcher),
+            MAX_INTERACTION_ACCOUNTS, builds.budget)
+        if err != nil {
+            return nil, err
+        }
+    case "shared":
+        result.WeightByShared()
+    }
+
+    return json.Marshal(result)
+}
+
+// expandNetworkMap expands the network for key, a '+' separated list of
+// users of source, to the followings of its most shared followings.
+func expandNetworkMap(ctx context.Context, source, key string, opts networkOptions) ([]byte, error) {
+
+    base := opts
+    base.Depth = 1
+    js, err := getNetworkMap(ctx, source, key, base)
+    if err != nil {
+        return nil, err
+    }
+
+    var result networkmapper.Result
+    if err = json.Unmarshal(js, &result); err != nil {
+        return nil, err
+    }
+
+    networkmapper.Expand(ctx, networkMapperFor(source, opts), &result, config.MaxExpanded, JOB_CHUNK_SIZE)
+    if err = ctx.Err(); err != nil {
+        return nil, err
+    }
+
+    return json.Marshal(result)
+}
+
+// playlistNetworkMap builds the network of the artists in the playlists
+// of the users in key, a '+' separated list of users of source.
+func playlistNetworkMap(ctx context.Context, source, key string) ([]byte, error) {
+
+    result := networkmapper.CoOccurrence(ctx, sources[source].(networkmapper.PlaylistFetcher), strings.Split(key, "+"))
+    if err := ctx.Err(); err != nil {
+        return nil, err
+    }
+
+    return json.Marshal(result)
+}
+
+// combineNetworkMap combines the networks of who the users in key, a '+'
+// separated list of users of source, follow and who follows them.
+func combineNetworkMap(ctx context.Context, source, key string, opts networkOptions) ([]byte, error) {
+
+    parts := make([]*networkmapper.Result, 2)
+    for i, relation := range []string{"", networkmapper.RelationFollowers} {
+        base := opts
+        base.Relation = relation
+        js, err := getNetworkMap(ctx, source, key, base)
+        if err != nil {
+            return nil, err
+        }
+
+        parts[i] = &networkmapper.Result{}
+        if err = json.Unmarshal(js, parts[i]); err != nil {
+            return nil, err
+        }
+    }
+
+    return json.Marshal(networkmapper.Combine(parts[0], parts[1]))
+}
+
+// describeNetworkMap adds the profile of each account to the network for
+// key, a '+' separated list of users of source.
+func describeNetworkMap(ctx context.Context, source, key string, opts networkOptions) ([]byte, error) {
+
+    base := opts
+    base.Metadata = false
+    js, err := getNetworkMap(ctx, source, key, base)
+    if err != nil {
+        return nil, err
+    }
+
+    var result networkmapper.Result
+    if err = json.Unmarshal(js, &result); err != nil {
+        return nil, err
+    }
+
+    networkmapper.AddProfiles(ctx, &result, sources[source].(networkmapper.ProfileFetcher))
+    if err = ctx.Err(); err != nil {
+        return nil, err
+    }
+
+    return json.Marshal(result)
+}
+
+// groupNetworkMap groups the nodes of the network for key, a '+'
+// separated list of users of source, by their profiles or communities.
+func groupNetworkMap(ctx context.Context, source, key string, opts networkOptions) ([]byte, error) {
+
+    base := opts
+    base.Group = ""
+    base.Metadata = opts.Metadata || networkmapper.GroupNeedsProfiles(opts.Group)
+    js, err := getNetworkMap(ctx, source, key, base)
+    if err != nil {
+        return nil, err
+    }
+
+    var result networkmapper.Result
+    if err = json.Unmarshal(js, &result); err != nil {
+        return nil, err
+    }
+
+    switch opts.Group {
+    case networkmapper.GroupGenre:
+        networkmapper.AddGenres(ctx, &result, sources[source].(networkmapper.GenreFetcher))
+    case networkmapper.GroupLabel:
+        networkmapper.AddLabels(ctx, &result, sources[source].(networkmapper.LabelFetcher))
+    }
+    if err = ctx.Err(); err != nil {
+        return nil, err
+    }
+
+    if err = result.GroupBy(opts.Group); err != nil {
+        return nil, err
+    }
+
+    return json.Marshal(result)
+}
+
+// getCachedResult returns the already built Result for key, a '+'
+// separated list of users of source, built with opts, and nil if it isn't
+// in the cache.
+func getCachedResult(source, key string, opts networkOptions) (*networkmapper.Result, error) {
+
+    js, ok, err := getCachedNetwork(networkCacheKey(source, key, opts))
+    if err != nil || !ok {
+        return nil, err
+    }
+
+    var result networkmapper.Result
+    if err = json.Unmarshal(js, &result); err != nil {
+        return nil, err
+    }
+    return &result, nil
+}
+
+// freshKey returns the cache key that's set while the network cached at
+// cacheKey doesn't need rebuilding.
+func freshKey(cacheKey string) string {
+    return "fresh:" + cacheKey
+}
+
+// buildLockKey returns the cache key held while the network cached at
+// cacheKey is first built.
+func buildLockKey(cacheKey string) string {
+    return "build:" + cacheKey
+}
+
+// refreshKey returns the cache key held while the network cached at
+// cacheKey is being rebuilt.
+func refreshKey(cacheKey string) string {
+    return "refresh:" + cacheKey
+}
+
+// networkCacheKey returns the cache key of the network for key built with
+// opts, caching other sources' networks separately from SoundCloud's. The
+// users are sorted, so the same users in any order share a network.
+func networkCacheKey(source, key string, opts networkOptions) string {
+    users := strings.Split(key, "+")
+    sort.Strings(users)
+    key = strings.Join(users, "+")
+
+    if source != DEFAULT_SOURCE {
+        key = source + ":" + key
+    }
+    if opts.Weight != "" {
+        key += "#weight=" + opts.Weight
+    }
+    if opts.Metadata {
+        key += "#metadata"
+    }
+    if opts.Relation != "" {
+        key += "#relation=" + opts.Relation
+    }
+    if opts.Playlists {
+        key += "#playlists"
+    }
+    if opts.Depth > 1 {
+        key += "#depth=" + strconv.Itoa(opts.Depth)
+    }
+    if opts.Group != "" {
+        key += "#group=" + opts.Group
+    }
+    return key
+}
+
+// isPartialNetwork returns whether the network js is missing followings,
+// because its build ran out of time or some couldn't be fetched.
+func isPartialNetwork(js []byte) bool {
+    var network struct {
+        Meta *networkmapper.Meta `json:"meta"`
+    }
+    if err := json.Unmarshal(js, &network); err != nil {
+        return false
+    }
+    return network.Meta != nil && network.Meta.Partial
+}
@@ -0,0 +1,71 @@
+// anonymize.go contains the anonymizing of Results for sharing as
+// datasets, keeping their structure but not who's in them
+
+package networkmapper
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+)
+
+// The hex digits of the hashes names are replaced with.
+const anonymizedNameLength = 16
+
+// Anonymize returns a copy of r with each name replaced by a hash of it
+// keyed with key, so the same account gets the same name across exports
+// made with the same key, and without ids, profiles, group names or
+// anything else that could tell who's who.
+func (r *Result) Anonymize(key []byte) *Result {
+
+    hash := func(name string) string {
+        mac := hmac.New(sha256.New, key)
+        mac.Write([]byte(name))
+        return hex.EncodeToString(mac.Sum(nil))[:anonymizedNameLength]
+    }
+
+    nodes := make([]Node, len(r.Nodes))
+    for i, node := range r.Nodes {
+        nodes[i] = Node{
+            Name: hash(node.Name),
+            Group: node.Group,
+            InDegree: node.InDegree,
+            OutDegree: node.OutDegree,
+            Rank: node.Rank,
+        }
+    }
+
+    links := make([]Link, len(r.Links))
+    copy(links, r.Links)
+
+    anonymized := &Result{Nodes: nodes, Links: links, Directed: r.Directed, Relation: r.Relation}
+
+    // Keep the counts of what was left out, but not who
+    if r.Meta != nil {
+        anonymized.Meta = &Meta{
+            Truncated: r.Meta.Truncated,
+            OmittedNodes: r.Meta.OmittedNodes,
+            OmittedLinks: r.Meta.OmittedLinks,
+            Partial: r.Meta.Partial,
+            SkippedUsers: r.Meta.SkippedUsers + len(r.Meta.Errors),
+        }
+    }
+
+    if r.Stats != nil {
+        stats := *r.Stats
+        if stats.MostShared != "" {
+            stats.MostShared = hash(stats.MostShared)
+        }
+        anonymized.Stats = &stats
+    }
+
+    if r.Similarity != nil {
+        users := make([]string, len(r.Similarity.Users))
+        for i, u := range r.Similarity.Users {
+            users[i] = hash(u)
+        }
+        anonymized.Similarity = &Similarity{Users: users, Jaccard: r.Similarity.Jaccard}
+    }
+
+    return anonymized
+}
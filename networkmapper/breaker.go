@@ -0,0 +1,174 @@
+// breaker.go contains the circuit breaker that stops calling a source once
+// it's down, failing fast instead of hanging every build, until a probe
+// finds it has recovered
+
+package networkmapper
+
+import (
+    "context"
+    "strconv"
+    "sync"
+    "time"
+)
+
+const (
+    // The consecutive failures that trip a breaker
+    breakerThreshold = 5
+
+    // How long a tripped breaker fails fast before probing the source
+    breakerCooldown = 30 * time.Second
+)
+
+// A type that satisfies networkmapper.AvailabilityReporter stops calling
+// its source while it's down.
+type AvailabilityReporter interface {
+
+    // Gets an *UnavailableError if the source is down, or nil
+    Available() error
+
+}
+
+// A type for the error returned instead of calling a source that's down.
+type UnavailableError struct {
+
+    // The name of the source
+    Source string
+
+    // How long until the source is probed again
+    RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+    return e.Source + " is unavailable, try again in " +
+        strconv.Itoa(int(e.RetryAfter.Seconds())) + " seconds"
+}
+
+// A type for the error returned when a source couldn't be reached or
+// errored.
+type SourceError struct {
+    Source string
+    Err error
+}
+
+func (e *SourceError) Error() string {
+    return e.Source + ": " + e.Err.Error()
+}
+
+// A type for a circuit breaker, which opens after threshold consecutive
+// failures and then lets a single probe through every cooldown until one
+// succeeds.
+type Breaker struct {
+    mu sync.Mutex
+    source string
+    threshold int
+    cooldown time.Duration
+    failures int
+    opened time.Time
+    probing bool
+}
+
+// NewBreaker creates a closed Breaker for the named source.
+func NewBreaker(source string, threshold int, cooldown time.Duration) *Breaker {
+    return &Breaker{source: source, threshold: threshold, cooldown: cooldown}
+}
+
+// Allow returns nil if a call may be made, which must then be reported to
+// Success, Failure or Abandon, or an *UnavailableError if the breaker is
+// open.
+func (b *Breaker) Allow() error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    if b.failures < b.threshold {
+        return nil
+    }
+
+    // Let one call through to probe the source once the cooldown is over
+    if time.Since(b.opened) >= b.cooldown && !b.probing {
+        b.probing = true
+        return nil
+    }
+    return b.unavailable()
+}
+
+// Success records a successful call, closing the breaker.
+func (b *Breaker) Success() {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    b.failures = 0
+    b.probing = false
+}
+
+// Failure records a failed call, opening the breaker if it's the last
+// straw or a failed probe.
+func (b *Breaker) Failure() {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    b.failures++
+    if b.failures >= b.threshold {
+        b.opened = time.Now()
+        b.probing = false
+    }
+}
+
+// Abandon records a call that was given up on before it could tell
+// whether the source is up, freeing the probe if it was one.
+func (b *Breaker) Abandon() {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    b.probing = false
+}
+
+// Err returns an *UnavailableError if the breaker is open, without taking
+// the probe. Once the cooldown is over and no probe has been taken it
+// returns nil, so a call is made that can take it.
+func (b *Breaker) Err() error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    if b.failures < b.threshold {
+        return nil
+    }
+    if time.Since(b.opened) >= b.cooldown && !b.probing {
+        return nil
+    }
+    return b.unavailable()
+}
+
+// unavailable returns the error for calls while the breaker is open, which
+// b.mu must be held for.
+func (b *Breaker) unavailable() error {
+    wait := b.cooldown - time.Since(b.opened)
+    if wait < time.Second {
+        wait = time.Second
+    }
+    return &UnavailableError{Source: b.source, RetryAfter: wait}
+}
+
+// Available returns an *UnavailableError if SoundCloud is down.
+func (n *networkMapper) Available() error {
+    return n.breaker.Err()
+}
+
+/* Helpers */
+
+// unavailable returns the *UnavailableError of n if it's down, or nil.
+func unavailable(n NetworkMapper) error {
+    if reporter, ok := n.(AvailabilityReporter); ok {
+        return reporter.Available()
+    }
+    return nil
+}
+
+// failed reports whether a call made with ctx that returned a response
+// with status, or err, counts against the breaker: it couldn't be made or
+// the source errored. Rate limited calls don't count.
+func failed(ctx context.Context, status int, err error) bool {
+    if err != nil {
+        return ctx.Err() == nil
+    }
+    return status >= 500
+}
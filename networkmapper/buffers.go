@@ -0,0 +1,61 @@
+// buffers.go contains the pooling of the buffers networks are serialized
+// into, so concurrent responses reuse memory instead of each allocating
+// their own
+
+package networkmapper
+
+import (
+    "bytes"
+    "encoding/json"
+    "sync"
+)
+
+// Buffers that grew larger than this are left to the garbage collector
+// rather than pinning their memory in the pool.
+const maxPooledBufferSize = 16 << 20
+
+var bufferPool = sync.Pool{
+    New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// GetBuffer returns an empty buffer from the pool. It should be handed back
+// with PutBuffer once its contents are no longer used.
+func GetBuffer() *bytes.Buffer {
+    return bufferPool.Get().(*bytes.Buffer)
+}
+
+// PutBuffer returns buf to the pool.
+func PutBuffer(buf *bytes.Buffer) {
+    if buf.Cap() > maxPooledBufferSize {
+        return
+    }
+    buf.Reset()
+    bufferPool.Put(buf)
+}
+
+// EncodeJSON writes v to buf as JSON, without the trailing newline of a
+// json.Encoder, so it matches json.Marshal.
+func EncodeJSON(buf *bytes.Buffer, v interface{}) error {
+    if err := json.NewEncoder(buf).Encode(v); err != nil {
+        return err
+    }
+    buf.Truncate(buf.Len() - 1)
+    return nil
+}
+
+// countingWriter counts the bytes written to it and discards them.
+type countingWriter int
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+    *c += countingWriter(len(p))
+    return len(p), nil
+}
+
+// jsonSize returns the length of the JSON of v without keeping it.
+func jsonSize(v interface{}) (int, error) {
+    var c countingWriter
+    if err := json.NewEncoder(&c).Encode(v); err != nil {
+        return 0, err
+    }
+    return int(c) - 1, nil
+}
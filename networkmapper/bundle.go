@@ -0,0 +1,45 @@
+// bundle.go contains the data for D3 hierarchical edge bundling, an
+// alternative to force layouts for dense networks
+
+package networkmapper
+
+import (
+    "strconv"
+)
+
+// A type for a leaf of a hierarchical edge bundling layout. Its Name is
+// its dot separated path through the community tree, as d3.layout.bundle
+// expects, ending in the node's index; Label is the node's name.
+type BundleNode struct {
+    Name string `json:"name" doc:"The node's dot separated path through the community tree"`
+    Label string `json:"label" doc:"The account's username"`
+    Group int `json:"group"`
+    Imports []string `json:"imports" doc:"The Names of the nodes it links to"`
+}
+
+// Bundle returns r as leaves of its CommunityTree for hierarchical edge
+// bundling, where each node imports the targets of its links.
+func (r *Result) Bundle() []BundleNode {
+
+    levels := r.CommunityTree()
+
+    // Build each node's path, coarsest community first
+    names := make([]string, len(r.Nodes))
+    for i := range r.Nodes {
+        name := "cumuli"
+        for l := len(levels) - 1; l >= 0; l-- {
+            name += ".c" + strconv.Itoa(levels[l][i])
+        }
+        names[i] = name + ".n" + strconv.Itoa(i)
+    }
+
+    bundle := make([]BundleNode, len(r.Nodes))
+    for i, node := range r.Nodes {
+        bundle[i] = BundleNode{Name: names[i], Label: node.Name, Group: node.Group, Imports: []string{}}
+    }
+    for _, l := range r.Links {
+        bundle[l.Source].Imports = append(bundle[l.Source].Imports, names[l.Target])
+    }
+
+    return bundle
+}
@@ -0,0 +1,103 @@
+// collapse.go contains the collapsing of nodes into super-nodes, for a
+// readable "macro map" of a large network
+
+package networkmapper
+
+import (
+    "sort"
+)
+
+// A type for a network of super-nodes.
+type Collapsed struct {
+    Nodes []SuperNode `json:"nodes"`
+    Links []SuperLink `json:"links"`
+}
+
+// A type for a super-node standing for the nodes that share a key. Members
+// and Internal let a client drill down into it.
+type SuperNode struct {
+    Name string `json:"name" doc:"The key its members share"`
+    Group int `json:"group"`
+    Size int `json:"size" doc:"How many nodes it stands for"`
+    Members []string `json:"members" doc:"The names of the nodes it stands for"`
+    Internal int `json:"internal" doc:"How many links there are among the members"`
+}
+
+// A type for the links between two super-nodes, weighted by how many
+// links of the original network they stand for.
+type SuperLink struct {
+    Source int `json:"source" doc:"The index of a super-node"`
+    Target int `json:"target" doc:"The index of a super-node"`
+    Weight int `json:"weight" doc:"How many links of the original network it stands for"`
+}
+
+// Collapse merges the nodes of r that keyOf gives the same key, by node
+// index, into super-nodes named by their key, largest first. Nodes with an
+// empty key are kept as super-nodes of their own.
+func (r *Result) Collapse(keyOf func(i int) string) *Collapsed {
+
+    // Group the nodes by key
+    superNums := make(map[string]int)
+    supers := []SuperNode{}
+    superOf := make([]int, len(r.Nodes))
+
+    for i, node := range r.Nodes {
+        key := keyOf(i)
+        if key == "" {
+            key = node.Name
+        }
+
+        num, ok := superNums[key]
+        if !ok {
+            num = len(supers)
+            superNums[key] = num
+            supers = append(supers, SuperNode{Name: key, Group: node.Group, Members: []string{}})
+        }
+
+        if node.Group < supers[num].Group {
+            supers[num].Group = node.Group
+        }
+        supers[num].Size++
+        supers[num].Members = append(supers[num].Members, node.Name)
+        superOf[i] = num
+    }
+
+    // Order the super-nodes largest first
+    order := make([]int, len(supers))
+    for i := range order {
+        order[i] = i
+    }
+    sort.SliceStable(order, func(a, b int) bool {
+        return supers[order[a]].Size > supers[order[b]].Size
+    })
+
+    newIndex := make([]int, len(supers))
+    nodes := make([]SuperNode, len(supers))
+    for i, num := range order {
+        newIndex[num] = i
+        nodes[i] = supers[num]
+    }
+
+    // Aggregate the links
+    links := []SuperLink{}
+    linkNums := make(map[[2]int]int)
+
+    for _, l := range r.Links {
+        source, target := newIndex[superOf[l.Source]], newIndex[superOf[l.Target]]
+        if source == target {
+            nodes[source].Internal++
+            continue
+        }
+
+        pair := [2]int{source, target}
+        num, ok := linkNums[pair]
+        if !ok {
+            num = len(links)
+            linkNums[pair] = num
+            links = append(links, SuperLink{Source: source, Target: target})
+        }
+        links[num].Weight++
+    }
+
+    return &Collapsed{Nodes: nodes, Links: links}
+}
@@ -0,0 +1,170 @@
+// community.go contains community detection over built Results
+
+package networkmapper
+
+// maxPropagations limits the rounds of label propagation, which can
+// oscillate on bipartite-like networks instead of settling.
+const maxPropagations = 20
+
+// Communities assigns each node of r to a community by label propagation,
+// ignoring link direction, and returns the community of each node,
+// numbered from 0 in order of first appearance.
+func (r *Result) Communities() []int {
+    return propagateLabels(len(r.Nodes), r.weightedAdjacency())
+}
+
+// weightedAdjacency returns, for each node of r, how many links join it
+// to each of its neighbors.
+func (r *Result) weightedAdjacency() []map[int]int {
+    adjacent := make([]map[int]int, len(r.Nodes))
+    for i := range adjacent {
+        adjacent[i] = make(map[int]int)
+    }
+    for _, l := range r.Links {
+        if l.Source != l.Target {
+            adjacent[l.Source][l.Target]++
+            adjacent[l.Target][l.Source]++
+        }
+    }
+    return adjacent
+}
+
+// propagateLabels runs label propagation over a graph of n nodes given by
+// its weighted adjacency and returns the renumbered label of each node.
+// Nodes are visited in order and ties go to the smallest label, so the
+// result is deterministic.
+func propagateLabels(n int, adjacent []map[int]int) []int {
+
+    labels := make([]int, n)
+    for i := range labels {
+        labels[i] = i
+    }
+
+    for round := 0; round < maxPropagations; round++ {
+        changed := false
+
+        for i := 0; i < n; i++ {
+            if len(adjacent[i]) == 0 {
+                continue
+            }
+
+            // Weigh the labels of the neighbors
+            weights := make(map[int]int)
+            for j, w := range adjacent[i] {
+                weights[labels[j]] += w
+            }
+
+            best, bestWeight := labels[i], weights[labels[i]]
+            for label, w := range weights {
+                if w > bestWeight || (w == bestWeight && label < best) {
+                    best, bestWeight = label, w
+                }
+            }
+
+            if best != labels[i] {
+                labels[i] = best
+                changed = true
+            }
+        }
+
+        if !changed {
+            break
+        }
+    }
+
+    // Renumber the labels from 0
+    renumbered := make(map[int]int)
+    for i, label := range labels {
+        num, ok := renumbered[label]
+        if !ok {
+            num = len(renumbered)
+            renumbered[label] = num
+        }
+        labels[i] = num
+    }
+
+    return labels[0:]
+}
+
+// CollapseCommunities collapses each of the Communities of r into a
+// super-node named after its largest member.
+func (r *Result) CollapseCommunities() *Collapsed {
+    return r.Collapse(r.communityNames())
+}
+
+// communityNames returns the name of the community of each node of r, by
+// node index, after the community's highest degree node.
+func (r *Result) communityNames() func(i int) string {
+
+    communities := r.Communities()
+    degrees := r.Degrees()
+
+    names := make(map[int]string)
+    best := make(map[int]int)
+    for i, c := range communities {
+        if b, ok := best[c]; !ok || degrees[i] > degrees[b] {
+            best[c] = i
+            names[c] = r.Nodes[i].Name
+        }
+    }
+
+    return func(i int) string {
+        return names[communities[i]] + " community"
+    }
+}
+
+// CommunityTree groups the nodes of r into a hierarchy of communities.
+// The first level is Communities; each further level is found by label
+// propagation over the network of the level below, with links between
+// communities weighted by how many links join them, until the communities
+// stop merging. It returns each level's community of every node, finest
+// first.
+func (r *Result) CommunityTree() [][]int {
+
+    levels := [][]int{r.Communities()}
+    adjacent := r.weightedAdjacency()
+
+    for {
+        below := levels[len(levels) - 1]
+        count := 0
+        for _, c := range below {
+            if c + 1 > count {
+                count = c + 1
+            }
+        }
+
+        // Build the network of the communities below
+        communityAdjacent := make([]map[int]int, count)
+        for i := range communityAdjacent {
+            communityAdjacent[i] = make(map[int]int)
+        }
+        for i, neighbors := range adjacent {
+            for j, w := range neighbors {
+                if below[i] != below[j] {
+                    communityAdjacent[below[i]][below[j]] += w
+                }
+            }
+        }
+
+        merged := propagateLabels(count, communityAdjacent)
+
+        // Stop once the communities don't merge any further
+        mergedCount := 0
+        for _, c := range merged {
+            if c + 1 > mergedCount {
+                mergedCount = c + 1
+            }
+        }
+        if mergedCount >= count || count <= 1 {
+            break
+        }
+
+        level := make([]int, len(below))
+        for i, c := range below {
+            level[i] = merged[c]
+        }
+        levels = append(levels, level)
+    }
+
+    return levels
+}
@@ -0,0 +1,39 @@
+// compat.go contains the original Result format, which is kept so embeds
+// and scripts written against it keep working as the Result grows.
+
+package networkmapper
+
+// A type for a Result in the original format, version v0.
+type LegacyResult struct {
+    Nodes []LegacyNode `json:"nodes"`
+    Links []LegacyLink `json:"links"`
+}
+
+// A type for each node in the original format.
+type LegacyNode struct {
+    Name string `json:"name"`
+    Group int `json:"group"`
+}
+
+// A type for each link in the original format, by node index.
+type LegacyLink struct {
+    Source int `json:"source" doc:"The index of the following node"`
+    Target int `json:"target" doc:"The index of the followed node"`
+}
+
+// Legacy returns r in the original {nodes, links} format, dropping
+// everything that has been added to the Result since.
+func (r *Result) Legacy() *LegacyResult {
+
+    nodes := make([]LegacyNode, len(r.Nodes))
+    for i, node := range r.Nodes {
+        nodes[i] = LegacyNode{Name: node.Name, Group: node.Group}
+    }
+
+    links := make([]LegacyLink, len(r.Links))
+    for i, l := range r.Links {
+        links[i] = LegacyLink{Source: l.Source, Target: l.Target}
+    }
+
+    return &LegacyResult{Nodes: nodes, Links: links}
+}
@@ -0,0 +1,68 @@
+// cost.go contains estimates of how expensive a network is to build
+
+package networkmapper
+
+import (
+    "context"
+    "sync"
+    "time"
+)
+
+const (
+    // Above this many users, costs are estimated without asking the source
+    maxEstimatedUsers = 20
+
+    // The followings assumed for a user whose count is unknown
+    defaultFollowingsCount = 500
+
+    // How long counts are looked up for before the rest are assumed
+    estimateTimeout = 2 * time.Second
+)
+
+// A type that satisfies networkmapper.CostEstimator can tell how many
+// users a user follows without fetching them all, so builds can be
+// scheduled by cost.
+type CostEstimator interface {
+
+    // Gets the number of followings of a given user
+    FollowingsCount(ctx context.Context, user string) (int, error)
+
+}
+
+// EstimateCost returns the estimated number of followings that building
+// the network for users with n will fetch. Counts are only looked up for
+// small sets of users, all at once and for at most estimateTimeout; larger
+// sets, sources that aren't a CostEstimator, and users whose counts aren't
+// found in time are assumed to have an average number of followings.
+func EstimateCost(ctx context.Context, n NetworkMapper, users []string) int {
+
+    estimator, ok := n.(CostEstimator)
+    if !ok || len(users) > maxEstimatedUsers {
+        return len(users) * defaultFollowingsCount
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, estimateTimeout)
+    defer cancel()
+
+    counts := make([]int, len(users))
+    var wg sync.WaitGroup
+    for i, u := range users {
+        wg.Add(1)
+        go func(i int, u string) {
+            count, err := estimator.FollowingsCount(ctx, u)
+            if err != nil {
+                count = defaultFollowingsCount
+            }
+            counts[i] = count
+            wg.Done()
+        } (i, u)
+    }
+    wg.Wait()
+
+    cost := 0
+    for _, count := range counts {
+        cost += count
+    }
+
+    return cost
+}
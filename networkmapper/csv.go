@@ -0,0 +1,69 @@
+// csv.go contains the export of Results as CSV node and edge lists, for
+// loading into pandas, R and spreadsheets
+
+package networkmapper
+
+import (
+    "archive/zip"
+    "encoding/csv"
+    "io"
+    "strconv"
+)
+
+// WriteNodesCSV writes the nodes of r to w as CSV with the columns id,
+// name and group. A node's id is its index in r.Nodes, which is what the
+// source and target columns of WriteEdgesCSV refer to.
+func WriteNodesCSV(w io.Writer, r *Result) error {
+
+    c := csv.NewWriter(w)
+    c.Write([]string{"id", "name", "group"})
+    for i, node := range r.Nodes {
+        c.Write([]string{strconv.Itoa(i), node.Name, strconv.Itoa(node.Group)})
+    }
+
+    c.Flush()
+    return c.Error()
+}
+
+// WriteEdgesCSV writes the links of r to w as CSV with the columns
+// source, target and weight, which is 1 for unweighted links.
+func WriteEdgesCSV(w io.Writer, r *Result) error {
+
+    c := csv.NewWriter(w)
+    c.Write([]string{"source", "target", "weight"})
+    for _, l := range r.Links {
+        weight := l.Weight
+        if weight == 0 {
+            weight = 1
+        }
+        c.Write([]string{strconv.Itoa(l.Source), strconv.Itoa(l.Target), strconv.Itoa(weight)})
+    }
+
+    c.Flush()
+    return c.Error()
+}
+
+// WriteCSVZip writes r to w as a zip of nodes.csv and edges.csv, as
+// written by WriteNodesCSV and WriteEdgesCSV.
+func WriteCSVZip(w io.Writer, r *Result) error {
+
+    z := zip.NewWriter(w)
+
+    f, err := z.Create("nodes.csv")
+    if err != nil {
+        return err
+    }
+    if err = WriteNodesCSV(f, r); err != nil {
+        return err
+    }
+
+    f, err = z.Create("edges.csv")
+    if err != nil {
+        return err
+    }
+    if err = WriteEdgesCSV(f, r); err != nil {
+        return err
+    }
+
+    return z.Close()
+}
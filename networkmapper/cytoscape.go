@@ -0,0 +1,74 @@
+// cytoscape.go contains the Cytoscape.js elements format of a Result, for
+// Cytoscape based frontends
+
+package networkmapper
+
+import (
+    "strconv"
+)
+
+// A type for a Result in the Cytoscape.js elements format.
+type CytoscapeResult struct {
+    Elements CytoscapeElements `json:"elements"`
+    Meta *Meta `json:"meta,omitempty"`
+}
+
+// A type for the nodes and edges of a CytoscapeResult.
+type CytoscapeElements struct {
+    Nodes []CytoscapeNode `json:"nodes"`
+    Edges []CytoscapeEdge `json:"edges"`
+}
+
+// A type for each node in the Cytoscape.js format.
+type CytoscapeNode struct {
+    Data CytoscapeNodeData `json:"data"`
+}
+
+// A type for the data of a CytoscapeNode: the Node, with its name as id
+// and its own id moved aside.
+type CytoscapeNodeData struct {
+    Id string `json:"id" doc:"The account's username"`
+    AccountId int64 `json:"account_id,omitempty" doc:"The account's id, which unlike its name doesn't change"`
+    Node
+}
+
+// A type for each edge in the Cytoscape.js format.
+type CytoscapeEdge struct {
+    Data CytoscapeEdgeData `json:"data"`
+}
+
+// A type for the data of a CytoscapeEdge, referring to nodes by id.
+type CytoscapeEdgeData struct {
+    Id string `json:"id"`
+    Source string `json:"source" doc:"The id of the following node"`
+    Target string `json:"target" doc:"The id of the followed node"`
+    Sources []string `json:"sources,omitempty" doc:"The sources the link was found on, in merged networks"`
+    Weight int `json:"weight,omitempty" doc:"The strength of the link, when weighted"`
+    Mutual bool `json:"mutual,omitempty" doc:"Whether the target follows the source back, between the users or in directed networks"`
+    Type string `json:"type,omitempty" doc:"In networks of both relations, follows if it was found among the users' followings, followed_by if among their followers"`
+}
+
+// Cytoscape returns r in the Cytoscape.js elements format. Nodes' ids are
+// their names, and edges' ids e0, e1, ... by index.
+func (r *Result) Cytoscape() *CytoscapeResult {
+
+    nodes := make([]CytoscapeNode, len(r.Nodes))
+    for i, node := range r.Nodes {
+        nodes[i] = CytoscapeNode{Data: CytoscapeNodeData{Id: node.Name, AccountId: node.Id, Node: node}}
+    }
+
+    edges := make([]CytoscapeEdge, len(r.Links))
+    for i, l := range r.Links {
+        edges[i] = CytoscapeEdge{Data: CytoscapeEdgeData{
+            Id: "e" + strconv.Itoa(i),
+            Source: r.Nodes[l.Source].Name,
+            Target: r.Nodes[l.Target].Name,
+            Sources: l.Sources,
+            Weight: l.Weight,
+            Mutual: l.Mutual,
+            Type: l.Type,
+        }}
+    }
+
+    return &CytoscapeResult{Elements: CytoscapeElements{Nodes: nodes, Edges: edges}, Meta: r.Meta}
+}
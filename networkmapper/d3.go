@@ -0,0 +1,43 @@
+// d3.go contains the Result format of d3-force v4 and later, whose links
+// refer to nodes by id rather than by index
+
+package networkmapper
+
+// A type for a Result in the format of d3-force v4 and later, version
+// d3v7, for use with forceLink().id(d => d.name).
+type NamedResult struct {
+    Nodes []Node `json:"nodes"`
+    Links []NamedLink `json:"links"`
+    Meta *Meta `json:"meta,omitempty"`
+    Directed bool `json:"directed,omitempty"`
+    Groups []string `json:"groups,omitempty"`
+}
+
+// A type for each link in the d3v7 format, by node name.
+type NamedLink struct {
+    Source string `json:"source" doc:"The name of the following node"`
+    Target string `json:"target" doc:"The name of the followed node"`
+    Sources []string `json:"sources,omitempty" doc:"The sources the link was found on, in merged networks"`
+    Weight int `json:"weight,omitempty" doc:"The strength of the link, when weighted"`
+    Mutual bool `json:"mutual,omitempty" doc:"Whether the target follows the source back, between the users or in directed networks"`
+    Type string `json:"type,omitempty" doc:"In networks of both relations, follows if it was found among the users' followings, followed_by if among their followers"`
+}
+
+// Named returns r in the d3v7 format, with links referring to nodes by
+// name, which is unique within a network.
+func (r *Result) Named() *NamedResult {
+
+    links := make([]NamedLink, len(r.Links))
+    for i, l := range r.Links {
+        links[i] = NamedLink{
+            Source: r.Nodes[l.Source].Name,
+            Target: r.Nodes[l.Target].Name,
+            Sources: l.Sources,
+            Weight: l.Weight,
+            Mutual: l.Mutual,
+            Type: l.Type,
+        }
+    }
+
+    return &NamedResult{Nodes: r.Nodes, Links: links, Meta: r.Meta, Directed: r.Directed, Groups: r.Groups}
+}
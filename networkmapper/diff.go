@@ -0,0 +1,73 @@
+// diff.go contains the differences between two builds of a network, such
+// as two snapshots of a saved graph, to show what changed in between
+
+package networkmapper
+
+// A type for what changed from one network to another, with links by
+// node name, since node indexes differ between networks.
+type ResultDiff struct {
+    AddedNodes []Node `json:"added_nodes" doc:"The nodes only in the newer network, as they are in it"`
+    RemovedNodes []Node `json:"removed_nodes" doc:"The nodes only in the older network, as they were in it"`
+    AddedLinks []NamedLink `json:"added_links" doc:"The links only in the newer network"`
+    RemovedLinks []NamedLink `json:"removed_links" doc:"The links only in the older network"`
+}
+
+// Diff returns what changed from the network from to the network to.
+// Nodes are matched by name, and links by the names of their ends and
+// their type.
+func Diff(from, to *Result) *ResultDiff {
+
+    return &ResultDiff{
+        AddedNodes: missingNodes(to, from),
+        RemovedNodes: missingNodes(from, to),
+        AddedLinks: missingLinks(to, from),
+        RemovedLinks: missingLinks(from, to),
+    }
+}
+
+/* Helpers */
+
+// missingNodes returns the nodes of r that other doesn't have.
+func missingNodes(r, other *Result) []Node {
+
+    has := make(map[string]bool, len(other.Nodes))
+    for _, node := range other.Nodes {
+        has[node.Name] = true
+    }
+
+    missing := []Node{}
+    for _, node := range r.Nodes {
+        if !has[node.Name] {
+            missing = append(missing, node)
+        }
+    }
+    return missing
+}
+
+// missingLinks returns the links of r, by name, that other doesn't have.
+func missingLinks(r, other *Result) []NamedLink {
+
+    has := make(map[linkKey]bool, len(other.Links))
+    for _, l := range other.Links {
+        has[other.linkKey(l)] = true
+    }
+
+    named := r.Named().Links
+    missing := []NamedLink{}
+    for i, l := range r.Links {
+        if !has[r.linkKey(l)] {
+            missing = append(missing, named[i])
+        }
+    }
+    return missing
+}
+
+// A type for what identifies a link across networks.
+type linkKey struct {
+    source, target, typ string
+}
+
+// linkKey returns what identifies l, a link of r, across networks.
+func (r *Result) linkKey(l Link) linkKey {
+    return linkKey{r.Nodes[l.Source].Name, r.Nodes[l.Target].Name, l.Type}
+}
@@ -0,0 +1,51 @@
+// dot.go contains the export of Results as Graphviz DOT, to be piped into
+// dot, neato and the like
+
+package networkmapper
+
+import (
+    "bufio"
+    "io"
+    "strconv"
+    "strings"
+)
+
+// WriteDOT writes r to w as a Graphviz digraph. Nodes have the ids n0,
+// n1, ... by index, are labelled with their names and carry their group;
+// edges carry their weight, 1 if unweighted.
+func WriteDOT(w io.Writer, r *Result) error {
+
+    b := bufio.NewWriter(w)
+    b.WriteString("digraph cumuli {\n")
+
+    for i, node := range r.Nodes {
+        b.WriteString("  n" + strconv.Itoa(i) + " [label=" + dotQuote(node.Name) +
+            ", group=" + strconv.Itoa(node.Group) + "];\n")
+    }
+
+    for _, l := range r.Links {
+        weight := l.Weight
+        if weight == 0 {
+            weight = 1
+        }
+        attrs := "weight=" + strconv.Itoa(weight)
+        if l.Mutual {
+            attrs += ", dir=both"
+        }
+        b.WriteString("  n" + strconv.Itoa(l.Source) + " -> n" + strconv.Itoa(l.Target) +
+            " [" + attrs + "];\n")
+    }
+
+    b.WriteString("}\n")
+    return b.Flush()
+}
+
+/* Helpers */
+
+// dotQuote returns s as a quoted DOT string.
+func dotQuote(s string) string {
+    s = strings.Replace(s, `\`, `\\`, -1)
+    s = strings.Replace(s, `"`, `\"`, -1)
+    s = strings.Replace(s, "\n", `\n`, -1)
+    return `"` + s + `"`
+}
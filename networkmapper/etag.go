@@ -0,0 +1,87 @@
+// etag.go contains the conditional fetching of pages by their ETags, so
+// refreshing followings that haven't changed costs SoundCloud a 304
+// rather than the whole page
+
+package networkmapper
+
+import (
+    "bytes"
+    "io/ioutil"
+    "net/http"
+)
+
+// A type for a cache of up to size pages and their ETags, dropping the
+// least recently used first when it's full.
+type ETagCache struct {
+    lru *lruCache
+}
+
+// A type for a page in an ETagCache.
+type etagEntry struct {
+    etag string
+    body []byte
+}
+
+// NewETagCache creates an ETagCache of up to size pages.
+func NewETagCache(size int) *ETagCache {
+    return &ETagCache{lru: newLRUCache(size, 0)}
+}
+
+// WithETagCache makes the NetworkMapper keep up to size pages with their
+// ETags, and only fetch them again if they've changed.
+func WithETagCache(size int) Option {
+    return func(n *networkMapper) {
+        if size > 0 {
+            n.etags = NewETagCache(size)
+        }
+    }
+}
+
+// prepare makes req conditional on its page having changed, if it's
+// cached, returning the cached page.
+func (c *ETagCache) prepare(req *http.Request) *etagEntry {
+    if c == nil {
+        return nil
+    }
+
+    e, ok := c.lru.get(req.URL.String())
+    if !ok {
+        return nil
+    }
+
+    entry := e.(*etagEntry)
+    req.Header.Set("If-None-Match", entry.etag)
+    return entry
+}
+
+// resolve returns r, the response to req, with the cached page prepare
+// returned if it hasn't changed, caching it if it has an ETag.
+func (c *ETagCache) resolve(req *http.Request, cached *etagEntry, r *http.Response) (*http.Response, error) {
+    if c == nil {
+        return r, nil
+    }
+
+    // An unchanged page is a cache hit
+    if r.StatusCode == http.StatusNotModified && cached != nil {
+        r.Body.Close()
+        r.StatusCode = http.StatusOK
+        r.Status = "200 OK"
+        r.Body = ioutil.NopCloser(bytes.NewReader(cached.body))
+        return r, nil
+    }
+
+    etag := r.Header.Get("ETag")
+    if r.StatusCode != http.StatusOK || etag == "" {
+        return r, nil
+    }
+
+    body, err := ioutil.ReadAll(r.Body)
+    r.Body.Close()
+    if err != nil {
+        return nil, err
+    }
+    r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+    c.lru.put(req.URL.String(), &etagEntry{etag: etag, body: body})
+    return r, nil
+}
@@ -0,0 +1,127 @@
+// expand.go contains the expansion of a network one hop further, to the
+// followings of its shared followings, revealing the wider scene around
+// the users being compared
+
+package networkmapper
+
+import (
+    "context"
+    "sort"
+)
+
+// The group of the nodes added by Expand.
+const expandedGroup = 3
+
+// Expand adds to r the accounts followed by at least minShared of its
+// limit most shared followings, as nodes of group 3, along with the links
+// from those followings to them and to the nodes r already has. Networks
+// of followers are expanded to the followers of their followers. Only limit
+// followings' followings are fetched, chunkSize at a time, to keep the
+// fan-out in check. Followings that can't be fetched are listed in its
+// Meta, as are those skipped because ctx was done first.
+func Expand(ctx context.Context, n NetworkMapper, r *Result, limit, chunkSize int) {
+
+    // Expand the followings most of the users share
+    shared := make([]int, len(r.Nodes))
+    for _, l := range r.Links {
+        if user, other := r.ends(l); r.Nodes[user].Group == 1 {
+            shared[other]++
+        }
+    }
+
+    order := []int{}
+    for i, node := range r.Nodes {
+        if node.Group != 1 {
+            order = append(order, i)
+        }
+    }
+    sort.SliceStable(order, func(a, b int) bool {
+        return shared[order[a]] > shared[order[b]]
+    })
+    if len(order) > limit {
+        order = order[:limit]
+    }
+    if len(order) == 0 {
+        return
+    }
+
+    names := make([]string, len(order))
+    for i, num := range order {
+        names[i] = r.Nodes[num].Name
+    }
+    if chunkSize < 1 {
+        chunkSize = len(names)
+    }
+
+    // Links run the other way in networks of followers
+    link := func(who, found int) Link {
+        if r.Relation == RelationFollowers {
+            return Link{Source: found, Target: who}
+        }
+        return Link{Source: who, Target: found}
+    }
+
+    nodeNums := make(map[string]int, len(r.Nodes))
+    for i, node := range r.Nodes {
+        nodeNums[node.Name] = i
+    }
+
+    // Link to the nodes r has, and count the followers of the rest
+    type pair struct {
+        source int
+        target string
+    }
+    var (
+        fetched int
+        errs []FetchError
+        pending []pair
+    )
+    followers := make(map[string]int)
+
+    cf := untilDone(GetAllFollowingsChunked(ctx, n, names, chunkSize), ctx.Done(), &fetched)
+    for fs := range collectErrors(cf, &errs) {
+        source := nodeNums[fs.Who]
+        seen := make(map[string]bool, len(fs.Whoms))
+        for _, f := range fs.Whoms {
+            if f == "" || seen[f] {
+                continue
+            }
+            seen[f] = true
+
+            if target, ok := nodeNums[f]; ok {
+                if target != source {
+                    r.Links = append(r.Links, link(source, target))
+                }
+                continue
+            }
+            followers[f]++
+            pending = append(pending, pair{source, f})
+        }
+    }
+
+    // Add the accounts enough of them follow
+    for _, p := range pending {
+        if followers[p.target] < minShared {
+            continue
+        }
+        target, ok := nodeNums[p.target]
+        if !ok {
+            target = len(r.Nodes)
+            nodeNums[p.target] = target
+            r.Nodes = append(r.Nodes, Node{Name: p.target, Group: expandedGroup})
+        }
+        r.Links = append(r.Links, link(p.source, target))
+    }
+
+    if fetched < len(names) || len(errs) > 0 {
+        if r.Meta == nil {
+            r.Meta = &Meta{}
+        }
+        r.Meta.Partial = true
+        r.Meta.SkippedUsers += len(names) - fetched
+        r.Meta.Errors = append(r.Meta.Errors, errs...)
+    }
+
+    r.countDegrees()
+    r.Stats = r.ComputeStats()
+}
@@ -0,0 +1,73 @@
+// filter.go contains the filtering of Results down to the nodes matching
+// some tests, so clients don't have to re-index links themselves
+
+package networkmapper
+
+import (
+    "regexp"
+)
+
+// A type for a test of whether to keep the node of r at index i.
+type Predicate func(r *Result, i int) bool
+
+// Filter returns a new Result holding the nodes of r that pass every one
+// of keep and the links between them, re-indexed to match. Nodes keep
+// their order from r, and their degrees are counted among what's kept.
+// Filtered out nodes and links are counted as omitted if r has a Meta.
+func (r *Result) Filter(keep ...Predicate) *Result {
+
+    kept := make([]bool, len(r.Nodes))
+    for i := range r.Nodes {
+        kept[i] = true
+        for _, k := range keep {
+            if !k(r, i) {
+                kept[i] = false
+                break
+            }
+        }
+    }
+
+    filtered := r.keepNodes(kept)
+    if r.Meta != nil {
+        meta := *r.Meta
+        meta.OmittedNodes += len(r.Nodes) - len(filtered.Nodes)
+        meta.OmittedLinks += len(r.Links) - len(filtered.Links)
+        filtered.Meta = &meta
+    }
+    return filtered
+}
+
+// MinDegree keeps the nodes with at least min links to or from them.
+func MinDegree(min int) Predicate {
+    return func(r *Result, i int) bool {
+        return r.Nodes[i].InDegree + r.Nodes[i].OutDegree >= min
+    }
+}
+
+// InGroups keeps the nodes in any of groups.
+func InGroups(groups ...int) Predicate {
+    return func(r *Result, i int) bool {
+        for _, g := range groups {
+            if r.Nodes[i].Group == g {
+                return true
+            }
+        }
+        return false
+    }
+}
+
+// NameMatches keeps the nodes whose names match re.
+func NameMatches(re *regexp.Regexp) Predicate {
+    return func(r *Result, i int) bool {
+        return re.MatchString(r.Nodes[i].Name)
+    }
+}
+
+// FollowersBetween keeps the nodes with from min to max followers, or at
+// least min if max is 0. Nodes without profiles are left out.
+func FollowersBetween(min, max int) Predicate {
+    return func(r *Result, i int) bool {
+        p := r.Nodes[i].Profile
+        return p != nil && p.Followers >= min && (max == 0 || p.Followers <= max)
+    }
+}
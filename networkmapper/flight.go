@@ -0,0 +1,74 @@
+// flight.go contains the coalescing of concurrent fetches of the same
+// user's followings, so overlapping builds share a single fetch
+
+package networkmapper
+
+import (
+    "context"
+    "strings"
+    "sync"
+)
+
+// flightGroup coalesces concurrent fetches of the same user's followings.
+type flightGroup struct {
+    mu sync.Mutex
+    flights map[string]*flight
+}
+
+// flight is a fetch in progress, whose results are set before done is
+// closed.
+type flight struct {
+    done chan struct{}
+    followings []string
+    err *FetchError
+
+    // Whether the fetch ran to the end rather than being cut short by the
+    // context of the build that started it
+    complete bool
+}
+
+// newFlightGroup creates an empty flightGroup.
+func newFlightGroup() *flightGroup {
+    return &flightGroup{flights: make(map[string]*flight)}
+}
+
+// do calls fetch for user, unless a fetch for user is already in flight,
+// in which case it waits for that one's results instead. A fetch cut short
+// by the context of the build that started it is tried again. do returns
+// early if ctx is done first.
+func (g *flightGroup) do(ctx context.Context, user string, fetch func() ([]string, *FetchError, bool)) ([]string, *FetchError) {
+
+    key := strings.ToLower(user)
+
+    for {
+        g.mu.Lock()
+        f, ok := g.flights[key]
+        if !ok {
+            f = &flight{done: make(chan struct{})}
+            g.flights[key] = f
+            g.mu.Unlock()
+
+            f.followings, f.err, f.complete = fetch()
+
+            g.mu.Lock()
+            delete(g.flights, key)
+            g.mu.Unlock()
+            close(f.done)
+
+            return f.followings, f.err
+        }
+        g.mu.Unlock()
+
+        select {
+        case <-f.done:
+            if f.complete {
+                return f.followings, f.err
+            }
+        case <-ctx.Done():
+            return []string{}, nil
+        }
+        if ctx.Err() != nil {
+            return f.followings, nil
+        }
+    }
+}
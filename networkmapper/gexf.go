@@ -0,0 +1,138 @@
+// gexf.go contains the export of Results as GEXF, the format Gephi opens
+
+package networkmapper
+
+import (
+    "encoding/xml"
+    "io"
+    "strconv"
+)
+
+const gexfNamespace = "http://gexf.net/1.3"
+
+// Types for GEXF marshaling.
+type gexf struct {
+    XMLName xml.Name `xml:"gexf"`
+    Xmlns string `xml:"xmlns,attr"`
+    Version string `xml:"version,attr"`
+    Graph gexfGraph `xml:"graph"`
+}
+
+type gexfGraph struct {
+    DefaultEdgeType string `xml:"defaultedgetype,attr"`
+    Attributes []gexfAttributes `xml:"attributes"`
+    Nodes []gexfNode `xml:"nodes>node"`
+    Edges []gexfEdge `xml:"edges>edge"`
+}
+
+type gexfAttributes struct {
+    Class string `xml:"class,attr"`
+    Attributes []gexfAttribute `xml:"attribute"`
+}
+
+type gexfAttribute struct {
+    Id string `xml:"id,attr"`
+    Title string `xml:"title,attr"`
+    Type string `xml:"type,attr"`
+}
+
+type gexfNode struct {
+    Id string `xml:"id,attr"`
+    Label string `xml:"label,attr"`
+    Values []gexfValue `xml:"attvalues>attvalue,omitempty"`
+}
+
+type gexfEdge struct {
+    Id string `xml:"id,attr"`
+    Source string `xml:"source,attr"`
+    Target string `xml:"target,attr"`
+    Weight int `xml:"weight,attr"`
+    Values []gexfValue `xml:"attvalues>attvalue,omitempty"`
+}
+
+type gexfValue struct {
+    For string `xml:"for,attr"`
+    Value string `xml:"value,attr"`
+}
+
+// The attributes of the nodes and edges of a GEXF export. An edge's weight
+// is an attribute of its own in GEXF.
+var gexfAttributeClasses = []gexfAttributes{
+    {Class: "node", Attributes: []gexfAttribute{
+        {Id: "group", Title: "group", Type: "integer"},
+        {Id: "account_id", Title: "account_id", Type: "long"},
+        {Id: "full_name", Title: "full_name", Type: "string"},
+        {Id: "followers", Title: "followers", Type: "integer"},
+        {Id: "tracks", Title: "tracks", Type: "integer"},
+        {Id: "city", Title: "city", Type: "string"},
+    }},
+    {Class: "edge", Attributes: []gexfAttribute{
+        {Id: "mutual", Title: "mutual", Type: "boolean"},
+        {Id: "type", Title: "type", Type: "string"},
+    }},
+}
+
+// WriteGEXF writes r to w as a directed GEXF graph. Nodes have the ids n0,
+// n1, ... by index and their names as labels, and carry their group and
+// whatever of their profile is known, like WriteGraphML's; edges have
+// their weight, 1 if unweighted.
+func WriteGEXF(w io.Writer, r *Result) error {
+
+    g := gexf{
+        Xmlns: gexfNamespace,
+        Version: "1.3",
+        Graph: gexfGraph{
+            DefaultEdgeType: "directed",
+            Attributes: gexfAttributeClasses,
+            Nodes: make([]gexfNode, len(r.Nodes)),
+            Edges: make([]gexfEdge, len(r.Links)),
+        },
+    }
+
+    for i, node := range r.Nodes {
+        values := []gexfValue{{For: "group", Value: strconv.Itoa(node.Group)}}
+        if node.Id != 0 {
+            values = append(values, gexfValue{For: "account_id", Value: strconv.FormatInt(node.Id, 10)})
+        }
+        if p := node.Profile; p != nil {
+            if p.FullName != "" {
+                values = append(values, gexfValue{For: "full_name", Value: p.FullName})
+            }
+            values = append(values,
+                gexfValue{For: "followers", Value: strconv.Itoa(p.Followers)},
+                gexfValue{For: "tracks", Value: strconv.Itoa(p.Tracks)})
+            if p.City != "" {
+                values = append(values, gexfValue{For: "city", Value: p.City})
+            }
+        }
+        g.Graph.Nodes[i] = gexfNode{Id: graphMLNodeId(i), Label: node.Name, Values: values}
+    }
+
+    for i, l := range r.Links {
+        weight := l.Weight
+        if weight == 0 {
+            weight = 1
+        }
+        var values []gexfValue
+        if l.Mutual {
+            values = append(values, gexfValue{For: "mutual", Value: "true"})
+        }
+        if l.Type != "" {
+            values = append(values, gexfValue{For: "type", Value: l.Type})
+        }
+        g.Graph.Edges[i] = gexfEdge{
+            Id: "e" + strconv.Itoa(i),
+            Source: graphMLNodeId(l.Source),
+            Target: graphMLNodeId(l.Target),
+            Weight: weight,
+            Values: values,
+        }
+    }
+
+    if _, err := io.WriteString(w, xml.Header); err != nil {
+        return err
+    }
+    enc := xml.NewEncoder(w)
+    enc.Indent("", "  ")
+    return enc.Encode(g)
+}
@@ -0,0 +1,215 @@
+// github.go contains NetworkMappers for GitHub, mapping the accounts or
+// repositories shared among developers.
+
+package networkmapper
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    neturl "net/url"
+    "regexp"
+    "strconv"
+    "time"
+)
+
+const gitHubAPI = `https://api.github.com`
+
+// gitHubNext matches the next page in a GitHub Link header.
+var gitHubNext = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// gitHubNetworkMapper is the Provider for the GitHub REST API.
+type gitHubNetworkMapper struct {
+    token string
+    stars bool
+    perPage int
+    client *http.Client
+    quota *Quota
+}
+
+// NewGitHubNetworkMapper creates a NetworkMapper whose followings are the
+// GitHub accounts a user follows. Requests are authenticated with token,
+// a personal access token, unless it is empty.
+func NewGitHubNetworkMapper(token string) NetworkMapper {
+    return &gitHubNetworkMapper{token: token, perPage: maxGitHubPageSize,
+        client: newTimeoutClient(defaultConnectTimeout, defaultReadTimeout),
+        quota: newGitHubQuota(token)}
+}
+
+// NewGitHubStarsNetworkMapper creates a NetworkMapper whose followings are
+// the repositories, named owner/repo, a user has starred.
+func NewGitHubStarsNetworkMapper(token string) NetworkMapper {
+    return &gitHubNetworkMapper{token: token, stars: true, perPage: maxGitHubPageSize,
+        client: newTimeoutClient(defaultConnectTimeout, defaultReadTimeout),
+        quota: newGitHubQuota(token)}
+}
+
+// GetFollowings returns the logins of the accounts user follows, or the
+// names of the repositories they have starred. It returns what it has if
+// ctx is done first or a page can't be fetched.
+func (n *gitHubNetworkMapper) GetFollowings(ctx context.Context, user string) []string {
+    followings, _ := n.TryGetFollowings(ctx, user)
+    return followings
+}
+
+// TryGetFollowings is like GetFollowings but also returns why the
+// followings are incomplete if a page couldn't be fetched.
+func (n *gitHubNetworkMapper) TryGetFollowings(ctx context.Context, user string) ([]string, *FetchError) {
+
+    url := gitHubAPI + `/users/` + neturl.PathEscape(user) + `/following?per_page=` + strconv.Itoa(n.perPage)
+    if n.stars {
+        url = gitHubAPI + `/users/` + neturl.PathEscape(user) + `/starred?per_page=` + strconv.Itoa(n.perPage)
+    }
+
+    followings := []string{}
+
+    // Follow the Link header through every page
+    for page := 0; url != ""; page++ {
+
+        // Accounts have a login, repositories a full_name
+        var p []struct {
+            Login string `json:"login"`
+            FullName string `json:"full_name"`
+        }
+
+        r, err := n.get(ctx, url, &p)
+        if err != nil {
+            return followings, &FetchError{User: user, Page: page, Message: err.Error()}
+        }
+
+        for _, f := range p {
+            if n.stars {
+                followings = append(followings, f.FullName)
+            } else {
+                followings = append(followings, f.Login)
+            }
+        }
+
+        url = ""
+        if m := gitHubNext.FindStringSubmatch(r.Header.Get("Link")); m != nil {
+            url = m[1]
+        }
+    }
+
+    return followings, nil
+}
+
+// PartialResults returns true, so an account that can't be found is left
+// out rather than failing the build.
+func (n *gitHubNetworkMapper) PartialResults() bool {
+    return true
+}
+
+// GetProfile returns the profile of the GitHub account name. Repositories
+// have no profile.
+func (n *gitHubNetworkMapper) GetProfile(ctx context.Context, name string) (*Profile, error) {
+    if n.stars {
+        return &Profile{}, nil
+    }
+
+    u, err := n.GetUser(ctx, name)
+    if err != nil {
+        return nil, err
+    }
+    return u.Profile, nil
+}
+
+// newGitHubQuota creates the Quota GitHub gives token, or an anonymous
+// client if it is empty, until its rate limit headers say otherwise.
+func newGitHubQuota(token string) *Quota {
+    if token == "" {
+        return NewQuota(60, time.Hour)
+    }
+    return NewQuota(5000, time.Hour)
+}
+
+// GetUser returns the account of user on GitHub.
+func (n *gitHubNetworkMapper) GetUser(ctx context.Context, user string) (*User, error) {
+
+    var u gitHubUser
+    if err := n.getJSON(ctx, gitHubAPI + `/users/` + neturl.PathEscape(user), &u); err != nil {
+        return nil, err
+    }
+    return u.user(), nil
+}
+
+// Search returns up to limit GitHub accounts matching q.
+func (n *gitHubNetworkMapper) Search(ctx context.Context, q string, limit int) ([]User, error) {
+
+    var found struct {
+        Items []gitHubUser `json:"items"`
+    }
+    url := gitHubAPI + `/search/users?q=` + neturl.QueryEscape(q) + `&per_page=` + strconv.Itoa(limit)
+    if err := n.getJSON(ctx, url, &found); err != nil {
+        return nil, err
+    }
+
+    users := make([]User, 0, len(found.Items))
+    for _, u := range found.Items {
+        users = append(users, *u.user())
+    }
+    if len(users) > limit {
+        users = users[:limit]
+    }
+    return users, nil
+}
+
+// getJSON gets url from GitHub and unmarshals its JSON body into v.
+func (n *gitHubNetworkMapper) getJSON(ctx context.Context, url string, v interface{}) error {
+    _, err := n.get(ctx, url, v)
+    return err
+}
+
+// get gets url from GitHub, unmarshaling its JSON body into v, and returns
+// the response for its headers.
+func (n *gitHubNetworkMapper) get(ctx context.Context, url string, v interface{}) (*http.Response, error) {
+
+    req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Accept", "application/vnd.github+json")
+    if n.token != "" {
+        req.Header.Set("Authorization", "Bearer " + n.token)
+    }
+
+    n.quota.Take()
+    r, err := n.client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer r.Body.Close()
+    n.quota.Observe(r)
+
+    body, err := ioutil.ReadAll(r.Body)
+    if err != nil {
+        return nil, err
+    }
+    if r.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("github: %s %s", r.Request.URL.Path, r.Status)
+    }
+
+    return r, json.Unmarshal(body, v)
+}
+
+// gitHubUser is a GitHub account as the API returns it.
+type gitHubUser struct {
+    Id int64 `json:"id"`
+    Login string `json:"login"`
+    AvatarURL string `json:"avatar_url"`
+    Name string `json:"name"`
+    Followers int `json:"followers"`
+    Location string `json:"location"`
+    Blog string `json:"blog"`
+}
+
+// user returns the User of u.
+func (u *gitHubUser) user() *User {
+    return &User{
+        Name: u.Login,
+        Id: u.Id,
+        Profile: &Profile{AvatarURL: u.AvatarURL, FullName: u.Name, Followers: u.Followers, City: u.Location, Website: u.Blog},
+    }
+}
@@ -0,0 +1,230 @@
+// graph.go contains functions for working with already built Results
+
+package networkmapper
+
+import (
+    "strings"
+)
+
+// A type for a node found by Search.
+type NodeMatch struct {
+    Index int `json:"index"`
+    Node
+}
+
+// NodeIndex returns the index of the node with the given name in r, and
+// -1 if there is none.
+func (r *Result) NodeIndex(name string) int {
+    for i, node := range r.Nodes {
+        if node.Name == name {
+            return i
+        }
+    }
+    return -1
+}
+
+// Search returns up to limit nodes of r whose names contain q, ignoring
+// case, with names starting with q first. Each match includes the node's
+// index in r.Nodes so it can be found among the rendered nodes.
+func (r *Result) Search(q string, limit int) []NodeMatch {
+
+    q = strings.ToLower(q)
+    prefixed := []NodeMatch{}
+    contained := []NodeMatch{}
+
+    for i, node := range r.Nodes {
+        name := strings.ToLower(node.Name)
+        if strings.HasPrefix(name, q) {
+            prefixed = append(prefixed, NodeMatch{Index: i, Node: node})
+        } else if strings.Contains(name, q) {
+            contained = append(contained, NodeMatch{Index: i, Node: node})
+        }
+
+        // Prefix matches come first, so stop once there are enough
+        if len(prefixed) >= limit {
+            break
+        }
+    }
+
+    matches := append(prefixed, contained...)
+    if len(matches) > limit {
+        matches = matches[:limit]
+    }
+    return matches[0:]
+}
+
+// Subgraph returns the neighborhood of the node named center: every node
+// within hops links of it, ignoring link direction, and the links among
+// them. Links are re-indexed to match the returned nodes, which keep their
+// order from r. It returns nil if there is no node named center.
+func (r *Result) Subgraph(center string, hops int) *Result {
+
+    start := r.NodeIndex(center)
+    if start < 0 {
+        return nil
+    }
+
+    // Build the adjacency lists
+    adjacent := make([][]int, len(r.Nodes))
+    for _, l := range r.Links {
+        adjacent[l.Source] = append(adjacent[l.Source], l.Target)
+        adjacent[l.Target] = append(adjacent[l.Target], l.Source)
+    }
+
+    // Breadth first search out to hops
+    keep := make([]bool, len(r.Nodes))
+    keep[start] = true
+    frontier := []int{start}
+
+    for h := 0; h < hops && len(frontier) > 0; h++ {
+        next := []int{}
+        for _, i := range frontier {
+            for _, j := range adjacent[i] {
+                if !keep[j] {
+                    keep[j] = true
+                    next = append(next, j)
+                }
+            }
+        }
+        frontier = next
+    }
+
+    return r.keepNodes(keep)
+}
+
+// WeightByShared sets the Weight of each link of r to how many of the
+// users being compared follow its target, so the accounts most of them
+// share weigh the most.
+func (r *Result) WeightByShared() {
+
+    shared := make([]int, len(r.Nodes))
+    for _, l := range r.Links {
+        _, other := r.ends(l)
+        shared[other]++
+    }
+
+    for i, l := range r.Links {
+        _, other := r.ends(l)
+        r.Links[i].Weight = shared[other]
+    }
+}
+
+// RequireShared returns r without the followings followed by fewer than
+// min of the users being compared, and the links to them. Networks are
+// built with every following shared by at least two, so a min of two or
+// less returns r.
+func (r *Result) RequireShared(min int) *Result {
+
+    if min <= minShared {
+        return r
+    }
+
+    shared := make([]int, len(r.Nodes))
+    for _, l := range r.Links {
+        if user, other := r.ends(l); r.Nodes[user].Group == 1 {
+            shared[other]++
+        }
+    }
+
+    keep := make([]bool, len(r.Nodes))
+    for i, node := range r.Nodes {
+        keep[i] = node.Group == 1 || shared[i] >= min
+    }
+
+    kept := r.keepNodes(keep)
+    kept.Meta = r.Meta
+    return kept
+}
+
+// MarkDirected marks r as directed, so its links are drawn from follower
+// to followed, and marks each link whose target follows its source back as
+// mutual.
+func (r *Result) MarkDirected() {
+    r.markMutual(func(l Link) bool { return true })
+    r.Directed = true
+}
+
+// markMutualUsers marks each link between two of the users being compared
+// as mutual if they follow each other, so those relationships show even
+// in undirected networks.
+func (r *Result) markMutualUsers() {
+    r.markMutual(func(l Link) bool {
+        return l.Source != l.Target && r.Nodes[l.Source].Group == 1 && r.Nodes[l.Target].Group == 1
+    })
+}
+
+// markMutual marks each link of r for which mark is true as mutual if its
+// target links back to its source.
+func (r *Result) markMutual(mark func(l Link) bool) {
+
+    type pair struct{ source, target int }
+    links := make(map[pair]bool, len(r.Links))
+    for _, l := range r.Links {
+        links[pair{l.Source, l.Target}] = true
+    }
+
+    for i, l := range r.Links {
+        if mark(l) {
+            r.Links[i].Mutual = links[pair{l.Target, l.Source}]
+        }
+    }
+}
+
+// PruneOrphans returns r without the nodes that have no links, such as
+// those left behind by truncating or filtering it, re-indexing the links
+// to match. Pruned nodes are counted as omitted if r has a Meta.
+func (r *Result) PruneOrphans() *Result {
+
+    keep := make([]bool, len(r.Nodes))
+    for _, l := range r.Links {
+        keep[l.Source] = true
+        keep[l.Target] = true
+    }
+
+    pruned := r.keepNodes(keep)
+    if r.Meta != nil {
+        meta := *r.Meta
+        meta.OmittedNodes += len(r.Nodes) - len(pruned.Nodes)
+        pruned.Meta = &meta
+    }
+    return pruned
+}
+
+// keepNodes returns a new Result holding the nodes of r for which keep is
+// true and the links between them, re-indexed to match.
+func (r *Result) keepNodes(keep []bool) *Result {
+
+    nodes := []Node{}
+    newIndex := make([]int, len(r.Nodes))
+
+    for i, node := range r.Nodes {
+        if keep[i] {
+            newIndex[i] = len(nodes)
+            nodes = append(nodes, node)
+        }
+    }
+
+    links := []Link{}
+    for _, l := range r.Links {
+        if keep[l.Source] && keep[l.Target] {
+            l.Source, l.Target = newIndex[l.Source], newIndex[l.Target]
+            links = append(links, l)
+        }
+    }
+
+    kept := &Result{Nodes: nodes, Links: links, Directed: r.Directed, Groups: r.Groups, Stats: r.Stats, Similarity: r.Similarity, Relation: r.Relation}
+    kept.countDegrees()
+    return kept
+}
+
+// countDegrees sets the InDegree and OutDegree of each node of r from its
+// links.
+func (r *Result) countDegrees() {
+    for i := range r.Nodes {
+        r.Nodes[i].InDegree, r.Nodes[i].OutDegree = 0, 0
+    }
+    for _, l := range r.Links {
+        r.Nodes[l.Source].OutDegree++
+        r.Nodes[l.Target].InDegree++
+    }
+}
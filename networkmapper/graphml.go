@@ -0,0 +1,133 @@
+// graphml.go contains the export of Results as GraphML, for tools like
+// yEd and igraph
+
+package networkmapper
+
+import (
+    "encoding/xml"
+    "io"
+    "strconv"
+)
+
+const graphMLNamespace = "http://graphml.graphdrawing.org/xmlns"
+
+// Types for GraphML marshaling.
+type graphML struct {
+    XMLName xml.Name `xml:"graphml"`
+    Xmlns string `xml:"xmlns,attr"`
+    Keys []graphMLKey `xml:"key"`
+    Graph graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+    Id string `xml:"id,attr"`
+    For string `xml:"for,attr"`
+    Name string `xml:"attr.name,attr"`
+    Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+    Id string `xml:"id,attr"`
+    EdgeDefault string `xml:"edgedefault,attr"`
+    Nodes []graphMLElement `xml:"node"`
+    Edges []graphMLElement `xml:"edge"`
+}
+
+type graphMLElement struct {
+    Id string `xml:"id,attr"`
+    Source string `xml:"source,attr,omitempty"`
+    Target string `xml:"target,attr,omitempty"`
+    Data []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+    Key string `xml:"key,attr"`
+    Value string `xml:",chardata"`
+}
+
+// The attributes of the nodes and edges of a GraphML export.
+var graphMLKeys = []graphMLKey{
+    {Id: "name", For: "node", Name: "name", Type: "string"},
+    {Id: "group", For: "node", Name: "group", Type: "int"},
+    {Id: "account_id", For: "node", Name: "account_id", Type: "long"},
+    {Id: "full_name", For: "node", Name: "full_name", Type: "string"},
+    {Id: "followers", For: "node", Name: "followers", Type: "int"},
+    {Id: "tracks", For: "node", Name: "tracks", Type: "int"},
+    {Id: "city", For: "node", Name: "city", Type: "string"},
+    {Id: "weight", For: "edge", Name: "weight", Type: "int"},
+    {Id: "mutual", For: "edge", Name: "mutual", Type: "boolean"},
+    {Id: "type", For: "edge", Name: "type", Type: "string"},
+}
+
+// WriteGraphML writes r to w as a directed GraphML graph. Nodes have the
+// ids n0, n1, ... by index and carry their name, group and whatever of
+// their profile is known; edges carry their weight, 1 if unweighted.
+func WriteGraphML(w io.Writer, r *Result) error {
+
+    g := graphML{
+        Xmlns: graphMLNamespace,
+        Keys: graphMLKeys,
+        Graph: graphMLGraph{
+            Id: "G",
+            EdgeDefault: "directed",
+            Nodes: make([]graphMLElement, len(r.Nodes)),
+            Edges: make([]graphMLElement, len(r.Links)),
+        },
+    }
+
+    for i, node := range r.Nodes {
+        data := []graphMLData{
+            {Key: "name", Value: node.Name},
+            {Key: "group", Value: strconv.Itoa(node.Group)},
+        }
+        if node.Id != 0 {
+            data = append(data, graphMLData{Key: "account_id", Value: strconv.FormatInt(node.Id, 10)})
+        }
+        if p := node.Profile; p != nil {
+            if p.FullName != "" {
+                data = append(data, graphMLData{Key: "full_name", Value: p.FullName})
+            }
+            data = append(data,
+                graphMLData{Key: "followers", Value: strconv.Itoa(p.Followers)},
+                graphMLData{Key: "tracks", Value: strconv.Itoa(p.Tracks)})
+            if p.City != "" {
+                data = append(data, graphMLData{Key: "city", Value: p.City})
+            }
+        }
+        g.Graph.Nodes[i] = graphMLElement{Id: graphMLNodeId(i), Data: data}
+    }
+
+    for i, l := range r.Links {
+        weight := l.Weight
+        if weight == 0 {
+            weight = 1
+        }
+        data := []graphMLData{{Key: "weight", Value: strconv.Itoa(weight)}}
+        if l.Mutual {
+            data = append(data, graphMLData{Key: "mutual", Value: "true"})
+        }
+        if l.Type != "" {
+            data = append(data, graphMLData{Key: "type", Value: l.Type})
+        }
+        g.Graph.Edges[i] = graphMLElement{
+            Id: "e" + strconv.Itoa(i),
+            Source: graphMLNodeId(l.Source),
+            Target: graphMLNodeId(l.Target),
+            Data: data,
+        }
+    }
+
+    if _, err := io.WriteString(w, xml.Header); err != nil {
+        return err
+    }
+    enc := xml.NewEncoder(w)
+    enc.Indent("", "  ")
+    return enc.Encode(g)
+}
+
+/* Helpers */
+
+// graphMLNodeId returns the GraphML id of the node at index i.
+func graphMLNodeId(i int) string {
+    return "n" + strconv.Itoa(i)
+}
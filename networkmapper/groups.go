@@ -0,0 +1,297 @@
+// groups.go contains the grouping of nodes by what their profiles say
+// about them or the communities they're in, so a network can be colored
+// by more than who's who
+
+package networkmapper
+
+import (
+    "context"
+    "errors"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// Ways to group the nodes of a network besides by role.
+const (
+    GroupGenre = "genre"
+    GroupLabel = "label"
+    GroupCountry = "country"
+    GroupCity = "city"
+    GroupFollowers = "followers"
+    GroupCommunity = "community"
+)
+
+const (
+    // The most recent tracks looked at for a user's genre or label
+    maxGenreTracks = 50
+
+    // The group of nodes without a key
+    otherGroup = "other"
+)
+
+// A type that satisfies networkmapper.GenreFetcher can tell what genre a
+// user makes.
+type GenreFetcher interface {
+
+    // Gets the genre most of a given user's tracks are tagged with
+    GetGenre(ctx context.Context, user string) (string, error)
+
+}
+
+// A type that satisfies networkmapper.LabelFetcher can tell what label a
+// user releases on.
+type LabelFetcher interface {
+
+    // Gets the label most of a given user's tracks were released on
+    GetLabel(ctx context.Context, user string) (string, error)
+
+}
+
+// GroupNeedsProfiles returns whether grouping by by needs the nodes'
+// profiles.
+func GroupNeedsProfiles(by string) bool {
+    return by != GroupCommunity
+}
+
+// AddGenres sets the Genre of the Profile of each node of r, a few at a
+// time. Nodes whose genres can't be fetched are left without.
+func AddGenres(ctx context.Context, r *Result, f GenreFetcher) {
+    addToProfiles(ctx, r, f.GetGenre, func(p *Profile, genre string) { p.Genre = genre })
+}
+
+// AddLabels sets the Label of the Profile of each node of r, a few at a
+// time. Nodes whose labels can't be fetched are left without.
+func AddLabels(ctx context.Context, r *Result, f LabelFetcher) {
+    addToProfiles(ctx, r, f.GetLabel, func(p *Profile, label string) { p.Label = label })
+}
+
+// GroupBy sets the Group of each node of r other than the users being
+// compared by its profile, one of GroupGenre, GroupLabel, GroupCountry,
+// GroupCity or GroupFollowers, or by its community, GroupCommunity. Genres
+// and labels have to have been added with AddGenres and AddLabels first.
+func (r *Result) GroupBy(by string) error {
+
+    if by == GroupCommunity {
+        r.Regroup(r.communityNames())
+        return nil
+    }
+
+    key, err := r.profileKeys(by)
+    if err != nil {
+        return err
+    }
+    r.Regroup(key)
+    return nil
+}
+
+// CollapseBy collapses the nodes of r other than the users being compared
+// that share a genre, label, country, city or follower count, ignoring
+// case as GroupBy does, into super-nodes named after it. The users, and
+// nodes without one, are kept as super-nodes of their own.
+func (r *Result) CollapseBy(by string) (*Collapsed, error) {
+
+    if by == GroupCommunity {
+        return r.CollapseCommunities(), nil
+    }
+
+    key, err := r.profileKeys(by)
+    if err != nil {
+        return nil, err
+    }
+
+    // Name each key by the first spelling seen
+    names := make(map[string]string)
+    return r.Collapse(func(i int) string {
+        if r.Nodes[i].Group == 1 {
+            return ""
+        }
+        name := strings.TrimSpace(key(i))
+        if _, ok := names[strings.ToLower(name)]; !ok {
+            names[strings.ToLower(name)] = name
+        }
+        return names[strings.ToLower(name)]
+    }), nil
+}
+
+// Regroup sets the Group of each node of r other than the users being
+// compared by the key keyOf gives it, by node index, ignoring case. Groups
+// are numbered from 2, largest first, and named in r.Groups. Nodes with an
+// empty key are put in a last group of their own.
+func (r *Result) Regroup(keyOf func(i int) string) {
+
+    // Count the nodes with each key, keeping the first spelling seen
+    keys := make([]string, len(r.Nodes))
+    sizes := make(map[string]int)
+    names := make(map[string]string)
+
+    for i, node := range r.Nodes {
+        if node.Group == 1 {
+            continue
+        }
+        name := strings.TrimSpace(keyOf(i))
+        key := strings.ToLower(name)
+        if _, ok := names[key]; !ok {
+            names[key] = name
+        }
+        keys[i] = key
+        sizes[key]++
+    }
+
+    // Number the groups, largest first
+    order := make([]string, 0, len(sizes))
+    for key := range sizes {
+        if key != "" {
+            order = append(order, key)
+        }
+    }
+    sort.Slice(order, func(a, b int) bool {
+        if sizes[order[a]] != sizes[order[b]] {
+            return sizes[order[a]] > sizes[order[b]]
+        }
+        return order[a] < order[b]
+    })
+    if _, ok := sizes[""]; ok {
+        order = append(order, "")
+    }
+
+    r.Groups = []string{"", "users"}
+    nums := make(map[string]int, len(order))
+    for _, key := range order {
+        nums[key] = len(r.Groups)
+        if key == "" {
+            r.Groups = append(r.Groups, otherGroup)
+        } else {
+            r.Groups = append(r.Groups, names[key])
+        }
+    }
+
+    for i := range r.Nodes {
+        if r.Nodes[i].Group != 1 {
+            r.Nodes[i].Group = nums[keys[i]]
+        }
+    }
+}
+
+// GetGenre returns the genre most of the provided user's recent tracks are
+// tagged with, or "" if none are.
+func (n *networkMapper) GetGenre(ctx context.Context, user string) (string, error) {
+    return n.mostTagged(ctx, user, func(t scTrack) string { return t.Genre })
+}
+
+// GetLabel returns the label most of the provided user's recent tracks
+// were released on, or "" if none say.
+func (n *networkMapper) GetLabel(ctx context.Context, user string) (string, error) {
+    return n.mostTagged(ctx, user, func(t scTrack) string { return t.LabelName })
+}
+
+/* Helpers */
+
+// mostTagged returns what tagOf gives most of the provided user's recent
+// tracks, ignoring case and keeping the first spelling seen, or "" if it
+// gives none of them anything.
+func (n *networkMapper) mostTagged(ctx context.Context, user string, tagOf func(t scTrack) string) (string, error) {
+
+    var tracks []scTrack
+    url := n.baseURL + `/users/` + user + `/tracks.json?client_id=` + n.clientId +
+           `&limit=` + strconv.Itoa(maxGenreTracks)
+    if err := n.getJSON(ctx, url, &tracks); err != nil {
+        return "", err
+    }
+
+    counts := make(map[string]int)
+    names := make(map[string]string)
+    most := ""
+    for _, t := range tracks {
+        name := strings.TrimSpace(tagOf(t))
+        key := strings.ToLower(name)
+        if key == "" {
+            continue
+        }
+        if _, ok := names[key]; !ok {
+            names[key] = name
+        }
+        counts[key]++
+        if counts[key] > counts[most] {
+            most = key
+        }
+    }
+
+    return names[most], nil
+}
+
+// addToProfiles sets what get fetches for each node of r on a copy of its
+// Profile with set, a few at a time. Nodes whose values can't be fetched
+// are left as they are.
+func addToProfiles(ctx context.Context, r *Result, get func(ctx context.Context, user string) (string, error),
+    set func(p *Profile, value string)) {
+
+    var wg sync.WaitGroup
+    sem := make(chan struct{}, profileWorkers)
+
+    for i := range r.Nodes {
+        wg.Add(1)
+        sem <- struct{}{}
+        go func(node *Node) {
+            if value, err := get(ctx, node.Name); err == nil {
+
+                // Profiles may be shared, so set the value on a copy
+                p := &Profile{}
+                if node.Profile != nil {
+                    *p = *node.Profile
+                }
+                set(p, value)
+                node.Profile = p
+            }
+            <-sem
+            wg.Done()
+        } (&r.Nodes[i])
+    }
+    wg.Wait()
+}
+
+// profileKeys returns the key of each node of r, by node index, that
+// grouping by by goes by: the part of its profile by names, or "" if it
+// has no profile.
+func (r *Result) profileKeys(by string) (func(i int) string, error) {
+
+    var key func(p *Profile) string
+    switch by {
+    case GroupGenre:
+        key = func(p *Profile) string { return p.Genre }
+    case GroupLabel:
+        key = func(p *Profile) string { return p.Label }
+    case GroupCountry:
+        key = func(p *Profile) string { return p.Country }
+    case GroupCity:
+        key = func(p *Profile) string { return p.City }
+    case GroupFollowers:
+        key = func(p *Profile) string { return followersBucket(p.Followers) }
+    default:
+        return nil, errors.New("unknown grouping " + by)
+    }
+
+    return func(i int) string {
+        if r.Nodes[i].Profile == nil {
+            return ""
+        }
+        return key(r.Nodes[i].Profile)
+    }, nil
+}
+
+// followersBucket returns the name of the range followers falls in.
+func followersBucket(followers int) string {
+    switch {
+    case followers < 100:
+        return "under 100 followers"
+    case followers < 1000:
+        return "100+ followers"
+    case followers < 10000:
+        return "1k+ followers"
+    case followers < 100000:
+        return "10k+ followers"
+    default:
+        return "100k+ followers"
+    }
+}
@@ -0,0 +1,143 @@
+// grow.go contains the growing of an already built network by one more
+// user, so a comparison can be extended without building it again
+
+package networkmapper
+
+import (
+    "context"
+)
+
+// AddUser adds user to r, a network built with n, as one more of the
+// users being compared, along with the accounts it now shares with them.
+// The other users' followings are needed to find those, so they're asked
+// of n again, which serves them from its FollowingsCache if it has one
+// rather than fetching them. It returns the first error if followings
+// couldn't all be fetched and n doesn't make partial networks, leaving r
+// as it was.
+func AddUser(ctx context.Context, r *Result, n NetworkMapper, user string) error {
+
+    nodeNums := make(map[string]int, len(r.Nodes))
+    users := []int{}
+    for i, node := range r.Nodes {
+        nodeNums[node.Name] = i
+        if node.Group == 1 {
+            users = append(users, i)
+        }
+    }
+    if num, ok := nodeNums[user]; ok && r.Nodes[num].Group == 1 {
+        return nil
+    }
+
+    // Get everyone's followings, the new user's first
+    var errs []FetchError
+    followings := make([][]string, len(users) + 1)
+    for i, who := range append([]string{user}, names(r, users)...) {
+        fs, err := getFollowings(ctx, n, who)
+        if err != nil {
+            errs = append(errs, *err)
+        }
+        followings[i] = distinct(fs)
+    }
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+    if len(errs) > 0 && !partialResults(n) {
+        return &errs[0]
+    }
+
+    // Make the user a node, or one of the users if it was a following
+    num, ok := nodeNums[user]
+    if !ok {
+        num = len(r.Nodes)
+        nodeNums[user] = num
+        r.Nodes = append(r.Nodes, Node{Name: user, Group: 1})
+    }
+    r.Nodes[num].Group = 1
+    users = append([]int{num}, users...)
+
+    // Add each link once, the other way in networks of followers, since
+    // the user may have had some as a node of an expanded network
+    type pair struct{ source, target int }
+    linked := make(map[pair]bool, len(r.Links))
+    for _, l := range r.Links {
+        linked[pair{l.Source, l.Target}] = true
+    }
+    link := func(who, found int) {
+        l := Link{Source: who, Target: found}
+        if r.Relation == RelationFollowers {
+            l.Source, l.Target = found, who
+        }
+        if who != found && !linked[pair{l.Source, l.Target}] {
+            linked[pair{l.Source, l.Target}] = true
+            r.Links = append(r.Links, l)
+        }
+    }
+
+    // Count how many of the others follow each of the user's followings,
+    // linking those who follow the user to it
+    followers := make(map[string]int, len(followings[0]))
+    for _, f := range followings[0] {
+        followers[f] = 1
+    }
+    theirs := make([]map[string]bool, len(followings) - 1)
+    for i, fs := range followings[1:] {
+        theirs[i] = make(map[string]bool, len(fs))
+        for _, f := range fs {
+            theirs[i][f] = true
+            if _, ok := followers[f]; ok {
+                followers[f]++
+            }
+        }
+        if theirs[i][user] {
+            link(users[i + 1], num)
+        }
+    }
+
+    // Link the user to the nodes it follows, adding those it now shares
+    for _, f := range followings[0] {
+        target, ok := nodeNums[f]
+        if !ok {
+            if followers[f] < minShared {
+                continue
+            }
+            target = len(r.Nodes)
+            nodeNums[f] = target
+            r.Nodes = append(r.Nodes, Node{Name: f, Group: 2})
+
+            for i := range theirs {
+                if theirs[i][f] {
+                    link(users[i + 1], target)
+                }
+            }
+        }
+        link(num, target)
+    }
+
+    if r.Similarity != nil {
+        r.Similarity.add(user, followings[0], names(r, users[1:]), theirs)
+    }
+
+    if len(errs) > 0 {
+        if r.Meta == nil {
+            r.Meta = &Meta{}
+        }
+        r.Meta.Partial = true
+        r.Meta.Errors = append(r.Meta.Errors, errs...)
+    }
+
+    r.countDegrees()
+    r.markMutualUsers()
+    r.Stats = r.ComputeStats()
+    return nil
+}
+
+/* Helpers */
+
+// names returns the names of the nodes of r numbered nums.
+func names(r *Result, nums []int) []string {
+    ns := make([]string, len(nums))
+    for i, num := range nums {
+        ns[i] = r.Nodes[num].Name
+    }
+    return ns
+}
@@ -0,0 +1,57 @@
+// hooks.go contains the hooks a build calls as it goes, so callers can
+// report its progress and time it
+
+package networkmapper
+
+import (
+    "context"
+    "time"
+)
+
+// A type that satisfies networkmapper.Hooks is told how a build is going.
+// Its methods may be called from many goroutines at once.
+type Hooks interface {
+
+    // Called when a user's followings start being fetched
+    OnUserStart(user string)
+
+    // Called with the number of followings on each page of a user's
+    // followings as it's fetched, by sources that fetch by page
+    OnPageFetched(user string, page, count int)
+
+    // Called when a user's followings have been fetched, with how many
+    // there were and why they're incomplete, if they are
+    OnUserDone(user string, followings int, err error)
+
+    // Called when the build is done, with how long it took and why it
+    // failed, if it did
+    OnBuildDone(users int, elapsed time.Duration, err error)
+
+}
+
+// NopHooks is Hooks that do nothing, for embedding in Hooks that only
+// need some of them.
+type NopHooks struct{}
+
+func (NopHooks) OnUserStart(user string) {}
+func (NopHooks) OnPageFetched(user string, page, count int) {}
+func (NopHooks) OnUserDone(user string, followings int, err error) {}
+func (NopHooks) OnBuildDone(users int, elapsed time.Duration, err error) {}
+
+// hooksKey is the context key of a build's Hooks.
+type hooksKey struct{}
+
+// WithHooks returns a copy of ctx that makes builds with it call hooks.
+func WithHooks(ctx context.Context, hooks Hooks) context.Context {
+    return context.WithValue(ctx, hooksKey{}, hooks)
+}
+
+/* Helpers */
+
+// hooksFrom returns the Hooks of ctx, or NopHooks if it has none.
+func hooksFrom(ctx context.Context) Hooks {
+    if hooks, ok := ctx.Value(hooksKey{}).(Hooks); ok {
+        return hooks
+    }
+    return NopHooks{}
+}
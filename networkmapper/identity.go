@@ -0,0 +1,270 @@
+// identity.go contains the identity resolution used to merge networks
+// built from different sources, so the same artist's accounts on each
+// platform become one node.
+
+package networkmapper
+
+import (
+    "encoding/json"
+    "io"
+    "net/url"
+    "strings"
+    "unicode"
+)
+
+// A type for resolving accounts on different sources to the identities of
+// the people behind them.
+type Identities struct {
+
+    // Explicitly mapped identities' names by "source:account"
+    explicit map[string]string
+}
+
+// A type for an entry of an identity mapping file.
+type Identity struct {
+    Name string `json:"name"`
+    Accounts map[string]string `json:"accounts"`
+}
+
+// A type for a network built from one source, to be merged with others.
+type SourceResult struct {
+    Source string
+    Result *Result
+}
+
+// NewIdentities creates Identities that only match accounts by name.
+func NewIdentities() *Identities {
+    return &Identities{explicit: make(map[string]string)}
+}
+
+// ReadIdentities reads an identity mapping file from r: a JSON array of
+// Identity, each naming an identity and its account on each source.
+func ReadIdentities(r io.Reader) (*Identities, error) {
+
+    var entries []Identity
+    if err := json.NewDecoder(r).Decode(&entries); err != nil {
+        return nil, err
+    }
+
+    ids := NewIdentities()
+    for _, e := range entries {
+        for source, account := range e.Accounts {
+            ids.explicit[source + ":" + account] = e.Name
+        }
+    }
+
+    return ids, nil
+}
+
+// Resolve returns a key identifying the person behind account on source,
+// and the name to show for them. Accounts in the mapping file resolve to
+// their identity; any others are matched heuristically by their name with
+// case and punctuation ignored, so "Four-Tet" and "fourtet" are merged.
+func (ids *Identities) Resolve(source, account string) (string, string) {
+
+    if name, ok := ids.explicit[source + ":" + account]; ok {
+        return "id:" + name, name
+    }
+
+    normalized := strings.Map(func(r rune) rune {
+        if unicode.IsLetter(r) || unicode.IsDigit(r) {
+            return unicode.ToLower(r)
+        }
+        return -1
+    }, account)
+
+    if normalized == "" {
+        return source + ":" + account, account
+    }
+    return "name:" + normalized, account
+}
+
+// Merge combines networks built from different sources into one, merging
+// nodes that ids resolves to the same identity, or whose profile's website
+// is the other's account, as it is in networks built with metadata. Only
+// accounts on different platforms are merged, so two accounts on one
+// source with similar names stay apart. Each merged node lists its account
+// on every source it came from, and each link lists the sources that had
+// it and the sum of their weights; links between accounts merged into one
+// node are dropped.
+func Merge(parts []SourceResult, ids *Identities) *Result {
+
+    nodes := []Node{}
+    links := []Link{}
+    linkNums := make(map[[2]int]int)
+    var meta *Meta
+
+    // Where each identity, account, and account linked to from a website
+    // went
+    identityNums := make(map[string]int)
+    accountNums := make(map[string]int)
+    linkedNums := make(map[string]int)
+
+    for _, part := range parts {
+
+        // A merge of partial networks is partial
+        if m := part.Result.Meta; m != nil && m.Partial {
+            if meta == nil {
+                meta = &Meta{Partial: true}
+            }
+            meta.SkippedUsers += m.SkippedUsers
+            meta.Errors = append(meta.Errors, m.Errors...)
+        }
+
+        platform := platformOf(part.Source)
+
+        // Merge the nodes, remembering where each one went
+        newIndex := make([]int, len(part.Result.Nodes))
+        for i, node := range part.Result.Nodes {
+            key, name := ids.Resolve(part.Source, node.Name)
+            account := platform + ":" + strings.ToLower(node.Name)
+            linked := ""
+            if node.Profile != nil {
+                linked = linkedAccount(node.Profile.Website)
+            }
+
+            // The same account first, then its mapped identity, whoever
+            // links to it, whoever it links to, and whoever has its name
+            num := -1
+            if n, ok := accountNums[account]; ok {
+                num = n
+            } else {
+                candidates := []int{}
+                if n, ok := identityNums[key]; ok && strings.HasPrefix(key, "id:") {
+                    candidates = append(candidates, n)
+                }
+                if n, ok := linkedNums[account]; ok {
+                    candidates = append(candidates, n)
+                }
+                if n, ok := accountNums[linked]; ok && linked != "" {
+                    candidates = append(candidates, n)
+                }
+                if n, ok := identityNums[key]; ok {
+                    candidates = append(candidates, n)
+                }
+                for _, n := range candidates {
+                    if !hasPlatform(nodes[n], platform) {
+                        num = n
+                        break
+                    }
+                }
+            }
+
+            if num < 0 {
+                num = len(nodes)
+                nodes = append(nodes, Node{Name: name, Group: node.Group, Accounts: map[string]string{}})
+            }
+            if _, ok := identityNums[key]; !ok {
+                identityNums[key] = num
+            }
+            accountNums[account] = num
+            if linked != "" && linked != account {
+                linkedNums[linked] = num
+            }
+
+            // Given users stay given users, whichever source said so
+            if node.Group < nodes[num].Group {
+                nodes[num].Group = node.Group
+            }
+            if nodes[num].Profile == nil {
+                nodes[num].Profile = node.Profile
+            }
+            nodes[num].Accounts[part.Source] = node.Name
+            newIndex[i] = num
+        }
+
+        // Merge the links, keeping where each one came from
+        for _, l := range part.Result.Links {
+            pair := [2]int{newIndex[l.Source], newIndex[l.Target]}
+            if pair[0] == pair[1] {
+                continue
+            }
+
+            num, ok := linkNums[pair]
+            if !ok {
+                num = len(links)
+                linkNums[pair] = num
+                links = append(links, Link{Source: pair[0], Target: pair[1]})
+            }
+
+            if !containsString(links[num].Sources, part.Source) {
+                links[num].Sources = append(links[num].Sources, part.Source)
+            }
+            links[num].Weight += l.Weight
+        }
+    }
+
+    merged := &Result{Nodes: nodes, Links: links, Meta: meta}
+    merged.countDegrees()
+    merged.markMutualUsers()
+    merged.Stats = merged.ComputeStats()
+    return merged
+}
+
+/* Helpers */
+
+// The platforms whose profile pages are at their host followed by the
+// account's name.
+var profileHosts = map[string]string{
+    "soundcloud.com": "soundcloud",
+    "mixcloud.com": "mixcloud",
+    "github.com": "github",
+}
+
+// platformOf returns the platform of source, which sources like
+// soundcloud-likes share with soundcloud.
+func platformOf(source string) string {
+    if i := strings.Index(source, "-"); i > 0 {
+        return source[:i]
+    }
+    return source
+}
+
+// hasPlatform reports whether node already has an account on platform.
+func hasPlatform(node Node, platform string) bool {
+    for source := range node.Accounts {
+        if platformOf(source) == platform {
+            return true
+        }
+    }
+    return false
+}
+
+// linkedAccount returns the account website is the profile page of, as
+// "platform:account", or "" if it isn't one.
+func linkedAccount(website string) string {
+
+    if website == "" {
+        return ""
+    }
+    if !strings.Contains(website, "://") {
+        website = "https://" + website
+    }
+    u, err := url.Parse(website)
+    if err != nil {
+        return ""
+    }
+
+    host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+    parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+
+    switch {
+    case host == "open.spotify.com" && len(parts) == 2 && (parts[0] == "user" || parts[0] == "artist"):
+        return "spotify:" + strings.ToLower(parts[1])
+    case host == "last.fm" && len(parts) == 2 && parts[0] == "user":
+        return "lastfm:" + strings.ToLower(parts[1])
+    case profileHosts[host] != "" && len(parts) == 1 && parts[0] != "":
+        return profileHosts[host] + ":" + strings.ToLower(parts[0])
+    }
+    return ""
+}
+
+// containsString reports whether s is in ss.
+func containsString(ss []string, s string) bool {
+    for _, x := range ss {
+        if x == s {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,107 @@
+// ids.go contains users' ids, which unlike their names don't change when
+// they rename themselves
+
+package networkmapper
+
+import (
+    "context"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// The most users' ids kept from followings pages and profiles.
+const idCacheSize = 100000
+
+// A type that satisfies networkmapper.IdResolver can tell a user's stable
+// id.
+type IdResolver interface {
+
+    // Gets the id of a given user
+    GetId(ctx context.Context, user string) (int64, error)
+
+}
+
+// AddIds sets the Id of each node of r that doesn't have one, a few at a
+// time. Nodes whose ids can't be fetched are left without.
+func AddIds(ctx context.Context, r *Result, f IdResolver) {
+
+    var wg sync.WaitGroup
+    sem := make(chan struct{}, profileWorkers)
+
+    for i := range r.Nodes {
+        if r.Nodes[i].Id != 0 {
+            continue
+        }
+
+        wg.Add(1)
+        sem <- struct{}{}
+        go func(node *Node) {
+            if id, err := f.GetId(ctx, node.Name); err == nil {
+                node.Id = id
+            }
+            <-sem
+            wg.Done()
+        } (&r.Nodes[i])
+    }
+    wg.Wait()
+}
+
+// GetId returns the SoundCloud id of user, as last seen on a page of
+// followings or fetched from SoundCloud. Users may be given by id.
+func (n *networkMapper) GetId(ctx context.Context, user string) (int64, error) {
+
+    if id, err := strconv.ParseInt(user, 10, 64); err == nil {
+        return id, nil
+    }
+    if id, ok := n.ids.get(strings.ToLower(user)); ok {
+        return id.(int64), nil
+    }
+
+    var u scUser
+    if err := n.getJSON(ctx, n.baseURL + `/users/` + user + `.json?client_id=` + n.clientId, &u); err != nil {
+        return 0, err
+    }
+
+    n.remember(&u)
+    return u.Id, nil
+}
+
+// remember keeps the id and profile of u for later builds, returning the
+// profile.
+func (n *networkMapper) remember(u *scUser) *Profile {
+    p := u.profile()
+    if u.Permalink == "" {
+        return p
+    }
+
+    name := strings.ToLower(u.Permalink)
+    if u.Id != 0 {
+        n.ids.put(name, u.Id)
+    }
+    n.profiles.put(name, p)
+    return p
+}
+
+// userKey returns the key of user in n's caches: its id if it's known,
+// so a renamed user's followings are still found under their new name,
+// and its name otherwise. Followers are kept apart from followings.
+func (n *networkMapper) userKey(user string) string {
+    prefix := n.keyPrefix()
+    if _, err := strconv.ParseInt(user, 10, 64); err == nil {
+        return prefix + "id:" + user
+    }
+    if id, ok := n.ids.get(strings.ToLower(user)); ok {
+        return prefix + "id:" + strconv.FormatInt(id.(int64), 10)
+    }
+    return prefix + strings.ToLower(user)
+}
+
+// keyPrefix returns what the keys of n's caches start with, keeping the
+// followers of users apart from their followings.
+func (n *networkMapper) keyPrefix() string {
+    if n.relation == RelationFollowers {
+        return "followers:"
+    }
+    return ""
+}
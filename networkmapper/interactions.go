@@ -0,0 +1,273 @@
+// interactions.go contains the weighting of links by how much the users
+// at each end interact, to tell active relationships from dormant follows
+
+package networkmapper
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "sort"
+    "strconv"
+    "sync"
+    "time"
+)
+
+const (
+    // The most recent likes, reposts and comments looked at per user
+    maxInteractions = 200
+
+    // The most commented on tracks whose owners are looked up per user
+    maxCommentedTracks = 10
+
+    // The most users whose interactions are fetched at once
+    interactionWorkers = 10
+)
+
+// A type that satisfies networkmapper.InteractionFetcher can tell who a
+// user has interacted with.
+type InteractionFetcher interface {
+
+    // Gets how many times a given user has interacted with each user
+    GetInteractions(ctx context.Context, user string) (map[string]int, error)
+
+}
+
+// WeightByInteractions sets the Weight of each link of r to how much the
+// users at its ends have interacted with each other, counted as the
+// smaller of their interactions with one another, so only reciprocal
+// relationships weigh anything.
+//
+// The interactions of the users being compared are fetched, and then those
+// of up to limit of the accounts they link to that they've interacted with,
+// most interacted with first, as no other link can weigh anything. Fetching
+// stops once budget has passed, unless it's 0. Links to accounts whose
+// interactions weren't fetched, in time or at all, weigh nothing, and r's
+// Meta says which. It fails only if ctx is done or no user's interactions
+// could be fetched.
+func WeightByInteractions(ctx context.Context, r *Result, f InteractionFetcher, limit int, budget time.Duration) error {
+
+    fetchCtx := ctx
+    if budget > 0 {
+        var cancel context.CancelFunc
+        fetchCtx, cancel = context.WithTimeout(ctx, budget)
+        defer cancel()
+    }
+
+    interactions := make(map[int]map[string]int)
+    var errs []FetchError
+    skipped := 0
+
+    // The users being compared first
+    users := []int{}
+    for i, node := range r.Nodes {
+        if node.Group == 1 {
+            users = append(users, i)
+        }
+    }
+    fetchInteractions(fetchCtx, r, f, users, interactions, &errs, &skipped)
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+    if len(users) > 0 && len(interactions) == 0 && len(errs) > 0 {
+        return errors.New(errs[0].Message)
+    }
+
+    // Then the accounts they've interacted with
+    with := make(map[int]int)
+    for _, l := range r.Links {
+        user, other := r.ends(l)
+        if r.Nodes[other].Group == 1 {
+            continue
+        }
+        if n := interactions[user][r.Nodes[other].Name]; n > 0 {
+            with[other] += n
+        }
+    }
+
+    others := make([]int, 0, len(with))
+    for i := range with {
+        others = append(others, i)
+    }
+    sort.Slice(others, func(a, b int) bool {
+        if with[others[a]] != with[others[b]] {
+            return with[others[a]] > with[others[b]]
+        }
+        return others[a] < others[b]
+    })
+    if len(others) > limit {
+        skipped += len(others) - limit
+        others = others[:limit]
+    }
+    fetchInteractions(fetchCtx, r, f, others, interactions, &errs, &skipped)
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+
+    for i, l := range r.Links {
+        there := interactions[l.Source][r.Nodes[l.Target].Name]
+        back := interactions[l.Target][r.Nodes[l.Source].Name]
+        if back < there {
+            there = back
+        }
+        r.Links[i].Weight = there
+    }
+
+    if skipped > 0 || len(errs) > 0 {
+        if r.Meta == nil {
+            r.Meta = &Meta{}
+        }
+        r.Meta.Partial = true
+        r.Meta.SkippedUsers += skipped
+        r.Meta.Errors = append(r.Meta.Errors, errs...)
+    }
+
+    return nil
+}
+
+// fetchInteractions fetches the interactions of the nodes nums of r into
+// interactions, a few at a time, adding those that fail to errs and
+// counting those skipped because ctx was done first.
+func fetchInteractions(ctx context.Context, r *Result, f InteractionFetcher, nums []int,
+    interactions map[int]map[string]int, errs *[]FetchError, skipped *int) {
+
+    var mu sync.Mutex
+    var wg sync.WaitGroup
+    sem := make(chan struct{}, interactionWorkers)
+
+    for _, num := range nums {
+        wg.Add(1)
+        sem <- struct{}{}
+        go func(num int, name string) {
+            defer wg.Done()
+            defer func() { <-sem } ()
+
+            if ctx.Err() != nil {
+                mu.Lock()
+                *skipped++
+                mu.Unlock()
+                return
+            }
+
+            found, err := f.GetInteractions(ctx, name)
+
+            mu.Lock()
+            defer mu.Unlock()
+            switch {
+            case err == nil:
+                interactions[num] = found
+            case ctx.Err() != nil:
+                *skipped++
+            default:
+                *errs = append(*errs, FetchError{User: name, Message: err.Error()})
+            }
+        } (num, r.Nodes[num].Name)
+    }
+    wg.Wait()
+}
+
+// GetInteractions returns how many of the provided user's recent likes,
+// reposts and comments were on each user's tracks. Comments on tracks
+// whose owners can't be looked up are left out.
+func (n *networkMapper) GetInteractions(ctx context.Context, user string) (map[string]int, error) {
+
+    interactions := make(map[string]int)
+    limit := `&limit=` + strconv.Itoa(maxInteractions)
+
+    // Likes
+    var likes []struct {
+        User struct {
+            Permalink string `json:"permalink"`
+        } `json:"user"`
+    }
+
+    url := n.baseURL + `/users/` + user + `/favorites.json?client_id=` + n.clientId + limit
+    if err := n.getJSON(ctx, url, &likes); err != nil {
+        return nil, err
+    }
+
+    for _, like := range likes {
+        interactions[like.User.Permalink]++
+    }
+
+    // Reposts
+    var reposts []struct {
+        Track struct {
+            User struct {
+                Permalink string `json:"permalink"`
+            } `json:"user"`
+        } `json:"track"`
+    }
+
+    url = n.baseURL + `/e1/users/` + user + `/track_reposts.json?client_id=` + n.clientId + limit
+    if err := n.getJSON(ctx, url, &reposts); err != nil {
+        return nil, err
+    }
+
+    for _, repost := range reposts {
+        interactions[repost.Track.User.Permalink]++
+    }
+
+    // Comments, which only name their track
+    var comments []struct {
+        TrackId int `json:"track_id"`
+    }
+
+    url = n.baseURL + `/users/` + user + `/comments.json?client_id=` + n.clientId + limit
+    if err := n.getJSON(ctx, url, &comments); err != nil {
+        return nil, err
+    }
+
+    commented := make(map[int]int)
+    for _, c := range comments {
+        if _, ok := commented[c.TrackId]; ok || len(commented) < maxCommentedTracks {
+            commented[c.TrackId]++
+        }
+    }
+
+    for id, count := range commented {
+        var track struct {
+            User struct {
+                Permalink string `json:"permalink"`
+            } `json:"user"`
+        }
+
+        url = n.baseURL + `/tracks/` + strconv.Itoa(id) + `.json?client_id=` + n.clientId
+        if err := n.getJSON(ctx, url, &track); err != nil {
+            if ctx.Err() != nil {
+                return nil, err
+            }
+            continue
+        }
+        interactions[track.User.Permalink] += count
+    }
+
+    delete(interactions, "")
+    delete(interactions, user)
+
+    return interactions, nil
+}
+
+// getJSON gets url and unmarshals its JSON body into v.
+func (n *networkMapper) getJSON(ctx context.Context, url string, v interface{}) error {
+
+    r, err := n.get(ctx, url)
+    if err != nil {
+        return err
+    }
+    defer r.Body.Close()
+
+    body, err := ioutil.ReadAll(r.Body)
+    if err != nil {
+        return err
+    }
+
+    if r.StatusCode != http.StatusOK {
+        return fmt.Errorf("soundcloud: %s %s", r.Request.URL.Path, r.Status)
+    }
+
+    return json.Unmarshal(body, v)
+}
@@ -0,0 +1,270 @@
+// lastfm.go contains NetworkMappers for Last.fm, mapping the friends or
+// the top artists shared among listeners.
+
+package networkmapper
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "strconv"
+)
+
+const (
+    lastFMAPI = `https://ws.audioscrobbler.com/2.0/`
+
+    // The most friends or artists Last.fm returns per page
+    maxLastFMPageSize = 1000
+
+    // The top artists of each listener that are mapped, by default
+    defaultLastFMTopArtists = 50
+)
+
+// lastFMNetworkMapper is the Provider for the Last.fm API.
+type lastFMNetworkMapper struct {
+    apiKey string
+    artists bool
+    perPage int
+    client *http.Client
+    quota *Quota
+}
+
+// NewLastFMNetworkMapper creates a NetworkMapper whose followings are the
+// Last.fm friends of a user, calling the API with apiKey.
+func NewLastFMNetworkMapper(apiKey string) NetworkMapper {
+    return &lastFMNetworkMapper{apiKey: apiKey, perPage: maxLastFMPageSize,
+        client: newTimeoutClient(defaultConnectTimeout, defaultReadTimeout),
+        quota: NewQuota(lastFMQuota, lastFMQuotaWindow)}
+}
+
+// NewLastFMArtistsNetworkMapper creates a NetworkMapper whose followings
+// are the artists a Last.fm user has listened to most, calling the API
+// with apiKey. The page size is how many of them are mapped.
+func NewLastFMArtistsNetworkMapper(apiKey string) NetworkMapper {
+    return &lastFMNetworkMapper{apiKey: apiKey, artists: true, perPage: defaultLastFMTopArtists,
+        client: newTimeoutClient(defaultConnectTimeout, defaultReadTimeout),
+        quota: NewQuota(lastFMQuota, lastFMQuotaWindow)}
+}
+
+// GetFollowings returns the names of user's friends, or of their top
+// artists. It returns what it has if ctx is done first or a page can't be
+// fetched.
+func (n *lastFMNetworkMapper) GetFollowings(ctx context.Context, user string) []string {
+    followings, _ := n.TryGetFollowings(ctx, user)
+    return followings
+}
+
+// TryGetFollowings is like GetFollowings but also returns why the
+// followings are incomplete if a page couldn't be fetched. Friends are
+// fetched page by page, but only the first page of top artists is.
+func (n *lastFMNetworkMapper) TryGetFollowings(ctx context.Context, user string) ([]string, *FetchError) {
+
+    followings := []string{}
+
+    for page := 1; ; page++ {
+        params := url.Values{"user": {user}, "limit": {strconv.Itoa(n.perPage)}, "page": {strconv.Itoa(page)}}
+
+        // Friends and artists come in the same shape under different names
+        var p struct {
+            Friends *lastFMPage `json:"friends"`
+            TopArtists *lastFMPage `json:"topartists"`
+        }
+        method := "user.getfriends"
+        if n.artists {
+            method = "user.gettopartists"
+        }
+        if err := n.call(ctx, method, params, &p); err != nil {
+            return followings, &FetchError{User: user, Page: page - 1, Message: err.Error()}
+        }
+
+        list := p.Friends
+        if n.artists {
+            list = p.TopArtists
+        }
+        if list == nil {
+            break
+        }
+        for _, e := range append(list.Users, list.Artists...) {
+            followings = append(followings, e.Name)
+        }
+
+        total, _ := strconv.Atoi(list.Attr.TotalPages)
+        if n.artists || page >= total {
+            break
+        }
+    }
+
+    return followings, nil
+}
+
+// PartialResults returns true, so a user whose friends are private is left
+// out rather than failing the build.
+func (n *lastFMNetworkMapper) PartialResults() bool {
+    return true
+}
+
+// GetProfile returns the profile of a Last.fm user, or of an artist when
+// n maps top artists.
+func (n *lastFMNetworkMapper) GetProfile(ctx context.Context, name string) (*Profile, error) {
+
+    if n.artists {
+        var a struct {
+            Artist struct {
+                lastFMEntry
+                Stats struct {
+                    Listeners string `json:"listeners"`
+                } `json:"stats"`
+            } `json:"artist"`
+        }
+        if err := n.call(ctx, "artist.getinfo", url.Values{"artist": {name}}, &a); err != nil {
+            return nil, err
+        }
+        listeners, _ := strconv.Atoi(a.Artist.Stats.Listeners)
+        return &Profile{AvatarURL: a.Artist.image(), Followers: listeners}, nil
+    }
+
+    u, err := n.GetUser(ctx, name)
+    if err != nil {
+        return nil, err
+    }
+    return u.Profile, nil
+}
+
+// GetUser returns the Last.fm account of user.
+func (n *lastFMNetworkMapper) GetUser(ctx context.Context, user string) (*User, error) {
+
+    var u struct {
+        User struct {
+            lastFMEntry
+            RealName string `json:"realname"`
+            Country string `json:"country"`
+        } `json:"user"`
+    }
+    if err := n.call(ctx, "user.getinfo", url.Values{"user": {user}}, &u); err != nil {
+        return nil, err
+    }
+
+    country := u.User.Country
+    if country == "None" {
+        country = ""
+    }
+    return &User{Name: u.User.Name, Profile: &Profile{AvatarURL: u.User.image(), FullName: u.User.RealName, Country: country}}, nil
+}
+
+// Search returns up to limit Last.fm artists matching q. Last.fm can't be
+// searched for listeners, so only n's that map top artists can search.
+func (n *lastFMNetworkMapper) Search(ctx context.Context, q string, limit int) ([]User, error) {
+
+    if !n.artists {
+        return nil, errors.New("lastfm: listeners can't be searched")
+    }
+
+    var found struct {
+        Results struct {
+            Matches struct {
+                Artists []lastFMEntry `json:"artist"`
+            } `json:"artistmatches"`
+        } `json:"results"`
+    }
+    if err := n.call(ctx, "artist.search", url.Values{"artist": {q}, "limit": {strconv.Itoa(limit)}}, &found); err != nil {
+        return nil, err
+    }
+
+    users := []User{}
+    for _, a := range found.Results.Matches.Artists {
+        users = append(users, User{Name: a.Name, Profile: &Profile{AvatarURL: a.image()}})
+    }
+    if len(users) > limit {
+        users = users[:limit]
+    }
+    return users, nil
+}
+
+// WithPageSize returns a copy of n that fetches size friends per page, or
+// maps size top artists.
+func (n *lastFMNetworkMapper) WithPageSize(size int) NetworkMapper {
+    c := *n
+    c.perPage = size
+    return &c
+}
+
+// MaxPageSize returns the most friends or artists Last.fm returns per page.
+func (n *lastFMNetworkMapper) MaxPageSize() int {
+    return maxLastFMPageSize
+}
+
+// call calls method of the Last.fm API with params and unmarshals its JSON
+// response into v, returning the error Last.fm gives if there is one.
+func (n *lastFMNetworkMapper) call(ctx context.Context, method string, params url.Values, v interface{}) error {
+
+    params.Set("method", method)
+    params.Set("api_key", n.apiKey)
+    params.Set("format", "json")
+
+    req, err := http.NewRequestWithContext(ctx, "GET", lastFMAPI + "?" + params.Encode(), nil)
+    if err != nil {
+        return err
+    }
+
+    n.quota.Take()
+    r, err := n.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer r.Body.Close()
+
+    body, err := ioutil.ReadAll(r.Body)
+    if err != nil {
+        return err
+    }
+
+    // Errors may come with any status
+    var e struct {
+        Error int `json:"error"`
+        Message string `json:"message"`
+    }
+    if json.Unmarshal(body, &e) == nil && e.Error != 0 {
+        return fmt.Errorf("lastfm: %s: %s", method, e.Message)
+    }
+    if r.StatusCode != http.StatusOK {
+        return fmt.Errorf("lastfm: %s: %s", method, r.Status)
+    }
+
+    return json.Unmarshal(body, v)
+}
+
+// A type for a page of a Last.fm user's friends or top artists.
+type lastFMPage struct {
+    Users []lastFMEntry `json:"user"`
+    Artists []lastFMEntry `json:"artist"`
+    Attr struct {
+        TotalPages string `json:"totalPages"`
+    } `json:"@attr"`
+}
+
+// lastFMEntry is a Last.fm user or artist as the API returns it.
+type lastFMEntry struct {
+    Name string `json:"name"`
+    Images []struct {
+        URL string `json:"#text"`
+        Size string `json:"size"`
+    } `json:"image"`
+}
+
+// image returns the URL of e's medium image, or of its first if it has no
+// medium one.
+func (e *lastFMEntry) image() string {
+    for _, img := range e.Images {
+        if img.Size == "medium" {
+            return img.URL
+        }
+    }
+    if len(e.Images) > 0 {
+        return e.Images[0].URL
+    }
+    return ""
+}
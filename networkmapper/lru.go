@@ -0,0 +1,87 @@
+// lru.go contains the least recently used cache behind the package's
+// in-memory caches
+
+package networkmapper
+
+import (
+    "container/list"
+    "sync"
+    "time"
+)
+
+// lruCache is a cache of up to size values, each kept for ttl if it isn't
+// 0, dropping the least recently used first when it's full.
+type lruCache struct {
+    mu sync.Mutex
+    size int
+    ttl time.Duration
+    entries map[string]*list.Element
+    order *list.List // most recently used first
+}
+
+// lruEntry is a value in an lruCache.
+type lruEntry struct {
+    key string
+    value interface{}
+    expires time.Time
+}
+
+// newLRUCache creates an lruCache of up to size values, each kept for ttl,
+// or until they're dropped if ttl is 0.
+func newLRUCache(size int, ttl time.Duration) *lruCache {
+    return &lruCache{size: size, ttl: ttl, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// get returns the value cached for key and whether there was one.
+func (c *lruCache) get(key string) (interface{}, bool) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    e, ok := c.entries[key]
+    if !ok {
+        return nil, false
+    }
+
+    entry := e.Value.(*lruEntry)
+    if c.ttl > 0 && time.Now().After(entry.expires) {
+        c.order.Remove(e)
+        delete(c.entries, key)
+        return nil, false
+    }
+
+    c.order.MoveToFront(e)
+    return entry.value, true
+}
+
+// put caches value for key, dropping the least recently used values if the
+// cache is full.
+func (c *lruCache) put(key string, value interface{}) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    entry := &lruEntry{key: key, value: value, expires: time.Now().Add(c.ttl)}
+
+    if e, ok := c.entries[key]; ok {
+        e.Value = entry
+        c.order.MoveToFront(e)
+        return
+    }
+    c.entries[key] = c.order.PushFront(entry)
+
+    for c.order.Len() > c.size {
+        oldest := c.order.Back()
+        c.order.Remove(oldest)
+        delete(c.entries, oldest.Value.(*lruEntry).key)
+    }
+}
+
+// remove drops the value cached for key, if there is one.
+func (c *lruCache) remove(key string) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if e, ok := c.entries[key]; ok {
+        c.order.Remove(e)
+        delete(c.entries, key)
+    }
+}
@@ -0,0 +1,315 @@
+// mastodon.go contains the Provider for Mastodon, mapping the accounts
+// shared among fediverse users. Users are named by their handle, as in
+// user@instance, and each is fetched from the API of their own instance.
+
+package networkmapper
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "net"
+    "net/http"
+    "net/url"
+    "regexp"
+    "strconv"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
+)
+
+const (
+    // The most accounts Mastodon returns per page
+    maxMastodonPageSize = 80
+
+    // The most instances whose quotas are kept; past that, one is
+    // forgotten for each new one
+    maxMastodonInstances = 1000
+)
+
+// mastodonHost matches a DNS name with at least two labels, without a
+// port, which is all an instance may be named by.
+var mastodonHost = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z][a-z0-9-]{0,61}[a-z0-9]$`)
+
+// mastodonNetworkMapper is the Provider for the Mastodon API.
+type mastodonNetworkMapper struct {
+    tokens map[string]string
+    perPage int
+    client *http.Client
+
+    // Each instance has its own rate limit
+    mu *sync.Mutex
+    quotas map[string]*Quota
+}
+
+// NewMastodonNetworkMapper creates a NetworkMapper whose followings are the
+// handles of the accounts a Mastodon user follows. tokens holds the access
+// tokens to call each instance with, by its domain. Instances without one
+// are called anonymously, which only some allow.
+//
+// As the instances are named by whoever asks for a network, they're only
+// called by public DNS names, over connections that refuse any address
+// that isn't public, so they can't be used to reach the server's own
+// network.
+func NewMastodonNetworkMapper(tokens map[string]string) NetworkMapper {
+    client := newTimeoutClient(defaultConnectTimeout, defaultReadTimeout)
+    transport := client.Transport.(*http.Transport)
+    transport.DialContext = (&net.Dialer{Timeout: defaultConnectTimeout, KeepAlive: 30 * time.Second,
+        Control: dialPublicOnly}).DialContext
+    transport.Proxy = nil
+
+    return &mastodonNetworkMapper{tokens: tokens, perPage: maxMastodonPageSize,
+        client: client, mu: &sync.Mutex{}, quotas: map[string]*Quota{}}
+}
+
+// GetFollowings returns the handles of the accounts user follows. It
+// returns what it has if ctx is done first or a page can't be fetched.
+func (n *mastodonNetworkMapper) GetFollowings(ctx context.Context, user string) []string {
+    followings, _ := n.TryGetFollowings(ctx, user)
+    return followings
+}
+
+// TryGetFollowings is like GetFollowings but also returns why the
+// followings are incomplete if a page couldn't be fetched.
+func (n *mastodonNetworkMapper) TryGetFollowings(ctx context.Context, user string) ([]string, *FetchError) {
+
+    followings := []string{}
+
+    instance, err := mastodonInstance(user)
+    if err != nil {
+        return followings, &FetchError{User: user, Message: err.Error()}
+    }
+
+    var account mastodonAccount
+    if _, err := n.getJSON(ctx, instance, `/api/v1/accounts/lookup?acct=` + url.QueryEscape(strings.TrimPrefix(user, "@")), &account); err != nil {
+        return followings, &FetchError{User: user, Message: err.Error()}
+    }
+
+    // Follow the Link header through every page
+    next := `https://` + instance + `/api/v1/accounts/` + account.Id + `/following?limit=` + strconv.Itoa(n.perPage)
+    for page := 0; next != ""; page++ {
+        var accounts []mastodonAccount
+        r, err := n.getJSON(ctx, instance, next, &accounts)
+        if err != nil {
+            return followings, &FetchError{User: user, Page: page, Message: err.Error()}
+        }
+
+        for _, a := range accounts {
+            followings = append(followings, a.handle(instance))
+        }
+
+        // Only follow pages on the same instance, which the token is for
+        next = ""
+        if m := gitHubNext.FindStringSubmatch(r.Header.Get("Link")); m != nil {
+            if u, err := url.Parse(m[1]); err == nil && u.Scheme == "https" && u.Host == instance {
+                next = m[1]
+            }
+        }
+    }
+
+    return followings, nil
+}
+
+// PartialResults returns true, so a user whose follows are hidden is left
+// out rather than failing the build.
+func (n *mastodonNetworkMapper) PartialResults() bool {
+    return true
+}
+
+// GetProfile returns the profile of the Mastodon account with handle name.
+func (n *mastodonNetworkMapper) GetProfile(ctx context.Context, name string) (*Profile, error) {
+    u, err := n.GetUser(ctx, name)
+    if err != nil {
+        return nil, err
+    }
+    return u.Profile, nil
+}
+
+// GetUser returns the Mastodon account with handle user.
+func (n *mastodonNetworkMapper) GetUser(ctx context.Context, user string) (*User, error) {
+
+    instance, err := mastodonInstance(user)
+    if err != nil {
+        return nil, err
+    }
+
+    var a mastodonAccount
+    if _, err := n.getJSON(ctx, instance, `/api/v1/accounts/lookup?acct=` + url.QueryEscape(strings.TrimPrefix(user, "@")), &a); err != nil {
+        return nil, err
+    }
+    return a.user(instance), nil
+}
+
+// Search returns up to limit Mastodon accounts matching q, which must be
+// a handle or end in @instance to say which instance to search.
+func (n *mastodonNetworkMapper) Search(ctx context.Context, q string, limit int) ([]User, error) {
+
+    instance, err := mastodonInstance(q)
+    if err != nil {
+        return nil, err
+    }
+
+    var accounts []mastodonAccount
+    path := `/api/v1/accounts/search?q=` + url.QueryEscape(strings.TrimPrefix(q, "@")) + `&limit=` + strconv.Itoa(limit)
+    if _, err := n.getJSON(ctx, instance, path, &accounts); err != nil {
+        return nil, err
+    }
+
+    users := make([]User, 0, len(accounts))
+    for _, a := range accounts {
+        users = append(users, *a.user(instance))
+    }
+    if len(users) > limit {
+        users = users[:limit]
+    }
+    return users, nil
+}
+
+// WithPageSize returns a copy of n that fetches size accounts per page.
+func (n *mastodonNetworkMapper) WithPageSize(size int) NetworkMapper {
+    c := *n
+    c.perPage = size
+    return &c
+}
+
+// MaxPageSize returns the most accounts Mastodon returns per page.
+func (n *mastodonNetworkMapper) MaxPageSize() int {
+    return maxMastodonPageSize
+}
+
+// quota returns the Quota of instance, creating it the first time.
+func (n *mastodonNetworkMapper) quota(instance string) *Quota {
+
+    n.mu.Lock()
+    defer n.mu.Unlock()
+
+    q, ok := n.quotas[instance]
+    if !ok {
+        if len(n.quotas) >= maxMastodonInstances {
+            for i := range n.quotas {
+                delete(n.quotas, i)
+                break
+            }
+        }
+        q = NewQuota(mastodonQuota, mastodonQuotaWindow)
+        n.quotas[instance] = q
+    }
+    return q
+}
+
+// getJSON gets path, or a full URL, from instance and unmarshals its JSON
+// body into v, calling it with the instance's access token if there is one.
+func (n *mastodonNetworkMapper) getJSON(ctx context.Context, instance, path string, v interface{}) (*http.Response, error) {
+
+    if strings.HasPrefix(path, "/") {
+        path = `https://` + instance + path
+    }
+
+    req, err := http.NewRequestWithContext(ctx, "GET", path, nil)
+    if err != nil {
+        return nil, err
+    }
+    if token := n.tokens[instance]; token != "" {
+        req.Header.Set("Authorization", "Bearer " + token)
+    }
+
+    q := n.quota(instance)
+    q.Take()
+    r, err := n.client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer r.Body.Close()
+    q.Observe(r)
+
+    body, err := ioutil.ReadAll(r.Body)
+    if err != nil {
+        return nil, err
+    }
+    if r.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("mastodon: %s%s %s", instance, r.Request.URL.Path, r.Status)
+    }
+
+    return r, json.Unmarshal(body, v)
+}
+
+// mastodonInstance returns the instance of handle, as in user@instance or
+// @user@instance. The instance must be a public DNS name, not an IP
+// address, a name with a port, or a name only a local network has.
+func mastodonInstance(handle string) (string, error) {
+    i := strings.LastIndex(handle, "@")
+    if i <= 0 || i == len(handle) - 1 {
+        return "", fmt.Errorf("mastodon: %q isn't a handle like user@instance", handle)
+    }
+
+    instance := strings.ToLower(handle[i + 1:])
+    if !mastodonHost.MatchString(instance) || net.ParseIP(instance) != nil || localHost(instance) {
+        return "", fmt.Errorf("mastodon: %q isn't a public instance", instance)
+    }
+    return instance, nil
+}
+
+// localHost returns whether host is under a domain only a local network
+// resolves.
+func localHost(host string) bool {
+    for _, suffix := range []string{".localhost", ".local", ".internal", ".lan", ".home.arpa", ".corp"} {
+        if strings.HasSuffix(host, suffix) {
+            return true
+        }
+    }
+    return false
+}
+
+// dialPublicOnly refuses to connect to an address that isn't public, so a
+// public name that resolves to a private address can't reach it either.
+func dialPublicOnly(network, address string, c syscall.RawConn) error {
+    host, _, err := net.SplitHostPort(address)
+    if err != nil {
+        return err
+    }
+
+    ip := net.ParseIP(host)
+    if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+        ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddress(ip) {
+        return errors.New("mastodon: refusing to connect to " + host + ", which isn't public")
+    }
+    return nil
+}
+
+// sharedAddress returns whether ip is in the carrier-grade NAT range,
+// which isn't public but isn't counted as private either.
+func sharedAddress(ip net.IP) bool {
+    ip4 := ip.To4()
+    return ip4 != nil && ip4[0] == 100 && ip4[1] & 0xc0 == 64
+}
+
+// mastodonAccount is a Mastodon account as the API returns it.
+type mastodonAccount struct {
+    Id string `json:"id"`
+    Acct string `json:"acct"`
+    DisplayName string `json:"display_name"`
+    Avatar string `json:"avatar"`
+    Followers int `json:"followers_count"`
+}
+
+// handle returns the full handle of a, which instance names without its
+// instance if a is one of its own accounts.
+func (a *mastodonAccount) handle(instance string) string {
+    if strings.Contains(a.Acct, "@") {
+        return a.Acct
+    }
+    return a.Acct + "@" + instance
+}
+
+// user returns the User of a, as found on instance.
+func (a *mastodonAccount) user(instance string) *User {
+    id, _ := strconv.ParseInt(a.Id, 10, 64)
+    return &User{
+        Name: a.handle(instance),
+        Id: id,
+        Profile: &Profile{AvatarURL: a.Avatar, FullName: a.DisplayName, Followers: a.Followers},
+    }
+}
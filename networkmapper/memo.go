@@ -0,0 +1,140 @@
+// memo.go contains the caches of users' followings, in memory and in a
+// shared store, so users shared by overlapping networks are only fetched
+// once in a while
+
+package networkmapper
+
+import (
+    "strconv"
+    "strings"
+    "time"
+)
+
+// A type for a cache of up to size users' followings, each kept for ttl,
+// dropping the least recently used first when it's full.
+type FollowingsCache struct {
+    lru *lruCache
+}
+
+// NewFollowingsCache creates a FollowingsCache of up to size users'
+// followings, each kept for ttl.
+func NewFollowingsCache(size int, ttl time.Duration) *FollowingsCache {
+    return &FollowingsCache{lru: newLRUCache(size, ttl)}
+}
+
+// WithFollowingsCache makes the NetworkMapper keep up to size users'
+// followings in memory for ttl, rather than fetching them for every build.
+func WithFollowingsCache(size int, ttl time.Duration) Option {
+    return func(n *networkMapper) {
+        if size > 0 && ttl > 0 {
+            n.memo = NewFollowingsCache(size, ttl)
+        }
+    }
+}
+
+// Get returns the cached followings of user, which mustn't be modified,
+// and whether there were any.
+func (c *FollowingsCache) Get(user string) ([]string, bool) {
+    if c == nil {
+        return nil, false
+    }
+
+    followings, ok := c.lru.get(strings.ToLower(user))
+    if !ok {
+        return nil, false
+    }
+    return followings.([]string), true
+}
+
+// Put caches followings as those of user, dropping the least recently
+// used users if the cache is full.
+func (c *FollowingsCache) Put(user string, followings []string) {
+    if c == nil {
+        return
+    }
+
+    c.lru.put(strings.ToLower(user), followings)
+}
+
+// Delete drops the cached followings of user, if there are any.
+func (c *FollowingsCache) Delete(user string) {
+    if c == nil {
+        return
+    }
+
+    c.lru.remove(strings.ToLower(user))
+}
+
+// A type that satisfies networkmapper.FollowingsStore keeps users'
+// followings somewhere shared, such as Redis, so they outlive the process
+// and are reused by every server.
+type FollowingsStore interface {
+
+    // Gets the followings stored under a given key, and whether there were
+    // any
+    GetFollowings(key string) ([]string, bool)
+
+    // Stores the followings under a given key
+    PutFollowings(key string, followings []string)
+
+    // Drops the followings stored under a given key
+    DeleteFollowings(key string)
+
+}
+
+// A type that satisfies networkmapper.Forgetter can drop what it has
+// cached about a user, so it's fetched again the next time it's needed.
+type Forgetter interface {
+
+    // Drops the cached followings and followers of a given user
+    Forget(user string)
+
+}
+
+// WithFollowingsStore makes the NetworkMapper look for users' followings
+// in s when they aren't cached in memory, and store those it fetches
+// there. Users are stored by their lowercased permalink, or their id once
+// it's known.
+func WithFollowingsStore(s FollowingsStore) Option {
+    return func(n *networkMapper) {
+        n.store = s
+    }
+}
+
+// stored returns the followings of user kept in n's FollowingsStore, also
+// caching them in memory, and whether there were any.
+func (n *networkMapper) stored(user string) ([]string, bool) {
+    if n.store == nil {
+        return nil, false
+    }
+
+    for _, key := range []string{n.userKey(user), n.keyPrefix() + strings.ToLower(user)} {
+        if followings, ok := n.store.GetFollowings(key); ok {
+            n.memo.Put(key, followings)
+            return followings, true
+        }
+    }
+    return nil, false
+}
+
+// Forget drops the followings and followers of user from n's
+// FollowingsCache and FollowingsStore, by name and by id if it's known.
+func (n *networkMapper) Forget(user string) {
+
+    keys := []string{}
+    for _, prefix := range []string{"", "followers:"} {
+        keys = append(keys, prefix + strings.ToLower(user))
+        if _, err := strconv.ParseInt(user, 10, 64); err == nil {
+            keys = append(keys, prefix + "id:" + user)
+        } else if id, ok := n.ids.get(strings.ToLower(user)); ok {
+            keys = append(keys, prefix + "id:" + strconv.FormatInt(id.(int64), 10))
+        }
+    }
+
+    for _, key := range keys {
+        n.memo.Delete(key)
+        if n.store != nil {
+            n.store.DeleteFollowings(key)
+        }
+    }
+}
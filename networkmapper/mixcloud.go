@@ -0,0 +1,181 @@
+// mixcloud.go contains the Provider for Mixcloud, mapping the accounts
+// shared among DJs and listeners.
+
+package networkmapper
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "strconv"
+)
+
+const (
+    mixcloudAPI = `https://api.mixcloud.com`
+
+    // The most accounts Mixcloud returns per page
+    maxMixcloudPageSize = 100
+)
+
+// mixcloudNetworkMapper is the Provider for the Mixcloud API.
+type mixcloudNetworkMapper struct {
+    perPage int
+    client *http.Client
+    quota *Quota
+}
+
+// NewMixcloudNetworkMapper creates a NetworkMapper whose followings are the
+// Mixcloud accounts a user follows. Mixcloud's API needs no credentials to
+// read them.
+func NewMixcloudNetworkMapper() NetworkMapper {
+    return &mixcloudNetworkMapper{perPage: maxMixcloudPageSize,
+        client: newTimeoutClient(defaultConnectTimeout, defaultReadTimeout),
+        quota: NewQuota(mixcloudQuota, mixcloudQuotaWindow)}
+}
+
+// GetFollowings returns the usernames of the accounts user follows. It
+// returns what it has if ctx is done first or a page can't be fetched.
+func (n *mixcloudNetworkMapper) GetFollowings(ctx context.Context, user string) []string {
+    followings, _ := n.TryGetFollowings(ctx, user)
+    return followings
+}
+
+// TryGetFollowings is like GetFollowings but also returns why the
+// followings are incomplete if a page couldn't be fetched.
+func (n *mixcloudNetworkMapper) TryGetFollowings(ctx context.Context, user string) ([]string, *FetchError) {
+
+    followings := []string{}
+
+    // Follow the paging through every page
+    next := mixcloudAPI + `/` + url.PathEscape(user) + `/following/?limit=` + strconv.Itoa(n.perPage)
+    for page := 0; next != ""; page++ {
+        var p struct {
+            Data []mixcloudUser `json:"data"`
+            Paging struct {
+                Next string `json:"next"`
+            } `json:"paging"`
+        }
+        if err := n.getJSON(ctx, next, &p); err != nil {
+            return followings, &FetchError{User: user, Page: page, Message: err.Error()}
+        }
+
+        for _, u := range p.Data {
+            followings = append(followings, u.Username)
+        }
+
+        // The last page still links to an empty one
+        next = ""
+        if len(p.Data) > 0 {
+            next = p.Paging.Next
+        }
+    }
+
+    return followings, nil
+}
+
+// PartialResults returns true, so a user who can't be found is left out
+// rather than failing the build.
+func (n *mixcloudNetworkMapper) PartialResults() bool {
+    return true
+}
+
+// GetProfile returns the profile of the Mixcloud account name.
+func (n *mixcloudNetworkMapper) GetProfile(ctx context.Context, name string) (*Profile, error) {
+    u, err := n.GetUser(ctx, name)
+    if err != nil {
+        return nil, err
+    }
+    return u.Profile, nil
+}
+
+// GetUser returns the account of user on Mixcloud.
+func (n *mixcloudNetworkMapper) GetUser(ctx context.Context, user string) (*User, error) {
+
+    var u mixcloudUser
+    if err := n.getJSON(ctx, mixcloudAPI + `/` + url.PathEscape(user) + `/`, &u); err != nil {
+        return nil, err
+    }
+    return u.user(), nil
+}
+
+// Search returns up to limit Mixcloud accounts matching q.
+func (n *mixcloudNetworkMapper) Search(ctx context.Context, q string, limit int) ([]User, error) {
+
+    var found struct {
+        Data []mixcloudUser `json:"data"`
+    }
+    path := mixcloudAPI + `/search/?type=user&q=` + url.QueryEscape(q) + `&limit=` + strconv.Itoa(limit)
+    if err := n.getJSON(ctx, path, &found); err != nil {
+        return nil, err
+    }
+
+    users := make([]User, 0, len(found.Data))
+    for _, u := range found.Data {
+        users = append(users, *u.user())
+    }
+    if len(users) > limit {
+        users = users[:limit]
+    }
+    return users, nil
+}
+
+// WithPageSize returns a copy of n that fetches size accounts per page.
+func (n *mixcloudNetworkMapper) WithPageSize(size int) NetworkMapper {
+    c := *n
+    c.perPage = size
+    return &c
+}
+
+// MaxPageSize returns the most accounts Mixcloud returns per page.
+func (n *mixcloudNetworkMapper) MaxPageSize() int {
+    return maxMixcloudPageSize
+}
+
+// getJSON gets url from Mixcloud and unmarshals its JSON body into v.
+func (n *mixcloudNetworkMapper) getJSON(ctx context.Context, url string, v interface{}) error {
+
+    req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+    if err != nil {
+        return err
+    }
+
+    n.quota.Take()
+    r, err := n.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer r.Body.Close()
+
+    body, err := ioutil.ReadAll(r.Body)
+    if err != nil {
+        return err
+    }
+    if r.StatusCode != http.StatusOK {
+        return fmt.Errorf("mixcloud: %s %s", r.Request.URL.Path, r.Status)
+    }
+
+    return json.Unmarshal(body, v)
+}
+
+// mixcloudUser is a Mixcloud account as the API returns it.
+type mixcloudUser struct {
+    Username string `json:"username"`
+    Name string `json:"name"`
+    City string `json:"city"`
+    Country string `json:"country"`
+    Followers int `json:"follower_count"`
+    Pictures struct {
+        Medium string `json:"medium"`
+    } `json:"pictures"`
+}
+
+// user returns the User of u.
+func (u *mixcloudUser) user() *User {
+    return &User{
+        Name: u.Username,
+        Profile: &Profile{AvatarURL: u.Pictures.Medium, FullName: u.Name, Followers: u.Followers, City: u.City, Country: u.Country},
+    }
+}
@@ -0,0 +1,186 @@
+// mock.go contains a NetworkMapper that makes up a follow network, the
+// same one every time for the same seed, so networks can be built for
+// tests and demos without calling any API.
+
+package networkmapper
+
+import (
+    "context"
+    "fmt"
+    "hash/fnv"
+    "math/rand"
+    "strings"
+)
+
+const (
+    // How many accounts each user follows, at least and at most
+    minMockFollowings = 20
+    maxMockFollowings = 100
+
+    // How strongly followings favor the most popular accounts; higher
+    // shares more of them among users
+    mockPopularity = 1.2
+)
+
+// The words mock account names are made of
+var (
+    mockAdjectives = []string{"amber", "blue", "cosmic", "deep", "electric", "faded", "golden", "hazy",
+        "iron", "jade", "lunar", "midnight", "neon", "obsidian", "polar", "quiet"}
+    mockNouns = []string{"echo", "bass", "circuit", "drift", "engine", "forest", "groove", "harbor",
+        "island", "jungle", "kite", "loop", "mirror", "nomad", "orbit", "pulse"}
+
+    // The genres and labels made up accounts are given
+    mockGenres = []string{"House", "Techno", "Ambient", "Drum & Bass", "Hip Hop", "Jazz"}
+    mockLabels = []string{"Warp", "Ninja Tune", "Hyperdub", "Ghostly", "Kompakt"}
+)
+
+// A type for a NetworkMapper that makes up who follows whom. The same seed
+// and number of accounts always make the same network, whichever users are
+// asked about and in whatever order.
+type MockNetworkMapper struct {
+    seed int64
+    accounts int
+}
+
+// NewMockNetworkMapper creates a MockNetworkMapper whose users follow
+// among accounts made up accounts, chosen by a random source seeded with
+// seed.
+func NewMockNetworkMapper(seed int64, accounts int) *MockNetworkMapper {
+    if accounts < 1 {
+        accounts = 1
+    }
+    return &MockNetworkMapper{seed: seed, accounts: accounts}
+}
+
+// GetFollowings returns the made up followings of user, favoring the
+// most popular accounts so users share some of them.
+func (n *MockNetworkMapper) GetFollowings(ctx context.Context, user string) []string {
+
+    rng := n.rand(user)
+
+    count := minMockFollowings + rng.Intn(maxMockFollowings - minMockFollowings + 1)
+    if count > n.accounts {
+        count = n.accounts
+    }
+
+    // Zipf picks the most popular accounts, the lowest, most often
+    popular := rand.NewZipf(rng, mockPopularity, 1, uint64(n.accounts - 1))
+
+    followings := make([]string, 0, count)
+    followed := make(map[uint64]bool, count)
+    for tries := 0; len(followings) < count && tries < count * 10; tries++ {
+        if i := popular.Uint64(); !followed[i] {
+            followed[i] = true
+            followings = append(followings, mockName(int(i)))
+        }
+    }
+
+    return followings[0:]
+}
+
+// GetProfile returns the made up profile of user, with more followers the
+// more popular it is.
+func (n *MockNetworkMapper) GetProfile(ctx context.Context, user string) (*Profile, error) {
+    u, err := n.GetUser(ctx, user)
+    if err != nil {
+        return nil, err
+    }
+    return u.Profile, nil
+}
+
+// GetUser returns the made up account of user. Made up accounts are
+// numbered by popularity; the users asked about are given ids past them.
+func (n *MockNetworkMapper) GetUser(ctx context.Context, user string) (*User, error) {
+
+    i := mockIndex(user)
+    if i < 0 || i >= n.accounts {
+        rng := n.rand(user)
+        return &User{Name: user, Id: int64(n.accounts + rng.Intn(n.accounts) + 1),
+            Profile: &Profile{FullName: user, Followers: rng.Intn(100)}}, nil
+    }
+
+    return &User{Name: user, Id: int64(i + 1), Profile: &Profile{
+        FullName: strings.Title(strings.Replace(user, "-", " ", -1)),
+        Followers: 100000 / (i + 1),
+        Tracks: n.rand(user).Intn(200),
+    }}, nil
+}
+
+// GetGenre returns the made up genre of user.
+func (n *MockNetworkMapper) GetGenre(ctx context.Context, user string) (string, error) {
+    return mockGenres[n.rand(user).Intn(len(mockGenres))], nil
+}
+
+// GetLabel returns the made up label of user, or "" for the third or so
+// of accounts that release on none.
+func (n *MockNetworkMapper) GetLabel(ctx context.Context, user string) (string, error) {
+    if i := n.rand(user).Intn(len(mockLabels) * 3 / 2); i < len(mockLabels) {
+        return mockLabels[i], nil
+    }
+    return "", nil
+}
+
+// Search returns up to limit made up accounts whose names contain q, most
+// popular first.
+func (n *MockNetworkMapper) Search(ctx context.Context, q string, limit int) ([]User, error) {
+
+    q = strings.ToLower(q)
+    users := []User{}
+    for i := 0; i < n.accounts && len(users) < limit; i++ {
+        if name := mockName(i); strings.Contains(name, q) {
+            u, _ := n.GetUser(ctx, name)
+            users = append(users, *u)
+        }
+    }
+    return users, nil
+}
+
+// rand returns the random source for user, which depends only on the seed
+// and user.
+func (n *MockNetworkMapper) rand(user string) *rand.Rand {
+    h := fnv.New64a()
+    h.Write([]byte(user))
+    return rand.New(rand.NewSource(n.seed ^ int64(h.Sum64())))
+}
+
+/* Helpers */
+
+// mockName returns the name of the made up account numbered i.
+func mockName(i int) string {
+    words := len(mockAdjectives) * len(mockNouns)
+    name := mockAdjectives[i % len(mockAdjectives)] + "-" + mockNouns[i / len(mockAdjectives) % len(mockNouns)]
+    if i >= words {
+        name += fmt.Sprintf("-%d", i / words)
+    }
+    return name
+}
+
+// mockIndex returns the number of the made up account named name, or -1
+// if it isn't one.
+func mockIndex(name string) int {
+    parts := strings.Split(name, "-")
+    if len(parts) < 2 || len(parts) > 3 {
+        return -1
+    }
+
+    adjective, noun, round := -1, -1, 0
+    for i, w := range mockAdjectives {
+        if w == parts[0] {
+            adjective = i
+        }
+    }
+    for i, w := range mockNouns {
+        if w == parts[1] {
+            noun = i
+        }
+    }
+    if len(parts) == 3 {
+        if _, err := fmt.Sscanf(parts[2], "%d", &round); err != nil || round < 1 {
+            return -1
+        }
+    }
+    if adjective < 0 || noun < 0 {
+        return -1
+    }
+    return round * len(mockAdjectives) * len(mockNouns) + noun * len(mockAdjectives) + adjective
+}
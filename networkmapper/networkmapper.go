@@ -3,280 +3,386 @@
 package networkmapper
 
 import (
+    "context"
     "encoding/json"
-    "io/ioutil"
-    "math"
-    "net/http"
-    "strconv"
     "sync"
+    "time"
 )
 
 // A type that satisfies network.NetworkMapper can be used to generate networks
 // with this package.
 type NetworkMapper interface {
 
-    // Gets the followings of a given user
-    GetFollowings(user string) []string 
+    // Gets the followings of a given user, giving up if ctx is done
+    GetFollowings(ctx context.Context, user string) []string 
 
 }
 
-// networkMapper is the implimentation of NetworkMapper.
-type networkMapper struct {
-    clientId string
-    numResults int
-}
-
 // A type for the final JSON result.
 type Result struct {
-    Nodes []Node `json:"nodes"`
-    Links []Link `json:"links"`
+    Nodes []Node `json:"nodes" doc:"The followed accounts, referred to by index"`
+    Links []Link `json:"links" doc:"Who follows whom, from follower to followed"`
+    Meta *Meta `json:"meta,omitempty" doc:"Set when the network was cut down to fit"`
+    Directed bool `json:"directed,omitempty" doc:"Whether links should be drawn as arrows from follower to followed"`
+    Groups []string `json:"groups,omitempty" doc:"The name of each group by number, when grouped by something other than role"`
+    Stats *Stats `json:"stats,omitempty" doc:"A summary of the network as it was built, before it was cut down to fit"`
+    Similarity *Similarity `json:"similarity,omitempty" doc:"How alike the users' followings are, pair by pair"`
+    Relation string `json:"relation,omitempty" doc:"followers when built from who follows the users rather than who they follow, both when built from both"`
+}
+
+// A type for information about how a Result differs from the full network.
+type Meta struct {
+    Truncated bool `json:"truncated" doc:"Whether nodes or links were left out"`
+    OmittedNodes int `json:"omitted_nodes" doc:"How many nodes were left out"`
+    OmittedLinks int `json:"omitted_links" doc:"How many links were left out"`
+    Full string `json:"full,omitempty" doc:"A link to download the full network"`
+    Partial bool `json:"partial,omitempty" doc:"Whether some users' followings are missing, because the build ran out of time or they couldn't be fetched"`
+    SkippedUsers int `json:"skipped_users,omitempty" doc:"How many users' followings weren't fetched in time"`
+    Errors []FetchError `json:"errors,omitempty" doc:"The users whose followings couldn't all be fetched"`
 }
 
 // A type for each node.
 type Node struct {
-    Name string `json:"name"`
-    Group int `json:"group"`
+    Id int64 `json:"id,omitempty" doc:"The account's id, which unlike its name doesn't change"`
+    Name string `json:"name" doc:"The account's username"`
+    Group int `json:"group" doc:"The group to color the node by; 1 for the users being compared, 3 for accounts added by expanding, named in groups when grouped"`
+    Accounts map[string]string `json:"accounts,omitempty" doc:"The account on each source, in merged networks"`
+    InDegree int `json:"in_degree" doc:"How many nodes link to it, which for a following is how many of the users follow it"`
+    OutDegree int `json:"out_degree" doc:"How many nodes it links to"`
+    Rank float64 `json:"rank,omitempty" doc:"Its PageRank, when ranked"`
+    *Profile // set when the network is built with metadata
 }
 
 // A type for each link.
 type Link struct {
-    Source int `json:"source"`
-    Target int `json:"target"`
+    Source int `json:"source" doc:"The index of the following node"`
+    Target int `json:"target" doc:"The index of the followed node"`
+    Sources []string `json:"sources,omitempty" doc:"The sources the link was found on, in merged networks"`
+    Weight int `json:"weight,omitempty" doc:"The strength of the link, when weighted"`
+    Mutual bool `json:"mutual,omitempty" doc:"Whether the target follows the source back, between the users or in directed networks"`
+    Type string `json:"type,omitempty" doc:"In networks of both relations, follows if it was found among the users' followings, followed_by if among their followers"`
 }
 
 // A type for a user's followings.
 type Followings struct {
     Whoms []string
     Who string
-}
-
-// NewNetworkMapper creates a new NetworkMapper.
-func NewNetworkMapper(id string, num int) NetworkMapper {
-    return &networkMapper{
-        clientId: id,
-        numResults: num,
-    }
+    Err *FetchError
 }
 
 // BuildNetwork creates a new network entry in Redis for the given key.
-func BuildNetworkMap(n NetworkMapper, users []string) ([]byte, error) {
-
-    var js []byte
-
-    // Filter into shared followings among the users
-    result := GetSharedFollowings(n, users[0:])    
-
-    // JSON marshal the result
-    js, err := json.Marshal(*result)
-    if err != nil {
-        return nil, err
-    }
+// It returns ctx's error if ctx is done before the network is built.
+func BuildNetworkMap(ctx context.Context, n NetworkMapper, users []string) ([]byte, error) {
+    return BuildNetworkMapChunked(ctx, n, users[0:], len(users))
+}
 
-    return js[0:], nil
+// BuildNetworkMapChunked is like BuildNetworkMap but only fetches the
+// followings of chunkSize users at a time, for seed sets too large to
+// fetch all at once.
+func BuildNetworkMapChunked(ctx context.Context, n NetworkMapper, users []string, chunkSize int) ([]byte, error) {
+    return BuildNetworkMapBudget(ctx, n, users[0:], chunkSize, 0)
 }
 
-// // Types for soundcloud unmarshaling.
-// type scUser struct { FollowingCount float64  `json:"followings_count"` }
-// type scFollowing struct { Permalink string `json:"permalink"`}
+// BuildNetworkMapBudget is like BuildNetworkMapChunked but stops fetching
+// once budget has passed, building the network from the followings fetched
+// so far and marking it partial in its Meta. A budget of 0 is no limit.
+// Builds with a BuildTimeouter fail once its BuildTimeout has passed.
+// The Hooks of ctx, if it has any, are told how the build is going.
+func BuildNetworkMapBudget(ctx context.Context, n NetworkMapper, users []string, chunkSize int, budget time.Duration) (js []byte, err error) {
 
-// GetFollowings returns a slice of strings containing the usernames of 
-// the followings of the provided user.
-func (n *networkMapper) GetFollowings(user string) ([]string) {
+    start := time.Now()
+    defer func() {
+        hooksFrom(ctx).OnBuildDone(len(users), time.Since(start), err)
+    } ()
 
-    var url string
+    ctx, cancel := withBuildTimeout(ctx, n)
+    defer cancel()
 
-    // Get u's number of followings
-    url = `http://api.soundcloud.com/users/` + user + `.json?client_id=` + n.clientId
-    r, err := http.Get(url)
-    if err != nil {
-        panic(err)
+    // Fail fast if the source is down
+    if err := unavailable(n); err != nil {
+        return nil, err
     }
-    defer r.Body.Close()
 
-    body, err := ioutil.ReadAll(r.Body)
-    if err != nil {
-        panic(err)
+    // Stop fetching when the budget runs out
+    fetchCtx := ctx
+    if budget > 0 {
+        var cancel context.CancelFunc
+        fetchCtx, cancel = context.WithTimeout(ctx, budget)
+        defer cancel()
     }
 
-    // user object to store unmarshalled json
-    var u struct { 
-        FollowingCount float64  `json:"followings_count"` 
+    // Filter into shared followings among the users
+    var fetched int
+    var errs []FetchError
+    cf := untilDone(GetAllFollowingsChunked(fetchCtx, n, users[0:], chunkSize), fetchCtx.Done(), &fetched)
+    result := sharedFollowings(users[0:], collectErrors(cf, &errs))
+
+    // Links to followers run from the users, so turn them around
+    if relationOf(n) == RelationFollowers {
+        result.reverse()
     }
 
-    if err = json.Unmarshal(body, &u); err != nil {
-        panic(err)
+    // Give the nodes their ids, which are mostly known from the pages
+    if f, ok := n.(IdResolver); ok {
+        AddIds(fetchCtx, result, f)
     }
 
-    jsonFollowings := make([]struct { Permalink string `json:"permalink"`}, n.numResults)
-    followings := make([]string, int(u.FollowingCount))
-
-    // Search for the user's followings
-    // Iterate to account for the results limit
-    var wg sync.WaitGroup
-
-    countTo := math.Ceil(u.FollowingCount / float64(n.numResults))
-    for i := 0; i < int(countTo); i++ {
-
-        wg.Add(1)
-        go func(i int) {
-
-            url := `http://api.soundcloud.com/users/` + 
-                   user + `/followings.json?client_id=` + 
-                   n.clientId + `&offset=` + strconv.Itoa(i * 50)
-                   
-            r, err := http.Get(url)
-            if err != nil {
-                panic(err)
-            }
-            defer r.Body.Close()
+    // Give up if the build itself was cancelled, or the source went down
+    // and followings are missing
+    if err := ctx.Err(); err != nil {
+        return nil, err
+    }
+    if err := unavailable(n); err != nil {
+        return nil, err
+    }
 
-            body, err := ioutil.ReadAll(r.Body)
-            if err != nil {
-                panic(err)
-            }
+    result.Stats = result.ComputeStats()
 
-            // unmarshal into jsonFollowings
-            if err = json.Unmarshal(body, &jsonFollowings); err != nil {
-                panic(err)
-            }
+    if len(errs) > 0 && !partialResults(n) {
+        return nil, &errs[0]
+    }
+    for i := range errs {
+        if errs[i].UnknownUser() {
+            return nil, &errs[i]
+        }
+    }
 
-            for j, jf := range jsonFollowings {
-                index := j + (i * n.numResults)
-                if index >= int(u.FollowingCount) {
-                    break
-                }
-                followings[index] = jf.Permalink   
-            }
+    if fetched < len(users) || len(errs) > 0 {
+        result.Meta = &Meta{Partial: true, SkippedUsers: len(users) - fetched, Errors: errs}
+    }
 
-            wg.Done()
-        } (i)
+    // JSON marshal the result
+    js, err = json.Marshal(*result)
+    if err != nil {
+        return nil, err
     }
 
-    wg.Wait()
-    return followings[0:]
+    return js[0:], nil
 }
 
-
 // GetAllFollowings returns a channel of Followings objects for the 
 // given users.
 // A channel is used to concurrently handle the calls to GetFollowings.
-func GetAllFollowings(n NetworkMapper, users []string) (<-chan Followings) {
+// Once ctx is done, the users not yet fetched are left out.
+func GetAllFollowings(ctx context.Context, n NetworkMapper, users []string) (<-chan Followings) {
+    return GetAllFollowingsChunked(ctx, n, users[0:], len(users))
+}
+
+// GetAllFollowingsChunked is like GetAllFollowings but only calls
+// GetFollowings for chunkSize users at a time.
+func GetAllFollowingsChunked(ctx context.Context, n NetworkMapper, users []string, chunkSize int) (<-chan Followings) {
 
     // Create a channel for the followings
     cf := make(chan Followings)
+
+    if chunkSize <= 0 {
+        chunkSize = len(users)
+    }
     
-    // Iterate over the users and pass their
-    // followings onto channel
+    // Iterate over the users a chunk at a time and pass
+    // their followings onto channel
     go func() {
-        var wg sync.WaitGroup
-
-        // GetFollowings for each user
-        for _, u := range users {
-            wg.Add(1)
-            go func(u string) {
-                cf <- Followings{Whoms: n.GetFollowings(u), Who:u}
-                wg.Done()
-            } (u)
-        }
+        for start := 0; start < len(users) && ctx.Err() == nil; start += chunkSize {
+            end := start + chunkSize
+            if end > len(users) {
+                end = len(users)
+            }
 
-        wg.Wait()
+            var wg sync.WaitGroup
+
+            // GetFollowings for each user in the chunk
+            for _, u := range users[start:end] {
+                wg.Add(1)
+                go func(u string) {
+                    defer wg.Done()
+
+                    // Followings cut short by ctx are left out
+                    hooks := hooksFrom(ctx)
+                    hooks.OnUserStart(u)
+                    whoms, err := getFollowings(ctx, n, u)
+                    fs := Followings{Whoms: whoms, Who:u, Err: err}
+                    if ctx.Err() != nil {
+                        return
+                    }
+
+                    // A nil *FetchError isn't a nil error
+                    var doneErr error
+                    if err != nil {
+                        doneErr = err
+                    }
+                    hooks.OnUserDone(u, len(whoms), doneErr)
+                    select {
+                    case cf <- fs:
+                    case <-ctx.Done():
+                    }
+                } (u)
+            }
+
+            wg.Wait()
+        }
         close(cf)
     } ()
 
     return cf
 }
 
-// GetSharedFollowings creates a Result containing nodes and links for
-// all users followed by at least two of the given users.
-func GetSharedFollowings(n NetworkMapper, users []string) (*Result) {
-
-    // Get a channel of Followings for the given users
-    cf := GetAllFollowings(n, users[0:])
-
-    // Create two sets to handle consolidation of the map
-    checkSet := make(map[string]bool)
-    relevantSet := make(map[string]bool)
+// untilDone passes on the Followings from cf until done is closed, counting
+// them in count, which may be read once the returned channel is closed.
+func untilDone(cf <-chan Followings, done <-chan struct{}, count *int) (<-chan Followings) {
 
-    // Create a slice of Followings to check once the relevantSet
-    // is filled
-    followings := make([]Followings, len(users))
+    out := make(chan Followings)
 
-    // Create two slices to hold the nodes and links
-    nodes := make([]Node, len(users))
-
-    // Keep track of node numbers, which 
-    // are generated by order
-    nodeNums := make(map[string]int)
-    nodeCount := 0
+    go func() {
+        defer close(out)
+        for {
+            select {
+            case fs, ok := <-cf:
+                if !ok {
+                    return
+                }
+                select {
+                case out <- fs:
+                    *count++
+                case <-done:
+                    return
+                }
+            case <-done:
+                return
+            }
+        }
+    } ()
 
-    for i, u := range users {
+    return out
+}
 
-        // Put each user in the check and results sets
-        checkSet[u] = true
-        relevantSet[u] = true
+// The fewest of the given users that must follow a user for it to become
+// a node.
+const minShared = 2
 
-        // Make a node from each user and 
-        // pass it to the channel
-        nodes[i] = Node{Name: u, Group: 1} // Group: 1 -> given user
-        nodeNums[u] = nodeCount
-        nodeCount++
+// GetSharedFollowings creates a Result containing nodes and links for
+// all users followed by at least minShared of the given users.
+func GetSharedFollowings(ctx context.Context, n NetworkMapper, users []string) (*Result) {
+    result := sharedFollowings(users[0:], GetAllFollowings(ctx, n, users[0:]))
+    if relationOf(n) == RelationFollowers {
+        result.reverse()
     }
+    return result
+}
 
-    // Grab the first collection of followings
-    followings[0] = <-cf
+// sharedFollowings creates the Result for GetSharedFollowings from a
+// channel of the users' Followings.
+//
+// Each name is interned to an id on first sight, so the rest of the work
+// is done on slices indexed by id rather than maps keyed by name. A name
+// becomes a node when minShared users follow it.
+func sharedFollowings(users []string, cf <-chan Followings) (*Result) {
+
+    ids := make(map[string]int32, len(users))
+    var (
+        followers []int32 // by id, how many users follow it
+        lastFollower []int32 // by id, the last user seen following it
+        nodeNums []int32 // by id, its node number or -1
+    )
+
+    intern := func(name string) int32 {
+        if id, ok := ids[name]; ok {
+            return id
+        }
+        id := int32(len(followers))
+        ids[name] = id
+        followers = append(followers, 0)
+        lastFollower = append(lastFollower, -1)
+        nodeNums = append(nodeNums, -1)
+        return id
+    }
 
-    // Store first's followings in the set
-    for _, f := range followings[0].Whoms {
-        checkSet[f] = true
+    // Make a node from each user
+    nodes := make([]Node, len(users), len(users) * 4)
+    for i, u := range users {
+        nodes[i] = Node{Name: u, Group: 1} // Group: 1 -> given user
+        nodeNums[intern(u)] = int32(i)
     }
 
-    // Suck the remaining Followings into a hashmap
-    fIndex := 1
+    // Intern each user's followings, creating a node the second time a
+    // following is seen
+    whoms := make([][]int32, 0, len(users))
+    whos := make([]int32, 0, len(users))
     for fs := range cf {
 
-        followings[fIndex] = fs
-        for _, f := range fs.Whoms {
-
-            // This checkSet/!relevantSet combo is used to ensure a node only
-            // gets created on the second time a following is seen
-            if checkSet[f] {
-                if !relevantSet[f] {
-                    // Append a new node onto the slice
-                    if f == "" {
-                        continue
-                    }
-                    nodes = append(nodes, Node{Name: f, Group: 2}) // Group: 2 -> following
-                    nodeNums[f] = nodeCount
-                    nodeCount++
-                }
-                relevantSet[f] = true
+        // Size the tables for the first user's followings across every user
+        if len(whoms) == 0 {
+            grow := len(fs.Whoms) * len(users)
+            if grow > maxInternedPresize {
+                grow = maxInternedPresize
             }
-            checkSet[f] = true
+            ids = presize(ids, grow)
+            followers = append(make([]int32, 0, len(followers) + grow), followers...)
+            lastFollower = append(make([]int32, 0, len(lastFollower) + grow), lastFollower...)
+            nodeNums = append(make([]int32, 0, len(nodeNums) + grow), nodeNums...)
         }
-        fIndex++
-    }
 
-    links := findLinks(followings[0:], relevantSet, nodeNums)
+        who := intern(fs.Who)
+        fids := make([]int32, 0, len(fs.Whoms))
+        for _, f := range fs.Whoms {
+            if f == "" {
+                continue
+            }
 
-    // Return a pointer to a Result object
-    return &Result{Nodes: nodes, Links: links}
-}
+            id := intern(f)
+            fids = append(fids, id)
 
-// findLinks converts a slice of Followings with relevance specified 
-// by the relevantSet into a slice of Links with Nodes numbered by nodeNums
-func findLinks(followings []Followings, relevantSet map[string]bool, nodeNums map[string]int) []Link {
+            // Count each follower once
+            if lastFollower[id] == who {
+                continue
+            }
+            lastFollower[id] = who
+            followers[id]++
 
-    links := []Link{}
+            if followers[id] == minShared && nodeNums[id] < 0 {
+                nodeNums[id] = int32(len(nodes))
+                nodes = append(nodes, Node{Name: f, Group: 2}) // Group: 2 -> following
+            }
+        }
 
-    for _, fs := range followings {
-        for _, f := range fs.Whoms {
-            if relevantSet[f] {
+        whoms = append(whoms, fids)
+        whos = append(whos, who)
+    }
+
+    // Count the links, then make them in a single pass
+    count := 0
+    for _, fids := range whoms {
+        for _, id := range fids {
+            if nodeNums[id] >= 0 {
+                count++
+            }
+        }
+    }
 
-                // Append a new link to the slice
-                links = append(links, Link{Source: nodeNums[fs.Who], Target: nodeNums[f]})
+    links := make([]Link, 0, count)
+    for i, fids := range whoms {
+        source := int(nodeNums[whos[i]])
+        for _, id := range fids {
+            if nodeNums[id] >= 0 {
+                links = append(links, Link{Source: source, Target: int(nodeNums[id])})
             }
         }
     }
 
-    return links[0:]
-}
\ No newline at end of file
+    // Return a pointer to a Result object
+    result := &Result{Nodes: nodes, Links: links, Similarity: similarity(users, whos, whoms, nodeNums, len(followers))}
+    result.countDegrees()
+    result.markMutualUsers()
+    return result
+}
+
+// The most names sharedFollowings sizes its tables for up front.
+const maxInternedPresize = 1 << 20
+
+// presize returns ids copied into a map with room for grow more names.
+func presize(ids map[string]int32, grow int) map[string]int32 {
+    sized := make(map[string]int32, len(ids) + grow)
+    for name, id := range ids {
+        sized[name] = id
+    }
+    return sized
+}
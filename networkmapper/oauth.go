@@ -0,0 +1,152 @@
+// oauth.go contains authentication with SoundCloud by OAuth2 client
+// credentials, now that bare client ids are being phased out
+
+package networkmapper
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    // Where SoundCloud issues tokens, unless WithTokenURL says
+    soundCloudTokenURL = `https://secure.soundcloud.com/oauth/token`
+
+    // How long before a token expires to replace it
+    tokenExpiryMargin = time.Minute
+)
+
+// WithClientSecret makes the NetworkMapper authenticate its requests with
+// an OAuth2 token for its client id and secret, fetched with the client
+// credentials grant and refreshed before it expires.
+func WithClientSecret(secret string) Option {
+    return func(n *networkMapper) {
+        n.clientSecret = secret
+    }
+}
+
+// WithTokenURL makes the NetworkMapper fetch its tokens from url instead
+// of SoundCloud's token endpoint.
+func WithTokenURL(url string) Option {
+    return func(n *networkMapper) {
+        n.tokenURL = url
+    }
+}
+
+// tokenSource hands out a client's access token, fetching a new one when
+// it's about to expire.
+type tokenSource struct {
+    mu sync.Mutex
+    client *http.Client
+    url string
+    clientId string
+    clientSecret string
+
+    // What the Authorization header calls the token; OAuth by default
+    scheme string
+
+    access string
+    refresh string
+    expiry time.Time
+}
+
+// newTokenSource creates a tokenSource for the client with clientId and
+// clientSecret, fetching tokens from url with client.
+func newTokenSource(client *http.Client, url, clientId, clientSecret string) *tokenSource {
+    return &tokenSource{client: client, url: url, clientId: clientId, clientSecret: clientSecret}
+}
+
+// authorize sets the Authorization header of req to the current token.
+func (t *tokenSource) authorize(req *http.Request) error {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    if t.access == "" || time.Now().After(t.expiry.Add(-tokenExpiryMargin)) {
+        if err := t.fetch(req.Context()); err != nil {
+            return err
+        }
+    }
+
+    scheme := t.scheme
+    if scheme == "" {
+        scheme = "OAuth"
+    }
+    req.Header.Set("Authorization", scheme + " " + t.access)
+    return nil
+}
+
+// invalidate drops the current token, if it's still access, so the next
+// request fetches another.
+func (t *tokenSource) invalidate(access string) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    if t.access == access {
+        t.access = ""
+    }
+}
+
+// fetch gets a new token, by refreshing the current one if it can and by
+// the client credentials grant otherwise, which t.mu must be held for.
+func (t *tokenSource) fetch(ctx context.Context) error {
+
+    if t.refresh != "" {
+        err := t.grant(ctx, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {t.refresh}})
+        if err == nil || ctx.Err() != nil {
+            return err
+        }
+        t.refresh = ""
+    }
+
+    return t.grant(ctx, url.Values{"grant_type": {"client_credentials"}})
+}
+
+// grant asks for a token with form, which t.mu must be held for.
+func (t *tokenSource) grant(ctx context.Context, form url.Values) error {
+
+    req, err := http.NewRequestWithContext(ctx, "POST", t.url, strings.NewReader(form.Encode()))
+    if err != nil {
+        return err
+    }
+    req.SetBasicAuth(t.clientId, t.clientSecret)
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    req.Header.Set("Accept", "application/json")
+
+    r, err := t.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer r.Body.Close()
+
+    body, err := ioutil.ReadAll(r.Body)
+    if err != nil {
+        return err
+    }
+    if r.StatusCode != http.StatusOK {
+        return errors.New("oauth: " + r.Status + ": " + string(body))
+    }
+
+    var token struct {
+        AccessToken string `json:"access_token"`
+        RefreshToken string `json:"refresh_token"`
+        ExpiresIn int `json:"expires_in"`
+    }
+    if err = json.Unmarshal(body, &token); err != nil {
+        return err
+    }
+    if token.AccessToken == "" {
+        return errors.New("oauth: no access token in response")
+    }
+
+    t.access = token.AccessToken
+    t.refresh = token.RefreshToken
+    t.expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+    return nil
+}
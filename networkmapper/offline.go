@@ -0,0 +1,183 @@
+// offline.go contains a NetworkMapper for follow data that has already
+// been exported, such as from a GDPR request, so networks can be built
+// without calling any API.
+
+package networkmapper
+
+import (
+    "context"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// offlineNetworkMapper is a NetworkMapper backed by a fixed mapping of
+// users to their followings.
+type offlineNetworkMapper struct {
+    followings map[string][]string
+}
+
+// NewOfflineNetworkMapper creates a NetworkMapper that answers
+// GetFollowings from fs instead of SoundCloud. Users not in fs follow
+// nobody.
+func NewOfflineNetworkMapper(fs []Followings) NetworkMapper {
+    followings := make(map[string][]string, len(fs))
+    for _, f := range fs {
+        followings[f.Who] = append(followings[f.Who], f.Whoms...)
+    }
+
+    return &offlineNetworkMapper{followings: followings}
+}
+
+// GetFollowings returns the followings of user from the offline data.
+func (n *offlineNetworkMapper) GetFollowings(ctx context.Context, user string) []string {
+    return n.followings[user][0:]
+}
+
+// ReadFollowingsCSV reads follow data from r where each row is a user
+// followed by one or more of their followings, so both edge lists
+// (user,following) and one row per user are accepted. A header row
+// starting with "user" or "who" is skipped.
+//
+// Users are returned in the order they first appear.
+func ReadFollowingsCSV(r io.Reader) ([]Followings, error) {
+
+    cr := csv.NewReader(r)
+    cr.FieldsPerRecord = -1
+    cr.TrimLeadingSpace = true
+    cr.Comment = '#'
+
+    records, err := cr.ReadAll()
+    if err != nil {
+        return nil, err
+    }
+
+    // Skip the header, if there is one
+    if len(records) > 0 && len(records[0]) > 0 {
+        first := strings.ToLower(strings.TrimSpace(records[0][0]))
+        if first == "user" || first == "who" {
+            records = records[1:]
+        }
+    }
+
+    fs := []Followings{}
+    index := make(map[string]int)
+
+    for _, record := range records {
+        if len(record) == 0 {
+            continue
+        }
+
+        who := strings.TrimSpace(record[0])
+        if who == "" {
+            continue
+        }
+
+        i, ok := index[who]
+        if !ok {
+            i = len(fs)
+            index[who] = i
+            fs = append(fs, Followings{Whoms: []string{}, Who: who})
+        }
+
+        for _, whom := range record[1:] {
+            if whom = strings.TrimSpace(whom); whom != "" {
+                fs[i].Whoms = append(fs[i].Whoms, whom)
+            }
+        }
+    }
+
+    return fs[0:], nil
+}
+
+// ReadFollowingsJSON reads follow data from r as a JSON object mapping
+// each user to an array of their followings.
+//
+// Users are returned sorted by name, since JSON objects are unordered.
+func ReadFollowingsJSON(r io.Reader) ([]Followings, error) {
+
+    var m map[string][]string
+    if err := json.NewDecoder(r).Decode(&m); err != nil {
+        return nil, err
+    }
+
+    users := make([]string, 0, len(m))
+    for who := range m {
+        users = append(users, who)
+    }
+    sort.Strings(users)
+
+    fs := make([]Followings, len(users))
+    for i, who := range users {
+        fs[i] = Followings{Whoms: m[who], Who: who}
+    }
+
+    return fs[0:], nil
+}
+
+// ReadFollowingsFiles reads follow data from the CSV and JSON files at
+// paths, read as by ReadFollowingsCSV and ReadFollowingsJSON by their
+// extensions. A path that is a directory has every .csv and .json file in
+// it read. The followings of a user found in more than one file are
+// combined.
+func ReadFollowingsFiles(paths ...string) ([]Followings, error) {
+
+    files := []string{}
+    for _, path := range paths {
+        info, err := os.Stat(path)
+        if err != nil {
+            return nil, err
+        }
+        if !info.IsDir() {
+            files = append(files, path)
+            continue
+        }
+
+        entries, err := ioutil.ReadDir(path)
+        if err != nil {
+            return nil, err
+        }
+        for _, e := range entries {
+            if ext := strings.ToLower(filepath.Ext(e.Name())); !e.IsDir() && (ext == ".csv" || ext == ".json") {
+                files = append(files, filepath.Join(path, e.Name()))
+            }
+        }
+    }
+
+    all := []Followings{}
+    for _, file := range files {
+        fs, err := readFollowingsFile(file)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %s", file, err)
+        }
+        all = append(all, fs...)
+    }
+    return all, nil
+}
+
+/* Helpers */
+
+// readFollowingsFile reads the follow data in file, as CSV or JSON by its
+// extension.
+func readFollowingsFile(file string) ([]Followings, error) {
+
+    f, err := os.Open(file)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    switch strings.ToLower(filepath.Ext(file)) {
+    case ".csv":
+        return ReadFollowingsCSV(f)
+    case ".json":
+        return ReadFollowingsJSON(f)
+    }
+    return nil, fmt.Errorf("unknown follow data format %q", filepath.Ext(file))
+}
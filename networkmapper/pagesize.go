@@ -0,0 +1,61 @@
+// pagesize.go contains the choice of how many followings to fetch per
+// request, trading fewer round-trips for larger responses
+
+package networkmapper
+
+const (
+    // The most followings SoundCloud and GitHub return per page
+    maxSoundCloudPageSize = 200
+    maxGitHubPageSize = 100
+)
+
+// A type that satisfies networkmapper.PageSizer fetches followings a page
+// at a time, and can be made to fetch pages of a different size.
+type PageSizer interface {
+
+    // Gets a copy of the NetworkMapper that fetches size followings per page
+    WithPageSize(size int) NetworkMapper
+
+    // Gets the largest page size the source allows
+    MaxPageSize() int
+
+}
+
+// WithPageSize returns n fetching size followings per page, or n itself if
+// it isn't a PageSizer. size is capped at the source's MaxPageSize.
+func WithPageSize(n NetworkMapper, size int) NetworkMapper {
+
+    sizer, ok := n.(PageSizer)
+    if !ok || size <= 0 {
+        return n
+    }
+
+    if max := sizer.MaxPageSize(); size > max {
+        size = max
+    }
+    return sizer.WithPageSize(size)
+}
+
+// WithPageSize returns a copy of n that fetches size followings per page.
+func (n *networkMapper) WithPageSize(size int) NetworkMapper {
+    c := *n
+    c.numResults = size
+    return &c
+}
+
+// MaxPageSize returns the most followings SoundCloud returns per page.
+func (n *networkMapper) MaxPageSize() int {
+    return maxSoundCloudPageSize
+}
+
+// WithPageSize returns a copy of n that fetches size followings per page.
+func (n *gitHubNetworkMapper) WithPageSize(size int) NetworkMapper {
+    c := *n
+    c.perPage = size
+    return &c
+}
+
+// MaxPageSize returns the most followings GitHub returns per page.
+func (n *gitHubNetworkMapper) MaxPageSize() int {
+    return maxGitHubPageSize
+}
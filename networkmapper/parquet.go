@@ -0,0 +1,293 @@
+// parquet.go contains a minimal Parquet writer used to export Results
+// for analytics tools such as DuckDB and Spark.
+//
+// Only what cumuli needs is supported: a single row group of required,
+// uncompressed, PLAIN encoded INT32 and UTF8 columns.
+
+package networkmapper
+
+import (
+    "bytes"
+    "encoding/binary"
+    "io"
+)
+
+const parquetMagic = "PAR1"
+
+// Parquet physical types.
+const (
+    parquetInt32 = 1
+    parquetByteArray = 6
+)
+
+// Parquet enum values used in the file metadata.
+const (
+    parquetRequired = 0
+    parquetConvertedUTF8 = 0
+    parquetEncodingPlain = 0
+    parquetEncodingRLE = 3
+    parquetCodecUncompressed = 0
+    parquetDataPage = 0
+)
+
+// Thrift compact protocol field types.
+const (
+    thriftI32 = 5
+    thriftI64 = 6
+    thriftBinary = 8
+    thriftList = 9
+    thriftStruct = 12
+)
+
+// parquetColumn is a single PLAIN encoded column of a Parquet file.
+type parquetColumn struct {
+    name string
+    kind int32
+    utf8 bool
+    values *bytes.Buffer
+}
+
+// newParquetColumn creates a column with a pooled buffer for its values,
+// which writeParquet hands back.
+func newParquetColumn(name string, kind int32, utf8 bool) *parquetColumn {
+    return &parquetColumn{name: name, kind: kind, utf8: utf8, values: GetBuffer()}
+}
+
+// putInt32 appends v to the column.
+func (c *parquetColumn) putInt32(v int) {
+    var b [4]byte
+    binary.LittleEndian.PutUint32(b[:], uint32(int32(v)))
+    c.values.Write(b[:])
+}
+
+// putString appends s to the column.
+func (c *parquetColumn) putString(s string) {
+    var b [4]byte
+    binary.LittleEndian.PutUint32(b[:], uint32(len(s)))
+    c.values.Write(b[:])
+    c.values.WriteString(s)
+}
+
+// WriteNodesParquet writes the nodes of r to w as a Parquet file with the
+// columns id, name and group. A node's id is its index in r.Nodes, which is
+// what the source and target columns of WriteLinksParquet refer to.
+func WriteNodesParquet(w io.Writer, r *Result) error {
+
+    id := newParquetColumn("id", parquetInt32, false)
+    name := newParquetColumn("name", parquetByteArray, true)
+    group := newParquetColumn("group", parquetInt32, false)
+
+    for i, node := range r.Nodes {
+        id.putInt32(i)
+        name.putString(node.Name)
+        group.putInt32(node.Group)
+    }
+
+    return writeParquet(w, len(r.Nodes), []*parquetColumn{id, name, group})
+}
+
+// WriteLinksParquet writes the links of r to w as a Parquet file with the
+// columns source and target.
+func WriteLinksParquet(w io.Writer, r *Result) error {
+
+    source := newParquetColumn("source", parquetInt32, false)
+    target := newParquetColumn("target", parquetInt32, false)
+
+    for _, link := range r.Links {
+        source.putInt32(link.Source)
+        target.putInt32(link.Target)
+    }
+
+    return writeParquet(w, len(r.Links), []*parquetColumn{source, target})
+}
+
+// writeParquet writes cols to w as a Parquet file holding numRows rows
+// in a single row group.
+func writeParquet(w io.Writer, numRows int, cols []*parquetColumn) error {
+
+    file := GetBuffer()
+    defer PutBuffer(file)
+    for _, c := range cols {
+        defer PutBuffer(c.values)
+    }
+
+    file.WriteString(parquetMagic)
+
+    // Write a single data page per column, remembering where each column
+    // chunk starts and how large it is for the footer
+    offsets := make([]int64, len(cols))
+    sizes := make([]int64, len(cols))
+
+    for i, c := range cols {
+        header := &thriftWriter{}
+        header.structBegin()
+        header.i32(1, parquetDataPage)
+        header.i32(2, int32(c.values.Len()))
+        header.i32(3, int32(c.values.Len()))
+        header.fieldStructBegin(5)
+        header.i32(1, int32(numRows))
+        header.i32(2, parquetEncodingPlain)
+        header.i32(3, parquetEncodingRLE)
+        header.i32(4, parquetEncodingRLE)
+        header.structEnd()
+        header.structEnd()
+
+        offsets[i] = int64(file.Len())
+        sizes[i] = int64(header.buf.Len() + c.values.Len())
+        file.Write(header.buf.Bytes())
+        file.Write(c.values.Bytes())
+    }
+
+    // Write the FileMetaData footer
+    meta := &thriftWriter{}
+    meta.structBegin()
+    meta.i32(1, 1)
+
+    // Schema, flattened depth-first with a root element
+    meta.listBegin(2, thriftStruct, len(cols) + 1)
+    meta.structBegin()
+    meta.binary(4, "schema")
+    meta.i32(5, int32(len(cols)))
+    meta.structEnd()
+    for _, c := range cols {
+        meta.structBegin()
+        meta.i32(1, c.kind)
+        meta.i32(3, parquetRequired)
+        meta.binary(4, c.name)
+        if c.utf8 {
+            meta.i32(6, parquetConvertedUTF8)
+        }
+        meta.structEnd()
+    }
+
+    meta.i64(3, int64(numRows))
+
+    // Row groups
+    var totalSize int64
+    for _, s := range sizes {
+        totalSize += s
+    }
+
+    meta.listBegin(4, thriftStruct, 1)
+    meta.structBegin()
+    meta.listBegin(1, thriftStruct, len(cols))
+    for i, c := range cols {
+        meta.structBegin()
+        meta.i64(2, offsets[i])
+        meta.fieldStructBegin(3)
+        meta.i32(1, c.kind)
+        meta.listBegin(2, thriftI32, 2)
+        meta.varint(parquetEncodingPlain)
+        meta.varint(parquetEncodingRLE)
+        meta.listBegin(3, thriftBinary, 1)
+        meta.rawBinary(c.name)
+        meta.i32(4, parquetCodecUncompressed)
+        meta.i64(5, int64(numRows))
+        meta.i64(6, sizes[i])
+        meta.i64(7, sizes[i])
+        meta.i64(9, offsets[i])
+        meta.structEnd()
+        meta.structEnd()
+    }
+    meta.i64(2, totalSize)
+    meta.i64(3, int64(numRows))
+    meta.structEnd()
+
+    meta.binary(6, "cumuli")
+    meta.structEnd()
+
+    var footerLen [4]byte
+    binary.LittleEndian.PutUint32(footerLen[:], uint32(meta.buf.Len()))
+    file.Write(meta.buf.Bytes())
+    file.Write(footerLen[:])
+    file.WriteString(parquetMagic)
+
+    _, err := w.Write(file.Bytes())
+    return err
+}
+
+// thriftWriter encodes structs with the Thrift compact protocol, which is
+// what Parquet uses for its page headers and footer.
+type thriftWriter struct {
+    buf bytes.Buffer
+    lastField []int16
+}
+
+// structBegin starts a struct that is not itself a field, such as a list
+// element or the top level struct.
+func (t *thriftWriter) structBegin() {
+    t.lastField = append(t.lastField, 0)
+}
+
+// fieldStructBegin starts a struct stored in field id of the enclosing
+// struct.
+func (t *thriftWriter) fieldStructBegin(id int16) {
+    t.fieldHeader(id, thriftStruct)
+    t.structBegin()
+}
+
+// structEnd ends the current struct.
+func (t *thriftWriter) structEnd() {
+    t.buf.WriteByte(0)
+    t.lastField = t.lastField[:len(t.lastField) - 1]
+}
+
+// fieldHeader writes the header of field id, delta encoding the id when
+// possible.
+func (t *thriftWriter) fieldHeader(id int16, kind byte) {
+    last := &t.lastField[len(t.lastField) - 1]
+    if delta := id - *last; delta > 0 && delta <= 15 {
+        t.buf.WriteByte(byte(delta) << 4 | kind)
+    } else {
+        t.buf.WriteByte(kind)
+        t.varint(int64(id))
+    }
+    *last = id
+}
+
+// listBegin writes the header of a list of size elements of kind stored
+// in field id. The elements must be written immediately afterwards.
+func (t *thriftWriter) listBegin(id int16, kind byte, size int) {
+    t.fieldHeader(id, thriftList)
+    if size < 15 {
+        t.buf.WriteByte(byte(size) << 4 | kind)
+    } else {
+        t.buf.WriteByte(0xf0 | kind)
+        t.uvarint(uint64(size))
+    }
+}
+
+// i32 writes v to field id.
+func (t *thriftWriter) i32(id int16, v int32) {
+    t.fieldHeader(id, thriftI32)
+    t.varint(int64(v))
+}
+
+// i64 writes v to field id.
+func (t *thriftWriter) i64(id int16, v int64) {
+    t.fieldHeader(id, thriftI64)
+    t.varint(v)
+}
+
+// binary writes s to field id.
+func (t *thriftWriter) binary(id int16, s string) {
+    t.fieldHeader(id, thriftBinary)
+    t.rawBinary(s)
+}
+
+// rawBinary writes s without a field header, as used for list elements.
+func (t *thriftWriter) rawBinary(s string) {
+    t.uvarint(uint64(len(s)))
+    t.buf.WriteString(s)
+}
+
+// varint writes v zigzag encoded.
+func (t *thriftWriter) varint(v int64) {
+    t.uvarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+// uvarint writes v as an unsigned varint.
+func (t *thriftWriter) uvarint(v uint64) {
+    var b [binary.MaxVarintLen64]byte
+    t.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
@@ -0,0 +1,94 @@
+// partial.go contains the tolerance of followings that couldn't be
+// fetched, so a failed page costs a build the followings it held rather
+// than the whole network
+
+package networkmapper
+
+import (
+    "context"
+    "net/http"
+    "strconv"
+)
+
+// A type that satisfies networkmapper.FallibleMapper says what went wrong
+// when it couldn't fetch all of a user's followings.
+type FallibleMapper interface {
+
+    // Gets the followings of a given user that could be fetched, and why
+    // the rest couldn't be if any are missing
+    TryGetFollowings(ctx context.Context, user string) ([]string, *FetchError)
+
+    // Gets whether builds leave out what couldn't be fetched, listing it in
+    // their Meta, rather than failing
+    PartialResults() bool
+
+}
+
+// A type for a user whose followings couldn't all be fetched.
+type FetchError struct {
+    User string `json:"user" doc:"The user whose followings are incomplete"`
+    Page int `json:"page" doc:"The page that failed, counting from 0; it and the pages after it are missing"`
+    Message string `json:"error" doc:"What went wrong"`
+    Status int `json:"status,omitempty" doc:"The HTTP status the source turned the request away with, if it did"`
+}
+
+func (e *FetchError) Error() string {
+    return "couldn't fetch page " + strconv.Itoa(e.Page) + " of " + e.User + "'s followings: " + e.Message
+}
+
+// UnknownUser returns whether the source has no such user as e's.
+func (e *FetchError) UnknownUser() bool {
+    return e.Page == 0 && e.Status == http.StatusNotFound
+}
+
+// WithPartialResults makes builds with the NetworkMapper leave out the
+// followings it couldn't fetch, listing them in the Result's Meta, rather
+// than fail with a *FetchError. A user the source doesn't have still
+// fails the build, as there's no network of them to leave out.
+func WithPartialResults(partial bool) Option {
+    return func(n *networkMapper) {
+        n.partial = partial
+    }
+}
+
+// PartialResults returns whether builds leave out what couldn't be fetched.
+func (n *networkMapper) PartialResults() bool {
+    return n.partial
+}
+
+/* Helpers */
+
+// getFollowings gets the followings of user from n, with why they're
+// incomplete if n is a FallibleMapper.
+func getFollowings(ctx context.Context, n NetworkMapper, user string) ([]string, *FetchError) {
+    if f, ok := n.(FallibleMapper); ok {
+        return f.TryGetFollowings(ctx, user)
+    }
+    return n.GetFollowings(ctx, user), nil
+}
+
+// partialResults reports whether builds with n leave out what couldn't be
+// fetched.
+func partialResults(n NetworkMapper) bool {
+    f, ok := n.(FallibleMapper)
+    return ok && f.PartialResults()
+}
+
+// collectErrors passes on the Followings from cf, adding their errors to
+// errs, which may be read once the returned channel is closed.
+func collectErrors(cf <-chan Followings, errs *[]FetchError) (<-chan Followings) {
+
+    out := make(chan Followings)
+
+    go func() {
+        defer close(out)
+        for fs := range cf {
+            if fs.Err != nil {
+                *errs = append(*errs, *fs.Err)
+            }
+            out <- fs
+        }
+    } ()
+
+    return out
+}
@@ -0,0 +1,182 @@
+// playlists.go contains the network of artists that appear together in
+// users' playlists, linking artists by how they're listened to rather
+// than by who follows whom
+
+package networkmapper
+
+import (
+    "context"
+    "sort"
+    "strconv"
+    "sync"
+)
+
+const (
+    // The most playlists looked at per user
+    maxPlaylists = 50
+
+    // The most artists of a playlist paired up with each other, so a long
+    // playlist can't blow up the number of links
+    maxPlaylistArtists = 50
+)
+
+// A type that satisfies networkmapper.PlaylistFetcher can tell which
+// artists are in a user's playlists.
+type PlaylistFetcher interface {
+
+    // Gets the artists of the tracks in each of a given user's playlists
+    GetPlaylists(ctx context.Context, user string) ([][]string, error)
+
+}
+
+// CoOccurrence returns the network of the artists in the playlists of
+// users, a few users at a time, with a link between two artists weighted
+// by how many playlists they're both in. Artists that are among users are
+// in group 1. Users whose playlists can't be fetched are listed in its
+// Meta, as are those skipped because ctx was done first.
+func CoOccurrence(ctx context.Context, f PlaylistFetcher, users []string) *Result {
+
+    var (
+        mu sync.Mutex
+        wg sync.WaitGroup
+        playlists [][]string
+        errs []FetchError
+        fetched int
+    )
+    sem := make(chan struct{}, profileWorkers)
+
+    for _, user := range users {
+        wg.Add(1)
+        sem <- struct{}{}
+        go func(user string) {
+            defer wg.Done()
+            defer func() { <-sem } ()
+            if ctx.Err() != nil {
+                return
+            }
+
+            ps, err := f.GetPlaylists(ctx, user)
+            mu.Lock()
+            defer mu.Unlock()
+            if err != nil {
+                if ctx.Err() == nil {
+                    errs = append(errs, FetchError{User: user, Message: err.Error()})
+                }
+                return
+            }
+            fetched++
+            playlists = append(playlists, ps...)
+        } (user)
+    }
+    wg.Wait()
+
+    // Count the playlists each pair of artists is in
+    type pair struct {
+        a, b string
+    }
+    counts := make(map[pair]int)
+
+    for _, p := range playlists {
+        artists := distinct(p)
+        if len(artists) > maxPlaylistArtists {
+            artists = artists[:maxPlaylistArtists]
+        }
+        sort.Strings(artists)
+        for i := range artists {
+            for j := i + 1; j < len(artists); j++ {
+                counts[pair{artists[i], artists[j]}]++
+            }
+        }
+    }
+
+    // Number the artists in a stable order, so a network is built the same
+    // way every time
+    pairs := make([]pair, 0, len(counts))
+    for p := range counts {
+        pairs = append(pairs, p)
+    }
+    sort.Slice(pairs, func(i, j int) bool {
+        if counts[pairs[i]] != counts[pairs[j]] {
+            return counts[pairs[i]] > counts[pairs[j]]
+        }
+        if pairs[i].a != pairs[j].a {
+            return pairs[i].a < pairs[j].a
+        }
+        return pairs[i].b < pairs[j].b
+    })
+
+    isUser := make(map[string]bool, len(users))
+    for _, user := range users {
+        isUser[user] = true
+    }
+
+    result := &Result{Nodes: []Node{}, Links: []Link{}}
+    nodeNums := make(map[string]int)
+    num := func(artist string) int {
+        i, ok := nodeNums[artist]
+        if !ok {
+            i = len(result.Nodes)
+            nodeNums[artist] = i
+            group := 2
+            if isUser[artist] {
+                group = 1
+            }
+            result.Nodes = append(result.Nodes, Node{Name: artist, Group: group})
+        }
+        return i
+    }
+
+    for _, p := range pairs {
+        result.Links = append(result.Links, Link{Source: num(p.a), Target: num(p.b), Weight: counts[p]})
+    }
+
+    if fetched < len(users) {
+        result.Meta = &Meta{Partial: true, SkippedUsers: len(users) - fetched - len(errs), Errors: errs}
+    }
+
+    result.countDegrees()
+    result.Stats = result.ComputeStats()
+    return result
+}
+
+// GetPlaylists returns the artists of the tracks in each of user's most
+// recent playlists.
+func (n *networkMapper) GetPlaylists(ctx context.Context, user string) ([][]string, error) {
+
+    var playlists []struct {
+        Tracks []scTrack `json:"tracks"`
+    }
+
+    url := n.baseURL + `/users/` + user + `/playlists.json?client_id=` + n.clientId +
+           `&limit=` + strconv.Itoa(maxPlaylists)
+    if err := n.getJSON(ctx, url, &playlists); err != nil {
+        return nil, err
+    }
+
+    artists := make([][]string, len(playlists))
+    for i, p := range playlists {
+        artists[i] = []string{}
+        for _, t := range p.Tracks {
+            if t.User.Permalink != "" {
+                artists[i] = append(artists[i], t.User.Permalink)
+            }
+        }
+    }
+
+    return artists, nil
+}
+
+/* Helpers */
+
+// distinct returns the strings of s each once, in the order first seen.
+func distinct(s []string) []string {
+    seen := make(map[string]bool, len(s))
+    d := []string{}
+    for _, x := range s {
+        if !seen[x] {
+            seen[x] = true
+            d = append(d, x)
+        }
+    }
+    return d
+}
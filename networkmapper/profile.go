@@ -0,0 +1,127 @@
+// profile.go contains the profiles of the accounts in a network, for
+// views that show more than a name
+
+package networkmapper
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "io/ioutil"
+    "net/http"
+    "strings"
+    "sync"
+)
+
+const (
+    // The most profiles kept from followings pages
+    profileCacheSize = 20000
+
+    // The most profiles fetched at once
+    profileWorkers = 8
+)
+
+// A type that satisfies networkmapper.ProfileFetcher can describe a user.
+type ProfileFetcher interface {
+
+    // Gets the profile of a given user
+    GetProfile(ctx context.Context, user string) (*Profile, error)
+
+}
+
+// A type for what a source says about an account.
+type Profile struct {
+    AvatarURL string `json:"avatar_url,omitempty" doc:"A link to the account's avatar"`
+    FullName string `json:"full_name,omitempty" doc:"The account's display name"`
+    Followers int `json:"followers_count,omitempty" doc:"How many accounts follow it"`
+    Tracks int `json:"track_count,omitempty" doc:"How many tracks it has posted"`
+    City string `json:"city,omitempty" doc:"Where it says it is"`
+    Country string `json:"country,omitempty" doc:"The country it says it's in"`
+    Genre string `json:"genre,omitempty" doc:"The genre most of its tracks are tagged with, when grouped by genre"`
+    Label string `json:"label,omitempty" doc:"The label most of its tracks were released on, when grouped by label"`
+    Website string `json:"website,omitempty" doc:"The link it gives to its site, which may be its account on another source"`
+}
+
+// scUser is a SoundCloud user as the API returns it.
+type scUser struct {
+    Id int64 `json:"id"`
+    Permalink string `json:"permalink"`
+    AvatarURL string `json:"avatar_url"`
+    FullName string `json:"full_name"`
+    FollowersCount int `json:"followers_count"`
+    TrackCount int `json:"track_count"`
+    City string `json:"city"`
+    Country string `json:"country"`
+    Website string `json:"website"`
+}
+
+// profile returns the Profile of u.
+func (u *scUser) profile() *Profile {
+    return &Profile{
+        AvatarURL: u.AvatarURL,
+        FullName: u.FullName,
+        Followers: u.FollowersCount,
+        Tracks: u.TrackCount,
+        City: u.City,
+        Country: u.Country,
+        Website: u.Website,
+    }
+}
+
+// AddProfiles sets the Profile of each node of r that doesn't have one, a
+// few at a time. Nodes whose profiles can't be fetched are left without.
+func AddProfiles(ctx context.Context, r *Result, f ProfileFetcher) {
+
+    var wg sync.WaitGroup
+    sem := make(chan struct{}, profileWorkers)
+
+    for i := range r.Nodes {
+        if r.Nodes[i].Profile != nil {
+            continue
+        }
+
+        wg.Add(1)
+        sem <- struct{}{}
+        go func(node *Node) {
+            if p, err := f.GetProfile(ctx, node.Name); err == nil {
+                node.Profile = p
+            }
+            <-sem
+            wg.Done()
+        } (&r.Nodes[i])
+    }
+    wg.Wait()
+}
+
+// GetProfile returns the profile of user, as last seen on a page of
+// followings or fetched from SoundCloud.
+func (n *networkMapper) GetProfile(ctx context.Context, user string) (*Profile, error) {
+
+    if p, ok := n.profiles.get(strings.ToLower(user)); ok {
+        return p.(*Profile), nil
+    }
+
+    r, err := n.get(ctx, n.baseURL + `/users/` + user + `.json?client_id=` + n.clientId)
+    if err != nil {
+        return nil, err
+    }
+    defer r.Body.Close()
+
+    body, err := ioutil.ReadAll(r.Body)
+    if err != nil {
+        return nil, err
+    }
+    if r.StatusCode != http.StatusOK {
+        return nil, errors.New("soundcloud: " + r.Status)
+    }
+
+    var u scUser
+    if err = json.Unmarshal(body, &u); err != nil {
+        return nil, err
+    }
+
+    // Keep it under the name it was asked for too, which may be an id
+    p := n.remember(&u)
+    n.profiles.put(strings.ToLower(user), p)
+    return p, nil
+}
@@ -0,0 +1,29 @@
+// provider.go contains what a platform has to offer for networks to be
+// built from it. Building only needs a NetworkMapper, so everything else
+// here works the same whichever platform the followings come from.
+
+package networkmapper
+
+import (
+    "context"
+)
+
+// A type that satisfies networkmapper.Provider is a platform, such as
+// SoundCloud or GitHub, that can be searched for users as well as mapped.
+type Provider interface {
+    NetworkMapper
+
+    // Gets a given user's account
+    GetUser(ctx context.Context, user string) (*User, error)
+
+    // Gets up to limit accounts matching a query
+    Search(ctx context.Context, q string, limit int) ([]User, error)
+
+}
+
+// A type for an account on a Provider.
+type User struct {
+    Name string `json:"name" doc:"The account's username, by which networks are built"`
+    Id int64 `json:"id,omitempty" doc:"The account's id, which unlike its name doesn't change"`
+    *Profile
+}
@@ -0,0 +1,176 @@
+// quota.go contains the accounting of calls to sources' APIs against
+// their rate limits, so callers can tell when builds will be slow
+
+package networkmapper
+
+import (
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+)
+
+const (
+    // SoundCloud allows a client 15,000 API calls a day
+    soundCloudQuota = 15000
+    soundCloudQuotaWindow = 24 * time.Hour
+
+    // Spotify doesn't say, but turns clients away at around this many
+    // calls in 30 seconds
+    spotifyQuota = 180
+    spotifyQuotaWindow = 30 * time.Second
+
+    // Last.fm asks clients to keep to 5 calls a second
+    lastFMQuota = 300
+    lastFMQuotaWindow = time.Minute
+
+    // Mastodon lets each client make this many calls to each instance
+    mastodonQuota = 300
+    mastodonQuotaWindow = 5 * time.Minute
+
+    // Mixcloud doesn't say, but starts refusing calls at around this many
+    mixcloudQuota = 60
+    mixcloudQuotaWindow = time.Minute
+
+    // Below this fraction of its quota, a source is nearly exhausted
+    lowQuotaFraction = 0.1
+)
+
+// A type that satisfies networkmapper.QuotaReporter keeps track of its
+// calls against its source's rate limit.
+type QuotaReporter interface {
+
+    // Gets the state of the source's quota
+    Quota() QuotaStatus
+
+}
+
+// A type for the state of a quota.
+type QuotaStatus struct {
+    Calls int `json:"calls" doc:"The calls made this window"`
+    Limit int `json:"limit" doc:"The calls allowed per window"`
+    Remaining int `json:"remaining" doc:"The calls left this window"`
+    Reset time.Time `json:"reset" doc:"When the window ends and the quota resets"`
+    NearlyExhausted bool `json:"nearly_exhausted" doc:"Whether little enough is left that builds may slow down or fail"`
+    Unavailable bool `json:"unavailable,omitempty" doc:"Whether the source is down and calls to it are failing fast"`
+}
+
+// A type for a quota of limit calls every window.
+type Quota struct {
+    mu sync.Mutex
+    limit int
+    window time.Duration
+    reset time.Time
+    calls int
+    remaining int
+}
+
+// NewQuota creates a Quota of limit calls every window, starting now.
+func NewQuota(limit int, window time.Duration) *Quota {
+    return &Quota{
+        limit: limit,
+        window: window,
+        reset: time.Now().Add(window),
+        remaining: limit,
+    }
+}
+
+// Take records a call.
+func (q *Quota) Take() {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    q.roll()
+    q.calls++
+    if q.remaining > 0 {
+        q.remaining--
+    }
+}
+
+// Observe records the rate limit headers of r, which are the truth about
+// the quota when a source sends them, as GitHub does.
+func (q *Quota) Observe(r *http.Response) {
+
+    limit, err := strconv.Atoi(r.Header.Get("X-RateLimit-Limit"))
+    if err != nil {
+        return
+    }
+    remaining, err := strconv.Atoi(r.Header.Get("X-RateLimit-Remaining"))
+    if err != nil {
+        return
+    }
+    reset, err := strconv.ParseInt(r.Header.Get("X-RateLimit-Reset"), 10, 64)
+    if err != nil {
+        return
+    }
+
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    q.roll()
+    q.limit = limit
+    q.remaining = remaining
+    if t := time.Unix(reset, 0); !t.Equal(q.reset) {
+        q.reset = t
+        q.window = 0
+    }
+}
+
+// Status returns the state of the quota.
+func (q *Quota) Status() QuotaStatus {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    q.roll()
+    return QuotaStatus{
+        Calls: q.calls,
+        Limit: q.limit,
+        Remaining: q.remaining,
+        Reset: q.reset,
+        NearlyExhausted: float64(q.remaining) < float64(q.limit) * lowQuotaFraction,
+    }
+}
+
+// roll starts a new window if the current one has ended, which q.mu must
+// be held for. Windows set by Observe end when the source says they do.
+func (q *Quota) roll() {
+    now := time.Now()
+    if now.Before(q.reset) {
+        return
+    }
+
+    q.calls = 0
+    q.remaining = q.limit
+    if q.window > 0 {
+        for !now.Before(q.reset) {
+            q.reset = q.reset.Add(q.window)
+        }
+    }
+}
+
+// Quota returns the state of the SoundCloud client's quota.
+func (n *networkMapper) Quota() QuotaStatus {
+    status := n.quota.Status()
+    status.Unavailable = n.Available() != nil
+    return status
+}
+
+// Quota returns the state of the GitHub token's quota.
+func (n *gitHubNetworkMapper) Quota() QuotaStatus {
+    return n.quota.Status()
+}
+
+// Quota returns the state of the Spotify client's quota.
+func (n *spotifyNetworkMapper) Quota() QuotaStatus {
+    return n.quota.Status()
+}
+
+// Quota returns the state of the Last.fm API key's quota.
+func (n *lastFMNetworkMapper) Quota() QuotaStatus {
+    return n.quota.Status()
+}
+
+// Quota returns the state of the Mixcloud client's quota.
+func (n *mixcloudNetworkMapper) Quota() QuotaStatus {
+    return n.quota.Status()
+}
@@ -0,0 +1,145 @@
+// rank.go contains the PageRank of the nodes of built Results, to surface
+// the most structurally important accounts in large networks
+
+package networkmapper
+
+import (
+    "math"
+    "sort"
+)
+
+const (
+    // The chance of following a link rather than jumping anywhere
+    rankDamping = 0.85
+
+    // PageRank stops after this many rounds, or once a round changes the
+    // ranks by less than rankTolerance in all
+    maxRankRounds = 100
+    rankTolerance = 1e-6
+)
+
+// PageRank returns the PageRank of each node of r, following links from
+// follower to followed and weighting them by their Weight, if they have
+// one. The ranks sum to 1.
+func (r *Result) PageRank() []float64 {
+
+    n := len(r.Nodes)
+    if n == 0 {
+        return nil
+    }
+
+    // Total the weight of each node's links
+    out := make([]float64, n)
+    for _, l := range r.Links {
+        out[l.Source] += linkWeight(l)
+    }
+
+    ranks := make([]float64, n)
+    for i := range ranks {
+        ranks[i] = 1 / float64(n)
+    }
+    next := make([]float64, n)
+
+    for round := 0; round < maxRankRounds; round++ {
+
+        // Nodes without links share their rank with every node
+        dangling := 0.0
+        for i, rank := range ranks {
+            if out[i] == 0 {
+                dangling += rank
+            }
+        }
+
+        base := (1 - rankDamping + rankDamping * dangling) / float64(n)
+        for i := range next {
+            next[i] = base
+        }
+        for _, l := range r.Links {
+            next[l.Target] += rankDamping * ranks[l.Source] * linkWeight(l) / out[l.Source]
+        }
+
+        change := 0.0
+        for i := range ranks {
+            change += math.Abs(next[i] - ranks[i])
+        }
+        ranks, next = next, ranks
+
+        if change < rankTolerance {
+            break
+        }
+    }
+
+    return ranks
+}
+
+// AddRanks sets the Rank of each node of r to its PageRank.
+func (r *Result) AddRanks() {
+    for i, rank := range r.PageRank() {
+        r.Nodes[i].Rank = rank
+    }
+}
+
+// TopByRank returns r with only its count highest ranked nodes and the
+// links between them, highest first, re-indexing the links to match. The
+// nodes must have been ranked with AddRanks. Left out nodes are counted as
+// omitted in its Meta.
+func (r *Result) TopByRank(count int) *Result {
+
+    if count > len(r.Nodes) {
+        count = len(r.Nodes)
+    }
+
+    order := make([]int, len(r.Nodes))
+    for i := range order {
+        order[i] = i
+    }
+    sort.SliceStable(order, func(a, b int) bool {
+        return r.Nodes[order[a]].Rank > r.Nodes[order[b]].Rank
+    })
+    order = order[:count]
+
+    // Re-index the kept nodes in rank order
+    newIndex := make([]int, len(r.Nodes))
+    for i := range newIndex {
+        newIndex[i] = -1
+    }
+    nodes := make([]Node, count)
+    for i, old := range order {
+        newIndex[old] = i
+        nodes[i] = r.Nodes[old]
+    }
+
+    links := []Link{}
+    for _, l := range r.Links {
+        if newIndex[l.Source] >= 0 && newIndex[l.Target] >= 0 {
+            l.Source, l.Target = newIndex[l.Source], newIndex[l.Target]
+            links = append(links, l)
+        }
+    }
+
+    top := &Result{Nodes: nodes, Links: links, Directed: r.Directed, Groups: r.Groups, Stats: r.Stats, Similarity: r.Similarity, Relation: r.Relation}
+    top.countDegrees()
+
+    if count < len(r.Nodes) {
+        top.Meta = &Meta{}
+        if r.Meta != nil {
+            *top.Meta = *r.Meta
+        }
+        top.Meta.Truncated = true
+        top.Meta.OmittedNodes += len(r.Nodes) - len(nodes)
+        top.Meta.OmittedLinks += len(r.Links) - len(links)
+    } else {
+        top.Meta = r.Meta
+    }
+    return top
+}
+
+/* Helpers */
+
+// linkWeight returns the weight of l, 1 if it's unweighted.
+func linkWeight(l Link) float64 {
+    if l.Weight > 0 {
+        return float64(l.Weight)
+    }
+    return 1
+}
@@ -0,0 +1,97 @@
+// ratelimit.go contains a token bucket limiting how fast requests are sent
+// to a source, so large users' concurrent page fetches don't burn through
+// its quota in a burst
+
+package networkmapper
+
+import (
+    "context"
+    "math"
+    "sync"
+    "time"
+)
+
+// A type for a token bucket refilled at rate tokens a second up to burst.
+type RateLimiter struct {
+    mu sync.Mutex
+    rate float64
+    burst float64
+    tokens float64
+    last time.Time
+}
+
+// NewRateLimiter creates a RateLimiter allowing rps requests a second, in
+// bursts of up to as many as are allowed in a second. It returns nil, which
+// never waits, if rps isn't positive.
+func NewRateLimiter(rps float64) *RateLimiter {
+    if rps <= 0 {
+        return nil
+    }
+
+    burst := math.Max(1, math.Ceil(rps))
+    return &RateLimiter{rate: rps, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// WithMaxRPS makes the NetworkMapper send at most rps requests a second
+// across all of its builds. 0 is no limit.
+func WithMaxRPS(rps float64) Option {
+    return func(n *networkMapper) {
+        n.limiter = NewRateLimiter(rps)
+    }
+}
+
+// Wait blocks until a request may be sent, returning ctx's error if it's
+// done first.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+    if l == nil {
+        return nil
+    }
+
+    l.mu.Lock()
+
+    // Refill the bucket for the time since it was last used
+    now := time.Now()
+    l.tokens = math.Min(l.burst, l.tokens + now.Sub(l.last).Seconds() * l.rate)
+    l.last = now
+
+    // Take a token, going into debt for it if there isn't one, so waiters
+    // are let through in the order they came
+    l.tokens--
+    if l.tokens >= 0 {
+        l.mu.Unlock()
+        return nil
+    }
+    delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+    l.mu.Unlock()
+
+    if err := sleep(ctx, delay); err != nil {
+
+        // Give the token back
+        l.mu.Lock()
+        l.tokens++
+        l.mu.Unlock()
+        return err
+    }
+    return nil
+}
+
+// Allow takes a token if there is one, without waiting for it, and
+// otherwise returns how long until there will be.
+func (l *RateLimiter) Allow() (bool, time.Duration) {
+    if l == nil {
+        return true, 0
+    }
+
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    now := time.Now()
+    l.tokens = math.Min(l.burst, l.tokens + now.Sub(l.last).Seconds() * l.rate)
+    l.last = now
+
+    if l.tokens < 1 {
+        return false, time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+    }
+    l.tokens--
+    return true, 0
+}
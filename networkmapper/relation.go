@@ -0,0 +1,137 @@
+// relation.go contains the choice of which relation a network is built
+// from: who the users follow, or who follows them
+
+package networkmapper
+
+// The relations a network can be built from.
+const (
+    RelationFollowings = "followings"
+    RelationFollowers = "followers"
+    RelationBoth = "both"
+)
+
+// The types of links in networks of both relations, from the users' side.
+const (
+    LinkFollows = "follows"
+    LinkFollowedBy = "followed_by"
+)
+
+// A type that satisfies networkmapper.FollowersFetcher can build networks
+// from who follows users rather than who they follow.
+type FollowersFetcher interface {
+
+    // Gets a copy of the NetworkMapper that fetches users' followers in
+    // place of their followings
+    WithFollowers() NetworkMapper
+
+}
+
+// A type that satisfies networkmapper.Relater can tell which relation it
+// fetches.
+type Relater interface {
+
+    // Gets RelationFollowings or RelationFollowers
+    Relation() string
+
+}
+
+// WithFollowers returns n fetching users' followers in place of their
+// followings, and false if it can't.
+func WithFollowers(n NetworkMapper) (NetworkMapper, bool) {
+    f, ok := n.(FollowersFetcher)
+    if !ok {
+        return n, false
+    }
+    return f.WithFollowers(), true
+}
+
+// relationOf returns the relation n fetches.
+func relationOf(n NetworkMapper) string {
+    if r, ok := n.(Relater); ok {
+        return r.Relation()
+    }
+    return RelationFollowings
+}
+
+// reverse points the links of r the other way, for networks whose links
+// were built from the users to their followers, so they run from follower
+// to followed like any other network.
+func (r *Result) reverse() {
+    for i, l := range r.Links {
+        r.Links[i].Source, r.Links[i].Target = l.Target, l.Source
+    }
+    r.Relation = RelationFollowers
+    r.countDegrees()
+}
+
+// ends returns the ends of l as the user it was fetched for and the account
+// found, which it runs from in networks of followings and to in networks
+// of followers.
+func (r *Result) ends(l Link) (user, other int) {
+    if r.Relation == RelationFollowers || l.Type == LinkFollowedBy {
+        return l.Target, l.Source
+    }
+    return l.Source, l.Target
+}
+
+// Combine returns a network of both relations from the network of the
+// users' followings and that of their followers, with the nodes of both,
+// matched by name, and their links typed by the relation they came from.
+func Combine(followings, followers *Result) *Result {
+
+    combined := &Result{Relation: RelationBoth}
+    nodeNums := make(map[string]int)
+
+    add := func(r *Result, linkType string) {
+        nums := make([]int, len(r.Nodes))
+        for i, node := range r.Nodes {
+            num, ok := nodeNums[node.Name]
+            if !ok {
+                num = len(combined.Nodes)
+                nodeNums[node.Name] = num
+                combined.Nodes = append(combined.Nodes, node)
+            }
+            if node.Group < combined.Nodes[num].Group {
+                combined.Nodes[num].Group = node.Group
+            }
+            nums[i] = num
+        }
+
+        for _, l := range r.Links {
+            l.Source, l.Target = nums[l.Source], nums[l.Target]
+            l.Type = linkType
+            combined.Links = append(combined.Links, l)
+        }
+
+        // A combination of partial networks is partial
+        if r.Meta != nil {
+            if combined.Meta == nil {
+                combined.Meta = &Meta{}
+            }
+            combined.Meta.Partial = combined.Meta.Partial || r.Meta.Partial
+            combined.Meta.SkippedUsers += r.Meta.SkippedUsers
+            combined.Meta.Errors = append(combined.Meta.Errors, r.Meta.Errors...)
+        }
+    }
+    add(followings, LinkFollows)
+    add(followers, LinkFollowedBy)
+
+    combined.countDegrees()
+    combined.Stats = combined.ComputeStats()
+    return combined
+}
+
+// WithFollowers returns a copy of n that fetches users' followers.
+func (n *networkMapper) WithFollowers() NetworkMapper {
+    c := *n
+    c.relation = RelationFollowers
+    return &c
+}
+
+// Relation returns which relation n fetches.
+func (n *networkMapper) Relation() string {
+    if n.relation == "" {
+        return RelationFollowings
+    }
+    return n.relation
+}
@@ -0,0 +1,144 @@
+// retry.go contains the retrying of requests SoundCloud turns away under
+// load, with exponential backoff and jitter
+
+package networkmapper
+
+import (
+    "context"
+    "math/rand"
+    "net/http"
+    "strconv"
+    "time"
+)
+
+const (
+    // The most times a request is tried, unless WithMaxAttempts says
+    defaultMaxAttempts = 4
+
+    // The backoff before the first retry, doubling each time up to the max
+    retryBaseDelay = 500 * time.Millisecond
+    retryMaxDelay = 30 * time.Second
+
+    // The longest Retry-After that is waited for rather than given up on
+    maxRetryAfter = 2 * time.Minute
+)
+
+// WithMaxAttempts makes the NetworkMapper try each request up to attempts
+// times when SoundCloud is rate limiting or failing. 1 disables retries.
+func WithMaxAttempts(attempts int) Option {
+    return func(n *networkMapper) {
+        if attempts > 0 {
+            n.maxAttempts = attempts
+        }
+    }
+}
+
+// do sends req, retrying network errors, 429s and 5xxs with exponential
+// backoff and jitter, or after the Retry-After the response asks for. The
+// last response is returned once the attempts run out, whatever its status.
+func (n *networkMapper) do(req *http.Request) (*http.Response, error) {
+
+    ctx := req.Context()
+
+    for attempt := 1; ; attempt++ {
+        if err := n.limiter.Wait(ctx); err != nil {
+            return nil, err
+        }
+
+        if err := n.breaker.Allow(); err != nil {
+            return nil, err
+        }
+
+        n.quota.Take()
+        r, err := n.client.Do(req)
+
+        // Keep the breaker up to date with whether SoundCloud is up
+        status := 0
+        if err == nil {
+            status = r.StatusCode
+        }
+        switch {
+        case failed(ctx, status, err):
+            n.breaker.Failure()
+        case err == nil:
+            n.breaker.Success()
+        default:
+            n.breaker.Abandon()
+        }
+
+        if ctx.Err() != nil {
+            return r, err
+        }
+        if attempt >= n.maxAttempts || (err == nil && !retryable(r.StatusCode)) {
+            return r, err
+        }
+
+        // Wait for the backoff, or for as long as SoundCloud asks
+        delay := backoff(attempt)
+        if err == nil {
+            if after, ok := retryAfter(r); ok {
+                if after > maxRetryAfter {
+                    return r, nil
+                }
+                delay = after
+            }
+            r.Body.Close()
+        }
+
+        if err := sleep(ctx, delay); err != nil {
+            return nil, err
+        }
+    }
+}
+
+/* Helpers */
+
+// retryable reports whether a response with status is worth retrying.
+func retryable(status int) bool {
+    return status == http.StatusTooManyRequests || status >= 500
+}
+
+// backoff returns a random delay of up to retryBaseDelay doubled for each
+// attempt so far, capped at retryMaxDelay.
+func backoff(attempt int) time.Duration {
+    max := retryMaxDelay
+    if attempt < 32 {
+        if d := retryBaseDelay << uint(attempt - 1); d < max {
+            max = d
+        }
+    }
+    return time.Duration(rand.Int63n(int64(max))) + 1
+}
+
+// retryAfter returns how long r's Retry-After header asks to wait, given
+// in seconds or as an HTTP date.
+func retryAfter(r *http.Response) (time.Duration, bool) {
+    value := r.Header.Get("Retry-After")
+    if value == "" {
+        return 0, false
+    }
+
+    if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+        return time.Duration(secs) * time.Second, true
+    }
+    if t, err := http.ParseTime(value); err == nil {
+        if d := time.Until(t); d > 0 {
+            return d, true
+        }
+        return 0, true
+    }
+    return 0, false
+}
+
+// sleep waits for d, returning ctx's error if it's done first.
+func sleep(ctx context.Context, d time.Duration) error {
+    timer := time.NewTimer(d)
+    defer timer.Stop()
+
+    select {
+    case <-timer.C:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
@@ -0,0 +1,303 @@
+// schema.go contains the JSON Schema of each format of the network, and
+// validation against it, so renderers don't have to guess at what the
+// fields mean
+
+package networkmapper
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "reflect"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// The formats of the network, as asked for by the JSON route.
+const (
+    FORMAT_RESULT = "result"
+    FORMAT_LEGACY = "v0"
+    FORMAT_BUNDLE = "bundle"
+    FORMAT_COMMUNITY = "community"
+    FORMAT_D3V7 = "d3v7"
+    FORMAT_CYTOSCAPE = "cytoscape"
+)
+
+// formats holds a value of the Go type of each format.
+var formats = map[string]interface{}{
+    FORMAT_RESULT: Result{},
+    FORMAT_LEGACY: LegacyResult{},
+    FORMAT_BUNDLE: []BundleNode{},
+    FORMAT_COMMUNITY: Collapsed{},
+    FORMAT_D3V7: NamedResult{},
+    FORMAT_CYTOSCAPE: CytoscapeResult{},
+}
+
+// ResultSchema returns the JSON Schema document for the named format.
+func ResultSchema(format string) (map[string]interface{}, error) {
+
+    v, ok := formats[format]
+    if !ok {
+        return nil, errors.New("unknown format " + strconv.Quote(format))
+    }
+
+    defs := make(map[string]interface{})
+    schema := SchemaOf(reflect.TypeOf(v), "#/definitions/", defs)
+
+    // Siblings of a $ref are ignored, so inline the top-level type
+    if ref, ok := schema["$ref"].(string); ok {
+        schema = make(map[string]interface{})
+        for k, v := range defs[ref[strings.LastIndex(ref, "/") + 1:]].(map[string]interface{}) {
+            schema[k] = v
+        }
+    }
+    schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+    schema["title"] = "cumuli " + format
+    schema["definitions"] = defs
+
+    return schema, nil
+}
+
+// SchemaOf returns the JSON Schema of values of t as encoding/json would
+// marshal them. Named structs are added to defs and referred to by
+// refPrefix followed by their name. Fields are described by their doc tag.
+func SchemaOf(t reflect.Type, refPrefix string, defs map[string]interface{}) map[string]interface{} {
+
+    switch t.Kind() {
+    case reflect.Ptr:
+        return SchemaOf(t.Elem(), refPrefix, defs)
+
+    case reflect.Struct:
+        if t == reflect.TypeOf(time.Time{}) {
+            return map[string]interface{}{"type": "string", "format": "date-time"}
+        }
+        if t.Name() == "" {
+            return structSchema(t, refPrefix, defs)
+        }
+
+        // Add the schema before filling it in, in case t refers to itself
+        if _, ok := defs[t.Name()]; !ok {
+            defs[t.Name()] = map[string]interface{}{}
+            defs[t.Name()] = structSchema(t, refPrefix, defs)
+        }
+        return map[string]interface{}{"$ref": refPrefix + t.Name()}
+
+    case reflect.Slice, reflect.Array:
+        if t.Elem().Kind() == reflect.Uint8 {
+            return map[string]interface{}{"type": "string", "format": "byte"}
+        }
+        return map[string]interface{}{"type": "array", "items": SchemaOf(t.Elem(), refPrefix, defs)}
+
+    case reflect.Map:
+        return map[string]interface{}{"type": "object", "additionalProperties": SchemaOf(t.Elem(), refPrefix, defs)}
+
+    case reflect.String:
+        return map[string]interface{}{"type": "string"}
+
+    case reflect.Bool:
+        return map[string]interface{}{"type": "boolean"}
+
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+        reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        return map[string]interface{}{"type": "integer"}
+
+    case reflect.Float32, reflect.Float64:
+        return map[string]interface{}{"type": "number"}
+    }
+
+    return map[string]interface{}{}
+}
+
+// structSchema returns the object schema of the struct type t.
+func structSchema(t reflect.Type, refPrefix string, defs map[string]interface{}) map[string]interface{} {
+
+    properties := make(map[string]interface{})
+    required := []string{}
+
+    var addFields func(t reflect.Type)
+    addFields = func(t reflect.Type) {
+        for i := 0; i < t.NumField(); i++ {
+            f := t.Field(i)
+
+            tag := f.Tag.Get("json")
+            if tag == "-" {
+                continue
+            }
+            name, opts := tag, ""
+            if i := strings.Index(tag, ","); i >= 0 {
+                name, opts = tag[:i], tag[i + 1:]
+            }
+
+            // Embedded structs' fields are promoted
+            embedded := f.Type
+            if embedded.Kind() == reflect.Ptr {
+                embedded = embedded.Elem()
+            }
+            if f.Anonymous && name == "" && embedded.Kind() == reflect.Struct {
+                addFields(embedded)
+                continue
+            }
+            if f.PkgPath != "" {
+                continue
+            }
+
+            if name == "" {
+                name = f.Name
+            }
+            schema := SchemaOf(f.Type, refPrefix, defs)
+            if doc := f.Tag.Get("doc"); doc != "" {
+                if _, ok := schema["$ref"]; ok {
+                    // Siblings of $ref are ignored, so wrap it
+                    schema = map[string]interface{}{"allOf": []interface{}{schema}}
+                }
+                schema["description"] = doc
+            }
+            properties[name] = schema
+            if !strings.Contains(opts, "omitempty") {
+                required = append(required, name)
+            }
+        }
+    }
+    addFields(t)
+
+    schema := map[string]interface{}{"type": "object", "properties": properties}
+    if len(required) > 0 {
+        schema["required"] = required
+    }
+    return schema
+}
+
+// Validate checks that js is a network in the named format: that it
+// conforms to the format's ResultSchema and that its links refer to nodes
+// that exist.
+func Validate(js []byte, format string) error {
+
+    schema, err := ResultSchema(format)
+    if err != nil {
+        return err
+    }
+
+    var v interface{}
+    if err = json.Unmarshal(js, &v); err != nil {
+        return err
+    }
+    defs := schema["definitions"].(map[string]interface{})
+    if err = validateSchema(v, schema, defs, "$"); err != nil {
+        return err
+    }
+
+    // Check what the schema can't express
+    switch format {
+    case FORMAT_RESULT, FORMAT_LEGACY, FORMAT_COMMUNITY:
+        var r struct {
+            Nodes []json.RawMessage `json:"nodes"`
+            Links []struct { Source, Target int } `json:"links"`
+        }
+        json.Unmarshal(js, &r)
+        for i, l := range r.Links {
+            if l.Source < 0 || l.Source >= len(r.Nodes) || l.Target < 0 || l.Target >= len(r.Nodes) {
+                return fmt.Errorf("$.links[%d]: refers to a node that doesn't exist", i)
+            }
+        }
+
+    case FORMAT_BUNDLE:
+        var nodes []BundleNode
+        json.Unmarshal(js, &nodes)
+        names := make(map[string]bool)
+        for _, n := range nodes {
+            names[n.Name] = true
+        }
+        for i, n := range nodes {
+            for _, name := range n.Imports {
+                if !names[name] {
+                    return fmt.Errorf("$[%d].imports: %q doesn't exist", i, name)
+                }
+            }
+        }
+    }
+
+    return nil
+}
+
+// validateSchema checks the decoded JSON v against the parts of JSON
+// Schema that SchemaOf uses. path locates v in the document for errors.
+func validateSchema(v interface{}, schema map[string]interface{}, defs map[string]interface{}, path string) error {
+
+    if ref, ok := schema["$ref"].(string); ok {
+        def, _ := defs[ref[strings.LastIndex(ref, "/") + 1:]].(map[string]interface{})
+        return validateSchema(v, def, defs, path)
+    }
+    if all, ok := schema["allOf"].([]interface{}); ok {
+        for _, s := range all {
+            if err := validateSchema(v, s.(map[string]interface{}), defs, path); err != nil {
+                return err
+            }
+        }
+    }
+
+    // Check the type
+    var ok bool
+    switch schema["type"] {
+    case "object":
+        var obj map[string]interface{}
+        if obj, ok = v.(map[string]interface{}); ok {
+            return validateObject(obj, schema, defs, path)
+        }
+    case "array":
+        var arr []interface{}
+        if arr, ok = v.([]interface{}); ok {
+            items, _ := schema["items"].(map[string]interface{})
+            for i, item := range arr {
+                if err := validateSchema(item, items, defs, path + "[" + strconv.Itoa(i) + "]"); err != nil {
+                    return err
+                }
+            }
+        }
+    case "string":
+        _, ok = v.(string)
+    case "boolean":
+        _, ok = v.(bool)
+    case "number":
+        _, ok = v.(float64)
+    case "integer":
+        var f float64
+        f, ok = v.(float64)
+        ok = ok && f == float64(int64(f))
+    default:
+        ok = true
+    }
+
+    if !ok {
+        return fmt.Errorf("%s: must be of type %s", path, schema["type"])
+    }
+    return nil
+}
+
+// validateObject checks the decoded JSON object obj against schema.
+func validateObject(obj map[string]interface{}, schema map[string]interface{}, defs map[string]interface{}, path string) error {
+
+    required, _ := schema["required"].([]string)
+    for _, name := range required {
+        if _, ok := obj[name]; !ok {
+            return fmt.Errorf("%s: missing %q", path, name)
+        }
+    }
+
+    properties, _ := schema["properties"].(map[string]interface{})
+    additional, _ := schema["additionalProperties"].(map[string]interface{})
+    for name, value := range obj {
+        property, ok := properties[name].(map[string]interface{})
+        if !ok {
+            property = additional
+        }
+        if property == nil {
+            continue
+        }
+        if err := validateSchema(value, property, defs, path + "." + name); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
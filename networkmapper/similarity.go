@@ -0,0 +1,130 @@
+// similarity.go contains how alike the users' followings are, pair by
+// pair, for comparing tastes without drawing the whole network
+
+package networkmapper
+
+// A type for the pairwise similarity of the users' followings.
+type Similarity struct {
+    Users []string `json:"users" doc:"The users whose followings were fetched, in the order of the rows and columns"`
+    Jaccard [][]float64 `json:"jaccard" doc:"For each pair of users, how many accounts they both follow over how many either follows"`
+}
+
+// similarity returns the Similarity of the users whose followings were
+// fetched, given, as sharedFollowings interns them, each fetched user's
+// id in whos, their followings' ids in whoms, the node number of each id,
+// or -1, and how many ids there are. Only nodes can be followed by more
+// than one user, so intersections are counted among them.
+func similarity(users []string, whos []int32, whoms [][]int32, nodeNums []int32, numIds int) *Similarity {
+
+    // The rows in the order of users, leaving out those not fetched
+    rows := make([]int, len(users))
+    for i := range rows {
+        rows[i] = -1
+    }
+    s := &Similarity{Users: []string{}}
+    for _, who := range whos {
+        if user := nodeNums[who]; user >= 0 && int(user) < len(users) {
+            rows[user] = 0
+        }
+    }
+    for i, user := range users {
+        if rows[i] == 0 {
+            rows[i] = len(s.Users)
+            s.Users = append(s.Users, user)
+        }
+    }
+
+    // Count each user's distinct followings, and which users follow each
+    // node
+    sizes := make([]int, len(s.Users))
+    followedBy := make(map[int32][]int)
+    lastSeen := make([]int32, numIds)
+    for i := range lastSeen {
+        lastSeen[i] = -1
+    }
+
+    for i, fids := range whoms {
+        row := rows[nodeNums[whos[i]]]
+        for _, id := range fids {
+            if lastSeen[id] == int32(row) {
+                continue
+            }
+            lastSeen[id] = int32(row)
+            sizes[row]++
+            if nodeNums[id] >= 0 {
+                followedBy[id] = append(followedBy[id], row)
+            }
+        }
+    }
+
+    // Count the followings each pair shares
+    shared := make([][]int, len(s.Users))
+    for i := range shared {
+        shared[i] = make([]int, len(s.Users))
+    }
+    for _, rs := range followedBy {
+        for a := range rs {
+            for b := a + 1; b < len(rs); b++ {
+                shared[rs[a]][rs[b]]++
+                shared[rs[b]][rs[a]]++
+            }
+        }
+    }
+
+    s.Jaccard = make([][]float64, len(s.Users))
+    for a := range s.Jaccard {
+        s.Jaccard[a] = make([]float64, len(s.Users))
+        for b := range s.Jaccard[a] {
+            switch union := sizes[a] + sizes[b] - shared[a][b]; {
+            case a == b:
+                s.Jaccard[a][b] = 1
+            case union > 0:
+                s.Jaccard[a][b] = float64(shared[a][b]) / float64(union)
+            }
+        }
+    }
+
+    return s
+}
+
+// add adds user, who follows followings, to s, given the sets of the
+// followings of the others by name. Users who aren't in s are left out.
+func (s *Similarity) add(user string, followings []string, others []string, theirs []map[string]bool) {
+
+    set := make(map[string]bool, len(followings))
+    for _, f := range followings {
+        set[f] = true
+    }
+
+    row := make([]float64, len(s.Users) + 1)
+    for i, other := range others {
+        col := -1
+        for j, u := range s.Users {
+            if u == other {
+                col = j
+            }
+        }
+        if col < 0 {
+            continue
+        }
+
+        shared, union := 0, len(set)
+        for f := range theirs[i] {
+            if set[f] {
+                shared++
+            } else {
+                union++
+            }
+        }
+        if union > 0 {
+            row[col] = float64(shared) / float64(union)
+        }
+    }
+    row[len(s.Users)] = 1
+
+    for i := range s.Jaccard {
+        s.Jaccard[i] = append(s.Jaccard[i], row[i])
+    }
+    s.Users = append(s.Users, user)
+    s.Jaccard = append(s.Jaccard, row)
+}
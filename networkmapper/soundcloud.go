@@ -0,0 +1,288 @@
+// soundcloud.go contains the Provider for SoundCloud, the source networks
+// are built from by default
+
+package networkmapper
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "io/ioutil"
+    "net/http"
+    neturl "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// The SoundCloud API, unless NewNetworkMapper is given another.
+const soundCloudAPI = `http://api.soundcloud.com`
+
+// networkMapper is the Provider for SoundCloud.
+type networkMapper struct {
+    clientId string
+    numResults int
+    quota *Quota
+    client *http.Client
+    baseURL string
+    maxAttempts int
+    limiter *RateLimiter
+    breaker *Breaker
+    connectTimeout time.Duration
+    readTimeout time.Duration
+    buildTimeout time.Duration
+    clientSecret string
+    tokenURL string
+    token *tokenSource
+    partial bool
+    memo *FollowingsCache
+    store FollowingsStore
+    flights *flightGroup
+    etags *ETagCache
+    profiles *lruCache
+    ids *lruCache
+    relation string
+}
+
+// An Option configures the NetworkMapper created by NewNetworkMapper.
+type Option func(*networkMapper)
+
+// WithHTTPClient makes the NetworkMapper send its requests with c, for its
+// timeouts or proxy, instead of a client with the WithTimeouts timeouts.
+func WithHTTPClient(c *http.Client) Option {
+    return func(n *networkMapper) {
+        n.client = c
+    }
+}
+
+// WithBaseURL makes the NetworkMapper call the SoundCloud API at url, such
+// as a test server, instead of api.soundcloud.com.
+func WithBaseURL(url string) Option {
+    return func(n *networkMapper) {
+        n.baseURL = strings.TrimSuffix(url, "/")
+    }
+}
+
+// WithConcurrency used to make the NetworkMapper fetch at most workers
+// pages of a user's followings at once. It does nothing now, as each page
+// is found by the cursor of the one before it.
+//
+// Deprecated: pages are fetched one after another.
+func WithConcurrency(workers int) Option {
+    return func(n *networkMapper) {}
+}
+
+// NewNetworkMapper creates a new NetworkMapper.
+func NewNetworkMapper(id string, num int, opts ...Option) NetworkMapper {
+    n := &networkMapper{
+        clientId: id,
+        numResults: num,
+        quota: NewQuota(soundCloudQuota, soundCloudQuotaWindow),
+        baseURL: soundCloudAPI,
+        maxAttempts: defaultMaxAttempts,
+        breaker: NewBreaker("SoundCloud", breakerThreshold, breakerCooldown),
+        connectTimeout: defaultConnectTimeout,
+        readTimeout: defaultReadTimeout,
+        tokenURL: soundCloudTokenURL,
+        flights: newFlightGroup(),
+        profiles: newLRUCache(profileCacheSize, 0),
+        ids: newLRUCache(idCacheSize, 0),
+    }
+
+    for _, opt := range opts {
+        opt(n)
+    }
+    if n.client == nil {
+        n.client = newTimeoutClient(n.connectTimeout, n.readTimeout)
+    }
+    if n.clientSecret != "" {
+        n.token = newTokenSource(n.client, n.tokenURL, n.clientId, n.clientSecret)
+    }
+    return n
+}
+
+// FollowingsCount returns how many users the provided user follows, or
+// how many follow them if n fetches followers.
+func (n *networkMapper) FollowingsCount(ctx context.Context, user string) (int, error) {
+
+    url := n.baseURL + `/users/` + user + `.json?client_id=` + n.clientId
+    r, err := n.get(ctx, url)
+    if err != nil {
+        return 0, err
+    }
+    defer r.Body.Close()
+
+    body, err := ioutil.ReadAll(r.Body)
+    if err != nil {
+        return 0, err
+    }
+
+    // user object to store unmarshalled json
+    var u struct { 
+        scUser
+        FollowingCount float64  `json:"followings_count"` 
+    }
+
+    if err = json.Unmarshal(body, &u); err != nil {
+        return 0, err
+    }
+    n.remember(&u.scUser)
+
+    // Followers are what's fetched in their place
+    if n.Relation() == RelationFollowers {
+        return u.FollowersCount, nil
+    }
+    return int(u.FollowingCount), nil
+}
+
+// GetFollowings returns a slice of strings containing the usernames of 
+// the followings of the provided user, following SoundCloud's cursors
+// from page to page. It returns what it has if ctx is done first or a
+// page can't be fetched.
+func (n *networkMapper) GetFollowings(ctx context.Context, user string) ([]string) {
+    followings, _ := n.TryGetFollowings(ctx, user)
+    return followings
+}
+
+// TryGetFollowings is like GetFollowings but also returns why the
+// followings are incomplete if a page couldn't be fetched. Complete
+// followings are cached if n has a FollowingsCache, and concurrent calls
+// for the same user share a single fetch.
+func (n *networkMapper) TryGetFollowings(ctx context.Context, user string) ([]string, *FetchError) {
+
+    // Users are cached by id where it's known, so renaming doesn't lose
+    // them, but may have been cached by name before it was
+    key := n.userKey(user)
+    if followings, ok := n.memo.Get(key); ok {
+        return followings, nil
+    }
+    if followings, ok := n.memo.Get(n.keyPrefix() + user); ok {
+        return followings, nil
+    }
+    if followings, ok := n.stored(user); ok {
+        return followings, nil
+    }
+
+    return n.flights.do(ctx, key, func() ([]string, *FetchError, bool) {
+        return n.fetchFollowings(ctx, user)
+    })
+}
+
+// fetchFollowings fetches the followings of user, returning why they're
+// incomplete if a page couldn't be fetched, and whether the fetch ran to
+// the end rather than being cut short by ctx.
+func (n *networkMapper) fetchFollowings(ctx context.Context, user string) ([]string, *FetchError, bool) {
+
+    followings := []string{}
+
+    pages, errc := n.StreamFollowings(ctx, user)
+    for page := range pages {
+        followings = append(followings, page...)
+    }
+
+    // Followings cut short by ctx aren't an error here
+    switch err := (<-errc).(type) {
+    case *FetchError:
+        return followings, err, true
+    case nil:
+        n.memo.Put(n.userKey(user), followings)
+        if n.store != nil {
+            n.store.PutFollowings(n.userKey(user), followings)
+        }
+        return followings[0:], nil, true
+    }
+    return followings, nil, false
+}
+
+// authorizedDo is do, authorizing req with n's token if it has one. A
+// rejected token may have been revoked, so it's replaced and req is tried
+// once more.
+func (n *networkMapper) authorizedDo(req *http.Request) (*http.Response, error) {
+    if n.token == nil {
+        return n.do(req)
+    }
+
+    for tries := 0; ; tries++ {
+        if err := n.token.authorize(req); err != nil {
+            return nil, err
+        }
+
+        r, err := n.do(req)
+        if err != nil || r.StatusCode != http.StatusUnauthorized || tries > 0 {
+            return r, err
+        }
+        r.Body.Close()
+        n.token.invalidate(strings.TrimPrefix(req.Header.Get("Authorization"), "OAuth "))
+    }
+}
+
+// rebase returns href, a URL SoundCloud handed back, on n's base URL, so
+// cursors keep going through a proxy.
+func (n *networkMapper) rebase(href string) string {
+    u, err := neturl.Parse(href)
+    if err != nil || href == "" {
+        return href
+    }
+    return n.baseURL + u.RequestURI()
+}
+
+// get gets url from SoundCloud, retrying if it's turned away and counting
+// every try against the quota. It returns a *SourceError if SoundCloud
+// couldn't be reached or errored every time.
+func (n *networkMapper) get(ctx context.Context, url string) (*http.Response, error) {
+    req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+    if err != nil {
+        return nil, err
+    }
+
+    // Only fetch pages that have changed since they were cached
+    cached := n.etags.prepare(req)
+
+    r, err := n.authorizedDo(req)
+    if err != nil {
+        if _, ok := err.(*UnavailableError); ok || ctx.Err() != nil {
+            return nil, err
+        }
+        return nil, &SourceError{Source: "SoundCloud", Err: err}
+    }
+    if r.StatusCode >= 500 {
+        r.Body.Close()
+        return nil, &SourceError{Source: "SoundCloud", Err: errors.New(r.Status)}
+    }
+    return n.etags.resolve(req, cached, r)
+}
+
+// GetUser returns the account of user on SoundCloud, keeping its id and
+// profile for later builds.
+func (n *networkMapper) GetUser(ctx context.Context, user string) (*User, error) {
+
+    var u scUser
+    if err := n.getJSON(ctx, n.baseURL + `/users/` + user + `.json?client_id=` + n.clientId, &u); err != nil {
+        return nil, err
+    }
+
+    return &User{Name: u.Permalink, Id: u.Id, Profile: n.remember(&u)}, nil
+}
+
+// Search returns up to limit SoundCloud accounts matching q, keeping their
+// ids and profiles for later builds.
+func (n *networkMapper) Search(ctx context.Context, q string, limit int) ([]User, error) {
+
+    var found []scUser
+    url := n.baseURL + `/users.json?client_id=` + n.clientId + `&q=` + neturl.QueryEscape(q) +
+           `&limit=` + strconv.Itoa(limit)
+    if err := n.getJSON(ctx, url, &found); err != nil {
+        return nil, err
+    }
+
+    users := make([]User, 0, len(found))
+    for i := range found {
+        if found[i].Permalink != "" {
+            users = append(users, User{Name: found[i].Permalink, Id: found[i].Id, Profile: n.remember(&found[i])})
+        }
+    }
+    if len(users) > limit {
+        users = users[:limit]
+    }
+    return users, nil
+}
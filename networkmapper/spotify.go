@@ -0,0 +1,445 @@
+// spotify.go contains the Provider for Spotify, mapping the artists shared
+// among listeners. Spotify only tells a listener who they follow, so each
+// user has to have connected their account first, and their followings are
+// fetched with their own token.
+
+package networkmapper
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    spotifyAPI = `https://api.spotify.com/v1`
+    spotifyAccounts = `https://accounts.spotify.com`
+
+    // The most followed artists Spotify returns per page
+    maxSpotifyPageSize = 50
+
+    // What a connected account lets cumuli read
+    spotifyScope = "user-follow-read"
+)
+
+// A type that satisfies networkmapper.SpotifyTokens keeps the refresh
+// tokens of the Spotify accounts that have been connected.
+type SpotifyTokens interface {
+
+    // Gets the refresh token of a given user, or "" if they haven't
+    // connected their account
+    GetRefreshToken(user string) (string, error)
+
+    // Replaces the refresh token of a given user
+    PutRefreshToken(user, token string) error
+
+}
+
+// A type that satisfies networkmapper.Connector needs users to connect
+// their accounts before their followings can be fetched.
+type Connector interface {
+
+    // Gets where to send a user to connect their account, coming back to
+    // redirectURI with state
+    AuthorizeURL(redirectURI, state string) string
+
+    // Gets the user who connected their account, given the code they came
+    // back to redirectURI with
+    Connect(ctx context.Context, code, redirectURI string) (string, error)
+
+}
+
+// A type for an error fetching the followings of a user who hasn't
+// connected their Spotify account.
+type NotConnectedError struct {
+    User string
+}
+
+func (e *NotConnectedError) Error() string {
+    return e.User + " hasn't connected their Spotify account"
+}
+
+// spotifyNetworkMapper is the Provider for the Spotify Web API.
+type spotifyNetworkMapper struct {
+    clientId string
+    clientSecret string
+    tokens SpotifyTokens
+    client *http.Client
+    perPage int
+    quota *Quota
+
+    // The app's own token, for what any client may read
+    app *tokenSource
+
+    // Users' access tokens, by user
+    mu sync.Mutex
+    access map[string]spotifyAccess
+
+    // Artists' profiles from the pages of followings
+    profiles *lruCache
+}
+
+// A type for a user's access token and when it expires.
+type spotifyAccess struct {
+    token string
+    expiry time.Time
+}
+
+// NewSpotifyNetworkMapper creates a NetworkMapper whose followings are the
+// Spotify artists, by id, a user follows. Users are named by their Spotify
+// user id, and their refresh tokens are kept in tokens.
+func NewSpotifyNetworkMapper(clientId, clientSecret string, tokens SpotifyTokens) NetworkMapper {
+    client := newTimeoutClient(defaultConnectTimeout, defaultReadTimeout)
+    app := newTokenSource(client, spotifyAccounts + `/api/token`, clientId, clientSecret)
+    app.scheme = "Bearer"
+    return &spotifyNetworkMapper{
+        clientId: clientId,
+        clientSecret: clientSecret,
+        tokens: tokens,
+        client: client,
+        perPage: maxSpotifyPageSize,
+        quota: NewQuota(spotifyQuota, spotifyQuotaWindow),
+        app: app,
+        access: make(map[string]spotifyAccess),
+        profiles: newLRUCache(profileCacheSize, 0),
+    }
+}
+
+// AuthorizeURL returns where to send a listener to connect their Spotify
+// account, coming back to redirectURI with state.
+func (n *spotifyNetworkMapper) AuthorizeURL(redirectURI, state string) string {
+    return spotifyAccounts + `/authorize?` + url.Values{
+        "client_id": {n.clientId},
+        "response_type": {"code"},
+        "redirect_uri": {redirectURI},
+        "scope": {spotifyScope},
+        "state": {state},
+    }.Encode()
+}
+
+// Connect exchanges code, given to redirectURI when a listener connected
+// their account, for their tokens, keeping the refresh token. It returns
+// the listener's Spotify user id.
+func (n *spotifyNetworkMapper) Connect(ctx context.Context, code, redirectURI string) (string, error) {
+
+    token, err := n.grant(ctx, url.Values{
+        "grant_type": {"authorization_code"},
+        "code": {code},
+        "redirect_uri": {redirectURI},
+    })
+    if err != nil {
+        return "", err
+    }
+
+    var me struct {
+        Id string `json:"id"`
+    }
+    if err = n.getJSON(ctx, spotifyAPI + `/me`, "Bearer " + token.AccessToken, &me); err != nil {
+        return "", err
+    }
+    if me.Id == "" {
+        return "", errors.New("spotify: no user id")
+    }
+
+    if err = n.tokens.PutRefreshToken(me.Id, token.RefreshToken); err != nil {
+        return "", err
+    }
+    n.keep(me.Id, token)
+    return me.Id, nil
+}
+
+// GetFollowings returns the ids of the artists user follows, following
+// Spotify's cursors from page to page. It returns what it has if ctx is
+// done first or a page can't be fetched.
+func (n *spotifyNetworkMapper) GetFollowings(ctx context.Context, user string) []string {
+    followings, _ := n.TryGetFollowings(ctx, user)
+    return followings
+}
+
+// TryGetFollowings is like GetFollowings but also returns why the
+// followings are incomplete, such as user not having connected their
+// account.
+func (n *spotifyNetworkMapper) TryGetFollowings(ctx context.Context, user string) ([]string, *FetchError) {
+
+    followings := []string{}
+    fail := func(page int, err error) ([]string, *FetchError) {
+        return followings, &FetchError{User: user, Page: page, Message: err.Error()}
+    }
+
+    auth, err := n.authorization(ctx, user)
+    if err != nil {
+        return fail(0, err)
+    }
+
+    next := spotifyAPI + `/me/following?type=artist&limit=` + strconv.Itoa(n.perPage)
+    for page := 0; next != ""; page++ {
+        var p struct {
+            Artists struct {
+                Items []spotifyArtist `json:"items"`
+                Next string `json:"next"`
+            } `json:"artists"`
+        }
+        if err := n.getJSON(ctx, next, auth, &p); err != nil {
+            return fail(page, err)
+        }
+
+        for i := range p.Artists.Items {
+            a := &p.Artists.Items[i]
+            followings = append(followings, a.Id)
+            n.profiles.put(a.Id, a.profile())
+        }
+        next = p.Artists.Next
+    }
+
+    return followings, nil
+}
+
+// PartialResults returns true, so users who haven't connected their
+// accounts are left out rather than failing the build.
+func (n *spotifyNetworkMapper) PartialResults() bool {
+    return true
+}
+
+// GetProfile returns the profile of the artist with id, as last seen on a
+// page of followings or fetched from Spotify.
+func (n *spotifyNetworkMapper) GetProfile(ctx context.Context, id string) (*Profile, error) {
+
+    if p, ok := n.profiles.get(id); ok {
+        return p.(*Profile), nil
+    }
+
+    var a spotifyArtist
+    if err := n.getAppJSON(ctx, spotifyAPI + `/artists/` + url.PathEscape(id), &a); err != nil {
+        return nil, err
+    }
+
+    p := a.profile()
+    n.profiles.put(id, p)
+    return p, nil
+}
+
+// GetUser returns the Spotify account of user.
+func (n *spotifyNetworkMapper) GetUser(ctx context.Context, user string) (*User, error) {
+
+    var u struct {
+        Id string `json:"id"`
+        DisplayName string `json:"display_name"`
+        Followers struct {
+            Total int `json:"total"`
+        } `json:"followers"`
+        Images []struct {
+            URL string `json:"url"`
+        } `json:"images"`
+    }
+    if err := n.getAppJSON(ctx, spotifyAPI + `/users/` + url.PathEscape(user), &u); err != nil {
+        return nil, err
+    }
+
+    p := &Profile{FullName: u.DisplayName, Followers: u.Followers.Total}
+    if len(u.Images) > 0 {
+        p.AvatarURL = u.Images[0].URL
+    }
+    return &User{Name: u.Id, Profile: p}, nil
+}
+
+// Search returns up to limit Spotify artists matching q. Spotify can't be
+// searched for listeners, only for what they listen to.
+func (n *spotifyNetworkMapper) Search(ctx context.Context, q string, limit int) ([]User, error) {
+
+    if limit > maxSpotifyPageSize {
+        limit = maxSpotifyPageSize
+    }
+
+    var found struct {
+        Artists struct {
+            Items []spotifyArtist `json:"items"`
+        } `json:"artists"`
+    }
+    url := spotifyAPI + `/search?type=artist&q=` + url.QueryEscape(q) + `&limit=` + strconv.Itoa(limit)
+    if err := n.getAppJSON(ctx, url, &found); err != nil {
+        return nil, err
+    }
+
+    users := make([]User, len(found.Artists.Items))
+    for i, a := range found.Artists.Items {
+        users[i] = User{Name: a.Id, Profile: a.profile()}
+    }
+    return users, nil
+}
+
+// WithPageSize returns a copy of n that fetches size artists per page.
+func (n *spotifyNetworkMapper) WithPageSize(size int) NetworkMapper {
+    return &spotifyNetworkMapper{
+        clientId: n.clientId,
+        clientSecret: n.clientSecret,
+        tokens: n.tokens,
+        client: n.client,
+        perPage: size,
+        quota: n.quota,
+        app: n.app,
+        access: make(map[string]spotifyAccess),
+        profiles: n.profiles,
+    }
+}
+
+// MaxPageSize returns the most artists Spotify returns per page.
+func (n *spotifyNetworkMapper) MaxPageSize() int {
+    return maxSpotifyPageSize
+}
+
+// authorization returns the Authorization header for user's requests,
+// refreshing their access token if it's about to expire.
+func (n *spotifyNetworkMapper) authorization(ctx context.Context, user string) (string, error) {
+
+    n.mu.Lock()
+    a, ok := n.access[user]
+    n.mu.Unlock()
+    if ok && time.Now().Before(a.expiry.Add(-tokenExpiryMargin)) {
+        return "Bearer " + a.token, nil
+    }
+
+    refresh, err := n.tokens.GetRefreshToken(user)
+    if err != nil {
+        return "", err
+    }
+    if refresh == "" {
+        return "", &NotConnectedError{User: user}
+    }
+
+    token, err := n.grant(ctx, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refresh}})
+    if err != nil {
+        return "", err
+    }
+
+    // Spotify may hand out a new refresh token in place of the old
+    if token.RefreshToken != "" && token.RefreshToken != refresh {
+        if err = n.tokens.PutRefreshToken(user, token.RefreshToken); err != nil {
+            return "", err
+        }
+    }
+    n.keep(user, token)
+    return "Bearer " + token.AccessToken, nil
+}
+
+// keep keeps user's access token until it expires.
+func (n *spotifyNetworkMapper) keep(user string, token *spotifyToken) {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    n.access[user] = spotifyAccess{token.AccessToken, time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)}
+}
+
+// A type for the tokens Spotify grants.
+type spotifyToken struct {
+    AccessToken string `json:"access_token"`
+    RefreshToken string `json:"refresh_token"`
+    ExpiresIn int `json:"expires_in"`
+}
+
+// grant asks Spotify for a token with form.
+func (n *spotifyNetworkMapper) grant(ctx context.Context, form url.Values) (*spotifyToken, error) {
+
+    req, err := http.NewRequestWithContext(ctx, "POST", spotifyAccounts + `/api/token`, strings.NewReader(form.Encode()))
+    if err != nil {
+        return nil, err
+    }
+    req.SetBasicAuth(n.clientId, n.clientSecret)
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+    r, err := n.client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer r.Body.Close()
+
+    body, err := ioutil.ReadAll(r.Body)
+    if err != nil {
+        return nil, err
+    }
+    if r.StatusCode != http.StatusOK {
+        return nil, errors.New("spotify: " + r.Status + ": " + string(body))
+    }
+
+    var token spotifyToken
+    if err = json.Unmarshal(body, &token); err != nil {
+        return nil, err
+    }
+    if token.AccessToken == "" {
+        return nil, errors.New("spotify: no access token in response")
+    }
+    return &token, nil
+}
+
+// getAppJSON gets url from Spotify with the app's own token and unmarshals
+// its JSON body into v.
+func (n *spotifyNetworkMapper) getAppJSON(ctx context.Context, url string, v interface{}) error {
+
+    req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+    if err != nil {
+        return err
+    }
+    if err = n.app.authorize(req); err != nil {
+        return err
+    }
+    return n.getJSON(ctx, url, req.Header.Get("Authorization"), v)
+}
+
+// getJSON gets url from Spotify with the Authorization header auth and
+// unmarshals its JSON body into v.
+func (n *spotifyNetworkMapper) getJSON(ctx context.Context, url, auth string, v interface{}) error {
+
+    req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Authorization", auth)
+
+    n.quota.Take()
+    r, err := n.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer r.Body.Close()
+
+    body, err := ioutil.ReadAll(r.Body)
+    if err != nil {
+        return err
+    }
+    if r.StatusCode != http.StatusOK {
+        return fmt.Errorf("spotify: %s %s", r.Request.URL.Path, r.Status)
+    }
+
+    return json.Unmarshal(body, v)
+}
+
+// spotifyArtist is a Spotify artist as the API returns it.
+type spotifyArtist struct {
+    Id string `json:"id"`
+    Name string `json:"name"`
+    Followers struct {
+        Total int `json:"total"`
+    } `json:"followers"`
+    Genres []string `json:"genres"`
+    Images []struct {
+        URL string `json:"url"`
+    } `json:"images"`
+}
+
+// profile returns the Profile of a.
+func (a *spotifyArtist) profile() *Profile {
+    p := &Profile{FullName: a.Name, Followers: a.Followers.Total}
+    if len(a.Images) > 0 {
+        p.AvatarURL = a.Images[0].URL
+    }
+    if len(a.Genres) > 0 {
+        p.Genre = a.Genres[0]
+    }
+    return p
+}
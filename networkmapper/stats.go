@@ -0,0 +1,57 @@
+// stats.go contains the summary statistics of a network, so a summary can
+// be shown without walking the whole network
+
+package networkmapper
+
+// A type for the summary statistics of a network as it was built.
+type Stats struct {
+    Nodes int `json:"nodes"`
+    Links int `json:"links"`
+    Density float64 `json:"density" doc:"The links as a fraction of those possible between the nodes"`
+    AverageDegree float64 `json:"average_degree" doc:"The average links per node"`
+    MostShared string `json:"most_shared,omitempty" doc:"The following followed by the most of the users, or the follower following the most of them"`
+    MostSharedBy int `json:"most_shared_by,omitempty" doc:"How many of the users most_shared is shared by"`
+    MutualUsers int `json:"mutual_users,omitempty" doc:"How many pairs of the users follow each other"`
+}
+
+// ComputeStats returns the summary statistics of r. Links are directed,
+// so density is out of the n(n - 1) links possible among n nodes.
+func (r *Result) ComputeStats() *Stats {
+
+    s := &Stats{Nodes: len(r.Nodes), Links: len(r.Links)}
+    if s.Nodes == 0 {
+        return s
+    }
+
+    s.AverageDegree = 2 * float64(s.Links) / float64(s.Nodes)
+    if s.Nodes > 1 {
+        s.Density = float64(s.Links) / float64(s.Nodes * (s.Nodes - 1))
+    }
+
+    // Find the following most of the users follow, and the users who
+    // follow each other, whose links are each counted twice
+    shared := make([]int, len(r.Nodes))
+    best := -1
+    mutual := 0
+    for _, l := range r.Links {
+        if l.Mutual && r.Nodes[l.Source].Group == 1 && r.Nodes[l.Target].Group == 1 {
+            mutual++
+        }
+
+        user, other := r.ends(l)
+        if r.Nodes[user].Group != 1 || r.Nodes[other].Group == 1 {
+            continue
+        }
+        shared[other]++
+        if best < 0 || shared[other] > shared[best] {
+            best = other
+        }
+    }
+    if best >= 0 {
+        s.MostShared = r.Nodes[best].Name
+        s.MostSharedBy = shared[best]
+    }
+    s.MutualUsers = mutual / 2
+
+    return s
+}
@@ -0,0 +1,107 @@
+// stream.go contains the streaming of a user's followings a page at a
+// time as they're fetched
+
+package networkmapper
+
+import (
+    "context"
+    "encoding/json"
+    "io/ioutil"
+    "strconv"
+)
+
+// A type that satisfies networkmapper.FollowingsStreamer can send a
+// user's followings a page at a time, so callers can start on them before
+// they've all been fetched.
+type FollowingsStreamer interface {
+
+    // Gets a channel of the pages of followings of a given user and one
+    // for why they stopped early, if they did
+    StreamFollowings(ctx context.Context, user string) (<-chan []string, <-chan error)
+
+}
+
+// StreamFollowings sends each page of the followings of user as it's
+// fetched, following SoundCloud's cursors from page to page. Once the
+// pages are closed, the error channel has a *FetchError if a page couldn't
+// be fetched, ctx's error if ctx was done first, and is then closed.
+// Pages are sent in order and belong to the receiver.
+func (n *networkMapper) StreamFollowings(ctx context.Context, user string) (<-chan []string, <-chan error) {
+
+    pages := make(chan []string)
+    errc := make(chan error, 1)
+
+    go func() {
+        defer close(errc)
+        defer close(pages)
+
+        url := n.baseURL + `/users/` + user + `/` + n.Relation() + `.json?client_id=` +
+               n.clientId + `&limit=` + strconv.Itoa(n.numResults) +
+               `&linked_partitioning=1`
+
+        for page := 0; url != ""; page++ {
+
+            // The pages after one that fails can't be found without its
+            // cursor
+            fail := func(err error) {
+                if ctx.Err() != nil {
+                    errc <- ctx.Err()
+                    return
+                }
+                errc <- &FetchError{User: user, Page: page, Message: err.Error()}
+            }
+
+            r, err := n.get(ctx, url)
+            if err != nil {
+                fail(err)
+                return
+            }
+
+            // SoundCloud turned the request away, for good or until the
+            // quota resets
+            if r.StatusCode >= 400 {
+                r.Body.Close()
+                errc <- &FetchError{User: user, Page: page, Status: r.StatusCode, Message: r.Status}
+                return
+            }
+
+            body, err := ioutil.ReadAll(r.Body)
+            r.Body.Close()
+            if err != nil {
+                fail(err)
+                return
+            }
+
+            // A page of followings and the cursor to the next. Each page is
+            // decoded into its own buffer and handed off whole, so nothing
+            // is shared with the receiver or the fetches of other users
+            var p struct {
+                Collection []scUser `json:"collection"`
+                NextHref string `json:"next_href"`
+            }
+            if err = json.Unmarshal(body, &p); err != nil {
+                fail(err)
+                return
+            }
+
+            // Keep the ids and profiles on the page for later
+            followings := make([]string, len(p.Collection))
+            for i := range p.Collection {
+                followings[i] = p.Collection[i].Permalink
+                n.remember(&p.Collection[i])
+            }
+
+            hooksFrom(ctx).OnPageFetched(user, page, len(followings))
+
+            select {
+            case pages <- followings:
+            case <-ctx.Done():
+                errc <- ctx.Err()
+                return
+            }
+            url = n.rebase(p.NextHref)
+        }
+    } ()
+
+    return pages, errc
+}
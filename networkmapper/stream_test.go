@@ -0,0 +1,109 @@
+package networkmapper
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+)
+
+const (
+    testPages = 3
+    testPageSize = 50
+)
+
+// newFollowingsServer starts a fake SoundCloud API where every user
+// follows testPages pages of the same accounts, shared-0, shared-1, ...,
+// linked from page to page by cursor.
+func newFollowingsServer(t *testing.T) *httptest.Server {
+    var ts *httptest.Server
+    ts = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+        parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+        if len(parts) != 3 || parts[0] != "users" || parts[2] != "followings.json" {
+            http.NotFound(rw, r)
+            return
+        }
+
+        page, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+        collection := []map[string]interface{}{}
+        for i := page * testPageSize; i < (page + 1) * testPageSize; i++ {
+            collection = append(collection, map[string]interface{}{"id": i + 1, "permalink": "shared-" + strconv.Itoa(i)})
+        }
+
+        next := ""
+        if page + 1 < testPages {
+            next = fmt.Sprintf("%s/users/%s/followings.json?linked_partitioning=1&cursor=%d", ts.URL, parts[1], page + 1)
+        }
+
+        json.NewEncoder(rw).Encode(map[string]interface{}{"collection": collection, "next_href": next})
+    }))
+    t.Cleanup(ts.Close)
+    return ts
+}
+
+func TestStreamFollowingsPages(t *testing.T) {
+    ts := newFollowingsServer(t)
+    n := NewNetworkMapper("id", testPageSize, WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+    streamer := n.(FollowingsStreamer)
+
+    // Stream several users at once, as a build does
+    users := []string{"alice", "bob", "carol", "dave"}
+    var wg sync.WaitGroup
+    for _, u := range users {
+        wg.Add(1)
+        go func(u string) {
+            defer wg.Done()
+
+            pages, errc := streamer.StreamFollowings(context.Background(), u)
+            got := []string{}
+            for p := range pages {
+                got = append(got, p...)
+            }
+            if err := <-errc; err != nil {
+                t.Errorf("%s: %v", u, err)
+                return
+            }
+
+            if len(got) != testPages * testPageSize {
+                t.Errorf("%s: got %d followings, want %d", u, len(got), testPages * testPageSize)
+                return
+            }
+            for i, f := range got {
+                if want := "shared-" + strconv.Itoa(i); f != want {
+                    t.Errorf("%s: following %d is %s, want %s", u, i, f, want)
+                    return
+                }
+            }
+        } (u)
+    }
+    wg.Wait()
+}
+
+func TestBuildNetworkMapPages(t *testing.T) {
+    ts := newFollowingsServer(t)
+    n := NewNetworkMapper("id", testPageSize, WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+
+    users := []string{"alice", "bob", "carol"}
+    js, err := BuildNetworkMap(context.Background(), n, users)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var result Result
+    if err = json.Unmarshal(js, &result); err != nil {
+        t.Fatal(err)
+    }
+
+    // Every account is followed by all of the users
+    if want := len(users) + testPages * testPageSize; len(result.Nodes) != want {
+        t.Errorf("got %d nodes, want %d", len(result.Nodes), want)
+    }
+    if want := len(users) * testPages * testPageSize; len(result.Links) != want {
+        t.Errorf("got %d links, want %d", len(result.Links), want)
+    }
+}
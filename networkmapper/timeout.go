@@ -0,0 +1,77 @@
+// timeout.go contains the timeouts on calls to sources, so a stuck
+// connection can't hold up a build forever
+
+package networkmapper
+
+import (
+    "context"
+    "net"
+    "net/http"
+    "time"
+)
+
+const (
+    // How long to wait to connect to SoundCloud, and then for it to
+    // respond, unless WithTimeouts says
+    defaultConnectTimeout = 10 * time.Second
+    defaultReadTimeout = 30 * time.Second
+)
+
+// A type that satisfies networkmapper.BuildTimeouter limits how long a
+// build with it may take in all.
+type BuildTimeouter interface {
+
+    // Gets the longest a build may take, or 0 for no limit
+    BuildTimeout() time.Duration
+
+}
+
+// WithTimeouts makes the NetworkMapper give up on connecting to SoundCloud
+// after connect, and on a response after read. They don't apply to a
+// client given with WithHTTPClient, which brings its own.
+func WithTimeouts(connect, read time.Duration) Option {
+    return func(n *networkMapper) {
+        if connect > 0 {
+            n.connectTimeout = connect
+        }
+        if read > 0 {
+            n.readTimeout = read
+        }
+    }
+}
+
+// WithBuildTimeout makes builds with the NetworkMapper fail with
+// context.DeadlineExceeded if they take longer than d in all. 0 is no
+// limit.
+func WithBuildTimeout(d time.Duration) Option {
+    return func(n *networkMapper) {
+        n.buildTimeout = d
+    }
+}
+
+// BuildTimeout returns the longest a build may take, or 0 for no limit.
+func (n *networkMapper) BuildTimeout() time.Duration {
+    return n.buildTimeout
+}
+
+/* Helpers */
+
+// newTimeoutClient creates an http.Client that gives up connecting after
+// connect, waiting for response headers after read, and on the whole
+// request after both.
+func newTimeoutClient(connect, read time.Duration) *http.Client {
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    transport.DialContext = (&net.Dialer{Timeout: connect, KeepAlive: 30 * time.Second}).DialContext
+    transport.TLSHandshakeTimeout = connect
+    transport.ResponseHeaderTimeout = read
+
+    return &http.Client{Transport: transport, Timeout: connect + read}
+}
+
+// withBuildTimeout returns ctx limited to n's BuildTimeout, if it has one.
+func withBuildTimeout(ctx context.Context, n NetworkMapper) (context.Context, context.CancelFunc) {
+    if t, ok := n.(BuildTimeouter); ok && t.BuildTimeout() > 0 {
+        return context.WithTimeout(ctx, t.BuildTimeout())
+    }
+    return context.WithCancel(ctx)
+}
@@ -0,0 +1,133 @@
+// tracks.go contains NetworkMappers for the tracks SoundCloud users have
+// liked or reposted, comparing their taste track by track rather than by
+// who they follow
+
+package networkmapper
+
+import (
+    "context"
+    "encoding/json"
+    "io/ioutil"
+    "strconv"
+)
+
+// The collections of tracks a trackMapper can fetch.
+const (
+    likesCollection = "favorites"
+    repostsCollection = "track_reposts"
+)
+
+// trackMapper is a NetworkMapper whose followings are the tracks in a
+// collection of a SoundCloud user's, such as those they've liked, or the
+// artists of those tracks.
+type trackMapper struct {
+    n *networkMapper
+    collection string
+    artists bool
+}
+
+// scTrack is a SoundCloud track as the API returns it.
+type scTrack struct {
+    Permalink string `json:"permalink"`
+    Genre string `json:"genre"`
+    LabelName string `json:"label_name"`
+    User struct {
+        Permalink string `json:"permalink"`
+    } `json:"user"`
+}
+
+// NewLikesMapper creates a NetworkMapper from n, a SoundCloud
+// NetworkMapper, whose followings are the tracks a user has liked, named
+// artist/track, or if artists is set the artists of those tracks. It
+// fetches through n, sharing its quota and rate limits. It returns n and
+// false if n isn't a SoundCloud NetworkMapper.
+func NewLikesMapper(n NetworkMapper, artists bool) (NetworkMapper, bool) {
+    sc, ok := n.(*networkMapper)
+    if !ok {
+        return n, false
+    }
+    return &trackMapper{n: sc, collection: likesCollection, artists: artists}, true
+}
+
+// NewRepostsMapper creates a NetworkMapper from n, a SoundCloud
+// NetworkMapper, whose followings are the artists of the tracks a user has
+// reposted. It fetches through n, sharing its quota and rate limits. It
+// returns n and false if n isn't a SoundCloud NetworkMapper.
+func NewRepostsMapper(n NetworkMapper) (NetworkMapper, bool) {
+    sc, ok := n.(*networkMapper)
+    if !ok {
+        return n, false
+    }
+    return &trackMapper{n: sc, collection: repostsCollection, artists: true}, true
+}
+
+// GetFollowings returns the tracks in user's collection, or their
+// artists, each once, following SoundCloud's cursors from page to page. It
+// returns what it has if ctx is done first or a page can't be fetched.
+func (m *trackMapper) GetFollowings(ctx context.Context, user string) []string {
+
+    url := m.n.baseURL + `/users/` + user + `/` + m.collection + `.json?client_id=` + m.n.clientId +
+           `&limit=` + strconv.Itoa(m.n.numResults) + `&linked_partitioning=1`
+
+    tracks := []string{}
+    seen := make(map[string]bool)
+
+    for url != "" {
+        r, err := m.n.get(ctx, url)
+        if err != nil {
+            return tracks
+        }
+
+        body, err := ioutil.ReadAll(r.Body)
+        r.Body.Close()
+        if err != nil {
+            return tracks
+        }
+
+        // A page of tracks and the cursor to the next. Reposts wrap the
+        // track reposted
+        var p struct {
+            Collection []struct {
+                scTrack
+                Track *scTrack `json:"track"`
+            } `json:"collection"`
+            NextHref string `json:"next_href"`
+        }
+        if err = json.Unmarshal(body, &p); err != nil {
+            return tracks
+        }
+
+        for _, item := range p.Collection {
+            t := item.scTrack
+            if item.Track != nil {
+                t = *item.Track
+            }
+
+            track := t.User.Permalink
+            if !m.artists {
+                track += "/" + t.Permalink
+            }
+            if t.User.Permalink == "" || seen[track] {
+                continue
+            }
+            seen[track] = true
+            tracks = append(tracks, track)
+        }
+
+        url = m.n.rebase(p.NextHref)
+    }
+
+    return tracks[0:]
+}
+
+// WithPageSize returns a copy of m that fetches size tracks per page.
+func (m *trackMapper) WithPageSize(size int) NetworkMapper {
+    c := *m
+    c.n = m.n.WithPageSize(size).(*networkMapper)
+    return &c
+}
+
+// MaxPageSize returns the most tracks SoundCloud returns per page.
+func (m *trackMapper) MaxPageSize() int {
+    return maxSoundCloudPageSize
+}
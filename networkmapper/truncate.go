@@ -0,0 +1,126 @@
+// truncate.go contains functions for limiting the size of Results
+
+package networkmapper
+
+import (
+    "sort"
+)
+
+// Degrees returns the number of links to or from each node of r.
+func (r *Result) Degrees() []int {
+    degrees := make([]int, len(r.Nodes))
+    for _, l := range r.Links {
+        degrees[l.Source]++
+        degrees[l.Target]++
+    }
+    return degrees[0:]
+}
+
+// Truncate returns r if its JSON is at most maxSize bytes. Otherwise it
+// returns a copy without the lowest degree nodes, followings before given
+// users, that fits, recording what was omitted in its Meta along with
+// full, a link to the untruncated network.
+func (r *Result) Truncate(maxSize int, full string) (*Result, error) {
+
+    size, err := jsonSize(r)
+    if err != nil || size <= maxSize {
+        return r, err
+    }
+
+    // Order the nodes by how much they should be kept
+    order := r.keepOrder(r.Degrees())
+
+    // truncated returns r keeping only the first count nodes of order,
+    // reusing keep between calls
+    keep := make([]bool, len(r.Nodes))
+    truncated := func(count int) *Result {
+        t := r.keepFirst(order, count, keep)
+        t.Meta.Full = full
+        return t
+    }
+
+    // Binary search for the most nodes that fit
+    lo, hi := 0, len(order) - 1
+    for lo < hi {
+        mid := (lo + hi + 1) / 2
+        size, err := jsonSize(truncated(mid))
+        if err != nil {
+            return nil, err
+        }
+        if size <= maxSize {
+            lo = mid
+        } else {
+            hi = mid - 1
+        }
+    }
+
+    return truncated(lo), nil
+}
+
+// TopByDegree returns r if it has at most count nodes. Otherwise it returns
+// a copy with only count of them, the users being compared first and then
+// the followings with the most links, counted by weight in weighted
+// networks, and the links between them, recording what was omitted in its
+// Meta. Nodes keep their order from r.
+func (r *Result) TopByDegree(count int) *Result {
+
+    if len(r.Nodes) <= count {
+        return r
+    }
+
+    degrees := make([]int, len(r.Nodes))
+    for _, l := range r.Links {
+        weight := l.Weight
+        if weight == 0 {
+            weight = 1
+        }
+        degrees[l.Source] += weight
+        degrees[l.Target] += weight
+    }
+
+    return r.keepFirst(r.keepOrder(degrees), count, make([]bool, len(r.Nodes)))
+}
+
+/* Helpers */
+
+// keepOrder returns the indexes of the nodes of r in the order they should
+// be kept in: the users being compared first, then by degrees, highest
+// first.
+func (r *Result) keepOrder(degrees []int) []int {
+
+    order := make([]int, len(r.Nodes))
+    for i := range order {
+        order[i] = i
+    }
+    sort.SliceStable(order, func(a, b int) bool {
+        na, nb := r.Nodes[order[a]], r.Nodes[order[b]]
+        if (na.Group == 1) != (nb.Group == 1) {
+            return na.Group == 1
+        }
+        return degrees[order[a]] > degrees[order[b]]
+    })
+    return order
+}
+
+// keepFirst returns r keeping only the first count nodes of order and the
+// links between them, recording what was omitted in its Meta. keep is
+// reused between calls.
+func (r *Result) keepFirst(order []int, count int, keep []bool) *Result {
+
+    for i := range keep {
+        keep[i] = false
+    }
+    for _, i := range order[:count] {
+        keep[i] = true
+    }
+
+    t := r.keepNodes(keep)
+    t.Meta = &Meta{}
+    if r.Meta != nil {
+        *t.Meta = *r.Meta
+    }
+    t.Meta.Truncated = true
+    t.Meta.OmittedNodes += len(r.Nodes) - len(t.Nodes)
+    t.Meta.OmittedLinks += len(r.Links) - len(t.Links)
+    return t
+}
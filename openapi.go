@@ -0,0 +1,119 @@
+// openapi.go contains the generation of cumuli's OpenAPI specification
+// from the route registry
+
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "reflect"
+    "strconv"
+    "strings"
+    "sync"
+
+    "github.com/lkvnstrs/cumuli/networkmapper"
+)
+
+var (
+    openAPISpec []byte
+    openAPIOnce sync.Once
+)
+
+// OpenAPIHandler handles the route '/api/v1/openapi.json', describing the
+// API in OpenAPI 3.
+func OpenAPIHandler(rw http.ResponseWriter, r *http.Request) {
+
+    openAPIOnce.Do(func() {
+        openAPISpec, _ = json.Marshal(OpenAPI(routes()))
+    })
+
+    rw.Header().Set("Content-Type", "application/json")
+    rw.Write(openAPISpec)
+}
+
+// OpenAPI returns the OpenAPI 3 document for the operations of routes.
+func OpenAPI(routes []route) map[string]interface{} {
+
+    schemas := make(map[string]interface{})
+    paths := make(map[string]interface{})
+
+    for _, rt := range routes {
+        for _, op := range rt.Ops {
+
+            item, ok := paths[op.Path].(map[string]interface{})
+            if !ok {
+                item = make(map[string]interface{})
+                paths[op.Path] = item
+            }
+
+            params := []interface{}{}
+            for _, p := range op.Params {
+                schema := map[string]interface{}{"type": p.Type}
+                if len(p.Enum) > 0 {
+                    schema["enum"] = p.Enum
+                }
+                if p.Default != nil {
+                    schema["default"] = p.Default
+                }
+                params = append(params, map[string]interface{}{
+                    "name": p.Name,
+                    "in": p.In,
+                    "description": p.Description,
+                    "required": p.Required,
+                    "schema": schema,
+                })
+            }
+
+            // Describe the successful response
+            content := make(map[string]interface{})
+            if op.Response != nil {
+                content["application/json"] = map[string]interface{}{
+                    "schema": networkmapper.SchemaOf(reflect.TypeOf(op.Response), "#/components/schemas/", schemas),
+                }
+            }
+            for _, t := range op.ResponseTypes {
+                content[t] = map[string]interface{}{}
+            }
+
+            operation := map[string]interface{}{
+                "summary": op.Summary,
+                "parameters": params,
+                "responses": map[string]interface{}{
+                    strconv.Itoa(op.Status): map[string]interface{}{
+                        "description": http.StatusText(op.Status),
+                        "content": content,
+                    },
+                    "default": map[string]interface{}{
+                        "description": "An error",
+                        "content": map[string]interface{}{
+                            "application/json": map[string]interface{}{
+                                "schema": networkmapper.SchemaOf(reflect.TypeOf(ErrorResponse{}), "#/components/schemas/", schemas),
+                            },
+                        },
+                    },
+                },
+            }
+
+            if len(op.Body) > 0 {
+                body := make(map[string]interface{})
+                for _, t := range op.Body {
+                    body[t] = map[string]interface{}{}
+                }
+                operation["requestBody"] = map[string]interface{}{"required": true, "content": body}
+            }
+
+            item[strings.ToLower(op.Method)] = operation
+        }
+    }
+
+    return map[string]interface{}{
+        "openapi": "3.0.3",
+        "info": map[string]interface{}{
+            "title": "cumuli",
+            "description": "Map SoundCloud followings",
+            "version": "1",
+        },
+        "paths": paths,
+        "components": map[string]interface{}{"schemas": schemas},
+    }
+}
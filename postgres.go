@@ -0,0 +1,198 @@
+// postgres.go contains the GraphStore kept in Postgres, where every
+// snapshot can be kept for querying a network's history
+
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "time"
+
+    "github.com/lib/pq"
+)
+
+// The tables of the Postgres store, created if they don't exist
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS graphs (
+    id text PRIMARY KEY,
+    source text NOT NULL,
+    users text[] NOT NULL,
+    graph jsonb NOT NULL,
+    saved timestamptz NOT NULL
+);
+CREATE TABLE IF NOT EXISTS snapshots (
+    graph_id text NOT NULL REFERENCES graphs (id) ON DELETE CASCADE,
+    taken_at timestamptz NOT NULL,
+    nodes integer NOT NULL,
+    links integer NOT NULL,
+    partial boolean NOT NULL,
+    network jsonb NOT NULL,
+    PRIMARY KEY (graph_id, taken_at)
+);
+CREATE TABLE IF NOT EXISTS tokens (
+    source text NOT NULL,
+    account text NOT NULL,
+    token text NOT NULL,
+    PRIMARY KEY (source, account)
+);
+`
+
+// postgresGraphs is a GraphStore in a Postgres database.
+type postgresGraphs struct {
+    db *sql.DB
+}
+
+// newPostgresGraphs connects to the Postgres database at url, creating
+// the store's tables if they don't exist.
+func newPostgresGraphs(url string) (*postgresGraphs, error) {
+
+    db, err := sql.Open("postgres", url)
+    if err != nil {
+        return nil, err
+    }
+    if _, err = db.Exec(postgresSchema); err != nil {
+        db.Close()
+        return nil, err
+    }
+    return &postgresGraphs{db}, nil
+}
+
+// AddGraph saves graph, marshaled as gjs, unless it's already saved.
+func (s *postgresGraphs) AddGraph(graph *Graph, gjs []byte) error {
+    _, err := s.db.Exec(`INSERT INTO graphs (id, source, users, graph, saved) VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (id) DO NOTHING`, graph.Id, graph.Source, pq.Array(graph.Users), string(gjs), graph.Saved)
+    return err
+}
+
+// GetGraph returns the saved graph with the given id, or nil if there is
+// no such graph.
+func (s *postgresGraphs) GetGraph(id string) (*Graph, error) {
+
+    var js []byte
+    err := s.db.QueryRow(`SELECT graph FROM graphs WHERE id = $1`, id).Scan(&js)
+    if err == sql.ErrNoRows {
+        return nil, nil
+    } else if err != nil {
+        return nil, err
+    }
+
+    var graph Graph
+    if err = json.Unmarshal(js, &graph); err != nil {
+        return nil, err
+    }
+    return &graph, nil
+}
+
+// AddSnapshot adds js as the newest snapshot of the saved graph with the
+// given id, dropping the oldest beyond max, unless max is negative.
+func (s *postgresGraphs) AddSnapshot(id string, snapshot Snapshot, js []byte, max int) error {
+
+    tx, err := s.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    _, err = tx.Exec(`INSERT INTO snapshots (graph_id, taken_at, nodes, links, partial, network)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        ON CONFLICT (graph_id, taken_at) DO UPDATE
+        SET nodes = excluded.nodes, links = excluded.links, partial = excluded.partial, network = excluded.network`,
+        id, snapshot.At, snapshot.Nodes, snapshot.Links, snapshot.Partial, string(js))
+    if err != nil {
+        return err
+    }
+
+    // Drop the oldest snapshots
+    if max >= 0 {
+        _, err = tx.Exec(`DELETE FROM snapshots WHERE graph_id = $1 AND taken_at NOT IN (
+            SELECT taken_at FROM snapshots WHERE graph_id = $1 ORDER BY taken_at DESC LIMIT $2)`, id, max)
+        if err != nil {
+            return err
+        }
+    }
+
+    return tx.Commit()
+}
+
+// GetHistory returns the snapshots of the saved graph with the given id,
+// newest first.
+func (s *postgresGraphs) GetHistory(id string) ([]Snapshot, error) {
+
+    rows, err := s.db.Query(`SELECT taken_at, nodes, links, partial FROM snapshots
+        WHERE graph_id = $1 ORDER BY taken_at DESC`, id)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    history := []Snapshot{}
+    for rows.Next() {
+        var snapshot Snapshot
+        if err = rows.Scan(&snapshot.At, &snapshot.Nodes, &snapshot.Links, &snapshot.Partial); err != nil {
+            return nil, err
+        }
+        snapshot.At = snapshot.At.UTC()
+        snapshot.Timestamp = snapshot.At.Unix()
+        history = append(history, snapshot)
+    }
+    return history, rows.Err()
+}
+
+// GetSnapshot returns the network of the saved graph with the given id as
+// of its latest snapshot at or before at, or nil if there isn't one.
+func (s *postgresGraphs) GetSnapshot(id string, at time.Time) ([]byte, error) {
+
+    var js []byte
+    err := s.db.QueryRow(`SELECT network FROM snapshots WHERE graph_id = $1 AND taken_at <= $2
+        ORDER BY taken_at DESC LIMIT 1`, id, at).Scan(&js)
+    if err == sql.ErrNoRows {
+        return nil, nil
+    }
+    return js, err
+}
+
+// ListGraphs returns the ids of every saved graph.
+func (s *postgresGraphs) ListGraphs() ([]string, error) {
+
+    rows, err := s.db.Query(`SELECT id FROM graphs`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    ids := []string{}
+    for rows.Next() {
+        var id string
+        if err = rows.Scan(&id); err != nil {
+            return nil, err
+        }
+        ids = append(ids, id)
+    }
+    return ids, rows.Err()
+}
+
+// postgresTokens keeps the refresh tokens of a source's connected accounts
+// in a Postgres database.
+type postgresTokens struct {
+    db *sql.DB
+    source string
+}
+
+// GetRefreshToken returns the refresh token of user, or "" if they
+// haven't connected their account.
+func (t postgresTokens) GetRefreshToken(user string) (string, error) {
+
+    var token string
+    err := t.db.QueryRow(`SELECT token FROM tokens WHERE source = $1 AND account = $2`, t.source, user).Scan(&token)
+    if err == sql.ErrNoRows {
+        return "", nil
+    }
+    return token, err
+}
+
+// PutRefreshToken replaces the refresh token of user.
+func (t postgresTokens) PutRefreshToken(user, token string) error {
+    _, err := t.db.Exec(`INSERT INTO tokens (source, account, token) VALUES ($1, $2, $3)
+        ON CONFLICT (source, account) DO UPDATE SET token = EXCLUDED.token`, t.source, user, token)
+    return err
+}
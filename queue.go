@@ -0,0 +1,265 @@
+// queue.go contains the queue of network builds waiting for a worker.
+//
+// Builds are run cheapest first, so one huge build can't hold up everyone
+// else, and one worker only runs interactive builds, which someone is
+// waiting on, so they always have a worker even when the others are busy
+// with background jobs.
+
+package main
+
+import (
+    "container/heap"
+    "context"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/lkvnstrs/cumuli/networkmapper"
+)
+
+// A type for a network build waiting for a worker.
+type build struct {
+    ctx context.Context
+    mapper networkmapper.NetworkMapper
+    users []string
+    chunkSize int
+    budget time.Duration
+    cost int
+    interactive bool
+    seq int
+
+    // Called by the worker, if set, when it starts the build
+    start func()
+
+    // Called by the worker with the result of the build
+    finish func(js []byte, err error)
+}
+
+// A type for the error returned when there are too many builds waiting to
+// take another.
+type QueueFullError struct {
+
+    // An estimate of how long until there will be room
+    RetryAfter time.Duration
+}
+
+func (e *QueueFullError) Error() string {
+    return "too many networks are being built, try again in " +
+        strconv.Itoa(int(e.RetryAfter.Seconds())) + " seconds"
+}
+
+// buildHeap is a heap of builds, cheapest and then oldest first.
+type buildHeap []*build
+
+func (h buildHeap) Len() int { return len(h) }
+func (h buildHeap) Less(i, j int) bool { return h[i].before(h[j]) }
+func (h buildHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *buildHeap) Push(x interface{}) { *h = append(*h, x.(*build)) }
+func (h *buildHeap) Pop() interface{} {
+    old := *h
+    b := old[len(old) - 1]
+    *h = old[:len(old) - 1]
+    return b
+}
+
+// before reports whether b should be built before o.
+func (b *build) before(o *build) bool {
+    if b.cost != o.cost {
+        return b.cost < o.cost
+    }
+    return b.seq < o.seq
+}
+
+// buildQueue is a priority queue of builds with a pool of workers.
+type buildQueue struct {
+    mu sync.Mutex
+    ready *sync.Cond
+    interactive buildHeap
+    background buildHeap
+    seq int
+
+    workers int
+    maxInteractive int
+    maxBackground int
+    budget time.Duration
+
+    // A moving average of how long builds take
+    avgDuration time.Duration
+}
+
+// NewBuildQueue creates a buildQueue and starts its workers, one of which
+// is reserved for interactive builds unless it's the only one. At most maxInteractive interactive
+// and maxBackground background builds may wait for a worker at once.
+// Interactive builds stop fetching after budget, if it isn't 0.
+func NewBuildQueue(workers, maxInteractive, maxBackground int, budget time.Duration) *buildQueue {
+    q := &buildQueue{
+        workers: workers,
+        maxInteractive: maxInteractive,
+        maxBackground: maxBackground,
+        budget: budget,
+        avgDuration: 10 * time.Second,
+    }
+    q.ready = sync.NewCond(&q.mu)
+
+    // A lone worker takes background builds too, or they'd never run
+    go q.work(workers > 1)
+    for i := 1; i < workers; i++ {
+        go q.work(false)
+    }
+
+    return q
+}
+
+// Build builds the network for users with m, waiting for a worker first.
+// It returns a *QueueFullError if too many builds are already waiting, and
+// ctx's error if ctx is done before the build finishes.
+func (q *buildQueue) Build(ctx context.Context, m networkmapper.NetworkMapper, users []string) ([]byte, error) {
+
+    type result struct {
+        js []byte
+        err error
+    }
+    done := make(chan result, 1)
+
+    // Turn the build away before estimating its cost, which may call the
+    // source, if there's no room for it anyway
+    if err := q.full(true); err != nil {
+        return nil, err
+    }
+
+    err := q.push(&build{
+        ctx: ctx,
+        mapper: m,
+        users: users[0:],
+        chunkSize: len(users),
+        budget: q.budget,
+        cost: networkmapper.EstimateCost(ctx, m, users[0:]),
+        interactive: true,
+        finish: func(js []byte, err error) {
+            done <- result{js, err}
+        },
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    // A build no one waits for anymore is skipped by the worker that pops
+    // it, or given up by BuildNetworkMapBudget if it has started
+    select {
+    case r := <-done:
+        return r.js, r.err
+    case <-ctx.Done():
+        return nil, ctx.Err()
+    }
+}
+
+// full returns a *QueueFullError if there's no room for another
+// interactive build, or background build if not interactive.
+func (q *buildQueue) full(interactive bool) error {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    waiting, max := len(q.background), q.maxBackground
+    if interactive {
+        waiting, max = len(q.interactive), q.maxInteractive
+    }
+
+    if waiting >= max {
+        return &QueueFullError{RetryAfter: q.retryAfter(waiting)}
+    }
+    return nil
+}
+
+// idle reports whether no background builds are waiting for a worker.
+func (q *buildQueue) idle() bool {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    return len(q.background) == 0
+}
+
+// wait estimates how long until the first of waiting builds starts.
+func (q *buildQueue) wait(waiting int) time.Duration {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    return q.retryAfter(waiting)
+}
+
+// push adds b to the queue and wakes the workers, or returns a
+// *QueueFullError if there's no room for it.
+func (q *buildQueue) push(b *build) error {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    h, max := &q.background, q.maxBackground
+    if b.interactive {
+        h, max = &q.interactive, q.maxInteractive
+    }
+
+    if len(*h) >= max {
+        return &QueueFullError{RetryAfter: q.retryAfter(len(*h))}
+    }
+
+    b.seq = q.seq
+    q.seq++
+    heap.Push(h, b)
+
+    // Broadcast, since a woken reserved worker can't take background builds
+    q.ready.Broadcast()
+    return nil
+}
+
+// retryAfter estimates how long until the first of waiting builds starts,
+// which q.mu must be held for.
+func (q *buildQueue) retryAfter(waiting int) time.Duration {
+    wait := q.avgDuration * time.Duration(waiting) / time.Duration(q.workers)
+    if wait < time.Second {
+        wait = time.Second
+    }
+    return wait
+}
+
+// pop waits for and removes the next build, only taking interactive builds
+// if reserved.
+func (q *buildQueue) pop(reserved bool) *build {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    for {
+        h := &q.interactive
+        if !reserved && len(q.background) > 0 &&
+            (len(q.interactive) == 0 || q.background[0].before(q.interactive[0])) {
+            h = &q.background
+        }
+
+        if len(*h) > 0 {
+            return heap.Pop(h).(*build)
+        }
+        q.ready.Wait()
+    }
+}
+
+// work runs builds from the queue forever.
+func (q *buildQueue) work(reserved bool) {
+    for {
+        b := q.pop(reserved)
+
+        // Skip builds no one is waiting for anymore
+        if err := b.ctx.Err(); err != nil {
+            b.finish(nil, err)
+            continue
+        }
+
+        if b.start != nil {
+            b.start()
+        }
+
+        start := time.Now()
+        js, err := networkmapper.BuildNetworkMapBudget(b.ctx, b.mapper, b.users, b.chunkSize, b.budget)
+
+        q.mu.Lock()
+        q.avgDuration = (q.avgDuration * 7 + time.Since(start)) / 8
+        q.mu.Unlock()
+
+        b.finish(js, err)
+    }
+}
@@ -0,0 +1,161 @@
+// ratelimit.go contains the rate limits each client is held to on routes
+// that call a source for every request, so one client can't spend the
+// source's quota on everyone's behalf
+
+package main
+
+import (
+    "errors"
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/lkvnstrs/cumuli/networkmapper"
+)
+
+const (
+    MAX_LIMITED_CLIENTS = 10000 // kept before those idle for CLIENT_IDLE_TIME are dropped
+    CLIENT_IDLE_TIME = time.Minute
+)
+
+// A type for the rate limits of each client IP on a route.
+type ClientRateLimits struct {
+    mu sync.Mutex
+    rps float64
+    clients map[string]*clientLimiter
+}
+
+// A type for the rate limit of one client.
+type clientLimiter struct {
+    limiter *networkmapper.RateLimiter
+    last time.Time
+}
+
+// NewClientRateLimits creates ClientRateLimits letting each client make
+// rps requests a second, in bursts of up to as many as are allowed in a
+// second. It returns nil, which lets every request through, if rps isn't
+// positive.
+func NewClientRateLimits(rps float64) *ClientRateLimits {
+    if rps <= 0 {
+        return nil
+    }
+    return &ClientRateLimits{rps: rps, clients: make(map[string]*clientLimiter)}
+}
+
+// Allow returns whether the client at ip may make a request now, and if
+// not, how long until it may.
+func (l *ClientRateLimits) Allow(ip string) (bool, time.Duration) {
+    if l == nil {
+        return true, 0
+    }
+
+    l.mu.Lock()
+    now := time.Now()
+
+    // Forget the clients that have gone quiet, whose buckets are full again
+    if len(l.clients) >= MAX_LIMITED_CLIENTS {
+        for k, c := range l.clients {
+            if now.Sub(c.last) > CLIENT_IDLE_TIME {
+                delete(l.clients, k)
+            }
+        }
+    }
+
+    c, ok := l.clients[ip]
+    if !ok {
+        c = &clientLimiter{limiter: networkmapper.NewRateLimiter(l.rps)}
+        l.clients[ip] = c
+    }
+    c.last = now
+    l.mu.Unlock()
+
+    return c.limiter.Allow()
+}
+
+// RateLimited wraps h so each client is held to limits, answering a 429
+// saying when to retry once it's past them.
+func RateLimited(limits *ClientRateLimits, h http.HandlerFunc) http.HandlerFunc {
+    return func(rw http.ResponseWriter, r *http.Request) {
+        if ok, wait := limits.Allow(clientIP(r)); !ok {
+            setRetryAfter(rw, wait)
+            renderError(rw, http.StatusTooManyRequests, "rate_limited", "too many requests", nil)
+            return
+        }
+        h(rw, r)
+    }
+}
+
+// ParseTrustedProxies parses proxies, IPs or CIDRs, into the networks
+// trusted to say who they're forwarding for.
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+    nets := make([]*net.IPNet, 0, len(proxies))
+    for _, p := range proxies {
+        p = strings.TrimSpace(p)
+        if !strings.Contains(p, "/") {
+            ip := net.ParseIP(p)
+            if ip == nil {
+                return nil, errors.New("invalid trusted proxy " + p)
+            }
+            bits := 8 * net.IPv6len
+            if ip.To4() != nil {
+                ip, bits = ip.To4(), 8 * net.IPv4len
+            }
+            nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+            continue
+        }
+
+        _, n, err := net.ParseCIDR(p)
+        if err != nil {
+            return nil, errors.New("invalid trusted proxy " + p)
+        }
+        nets = append(nets, n)
+    }
+    return nets, nil
+}
+
+/* Helpers */
+
+// clientIP returns the IP of the client that sent r: the address r came
+// from, or if that's a trusted proxy, the last address in X-Forwarded-For
+// that isn't one, as each proxy appends who it's forwarding for. Anyone
+// can send an X-Forwarded-For, so it's only believed from trustedProxies.
+func clientIP(r *http.Request) string {
+    ip, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        ip = r.RemoteAddr
+    }
+
+    forwarded := r.Header.Get("X-Forwarded-For")
+    if forwarded == "" || !isTrustedProxy(ip) {
+        return ip
+    }
+
+    addrs := strings.Split(forwarded, ",")
+    for i := len(addrs) - 1; i >= 0; i-- {
+        addr := strings.TrimSpace(addrs[i])
+        if net.ParseIP(addr) == nil {
+            break
+        }
+        ip = addr
+        if !isTrustedProxy(addr) {
+            break
+        }
+    }
+    return ip
+}
+
+// isTrustedProxy returns whether ip is in trustedProxies.
+func isTrustedProxy(ip string) bool {
+    parsed := net.ParseIP(ip)
+    if parsed == nil {
+        return false
+    }
+    for _, n := range trustedProxies {
+        if n.Contains(parsed) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,333 @@
+// routes.go contains the registry of cumuli's routes. Every route is
+// registered from here, and the OpenAPI spec is generated from the same
+// entries, so the two can't drift apart.
+
+package main
+
+import (
+    "net/http"
+
+    "github.com/lkvnstrs/cumuli/networkmapper"
+)
+
+// A type for a route, handled by Handler for every path under Pattern.
+type route struct {
+    Pattern string
+    Handler http.HandlerFunc
+
+    // The operations documented in the OpenAPI spec; pages have none
+    Ops []operation
+}
+
+// A type for an operation of the API.
+type operation struct {
+    Method string
+    Path string
+    Summary string
+    Params []param
+
+    // The request body's content types, if it takes one
+    Body []string
+
+    // The status of a successful response and a value of its JSON type,
+    // or its content types if it isn't JSON
+    Status int
+    Response interface{}
+    ResponseTypes []string
+}
+
+// A type for a parameter of an operation.
+type param struct {
+    Name string
+    In string // path or query
+    Type string // string, integer or boolean
+    Description string
+    Required bool
+    Enum []string
+    Default interface{} // what's used if it isn't given, if that can be given too
+}
+
+// Parameters shared between operations.
+var (
+    usersPathParam = param{Name: "users", In: "path", Type: "string", Required: true,
+        Description: "The users to compare, separated by '+'; at most max_users of them"}
+    usersListParam = param{Name: "users", In: "query", Type: "string", Required: true,
+        Description: "The users to compare, separated by ',' or '+'; at most max_users of them"}
+    usersQueryParam = param{Name: "users", In: "query", Type: "string", Required: true,
+        Description: "The users of the network, separated by '+' or ','"}
+    sourceParam = param{Name: "source", In: "query", Type: "string",
+        Description: "The source to build from, such as mixcloud, or a ',' separated list to federate; soundcloud by default. platform is accepted in its place"}
+    weightParam = param{Name: "weight", In: "query", Type: "string", Enum: []string{"interactions", "shared"},
+        Description: "How to weight links: by the interactions between their ends, or by how many of the users follow their targets; unweighted by default"}
+    weightedParam = param{Name: "weighted", In: "query", Type: "boolean", Default: false,
+        Description: "Weight links by how many of the users follow their targets, as weight=shared does"}
+    pageSizeParam = param{Name: "page_size", In: "query", Type: "integer",
+        Description: "How many followings to fetch per request, up to the source's maximum"}
+    graphIdParam = param{Name: "id", In: "path", Type: "string", Required: true}
+    relationParam = param{Name: "relation", In: "query", Type: "string", Enum: []string{"followings", "followers", "both"}, Default: "followings",
+        Description: "Build the network from who the users follow, by default, who follows them, or both, with links typed follows or followed_by"}
+    playlistsParam = param{Name: "playlists", In: "query", Type: "boolean", Default: false,
+        Description: "Build the network of the artists in the users' playlists, linked by how many playlists they're both in, instead of their followings"}
+    depthParam = param{Name: "depth", In: "query", Type: "integer", Default: 1,
+        Description: "How many hops out from the users to build the network: 1 by default, or 2 to add the followings of the most shared followings"}
+    pruneParam = param{Name: "prune", In: "query", Type: "boolean", Default: false,
+        Description: "Leave out nodes without links"}
+    minSharedParam = param{Name: "min_shared", In: "query", Type: "integer", Default: 2,
+        Description: "How many of the users must follow an account for it to be a node, from 2 to the number of users; 2 by default"}
+    rankParam = param{Name: "rank", In: "query", Type: "boolean", Default: false,
+        Description: "Give each node its PageRank"}
+    topParam = param{Name: "top", In: "query", Type: "integer",
+        Description: "Keep only this many of the highest ranked nodes, highest first"}
+    minDegreeParam = param{Name: "min_degree", In: "query", Type: "integer",
+        Description: "Only send nodes with at least this many links"}
+    groupsParam = param{Name: "groups", In: "query", Type: "string",
+        Description: "Only send nodes in these groups, a ',' separated list of numbers"}
+    nameParam = param{Name: "name", In: "query", Type: "string",
+        Description: "Only send nodes whose names match this regular expression"}
+    minFollowersParam = param{Name: "min_followers", In: "query", Type: "integer",
+        Description: "Only send nodes with at least this many followers; needs metadata"}
+    maxFollowersParam = param{Name: "max_followers", In: "query", Type: "integer",
+        Description: "Only send nodes with at most this many followers; needs metadata"}
+    maxNodesParam = param{Name: "max_nodes", In: "query", Type: "integer",
+        Description: "Send at most this many nodes, the users and then those with the most links, and the links between them; all of them by default"}
+    directedParam = param{Name: "directed", In: "query", Type: "boolean", Default: false,
+        Description: "Mark links as running from follower to followed, and those followed back as mutual"}
+    groupParam = param{Name: "group", In: "query", Type: "string", Enum: []string{"genre", "label", "country", "city", "followers", "community"},
+        Description: "What to group the followings by for coloring, rather than only telling them from the users, as they are by default"}
+    metadataParam = param{Name: "metadata", In: "query", Type: "boolean", Default: false,
+        Description: "Give each node its account's avatar, name, counts and city"}
+)
+
+// routes returns every route of cumuli.
+func routes() []route {
+
+    // The parameters of a network's options, which /json/ builds and
+    // reshapes it by
+    networkParams := []param{sourceParam, weightParam, weightedParam, pageSizeParam, relationParam, playlistsParam, depthParam, pruneParam, minSharedParam, rankParam, topParam, minDegreeParam, groupsParam, nameParam, minFollowersParam, maxFollowersParam, maxNodesParam, directedParam, metadataParam, groupParam}
+
+    // The parameters of /json/ besides its users, who are either in its
+    // path or in its query
+    jsonParams := append(append([]param{}, networkParams...), []param{
+        {Name: "compat", In: "query", Type: "string", Enum: []string{"v0"},
+            Description: "Emit an older Result format"},
+        {Name: "view", In: "query", Type: "string", Enum: []string{"bundle"},
+            Description: "Emit hierarchical edge bundling data instead of the Result"},
+        {Name: "collapse", In: "query", Type: "string", Enum: []string{"community", "genre", "label"},
+            Description: "Emit a network of super-nodes, one for each community, genre or label, instead of the Result"},
+        {Name: "format", In: "query", Type: "string", Enum: []string{"d3v7", "cytoscape"},
+            Description: "Emit links that refer to nodes by name, for d3-force v4 and later, or Cytoscape.js elements"},
+        {Name: "table", In: "query", Type: "string", Enum: []string{"nodes", "links"}, Default: "links",
+            Description: "The table to send when the Accept header asks for text/csv"}}...)
+
+    return []route{
+        {Pattern: "/", Handler: MainHandler},
+        {Pattern: "/u/", Handler: UserHandler},
+        {Pattern: "/about/", Handler: AboutHandler},
+        {Pattern: "/static/", Handler: CacheControl("static", Compress(StaticHandler))},
+
+        {Pattern: "/json/", Handler: CacheControl("json", Compress(JSONHandler)), Ops: []operation{{
+            Method: "GET", Path: "/json/{users}",
+            Summary: "Build the network of the users' shared followings",
+            Params: append([]param{usersPathParam}, jsonParams...),
+            Status: http.StatusOK, Response: networkmapper.Result{}, ResponseTypes: networkTypes[1:],
+        }, {
+            Method: "GET", Path: "/json/",
+            Summary: "Build the network of the users' shared followings, listed in the query",
+            Params: append([]param{usersListParam}, jsonParams...),
+            Status: http.StatusOK, Response: networkmapper.Result{}, ResponseTypes: networkTypes[1:],
+        }}},
+
+        {Pattern: "/export/", Handler: CacheControl("json", ExportHandler), Ops: []operation{{
+            Method: "GET", Path: "/export/{users}",
+            Summary: "Download the network of the users' shared followings",
+            Params: []param{usersPathParam, sourceParam, weightParam, weightedParam, pageSizeParam, relationParam, playlistsParam, depthParam, pruneParam, minSharedParam, rankParam, topParam, minDegreeParam, groupsParam, nameParam, minFollowersParam, maxFollowersParam, maxNodesParam, directedParam, metadataParam, groupParam,
+                {Name: "format", In: "query", Type: "string", Required: true, Enum: []string{"json", "parquet", "csv", "graphml", "dot"},
+                    Description: "The export format"},
+                {Name: "table", In: "query", Type: "string", Enum: []string{"nodes", "links"},
+                    Description: "The table to export, for parquet, or for csv rather than a zip of both"},
+                {Name: "anonymize", In: "query", Type: "boolean",
+                    Description: "Replace names with stable hashes and leave out profiles and anything else that tells who's who; only if the server has an ANONYMIZE_KEY"}},
+            Status: http.StatusOK, ResponseTypes: []string{"application/json", "application/vnd.apache.parquet",
+                "text/csv", "application/zip", "application/graphml+xml", "text/vnd.graphviz"},
+        }}},
+
+        {Pattern: "/upload/", Handler: CacheControl("private", UploadHandler), Ops: []operation{{
+            Method: "POST", Path: "/upload/",
+            Summary: "Start building the network of an uploaded CSV or text file of usernames",
+            Params: []param{sourceParam},
+            Body: []string{"multipart/form-data", "text/csv", "text/plain"},
+            Status: http.StatusAccepted, Response: Job{},
+        }}},
+
+        {Pattern: "/offline/", Handler: CacheControl("private", OfflineHandler), Ops: []operation{{
+            Method: "POST", Path: "/offline/",
+            Summary: "Build a network from uploaded follow data without calling any source",
+            Params: []param{
+                {Name: "format", In: "query", Type: "string", Enum: []string{"csv", "json"},
+                    Description: "The format of the follow data, guessed if not given"},
+                {Name: "users", In: "query", Type: "string",
+                    Description: "The users to compare, separated by '+'; all of them by default"}},
+            Body: []string{"multipart/form-data", "text/csv", "application/json"},
+            Status: http.StatusOK, Response: networkmapper.Result{},
+        }}},
+
+        {Pattern: "/jobs/", Handler: CacheControl("private", JobHandler), Ops: []operation{{
+            Method: "GET", Path: "/jobs/{id}",
+            Summary: "Get the status of a network build job",
+            Params: []param{{Name: "id", In: "path", Type: "string", Required: true}},
+            Status: http.StatusOK, Response: Job{},
+        }, {
+            Method: "GET", Path: "/jobs/{id}/result",
+            Summary: "Get the network built by a finished job",
+            Params: []param{{Name: "id", In: "path", Type: "string", Required: true}},
+            Status: http.StatusOK, Response: networkmapper.Result{},
+        }}},
+
+        {Pattern: "/api/jobs", Handler: CacheControl("private", JobsAPIHandler)},
+        {Pattern: "/api/jobs/", Handler: CacheControl("private", JobsAPIHandler), Ops: []operation{{
+            Method: "POST", Path: "/api/jobs",
+            Summary: "Queue a build of the users' network, to be fetched once the job is done",
+            Params: []param{usersQueryParam, sourceParam, pageSizeParam,
+                {Name: "relation", In: "query", Type: "string", Enum: []string{"followings", "followers"},
+                    Description: "Build the network from who the users follow, by default, or who follows them"}},
+            Status: http.StatusAccepted, Response: Job{},
+        }, {
+            Method: "GET", Path: "/api/jobs/{id}",
+            Summary: "Get the status of a network build job: queued, running, done or failed",
+            Params: []param{{Name: "id", In: "path", Type: "string", Required: true}},
+            Status: http.StatusOK, Response: Job{},
+        }, {
+            Method: "GET", Path: "/api/jobs/{id}/result",
+            Summary: "Get the network built by a finished job",
+            Params: []param{{Name: "id", In: "path", Type: "string", Required: true}},
+            Status: http.StatusOK, Response: networkmapper.Result{},
+        }}},
+
+        {Pattern: "/g/", Handler: CacheControl("json", GraphHandler), Ops: []operation{{
+            Method: "POST", Path: "/g/",
+            Summary: "Save the graph of the users, keeping a snapshot of its network every time it is rebuilt",
+            Params: []param{usersQueryParam, sourceParam},
+            Status: http.StatusCreated, Response: Graph{},
+        }, {
+            Method: "GET", Path: "/g/{id}",
+            Summary: "Get a saved graph",
+            Params: []param{graphIdParam},
+            Status: http.StatusOK, Response: Graph{},
+        }, {
+            Method: "GET", Path: "/g/{id}/history",
+            Summary: "Get the snapshots of a saved graph's network, newest first",
+            Params: []param{graphIdParam,
+                {Name: "from", In: "query", Type: "string",
+                    Description: "Seconds since the epoch, an RFC 3339 time or a date; only snapshots taken from then, or that day, are listed"},
+                {Name: "to", In: "query", Type: "string",
+                    Description: "Seconds since the epoch, an RFC 3339 time or a date; only snapshots taken until then, or the end of that day, are listed"}},
+            Status: http.StatusOK, Response: []Snapshot{},
+        }, {
+            Method: "GET", Path: "/g/{id}/at/{timestamp}",
+            Summary: "Get a saved graph's network as it was at a time",
+            Params: []param{graphIdParam,
+                {Name: "timestamp", In: "path", Type: "string", Required: true,
+                    Description: "Seconds since the epoch, an RFC 3339 time or a date; the latest snapshot at or before it is returned"}},
+            Status: http.StatusOK, Response: networkmapper.Result{},
+        }, {
+            Method: "GET", Path: "/g/{id}/diff/{from}/{to}",
+            Summary: "Get what changed in a saved graph's network from one time to another",
+            Params: []param{graphIdParam,
+                {Name: "from", In: "path", Type: "string", Required: true,
+                    Description: "Seconds since the epoch, an RFC 3339 time or a date; the latest snapshot at or before it is compared"},
+                {Name: "to", In: "path", Type: "string", Required: true,
+                    Description: "Seconds since the epoch, an RFC 3339 time or a date, or left out with its slash for the latest snapshot"}},
+            Status: http.StatusOK, Response: networkmapper.ResultDiff{},
+        }}},
+
+        {Pattern: "/connect/", Handler: CacheControl("private", ConnectHandler), Ops: []operation{{
+            Method: "GET", Path: "/connect/{source}",
+            Summary: "Connect an account on a source that only tells a user who they follow, such as spotify",
+            Params: []param{{Name: "source", In: "path", Type: "string", Required: true}},
+            Status: http.StatusFound,
+        }, {
+            Method: "GET", Path: "/connect/{source}/callback",
+            Summary: "Finish connecting an account, going on to its network",
+            Params: []param{{Name: "source", In: "path", Type: "string", Required: true},
+                {Name: "code", In: "query", Type: "string"},
+                {Name: "state", In: "query", Type: "string", Required: true}},
+            Status: http.StatusFound,
+        }}},
+
+        {Pattern: "/admin/cache/purge", Handler: CacheControl("admin", AdminOnly(CachePurgeHandler)), Ops: []operation{{
+            Method: "POST", Path: "/admin/cache/purge",
+            Summary: "Drop cached followings or networks, so they're fetched and built again; needs the admin token",
+            Params: []param{sourceParam,
+                {Name: "user", In: "query", Type: "string",
+                    Description: "The users whose cached followings and followers to drop, separated by ','"},
+                {Name: "users", In: "query", Type: "string",
+                    Description: "The users whose cached networks to drop, built with any options, separated by '+'"}},
+            Status: http.StatusOK, Response: Purged{},
+        }}},
+
+        {Pattern: "/api/v1/subgraph", Handler: CacheControl("json", Compress(SubgraphHandler)), Ops: []operation{{
+            Method: "GET", Path: "/api/v1/subgraph",
+            Summary: "Get the neighborhood of a node in an already built network",
+            Params: []param{usersQueryParam, sourceParam,
+                {Name: "center", In: "query", Type: "string", Required: true,
+                    Description: "The name of the node at the center"},
+                {Name: "hops", In: "query", Type: "integer",
+                    Description: "How many links out from the center to go; 1 by default"}},
+            Status: http.StatusOK, Response: networkmapper.Result{},
+        }}},
+
+        {Pattern: "/api/v1/nodes", Handler: CacheControl("json", Compress(NodeSearchHandler)), Ops: []operation{{
+            Method: "GET", Path: "/api/v1/nodes",
+            Summary: "Search the nodes of an already built network by name, as /json/ sends it with the same options",
+            Params: append([]param{usersQueryParam,
+                {Name: "q", In: "query", Type: "string", Required: true,
+                    Description: "Text the names must contain"},
+                {Name: "limit", In: "query", Type: "integer",
+                    Description: "The most nodes to return; 10 by default"}}, networkParams...),
+            Status: http.StatusOK, Response: []networkmapper.NodeMatch{},
+        }}},
+
+        {Pattern: "/api/search", Handler: CacheControl("json", RateLimited(searchLimits, Compress(SearchHandler))), Ops: []operation{{
+            Method: "GET", Path: "/api/search",
+            Summary: "Search SoundCloud's users, for a search box; limited to search_rps requests a second per client",
+            Params: []param{
+                {Name: "q", In: "query", Type: "string", Required: true,
+                    Description: "What to search for"},
+                {Name: "limit", In: "query", Type: "integer",
+                    Description: "The most users to return; 10 by default"}},
+            Status: http.StatusOK, Response: []SearchResult{},
+        }}},
+
+        {Pattern: "/api/autocomplete", Handler: CacheControl("json", RateLimited(autocompleteLimits, Compress(AutocompleteHandler))), Ops: []operation{{
+            Method: "GET", Path: "/api/autocomplete",
+            Summary: "Suggest SoundCloud users as a username is typed; limited to autocomplete_rps requests a second per client",
+            Params: []param{
+                {Name: "q", In: "query", Type: "string", Required: true,
+                    Description: "What's been typed; fewer than 2 characters get no suggestions"}},
+            Status: http.StatusOK, Response: Autocomplete{},
+        }}},
+
+        {Pattern: "/api/v1/quota", Handler: CacheControl("private", QuotaHandler), Ops: []operation{{
+            Method: "GET", Path: "/api/v1/quota",
+            Summary: "Get the state of each source's quota of API calls",
+            Status: http.StatusOK, Response: map[string]networkmapper.QuotaStatus{},
+        }}},
+
+        {Pattern: "/api/v1/schema", Handler: CacheControl("json", Compress(SchemaHandler)), Ops: []operation{{
+            Method: "GET", Path: "/api/v1/schema",
+            Summary: "Get the JSON Schema of a format of the network",
+            Params: []param{
+                {Name: "format", In: "query", Type: "string", Description: "The format; result by default",
+                    Enum: []string{networkmapper.FORMAT_RESULT, networkmapper.FORMAT_LEGACY,
+                        networkmapper.FORMAT_BUNDLE, networkmapper.FORMAT_COMMUNITY, networkmapper.FORMAT_D3V7,
+                        networkmapper.FORMAT_CYTOSCAPE}}},
+            Status: http.StatusOK, ResponseTypes: []string{"application/json"},
+        }}},
+
+        {Pattern: "/api/v1/openapi.json", Handler: CacheControl("json", Compress(OpenAPIHandler)), Ops: []operation{{
+            Method: "GET", Path: "/api/v1/openapi.json",
+            Summary: "Get this OpenAPI specification",
+            Status: http.StatusOK, ResponseTypes: []string{"application/json"},
+        }}},
+    }
+}
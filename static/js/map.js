@@ -19,6 +19,9 @@ var force = d3.layout.force()
     .size([width, height])
     .friction(0.8);
 
+// Colors for networks grouped by something other than role
+var groupColor = d3.scale.category10();
+
 var svg = d3.select("#graph").append("svg")
     .attr("width", width)
     .attr("height", height);
@@ -38,6 +41,18 @@ var spinner = new Spinner({
 
 // D3
 d3.json(jsonPath, function(error, graph) {
+    if (error) {
+        spinner.stop()
+        var message = "Couldn't map these followings."
+        if (error.status == 503) {
+            message = error.responseText
+        }
+        d3.select("#graph").append("p")
+            .attr("class", "lead")
+            .text(message)
+        return
+    }
+
     force
         .nodes(graph.nodes)
         .links(graph.links)
@@ -46,7 +61,36 @@ d3.json(jsonPath, function(error, graph) {
     var link = svg.selectAll(".link")
         .data(graph.links)
         .enter().append("line")
-        .attr("class", "link");
+        .attr("class", "link")
+        .style("stroke-width", function(d) {
+            // Heavier links for weighted networks
+            if (d.weight) {
+                return Math.sqrt(d.weight) + "px";
+            }
+            return null;
+        })
+        .classed("mutual", function(d) { return d.mutual; })
+        .classed("followed-by", function(d) { return d.type == "followed_by"; });
+
+    // Draw arrows from follower to followed for directed networks, with
+    // mutual follows in both directions
+    if (graph.directed) {
+        svg.append("defs").selectAll("marker")
+            .data(["end", "start"])
+            .enter().append("marker")
+            .attr("id", function(d) { return "arrow-" + d; })
+            .attr("viewBox", "0 -5 10 10")
+            .attr("refX", function(d) { return d == "end" ? 20 : -10; })
+            .attr("markerWidth", 6)
+            .attr("markerHeight", 6)
+            .attr("orient", "auto")
+            .append("path")
+            .attr("d", function(d) { return d == "end" ? "M0,-5L10,0L0,5" : "M10,-5L0,0L10,5"; })
+            .style("fill", "#999");
+
+        link.attr("marker-end", "url(#arrow-end)")
+            .attr("marker-start", function(d) { return d.mutual ? "url(#arrow-start)" : null; });
+    }
 
     var node = svg.selectAll(".node")
         .data(graph.nodes)
@@ -54,16 +98,19 @@ d3.json(jsonPath, function(error, graph) {
         .attr("class", "node")
         .attr("r", function(d) { 
 
+            // Size followings by how many of the users follow them
+            var degree = d.in_degree || d.weight;
+
             // Handle mobile
             if (width < (768 / 1.9)) {
                 if (d.group != 1) {
-                    return d.weight * 2; 
+                    return degree * 2; 
                 }
                 return 7;
             }
 
             if (d.group != 1) {
-                return d.weight * 3; 
+                return degree * 3; 
             }
             return 10;
         })
@@ -71,16 +118,43 @@ d3.json(jsonPath, function(error, graph) {
             if (d.group == 1) {
                 return "#FA6900"
             }
+            if (graph.groups) {
+                return groupColor(d.group)
+            }
+            if (d.group == 3) {
+                return "#A7DBD8"
+            }
             return "#4A93A2"
         })
         .call(force.drag);
 
     node.append("title")
-        .text(function(d) { return d.name; });
+        .text(function(d) { return d.full_name || d.name; });
 
     // Remove the loading gif
     spinner.stop()
 
+    // Summarize the network
+    if (graph.stats) {
+        var summary = graph.stats.nodes + " accounts, " + graph.stats.links + " links"
+        if (graph.stats.most_shared) {
+            summary += "; most shared: " + graph.stats.most_shared +
+                " (" + graph.stats.most_shared_by + " users)"
+        }
+        d3.select("#graph").insert("p", ":first-child")
+            .attr("class", "summary")
+            .text(summary)
+    }
+
+    // Warn that followings are missing
+    if (graph.meta && graph.meta.errors) {
+        var users = graph.meta.errors.map(function(e) { return e.user; })
+        d3.select("#graph").insert("p", ":first-child")
+            .attr("class", "lead")
+            .text("Some followings couldn't be fetched, so the map is missing part of " +
+                users.join(", ") + ".")
+    }
+
     // Hover
     $('svg circle').tipsy({ 
         trigger: 'click',
@@ -88,7 +162,11 @@ d3.json(jsonPath, function(error, graph) {
         html: true, 
         title: function() {
           var d = this.__data__;
-          return d.name; 
+          var html = describe(d);
+          if (graph.groups && d.group != 1) {
+              html += "<br>" + escapeHtml(graph.groups[d.group]);
+          }
+          return html;
         }
     });
 
@@ -104,4 +182,31 @@ d3.json(jsonPath, function(error, graph) {
             .attr("y2", function(d) { return d.target.y; });
 
     });
-});
\ No newline at end of file
+});
+
+// describe returns the html of a node's tooltip, with its avatar and
+// profile if the network was built with metadata.
+function describe(d) {
+    var html = "";
+    if (d.avatar_url) {
+        html += '<img src="' + escapeHtml(d.avatar_url) + '" width="48" height="48"><br>';
+    }
+    if (d.full_name) {
+        html += escapeHtml(d.full_name) + " (" + escapeHtml(d.name) + ")";
+    } else {
+        html += escapeHtml(d.name);
+    }
+    if (d.city) {
+        html += "<br>" + escapeHtml(d.city);
+    }
+    if (d.followers_count !== undefined || d.track_count !== undefined) {
+        html += "<br>" + (d.followers_count || 0) + " followers, " + (d.track_count || 0) + " tracks";
+    }
+    return html;
+}
+
+// escapeHtml escapes s for use in html.
+function escapeHtml(s) {
+    return String(s).replace(/&/g, "&amp;").replace(/</g, "&lt;")
+        .replace(/>/g, "&gt;").replace(/"/g, "&quot;");
+}
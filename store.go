@@ -0,0 +1,218 @@
+// store.go contains where saved graphs and their snapshots are kept: Redis,
+// a bbolt file on disk, so single-binary deployments don't need Redis, or
+// Postgres
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/garyburd/redigo/redis"
+)
+
+// A type that satisfies GraphStore keeps saved graphs and the snapshots of
+// their networks.
+type GraphStore interface {
+
+    // Saves a graph, unless one with its id is already saved
+    AddGraph(graph *Graph, gjs []byte) error
+
+    // Gets the saved graph with a given id, or nil if there is none
+    GetGraph(id string) (*Graph, error)
+
+    // Adds the network js as a snapshot of a saved graph, dropping the
+    // oldest beyond max, unless it's negative
+    AddSnapshot(id string, snapshot Snapshot, js []byte, max int) error
+
+    // Gets the snapshots of a saved graph, newest first
+    GetHistory(id string) ([]Snapshot, error)
+
+    // Gets the network of a saved graph as of its latest snapshot at or
+    // before a given time, or nil if there isn't one
+    GetSnapshot(id string, at time.Time) ([]byte, error)
+
+    // Gets the ids of every saved graph
+    ListGraphs() ([]string, error)
+
+}
+
+// NewGraphStore creates the GraphStore configured by kind: "redis", "bolt"
+// in the bbolt database at path, or "postgres" in the database at url.
+func NewGraphStore(kind, path, url string) (GraphStore, error) {
+    switch kind {
+    case "", "redis":
+        return redisGraphs{}, nil
+    case "bolt":
+        db, err := openBolt(path)
+        if err != nil {
+            return nil, err
+        }
+        return boltGraphs{db}, nil
+    case "postgres":
+        return newPostgresGraphs(url)
+    }
+    return nil, fmt.Errorf("unknown store %q", kind)
+}
+
+// redisGraphs is a GraphStore in the Redis database of pool.
+type redisGraphs struct{}
+
+// AddGraph saves graph, marshaled as gjs, unless it's already saved.
+func (redisGraphs) AddGraph(graph *Graph, gjs []byte) error {
+    conn := pool.Get()
+    defer conn.Close()
+
+    _, err := conn.Do("SET", graphKey(graph.Id), gjs, "NX")
+    return err
+}
+
+// GetGraph returns the saved graph with the given id, or nil if there is
+// no such graph.
+func (redisGraphs) GetGraph(id string) (*Graph, error) {
+
+    conn := pool.Get()
+    defer conn.Close()
+
+    js, err := redis.Bytes(conn.Do("GET", graphKey(id)))
+    if err == redis.ErrNil {
+        return nil, nil
+    } else if err != nil {
+        return nil, err
+    }
+
+    var graph Graph
+    if err = json.Unmarshal(js, &graph); err != nil {
+        return nil, err
+    }
+    return &graph, nil
+}
+
+// AddSnapshot adds js as the newest snapshot of the saved graph with the
+// given id, dropping the oldest beyond max, unless max is negative.
+func (redisGraphs) AddSnapshot(id string, snapshot Snapshot, js []byte, max int) error {
+
+    sjs, _ := json.Marshal(snapshot)
+    network, err := encodeNetwork(js)
+    if err != nil {
+        return err
+    }
+
+    conn := pool.Get()
+    defer conn.Close()
+
+    // Keep the network before listing it, so the transaction only touches
+    // the history, which a Redis Cluster requires
+    if _, err := conn.Do("SET", snapshotKey(id, snapshot.Timestamp), network); err != nil {
+        return err
+    }
+    conn.Send("MULTI")
+    conn.Send("ZREMRANGEBYSCORE", graphHistoryKey(id), snapshot.Timestamp, snapshot.Timestamp)
+    conn.Send("ZADD", graphHistoryKey(id), snapshot.Timestamp, sjs)
+    if _, err := conn.Do("EXEC"); err != nil {
+        return err
+    }
+
+    // Drop the oldest snapshots
+    if max < 0 {
+        return nil
+    }
+    old, err := redis.Strings(conn.Do("ZRANGE", graphHistoryKey(id), 0, -(max + 1)))
+    if err != nil || len(old) == 0 {
+        return nil
+    }
+    for _, m := range old {
+        var s Snapshot
+        if json.Unmarshal([]byte(m), &s) == nil {
+            conn.Do("DEL", snapshotKey(id, s.Timestamp))
+        }
+    }
+    conn.Do("ZREMRANGEBYRANK", graphHistoryKey(id), 0, len(old) - 1)
+    return nil
+}
+
+// GetHistory returns the snapshots of the saved graph with the given id,
+// newest first.
+func (redisGraphs) GetHistory(id string) ([]Snapshot, error) {
+
+    conn := pool.Get()
+    defer conn.Close()
+
+    members, err := redis.Strings(conn.Do("ZREVRANGE", graphHistoryKey(id), 0, -1))
+    if err != nil {
+        return nil, err
+    }
+
+    history := make([]Snapshot, len(members))
+    for i, m := range members {
+        if err = json.Unmarshal([]byte(m), &history[i]); err != nil {
+            return nil, err
+        }
+    }
+    return history, nil
+}
+
+// GetSnapshot returns the network of the saved graph with the given id as
+// of its latest snapshot at or before at, or nil if there isn't one.
+func (redisGraphs) GetSnapshot(id string, at time.Time) ([]byte, error) {
+
+    conn := pool.Get()
+    defer conn.Close()
+
+    members, err := redis.Strings(conn.Do("ZREVRANGEBYSCORE", graphHistoryKey(id), at.Unix(), "-inf", "LIMIT", 0, 1))
+    if err != nil || len(members) == 0 {
+        return nil, err
+    }
+
+    var snapshot Snapshot
+    if err = json.Unmarshal([]byte(members[0]), &snapshot); err != nil {
+        return nil, err
+    }
+
+    js, err := redis.Bytes(conn.Do("GET", snapshotKey(id, snapshot.Timestamp)))
+    if err == redis.ErrNil {
+        return nil, nil
+    } else if err != nil {
+        return nil, err
+    }
+    return decodeNetwork(js)
+}
+
+// ListGraphs returns the ids of every saved graph.
+func (redisGraphs) ListGraphs() ([]string, error) {
+
+    keys, err := redisCache{}.Keys(graphKey(""))
+    if err != nil {
+        return nil, err
+    }
+
+    // Skip the keys of the graphs' histories and snapshots
+    ids := []string{}
+    for _, k := range keys {
+        if id := strings.TrimPrefix(k, graphKey("")); !strings.Contains(id, ":") {
+            ids = append(ids, id)
+        }
+    }
+    return ids, nil
+}
+
+/* Helpers */
+
+// graphKey returns the Redis key holding a saved graph.
+func graphKey(id string) string {
+    return "graph:" + id
+}
+
+// graphHistoryKey returns the Redis key of the sorted set of a saved
+// graph's snapshots, scored by when they were taken.
+func graphHistoryKey(id string) string {
+    return "graph:" + id + ":history"
+}
+
+// snapshotKey returns the Redis key holding the network of a snapshot.
+func snapshotKey(id string, timestamp int64) string {
+    return "graph:" + id + ":at:" + strconv.FormatInt(timestamp, 10)
+}
@@ -0,0 +1,147 @@
+// users.go contains the parsing and validation of usernames given to cumuli
+
+package main
+
+import (
+    "encoding/csv"
+    "fmt"
+    "io"
+    "net/http"
+    "path"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+const MAX_UPLOAD_USERS = 10000
+
+// validUsername matches a SoundCloud permalink.
+var validUsername = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,255}$`)
+
+// A type for a user that can't be a username on the source it's given for.
+type InvalidUsernameError struct {
+    User string
+}
+
+func (e *InvalidUsernameError) Error() string {
+    return "invalid username " + strconv.Quote(e.User)
+}
+
+// requestUsers returns the users of r, a request to the network route at
+// prefix: those of its users query parameter, separated by ',' or '+', or
+// else the '+' separated ones its path ends with. They're checked with
+// checkUsers, up to max_users of them.
+func requestUsers(r *http.Request, source, prefix string) ([]string, error) {
+    s := r.URL.Query().Get("users")
+    if s == "" && strings.TrimSuffix(r.URL.Path, "/") != strings.TrimSuffix(prefix, "/") {
+        s = path.Base(r.URL.Path)
+    }
+    return parseUsers(source, s, config.MaxUsers)
+}
+
+// parseUsers returns the users in s, separated by '+', ',' or spaces,
+// which is what a '+' becomes in a query string, checked with checkUsers.
+func parseUsers(source, s string, max int) ([]string, error) {
+    return checkUsers(source, strings.FieldsFunc(s, func(c rune) bool {
+        return c == '+' || c == ',' || c == ' '
+    }), max)
+}
+
+// checkUsers returns users without blanks or duplicates, keeping the first
+// of each. It's an error if none are left, if more than max are unless max
+// is -1, or if one can't be a username on source, which is an
+// *InvalidUsernameError. Only SoundCloud's usernames are checked, as other
+// sources name accounts their own ways.
+func checkUsers(source string, users []string, max int) ([]string, error) {
+
+    unique := []string{}
+    seen := make(map[string]bool)
+
+    for _, u := range users {
+        u = strings.TrimSpace(u)
+        if u == "" || seen[u] {
+            continue
+        }
+
+        if source == DEFAULT_SOURCE && !validUsername.MatchString(u) {
+            return nil, &InvalidUsernameError{u}
+        }
+
+        seen[u] = true
+        unique = append(unique, u)
+    }
+
+    if len(unique) == 0 {
+        return nil, fmt.Errorf("no users given")
+    }
+    if max > 0 && len(unique) > max {
+        return nil, fmt.Errorf("too many users: %d, the maximum is %d", len(unique), max)
+    }
+
+    return unique, nil
+}
+
+// ParseUsernames reads a CSV or plain text list of usernames of source
+// from r.
+//
+// If the first row is a header naming a "permalink" or "username" column
+// only that column is used, as in an exported follower list; otherwise the
+// first field of every row is used. Blank rows are skipped, duplicates are
+// dropped, and any username invalid on source is an error.
+func ParseUsernames(r io.Reader, source string) ([]string, error) {
+
+    cr := csv.NewReader(r)
+    cr.FieldsPerRecord = -1
+    cr.TrimLeadingSpace = true
+    cr.Comment = '#'
+
+    // Read the rows, keeping the line of the file each starts on, counting
+    // the comments and blank lines the reader skips
+    records := [][]string{}
+    lines := []int{}
+    for {
+        record, err := cr.Read()
+        if err == io.EOF {
+            break
+        } else if err != nil {
+            return nil, err
+        }
+        line, _ := cr.FieldPos(0)
+        records = append(records, record)
+        lines = append(lines, line)
+    }
+
+    // Find the username column from the header, if there is one
+    column := 0
+    if len(records) > 0 {
+        for i, field := range records[0] {
+            field = strings.ToLower(strings.TrimSpace(field))
+            if field == "permalink" || field == "username" {
+                column = i
+                records, lines = records[1:], lines[1:]
+                break
+            }
+        }
+    }
+
+    users := []string{}
+    lineOf := make(map[string]int)
+
+    for i, record := range records {
+        if column >= len(record) {
+            continue
+        }
+
+        u := strings.TrimSpace(record[column])
+        if _, ok := lineOf[u]; !ok {
+            lineOf[u] = lines[i]
+        }
+        users = append(users, u)
+    }
+
+    users, err := checkUsers(source, users, MAX_UPLOAD_USERS)
+    if e, ok := err.(*InvalidUsernameError); ok {
+        return nil, fmt.Errorf("%s on line %d", e, lineOf[e.User])
+    }
+    return users, err
+}